@@ -8,13 +8,13 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/DHowett/ghostbin/lib/crypto"
 	"github.com/DHowett/ghostbin/lib/templatepack"
 	"github.com/DHowett/ghostbin/model"
 	"github.com/golang/glog"
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
-	"golang.org/x/crypto/scrypt"
 )
 
 const USER_CACHE_MAX_ENTRIES int = 1000
@@ -236,14 +236,20 @@ func authTokenPageHandler(w http.ResponseWriter, r *http.Request) {
 	templatePack.ExecutePage(w, r, "authtoken", map[string]string{"token": token})
 }
 
-type AuthChallengeProvider struct{}
+type AuthChallengeProvider struct {
+	kdf crypto.KDF
+}
+
+func (a *AuthChallengeProvider) KDF() crypto.KDF {
+	return a.kdf
+}
 
-func (a *AuthChallengeProvider) DeriveKey(password string, salt []byte) []byte {
+func (a *AuthChallengeProvider) DeriveKey(password string, salt []byte, kdf crypto.KDF) []byte {
 	if password == "" {
 		return nil
 	}
 
-	key, err := scrypt.Key([]byte(password), salt, 16384, 8, 1, 32)
+	key, err := kdf.DeriveKey([]byte(password), salt, 32)
 	if err != nil {
 		panic(err)
 	}
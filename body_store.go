@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DHowett/ghostbin/lib/objectstore"
+	"github.com/DHowett/ghostbin/model"
+	"github.com/golang/glog"
+)
+
+// bodyStoreConfig describes where paste bodies are kept. It is populated from the -body-store,
+// -body-compression and -s3-* flags and the "body_store" section of the configuration file;
+// flags given on the command line take precedence.
+type bodyStoreConfig struct {
+	Backend     string `yaml:"backend"`
+	Path        string `yaml:"path"`
+	Compression string `yaml:"compression"`
+
+	S3 struct {
+		Endpoint  string `yaml:"endpoint"`
+		Bucket    string `yaml:"bucket"`
+		Region    string `yaml:"region"`
+		Prefix    string `yaml:"prefix"`
+		AccessKey string `yaml:"access_key"`
+		SecretKey string `yaml:"secret_key"`
+	} `yaml:"s3"`
+}
+
+func (c *bodyStoreConfig) register() {
+	flag.StringVar(&c.Backend, "body-store", "database", "paste body storage (database, filesystem, s3)")
+	flag.StringVar(&c.Compression, "body-compression", "gzip", "how paste bodies are compressed at rest (gzip, none); existing bodies are recompressed in the background")
+	flag.StringVar(&c.Path, "body-store-path", "", "directory for the filesystem body store (default: bodies under -root)")
+	flag.StringVar(&c.S3.Endpoint, "s3-endpoint", "", "URL of the S3-compatible object store for the s3 body store")
+	flag.StringVar(&c.S3.Bucket, "s3-bucket", "", "bucket for the s3 body store")
+	flag.StringVar(&c.S3.Region, "s3-region", "us-east-1", "region of the s3 body store's bucket")
+	flag.StringVar(&c.S3.Prefix, "s3-prefix", "", "prefix for the keys of objects in the s3 body store")
+	flag.StringVar(&c.S3.AccessKey, "s3-access-key", "", "access key for the s3 body store (default: $AWS_ACCESS_KEY_ID)")
+	flag.StringVar(&c.S3.SecretKey, "s3-secret-key", "", "secret key for the s3 body store (default: $AWS_SECRET_ACCESS_KEY)")
+}
+
+// merge fills in every setting that was not given explicitly on the command line from o.
+func (c *bodyStoreConfig) merge(o *bodyStoreConfig, explicit map[string]bool) {
+	if o.Backend != "" && !explicit["body-store"] {
+		c.Backend = o.Backend
+	}
+	if o.Path != "" && !explicit["body-store-path"] {
+		c.Path = o.Path
+	}
+	if o.Compression != "" && !explicit["body-compression"] {
+		c.Compression = o.Compression
+	}
+	if o.S3.Endpoint != "" && !explicit["s3-endpoint"] {
+		c.S3.Endpoint = o.S3.Endpoint
+	}
+	if o.S3.Bucket != "" && !explicit["s3-bucket"] {
+		c.S3.Bucket = o.S3.Bucket
+	}
+	if o.S3.Region != "" && !explicit["s3-region"] {
+		c.S3.Region = o.S3.Region
+	}
+	if o.S3.Prefix != "" && !explicit["s3-prefix"] {
+		c.S3.Prefix = o.S3.Prefix
+	}
+	if o.S3.AccessKey != "" && !explicit["s3-access-key"] {
+		c.S3.AccessKey = o.S3.AccessKey
+	}
+	if o.S3.SecretKey != "" && !explicit["s3-secret-key"] {
+		c.S3.SecretKey = o.S3.SecretKey
+	}
+}
+
+// openPasteBodyStore opens the named body store, configured by c. The database store
+// keeps bodies in sqlDb.
+func openPasteBodyStore(backend string, c *bodyStoreConfig, dialect string, sqlDb *sql.DB) (model.PasteBodyStore, error) {
+	switch strings.ToLower(backend) {
+	case "database", "db":
+		return model.NewDatabasePasteBodyStore(dialect, sqlDb)
+	case "filesystem", "fs":
+		path := c.Path
+		if path == "" {
+			path = filepath.Join(arguments.root, "bodies")
+		}
+		return model.NewFilesystemPasteBodyStore(path)
+	case "s3":
+		if c.S3.Endpoint == "" || c.S3.Bucket == "" {
+			return nil, fmt.Errorf("the s3 body store requires an endpoint (-s3-endpoint) and a bucket (-s3-bucket)")
+		}
+		client := &objectstore.Client{
+			Endpoint:  c.S3.Endpoint,
+			Bucket:    c.S3.Bucket,
+			Region:    c.S3.Region,
+			AccessKey: c.S3.AccessKey,
+			SecretKey: c.S3.SecretKey,
+		}
+		if client.AccessKey == "" {
+			client.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if client.SecretKey == "" {
+			client.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		return model.NewObjectPasteBodyStore(client, c.S3.Prefix), nil
+	}
+	return nil, fmt.Errorf("unsupported body store %q", backend)
+}
+
+// bodyCompressionBatch is how many bodies are recompressed at a time, and
+// bodyCompressionInterval how long the compressor waits once it runs out.
+const (
+	bodyCompressionBatch    = 100
+	bodyCompressionInterval = time.Hour
+)
+
+// startBodyCompressor recompresses the bodies written before the current -body-compression
+// setting in the background, if broker can.
+func startBodyCompressor(broker model.Broker) {
+	compressor, ok := broker.(model.PasteBodyCompressor)
+	if !ok {
+		return
+	}
+	go func() {
+		for {
+			n, err := compressor.CompressPasteBodies(bodyCompressionBatch)
+			if err != nil {
+				glog.Error("Failed to recompress paste bodies: ", err)
+			}
+			if n > 0 {
+				glog.Infof("Recompressed %d paste bodies.", n)
+			}
+			if err != nil || n < bodyCompressionBatch {
+				time.Sleep(bodyCompressionInterval)
+			}
+		}
+	}()
+}
+
+func migrateBodiesCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s", commands["migrate-bodies"].Usage)
+	}
+
+	sqlDb, dialect, err := openDatabase(&arguments.db)
+	if err != nil {
+		return err
+	}
+	defer sqlDb.Close()
+
+	migrator, err := model.NewSchemaMigrator(dialect, sqlDb)
+	if err != nil {
+		return err
+	}
+	if err := migrator.Check(); err != nil {
+		return err
+	}
+
+	from, err := openPasteBodyStore(args[0], &arguments.bodies, dialect, sqlDb)
+	if err != nil {
+		return err
+	}
+	to, err := openPasteBodyStore(args[1], &arguments.bodies, dialect, sqlDb)
+	if err != nil {
+		return err
+	}
+
+	n, err := model.MigratePasteBodies(dialect, sqlDb, from, to)
+	fmt.Printf("Moved %d paste bodies from the %s store to the %s store.\n", n, args[0], args[1])
+	return err
+}
+
+func init() {
+	registerCommand(&command{
+		Name:  "migrate-bodies",
+		Usage: "migrate-bodies <from> <to> (database, filesystem, s3; stop the server first)",
+		Run:   migrateBodiesCommand,
+	})
+}
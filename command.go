@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// A command is a subcommand run in place of the web server, as in `ghostbin migrate status`.
+type command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = make(map[string]*command)
+
+func registerCommand(c *command) {
+	if _, exists := commands[c.Name]; exists {
+		panic(fmt.Errorf("command %s registered twice", c.Name))
+	}
+	commands[c.Name] = c
+}
+
+func commandUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] [command [arguments]]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].Usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// runCommand runs the command named by args[0] with the remaining arguments.
+func runCommand(args []string) error {
+	c, ok := commands[args[0]]
+	if !ok {
+		commandUsage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return c.Run(args[1:])
+}
+
+func init() {
+	flag.Usage = commandUsage
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DHowett/ghostbin/lib/backup"
+	"github.com/DHowett/ghostbin/model"
+)
+
+// Everything under -root that isn't in the database or the body store is archived under files/.
+const backupFilePrefix = "files/"
+
+// backupFiles lists the files under -root that make up an instance, relative to it. Missing
+// files are left out.
+func backupFiles() ([]string, error) {
+	var files []string
+	for _, name := range []string{"session.key", "client_session_enc.key", "reports.gob", "expiry.gob"} {
+		if _, err := os.Stat(filepath.Join(arguments.root, name)); err == nil {
+			files = append(files, name)
+		}
+	}
+	err := filepath.Walk(filepath.Join(arguments.root, "sessions"), func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(arguments.root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+// backupFilePath returns where the archived file called name belongs under -root.
+func backupFilePath(name string) (string, error) {
+	rel := strings.TrimPrefix(name, backupFilePrefix)
+	clean := filepath.Clean(filepath.FromSlash(rel))
+	if rel == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid file %q in archive", name)
+	}
+	return filepath.Join(arguments.root, clean), nil
+}
+
+// snapshotSQLite copies a live SQLite database into a new file in dir, consistently, and
+// opens the copy.
+func snapshotSQLite(sqlDb *sql.DB, dir string) (*sql.DB, error) {
+	path := filepath.Join(dir, "snapshot.db")
+	if _, err := sqlDb.Exec("VACUUM INTO ?", path); err != nil {
+		return nil, fmt.Errorf("failed to snapshot the database: %v", err)
+	}
+	return sql.Open("sqlite3", path)
+}
+
+func exportCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", commands["export"].Usage)
+	}
+
+	sqlDb, dialect, err := openDatabase(&arguments.db)
+	if err != nil {
+		return err
+	}
+	defer sqlDb.Close()
+
+	// A server may still be writing to SQLite; the export is taken from a copy of the
+	// database made in a single statement.
+	if dialect == "sqlite3" {
+		dir, err := ioutil.TempDir(arguments.root, "export")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		snapshot, err := snapshotSQLite(sqlDb, dir)
+		if err != nil {
+			return err
+		}
+		defer snapshot.Close()
+		sqlDb = snapshot
+	}
+
+	bodies, err := openPasteBodyStore(arguments.bodies.Backend, &arguments.bodies, dialect, sqlDb)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if args[0] != "-" {
+		if out, err = os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+			return err
+		}
+	}
+
+	err = exportInstance(dialect, sqlDb, bodies, out)
+	if out != os.Stdout {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(args[0])
+		}
+	}
+	return err
+}
+
+func exportInstance(dialect string, sqlDb *sql.DB, bodies model.PasteBodyStore, out io.Writer) error {
+	w := backup.NewWriter(out)
+	if err := model.ExportDatabase(dialect, sqlDb, bodies, w); err != nil {
+		return err
+	}
+
+	files, err := backupFiles()
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		f, err := os.Open(filepath.Join(arguments.root, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		err = w.Add(backupFilePrefix+name, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// openBackup opens the archive named by path, or standard input for "-".
+func openBackup(path string) (*backup.Reader, io.Closer, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	r, err := backup.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return r, f, nil
+}
+
+// readBackup hands every entry in r to each of handlers in turn, until one accepts it.
+func readBackup(r *backup.Reader, handlers ...func(string, io.Reader) (bool, error)) error {
+	for {
+		name, content, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handled := false
+		for _, h := range handlers {
+			if handled, err = h(name, content); err != nil {
+				return err
+			}
+			if handled {
+				break
+			}
+		}
+		if !handled {
+			return fmt.Errorf("unknown entry %q in archive", name)
+		}
+	}
+}
+
+func importCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", commands["import"].Usage)
+	}
+
+	r, f, err := openBackup(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sqlDb, dialect, err := openDatabase(&arguments.db)
+	if err != nil {
+		return err
+	}
+	defer sqlDb.Close()
+
+	migrator, err := model.NewSchemaMigrator(dialect, sqlDb)
+	if err != nil {
+		return err
+	}
+	if err := migrator.Up(0); err != nil {
+		return err
+	}
+
+	bodies, err := openPasteBodyStore(arguments.bodies.Backend, &arguments.bodies, dialect, sqlDb)
+	if err != nil {
+		return err
+	}
+	importer, err := model.NewDatabaseImporter(dialect, sqlDb, bodies)
+	if err != nil {
+		return err
+	}
+
+	err = readBackup(r, importer.Import, func(name string, content io.Reader) (bool, error) {
+		if !strings.HasPrefix(name, backupFilePrefix) {
+			return false, nil
+		}
+		path, err := backupFilePath(name)
+		if err != nil {
+			return true, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return true, err
+		}
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return true, err
+		}
+		_, err = io.Copy(out, content)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		return true, err
+	})
+	if err == nil {
+		err = importer.Finish()
+	}
+	if err != nil {
+		return fmt.Errorf("%v\nThe import is incomplete; start again with an empty database.", err)
+	}
+	fmt.Printf("Imported %s.\n", args[0])
+	return nil
+}
+
+func verifyCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", commands["verify"].Usage)
+	}
+
+	r, f, err := openBackup(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sqlDb, dialect, err := openDatabase(&arguments.db)
+	if err != nil {
+		return err
+	}
+	defer sqlDb.Close()
+
+	bodies, err := openPasteBodyStore(arguments.bodies.Backend, &arguments.bodies, dialect, sqlDb)
+	if err != nil {
+		return err
+	}
+	verifier, err := model.NewDatabaseVerifier(dialect, sqlDb, bodies)
+	if err != nil {
+		return err
+	}
+
+	var differing []string
+	err = readBackup(r, verifier.Verify, func(name string, content io.Reader) (bool, error) {
+		if !strings.HasPrefix(name, backupFilePrefix) {
+			return false, nil
+		}
+		path, err := backupFilePath(name)
+		if err != nil {
+			return true, err
+		}
+		archived := sha256.New()
+		if _, err := io.Copy(archived, content); err != nil {
+			return true, err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			differing = append(differing, fmt.Sprintf("%s: %v", name, err))
+			return true, nil
+		}
+		if stored := sha256.Sum256(data); !bytes.Equal(stored[:], archived.Sum(nil)) {
+			differing = append(differing, name+" differs")
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := verifier.Finish(); err != nil {
+		mismatch, ok := err.(model.BackupMismatchError)
+		if !ok {
+			return err
+		}
+		differing = append(mismatch, differing...)
+	}
+	if len(differing) > 0 {
+		return model.BackupMismatchError(differing)
+	}
+	fmt.Printf("%s matches this instance.\n", args[0])
+	return nil
+}
+
+func init() {
+	registerCommand(&command{
+		Name:  "export",
+		Usage: "export <archive|-> (a consistent snapshot of the database, bodies, sessions and reports)",
+		Run:   exportCommand,
+	})
+	registerCommand(&command{
+		Name:  "import",
+		Usage: "import <archive|-> (into an empty database; stop the server first)",
+		Run:   importCommand,
+	})
+	registerCommand(&command{
+		Name:  "verify",
+		Usage: "verify <archive|-> (check the archive, and that this instance holds what it does)",
+		Run:   verifyCommand,
+	})
+}
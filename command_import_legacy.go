@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/DHowett/ghostbin/model"
+)
+
+// The filesystem paste store kept each paste in a file named by its ID, and everything but
+// the body in extended attributes on that file. It recorded no creation time; a paste's
+// modification time stands in for it.
+const (
+	legacyLanguageAttr         = "user.paste.language"
+	legacyExpirationAttr       = "user.paste.expiration"
+	legacyTitleAttr            = "user.paste.title"
+	legacyEncryptionSaltAttr   = "user.paste.encryption_salt"
+	legacyEncryptionMethodAttr = "user.paste.encryption_method"
+	legacyHMACAttr             = "user.paste.hmac"
+)
+
+// readLegacyPaste reads the paste kept in path. The caller must close the body.
+func readLegacyPaste(path string, info os.FileInfo) (*model.ImportedPaste, *os.File, error) {
+	attrs := make(map[string][]byte)
+	for _, name := range []string{legacyLanguageAttr, legacyExpirationAttr, legacyTitleAttr, legacyEncryptionSaltAttr, legacyEncryptionMethodAttr, legacyHMACAttr} {
+		value, err := getxattr(path, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		attrs[name] = value
+	}
+
+	p := &model.ImportedPaste{
+		ID:           model.PasteIDFromString(info.Name()),
+		CreatedAt:    info.ModTime(),
+		ModifiedAt:   info.ModTime(),
+		Title:        string(attrs[legacyTitleAttr]),
+		LanguageName: string(attrs[legacyLanguageAttr]),
+		Expiration:   string(attrs[legacyExpirationAttr]),
+	}
+
+	if salt := attrs[legacyEncryptionSaltAttr]; salt != nil {
+		// Pastes encrypted before the method was recorded used AES-OFB.
+		p.EncryptionMethod = model.PasteEncryptionMethodAES_OFB
+		if method := attrs[legacyEncryptionMethodAttr]; method != nil {
+			n, err := strconv.ParseUint(string(method), 10, 32)
+			if err != nil || n == 0 {
+				return nil, nil, fmt.Errorf("unknown encryption method %q", method)
+			}
+			p.EncryptionMethod = model.PasteEncryptionMethod(n)
+		}
+		if attrs[legacyHMACAttr] == nil {
+			return nil, nil, fmt.Errorf("encrypted, but has no HMAC")
+		}
+		p.EncryptionSalt = salt
+		p.HMAC = attrs[legacyHMACAttr]
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.Body = f
+	return p, f, nil
+}
+
+func importLegacyCommand(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: %s", commands["import-legacy"].Usage)
+	}
+	dir := filepath.Join(arguments.root, "pastes")
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	broker, err := openDatabaseBroker(&arguments.db)
+	if err != nil {
+		return err
+	}
+	importer, ok := broker.(model.PasteImporter)
+	if !ok {
+		return fmt.Errorf("pastes can't be imported into the %s dialect", arguments.db.Dialect)
+	}
+
+	var imported, skipped, failed int
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || model.ValidatePasteID(model.PasteIDFromString(info.Name())) != nil {
+			fmt.Fprintf(os.Stderr, "%s: not a paste; ignoring it\n", info.Name())
+			continue
+		}
+
+		p, body, err := readLegacyPaste(filepath.Join(dir, info.Name()), info)
+		if err == nil {
+			err = importer.ImportPaste(p)
+			body.Close()
+		}
+		switch err {
+		case nil:
+			imported++
+		case model.PasteIDUnavailableError:
+			skipped++
+		default:
+			fmt.Fprintf(os.Stderr, "paste %s: %v\n", info.Name(), err)
+			failed++
+		}
+	}
+
+	fmt.Printf("Imported %d pastes from %s; %d had been imported already.\n", imported, dir, skipped)
+	if failed > 0 {
+		return fmt.Errorf("%d pastes could not be imported; run import-legacy again once they have been seen to", failed)
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(&command{
+		Name:  "import-legacy",
+		Usage: "import-legacy [directory] (pastes kept by the filesystem paste store, in pastes under -root by default; can be run again)",
+		Run:   importLegacyCommand,
+	})
+}
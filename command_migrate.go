@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/DHowett/ghostbin/model"
+)
+
+func migrateCommand(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: %s", commands["migrate"].Usage)
+	}
+
+	target := -1
+	if len(args) == 2 {
+		var err error
+		target, err = strconv.Atoi(args[1])
+		if err != nil || target < 0 {
+			return fmt.Errorf("invalid schema version %q", args[1])
+		}
+	}
+
+	sqlDb, dialect, err := openDatabase(&arguments.db)
+	if err != nil {
+		return err
+	}
+	defer sqlDb.Close()
+
+	migrator, err := model.NewSchemaMigrator(dialect, sqlDb)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		if target == -1 {
+			target = 0 // latest
+		}
+		err = migrator.Up(target)
+	case "down":
+		if target == -1 {
+			var version int
+			version, err = migrator.Version()
+			if err != nil {
+				return err
+			}
+			if version == 0 {
+				return nil
+			}
+			target = version - 1
+		}
+		err = migrator.Down(target)
+	case "status":
+		return printSchemaStatus(migrator)
+	default:
+		return fmt.Errorf("unknown migrate action %q", args[0])
+	}
+
+	if err != nil {
+		return err
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Database schema is at version %d.\n", version)
+	return nil
+}
+
+func printSchemaStatus(migrator *model.SchemaMigrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, s := range statuses {
+		state := "pending"
+		if s.Applied {
+			state = "applied " + s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		if s.Unknown {
+			state += " (unknown to this build)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, state)
+	}
+	w.Flush()
+
+	fmt.Printf("\nDatabase schema is at version %d; this build expects version %d.\n", version, model.LatestSchemaVersion())
+	return nil
+}
+
+func init() {
+	registerCommand(&command{
+		Name:  "migrate",
+		Usage: "migrate up [version] | down [version] | status",
+		Run:   migrateCommand,
+	})
+}
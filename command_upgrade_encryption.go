@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/DHowett/ghostbin/model"
+)
+
+func upgradeEncryptionCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: %s", commands["upgrade-encryption"].Usage)
+	}
+
+	broker, err := openDatabaseBroker(&arguments.db)
+	if err != nil {
+		return err
+	}
+	upgrader, ok := broker.(model.PasteEncryptionUpgrader)
+	if !ok {
+		return fmt.Errorf("pastes can't be re-encrypted in the %s dialect", arguments.db.Dialect)
+	}
+
+	n, err := upgrader.UpgradePasteEncryption(CURRENT_ENCRYPTION_METHOD)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Marked %d encrypted pastes to be re-encrypted the next time they are unlocked.\n", n)
+	return nil
+}
+
+func init() {
+	registerCommand(&command{
+		Name:  "upgrade-encryption",
+		Usage: "upgrade-encryption (re-encrypt pastes with older encryption methods, older -kdf settings or titles in the clear as their passwords are next given; can be run again)",
+		Run:   upgradeEncryptionCommand,
+	})
+}
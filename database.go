@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DHowett/ghostbin/lib/crypto"
+	"github.com/DHowett/ghostbin/model"
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+)
+
+// databaseConfig describes the database that backs the model broker.
+// It is populated from the -db-* flags and the "database" section of the
+// configuration file; flags given on the command line take precedence.
+type databaseConfig struct {
+	Dialect         string        `yaml:"dialect"`
+	DSN             string        `yaml:"dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	Log             string        `yaml:"log"`
+	ManualMigration bool          `yaml:"manual_migration"`
+
+	// set holds the settings given in the configuration file, so that one given as zero
+	// isn't taken for one left out.
+	set map[string]bool
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, recording which settings were given.
+func (c *databaseConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain databaseConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	var keys map[string]interface{}
+	if err := unmarshal(&keys); err != nil {
+		return err
+	}
+	c.set = make(map[string]bool, len(keys))
+	for k := range keys {
+		c.set[k] = true
+	}
+	return nil
+}
+
+func (c *databaseConfig) register() {
+	flag.StringVar(&c.Dialect, "db-dialect", "sqlite3", "database dialect (sqlite3, postgres, mysql, or memory to keep nothing once ghostbin exits)")
+	flag.StringVar(&c.DSN, "db-dsn", "", "database connection string (default: ghostbin.db under -root for sqlite3)")
+	flag.IntVar(&c.MaxOpenConns, "db-max-open-conns", 0, "maximum number of open database connections (0 is unlimited)")
+	flag.IntVar(&c.MaxIdleConns, "db-max-idle-conns", 2, "maximum number of idle database connections")
+	flag.DurationVar(&c.ConnMaxLifetime, "db-conn-max-lifetime", 0, "maximum lifetime of a database connection (0 is forever)")
+	flag.StringVar(&c.Log, "db-log", "errors", "database logging (none, errors, all)")
+	flag.BoolVar(&c.ManualMigration, "db-manual-migration", false, "refuse to start on an outdated schema instead of migrating it (see the migrate command)")
+}
+
+// merge fills in every setting that was not given explicitly on the command line from o.
+func (c *databaseConfig) merge(o *databaseConfig, explicit map[string]bool) {
+	if o.Dialect != "" && !explicit["db-dialect"] {
+		c.Dialect = o.Dialect
+	}
+	if o.DSN != "" && !explicit["db-dsn"] {
+		c.DSN = o.DSN
+	}
+	if o.set["max_open_conns"] && !explicit["db-max-open-conns"] {
+		c.MaxOpenConns = o.MaxOpenConns
+	}
+	if o.set["max_idle_conns"] && !explicit["db-max-idle-conns"] {
+		c.MaxIdleConns = o.MaxIdleConns
+	}
+	if o.set["conn_max_lifetime"] && !explicit["db-conn-max-lifetime"] {
+		c.ConnMaxLifetime = o.ConnMaxLifetime
+	}
+	if o.Log != "" && !explicit["db-log"] {
+		c.Log = o.Log
+	}
+	if o.ManualMigration && !explicit["db-manual-migration"] {
+		c.ManualMigration = o.ManualMigration
+	}
+}
+
+var databaseLogLevels = map[string]model.DatabaseLogLevel{
+	"none":   model.DatabaseLogNone,
+	"errors": model.DatabaseLogErrors,
+	"all":    model.DatabaseLogAll,
+}
+
+func canonicalDatabaseDialect(dialect string) (string, error) {
+	switch strings.ToLower(dialect) {
+	case "sqlite3", "sqlite":
+		return "sqlite3", nil
+	case "postgres", "pgsql", "postgresql":
+		return "postgres", nil
+	case "mysql", "mariadb":
+		return "mysql", nil
+	}
+	return "", fmt.Errorf("unsupported database dialect %q", dialect)
+}
+
+// mysqlDSN adjusts a MySQL data source so that times are scanned into time.Time in UTC
+// and text is exchanged in utf8mb4.
+func mysqlDSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	if cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+	if _, ok := cfg.Params["charset"]; !ok {
+		cfg.Params["charset"] = "utf8mb4"
+	}
+	return cfg.FormatDSN(), nil
+}
+
+// openDatabase connects to the configured database and verifies that it is reachable.
+// The returned dialect is the canonical name for the driver in use.
+func openDatabase(c *databaseConfig) (*sql.DB, string, error) {
+	dialect, err := canonicalDatabaseDialect(c.Dialect)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dsn := c.DSN
+	if dsn == "" {
+		if dialect != "sqlite3" {
+			return nil, "", fmt.Errorf("a data source (-db-dsn) is required for the %s dialect", dialect)
+		}
+		dsn = filepath.Join(arguments.root, "ghostbin.db")
+	}
+
+	if dialect == "mysql" {
+		if dsn, err = mysqlDSN(dsn); err != nil {
+			return nil, "", fmt.Errorf("invalid mysql data source: %v", err)
+		}
+	}
+
+	sqlDb, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sqlDb.SetMaxOpenConns(c.MaxOpenConns)
+	sqlDb.SetMaxIdleConns(c.MaxIdleConns)
+	sqlDb.SetConnMaxLifetime(c.ConnMaxLifetime)
+
+	if err := sqlDb.Ping(); err != nil {
+		sqlDb.Close()
+		return nil, "", fmt.Errorf("failed to connect to %s database: %v", dialect, err)
+	}
+
+	glog.Infof("Connected to %s database.", dialect)
+	return sqlDb, dialect, nil
+}
+
+func openDatabaseBroker(c *databaseConfig) (model.Broker, error) {
+	pasteIDs, err := arguments.pasteIDs.generator()
+	if err != nil {
+		return nil, err
+	}
+
+	kdf, err := crypto.ParseKDF(arguments.kdf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key derivation function: %v", err)
+	}
+
+	if strings.ToLower(c.Dialect) == "memory" {
+		glog.Warning("Pastes and users are kept in memory, and will be lost when ghostbin exits.")
+		return model.NewMemoryBroker(&AuthChallengeProvider{kdf}, model.MemoryBrokerPasteIDs(pasteIDs), model.MemoryBrokerPasteKDF(kdf))
+	}
+
+	logLevel, ok := databaseLogLevels[strings.ToLower(c.Log)]
+	if !ok {
+		return nil, fmt.Errorf("invalid database log level %q", c.Log)
+	}
+
+	compression, err := model.ParsePasteCompressionMethod(arguments.bodies.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDb, dialect, err := openDatabase(c)
+	if err != nil {
+		return nil, err
+	}
+
+	bodies, err := openPasteBodyStore(arguments.bodies.Backend, &arguments.bodies, dialect, sqlDb)
+	if err != nil {
+		sqlDb.Close()
+		return nil, err
+	}
+
+	broker, err := model.NewDatabaseBroker(dialect, sqlDb, &AuthChallengeProvider{kdf},
+		model.DatabaseBrokerLogLevel(logLevel),
+		model.DatabaseBrokerManualMigration(c.ManualMigration),
+		model.DatabaseBrokerBodyStore(bodies),
+		model.DatabaseBrokerCompression(compression),
+		model.DatabaseBrokerPasteKDF(kdf),
+		model.DatabaseBrokerPasteIDs(pasteIDs))
+	if err != nil {
+		sqlDb.Close()
+		return nil, err
+	}
+	return broker, nil
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"io/ioutil"
+
 	"github.com/DHowett/ghostbin/lib/formatting"
 	"github.com/DHowett/ghostbin/model"
 )
@@ -8,7 +11,13 @@ import (
 func FormatPaste(p model.Paste) (string, error) {
 	reader, _ := p.Reader()
 	defer reader.Close()
-	return formatting.FormatStream(reader, formatting.LanguageNamed(p.GetLanguageName()))
+	// The body is read in full before it is formatted, so that one that can't be read is never
+	// formatted in part.
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return formatBody(bytes.NewReader(body), formatting.LanguageNamed(p.GetLanguageName()), p.IsBundle())
 }
 
 func _initLanguages() error {
@@ -0,0 +1,26 @@
+package main
+
+import "syscall"
+
+// getxattr returns the extended attribute called name on path, or nil if there is none.
+func getxattr(path, name string) ([]byte, error) {
+	for {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err == syscall.ENODATA || err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := syscall.Getxattr(path, name, buf)
+		if err == syscall.ERANGE {
+			// It grew in between.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// getxattr is only implemented on Linux, where the filesystem paste store ran.
+func getxattr(path, name string) ([]byte, error) {
+	return nil, errors.New("extended attributes can only be read on Linux")
+}
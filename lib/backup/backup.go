@@ -0,0 +1,243 @@
+// Package backup reads and writes the archives an instance is exported to. An archive is a
+// gzipped tarball of named entries followed by a manifest that records the length and
+// SHA-256 of each entry, and how many lines it holds, so that an archive can be checked as it
+// is read back.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/DHowett/ghostbin/lib/spool"
+)
+
+// FormatVersion is the version of the archive layout written by this package.
+const FormatVersion = 1
+
+// ManifestName is the name of the manifest, the last entry in every archive.
+const ManifestName = "manifest.json"
+
+// spoolMemory is how much of an entry is held in memory while its length is learned.
+const spoolMemory = 1024 * 1024
+
+// ErrNoManifest is returned when an archive ends without a manifest.
+var ErrNoManifest = errors.New("backup: archive has no manifest; it may be truncated")
+
+// Entry describes one entry in an archive.
+type Entry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Lines counts the newlines in the entry, which is the number of records in a JSON
+	// lines entry.
+	Lines int64 `json:"lines"`
+}
+
+// Manifest describes a whole archive.
+type Manifest struct {
+	Format  int       `json:"format"`
+	Created time.Time `json:"created"`
+	Entries []Entry   `json:"entries"`
+}
+
+// CorruptError is returned when an archive doesn't match its manifest.
+type CorruptError struct {
+	Name   string
+	Reason string
+}
+
+func (e CorruptError) Error() string {
+	return fmt.Sprintf("backup: entry %s is corrupt: %s", e.Name, e.Reason)
+}
+
+// entryDigest measures an entry as it passes through.
+type entryDigest struct {
+	hash  hash.Hash
+	size  int64
+	lines int64
+}
+
+func newEntryDigest() *entryDigest {
+	return &entryDigest{hash: sha256.New()}
+}
+
+func (d *entryDigest) Write(b []byte) (int, error) {
+	d.hash.Write(b)
+	d.size += int64(len(b))
+	d.lines += int64(bytes.Count(b, []byte{'\n'}))
+	return len(b), nil
+}
+
+func (d *entryDigest) entry(name string) Entry {
+	return Entry{Name: name, Size: d.size, SHA256: hex.EncodeToString(d.hash.Sum(nil)), Lines: d.lines}
+}
+
+// Writer writes an archive.
+type Writer struct {
+	zw       *gzip.Writer
+	tw       *tar.Writer
+	manifest Manifest
+}
+
+// NewWriter returns a Writer that writes an archive to w.
+func NewWriter(w io.Writer) *Writer {
+	zw := gzip.NewWriter(w)
+	return &Writer{
+		zw:       zw,
+		tw:       tar.NewWriter(zw),
+		manifest: Manifest{Format: FormatVersion, Created: time.Now().UTC()},
+	}
+}
+
+// Add adds everything read from r to the archive as an entry called name.
+func (w *Writer) Add(name string, r io.Reader) error {
+	if name == ManifestName {
+		return errors.New("backup: " + name + " is reserved")
+	}
+
+	// Tar needs to know how long an entry is before it is written.
+	s := spool.New(spoolMemory)
+	defer s.Close()
+	d := newEntryDigest()
+	if _, err := io.Copy(io.MultiWriter(s, d), r); err != nil {
+		return err
+	}
+	if err := w.write(name, d.size, s.Reader()); err != nil {
+		return err
+	}
+	w.manifest.Entries = append(w.manifest.Entries, d.entry(name))
+	return nil
+}
+
+func (w *Writer) write(name string, size int64, r io.Reader) error {
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: w.manifest.Created,
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(w.tw, r)
+	return err
+}
+
+// Close writes the manifest and finishes the archive. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	manifest, err := json.MarshalIndent(&w.manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := w.write(ManifestName, int64(len(manifest)), bytes.NewReader(manifest)); err != nil {
+		return err
+	}
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+// Reader reads an archive, checking each entry against the manifest once it has been read.
+type Reader struct {
+	tr *tar.Reader
+
+	name   string
+	digest *entryDigest
+	seen   []Entry
+
+	manifest *Manifest
+}
+
+// NewReader returns a Reader for the archive read from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{tr: tar.NewReader(zr)}, nil
+}
+
+// finish measures what is left of the current entry.
+func (r *Reader) finish() error {
+	if r.digest == nil {
+		return nil
+	}
+	if _, err := io.Copy(r.digest, r.tr); err != nil {
+		return err
+	}
+	r.seen = append(r.seen, r.digest.entry(r.name))
+	r.digest = nil
+	return nil
+}
+
+// Next returns the name of the next entry in the archive and a reader for its content, which
+// is valid until the following call to Next. Once every entry has been read and checked
+// against the manifest, Next returns io.EOF. An entry that doesn't match the manifest is
+// reported by a CorruptError from the call to Next that follows the last entry.
+func (r *Reader) Next() (string, io.Reader, error) {
+	if r.manifest != nil {
+		return "", nil, io.EOF
+	}
+	if err := r.finish(); err != nil {
+		return "", nil, err
+	}
+
+	h, err := r.tr.Next()
+	if err == io.EOF {
+		return "", nil, ErrNoManifest
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	if h.Name == ManifestName {
+		if err := r.readManifest(); err != nil {
+			return "", nil, err
+		}
+		return "", nil, io.EOF
+	}
+
+	r.name = h.Name
+	r.digest = newEntryDigest()
+	return h.Name, io.TeeReader(r.tr, r.digest), nil
+}
+
+func (r *Reader) readManifest() error {
+	data, err := ioutil.ReadAll(r.tr)
+	if err != nil {
+		return err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return CorruptError{Name: ManifestName, Reason: err.Error()}
+	}
+	if m.Format != FormatVersion {
+		return fmt.Errorf("backup: archive format %d is not supported", m.Format)
+	}
+
+	if len(m.Entries) != len(r.seen) {
+		return CorruptError{Name: ManifestName, Reason: fmt.Sprintf("it lists %d entries, but the archive holds %d", len(m.Entries), len(r.seen))}
+	}
+	for i, e := range m.Entries {
+		if r.seen[i] != e {
+			return CorruptError{Name: r.seen[i].Name, Reason: "it doesn't match the manifest"}
+		}
+	}
+	r.manifest = &m
+	return nil
+}
+
+// Manifest returns the archive's manifest once Next has returned io.EOF, or nil before.
+func (r *Reader) Manifest() *Manifest {
+	return r.manifest
+}
@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var testEntries = []struct {
+	name, content string
+}{
+	{"db/pastes.jsonl", "{\"ID\":\"a\"}\n{\"ID\":\"b\"}\n"},
+	{"bodies/a", "\x00binary\xff"},
+	{"files/empty", ""},
+}
+
+func writeArchive(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	for _, e := range testEntries {
+		if err := w.Add(e.name, strings.NewReader(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readArchive reads every entry in data, returning the error that ended it.
+func readArchive(data []byte) (map[string]string, *Reader, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	entries := make(map[string]string)
+	for {
+		name, content, err := r.Next()
+		if err != nil {
+			return entries, r, err
+		}
+		b, err := ioutil.ReadAll(content)
+		if err != nil {
+			return entries, r, err
+		}
+		entries[name] = string(b)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	entries, r, err := readArchive(writeArchive(t))
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	for _, e := range testEntries {
+		if entries[e.name] != e.content {
+			t.Errorf("read <%q> from %s; wrote <%q>", entries[e.name], e.name, e.content)
+		}
+	}
+
+	m := r.Manifest()
+	if m == nil || len(m.Entries) != len(testEntries) {
+		t.Fatalf("manifest is %+v", m)
+	}
+	if m.Entries[0].Lines != 2 || m.Entries[1].Size != 8 {
+		t.Errorf("manifest entries are %+v", m.Entries)
+	}
+}
+
+func TestUnreadEntriesAreChecked(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(writeArchive(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, _, err = r.Next(); err != nil {
+			break
+		}
+	}
+	if err != io.EOF {
+		t.Error(err)
+	}
+}
+
+// tamper rewrites the tarball inside an archive.
+func tamper(t *testing.T, data []byte, f func([]byte) []byte) []byte {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarball, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Write(f(tarball))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestCorruptEntry(t *testing.T) {
+	data := tamper(t, writeArchive(t), func(tarball []byte) []byte {
+		return bytes.Replace(tarball, []byte("binary"), []byte("BINARY"), 1)
+	})
+	_, _, err := readArchive(data)
+	if cerr, ok := err.(CorruptError); !ok || cerr.Name != "bodies/a" {
+		t.Errorf("read a corrupt archive with %v", err)
+	}
+}
+
+func TestTruncatedArchive(t *testing.T) {
+	data := tamper(t, writeArchive(t), func(tarball []byte) []byte {
+		i := bytes.Index(tarball, []byte(ManifestName))
+		// Keep the entries before the manifest's header, and end the tarball there.
+		return append(tarball[:i:i], make([]byte, 1024)...)
+	})
+	if _, _, err := readArchive(data); err != ErrNoManifest {
+		t.Errorf("read a truncated archive with %v", err)
+	}
+}
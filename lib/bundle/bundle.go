@@ -0,0 +1,129 @@
+// Package bundle stores several named files in one stream, so that a single paste body can
+// carry all of them. A bundle is a MIME multipart document whose boundary starts with a fixed
+// marker; each part holds one file, along with its name and language.
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+)
+
+// boundaryMarker begins the boundary of every bundle, and so every bundle.
+const boundaryMarker = "ghostbin-bundle-"
+
+// maxBoundaryLength is the longest boundary RFC 2046 allows.
+const maxBoundaryLength = 70
+
+const languageHeader = "Language"
+
+// HeadLength is how much of the start of a stream IsBundle needs to see.
+const HeadLength = 2 + 2 + len(boundaryMarker)
+
+// ErrNotBundle is returned by NewReader when a stream is not a bundle.
+var ErrNotBundle = errors.New("bundle: not a bundle")
+
+// ErrInvalidName is returned when a file can't be stored under the name it was given.
+var ErrInvalidName = errors.New("bundle: invalid file name")
+
+// File describes one file in a bundle.
+type File struct {
+	Name     string
+	Language string
+}
+
+// IsBundle reports whether a stream that begins with head is a bundle. head must hold at
+// least the first HeadLength bytes of the stream.
+func IsBundle(head []byte) bool {
+	return bytes.HasPrefix(trimEmptyBundle(head), []byte("--"+boundaryMarker))
+}
+
+// trimEmptyBundle removes the line break that starts a bundle with no files in it.
+func trimEmptyBundle(head []byte) []byte {
+	return bytes.TrimPrefix(head, []byte("\r\n"))
+}
+
+// Writer writes files to a bundle.
+type Writer struct {
+	mw *multipart.Writer
+}
+
+// NewWriter returns a Writer that writes a bundle to w.
+func NewWriter(w io.Writer) *Writer {
+	mw := multipart.NewWriter(w)
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err == nil {
+		mw.SetBoundary(boundaryMarker + hex.EncodeToString(random))
+	} else {
+		// The boundary multipart chose is random too; it just lacks the marker.
+		mw.SetBoundary(boundaryMarker + mw.Boundary()[:maxBoundaryLength-len(boundaryMarker)])
+	}
+	return &Writer{mw: mw}
+}
+
+// CreateFile adds f to the bundle, returning a writer for its content. The content must be
+// written before the next call to CreateFile or Close.
+func (w *Writer) CreateFile(f File) (io.Writer, error) {
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": f.Name})
+	if f.Name == "" || disposition == "" {
+		return nil, ErrInvalidName
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", disposition)
+	h.Set("Content-Type", "application/octet-stream")
+	if f.Language != "" {
+		h.Set(languageHeader, f.Language)
+	}
+	return w.mw.CreatePart(h)
+}
+
+// Close finishes the bundle. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	return w.mw.Close()
+}
+
+// Reader reads the files in a bundle.
+type Reader struct {
+	mr *multipart.Reader
+}
+
+// NewReader returns a Reader for the bundle read from r, or ErrNotBundle if r does not begin
+// with a bundle.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(2 + 2 + maxBoundaryLength + 2)
+	if !IsBundle(head) {
+		return nil, ErrNotBundle
+	}
+	head = trimEmptyBundle(head)
+	end := bytes.IndexByte(head, '\n')
+	if end < 0 {
+		return nil, ErrNotBundle
+	}
+	// An empty bundle's only line is its closing delimiter.
+	boundary := string(bytes.TrimSuffix(bytes.TrimRight(head[2:end], " \t\r"), []byte("--")))
+	return &Reader{mr: multipart.NewReader(br, boundary)}, nil
+}
+
+// Next returns the next file in the bundle and a reader for its content, which is valid
+// until the following call to Next. At the end of the bundle, Next returns io.EOF.
+func (r *Reader) Next() (*File, io.Reader, error) {
+	for {
+		part, err := r.mr.NextPart()
+		if err != nil {
+			return nil, nil, err
+		}
+		name := part.FileName()
+		if name == "" {
+			// Not a file; something newer may have put it there.
+			continue
+		}
+		return &File{Name: name, Language: part.Header.Get(languageHeader)}, part, nil
+	}
+}
@@ -0,0 +1,106 @@
+package bundle
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type testFile struct {
+	File
+	content string
+}
+
+func writeBundle(t *testing.T, files []testFile) []byte {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	for _, f := range files {
+		fw, err := w.CreateFile(f.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, f.content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readBundle(t *testing.T, data []byte) []testFile {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []testFile
+	for {
+		f, content, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, testFile{*f, string(b)})
+	}
+	return files
+}
+
+func TestRoundTrip(t *testing.T) {
+	files := []testFile{
+		{File{"config.yml", "yaml"}, "listen: :8080\n"},
+		{File{"trace.txt", "text"}, "panic: oh no\r\n\r\ngoroutine 1 [running]:\n"},
+		{File{"run.sh", ""}, "#!/bin/sh\n--ghostbin-bundle-0000\nexit 1"},
+		{File{"empty", "text"}, ""},
+		{File{"naïve \"name\".go", "go"}, "package main\n"},
+		{File{"binary.bin", ""}, "\x00\xff\r\n--\x00"},
+	}
+
+	data := writeBundle(t, files)
+	if !IsBundle(data) {
+		t.Fatal("a bundle wasn't recognized")
+	}
+
+	got := readBundle(t, data)
+	if len(got) != len(files) {
+		t.Fatalf("read %d files; wrote %d", len(got), len(files))
+	}
+	for i, f := range files {
+		if got[i] != f {
+			t.Errorf("file %d: read %+v; wrote %+v", i, got[i], f)
+		}
+	}
+}
+
+func TestEmptyBundle(t *testing.T) {
+	data := writeBundle(t, nil)
+	if got := readBundle(t, data); len(got) != 0 {
+		t.Errorf("read %d files from an empty bundle", len(got))
+	}
+}
+
+func TestNotBundle(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"hello, world",
+		"--ghostbin",
+		"--ghostbin-bundle-" + strings.Repeat("x", 100),
+	} {
+		if _, err := NewReader(strings.NewReader(s)); err != ErrNotBundle {
+			t.Errorf("NewReader(%q) = %v; want ErrNotBundle", s, err)
+		}
+	}
+}
+
+func TestInvalidName(t *testing.T) {
+	w := NewWriter(ioutil.Discard)
+	if _, err := w.CreateFile(File{Name: ""}); err != ErrInvalidName {
+		t.Errorf("CreateFile with no name = %v; want ErrInvalidName", err)
+	}
+}
@@ -1,7 +1,9 @@
 package crypto
 
 type ChallengeProvider interface {
-	DeriveKey(string, []byte) []byte
+	// KDF is what the keys of new challenges are derived with.
+	KDF() KDF
+	DeriveKey(string, []byte, KDF) []byte
 	RandomSalt() []byte
 	Challenge(message []byte, key []byte) []byte
 }
@@ -0,0 +1,121 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// A KDF derives keys from passwords. Its String names the function and its parameters, as in
+// "argon2id$t=3,m=65536,p=4", and is kept alongside the salt of whatever a key was derived
+// for, so that the parameters can change without stranding what was derived with the old ones.
+type KDF interface {
+	DeriveKey(password, salt []byte, keyLen int) ([]byte, error)
+	String() string
+}
+
+// Scrypt derives keys with scrypt, at a cost of N (a power of two), r and p.
+type Scrypt struct {
+	N, R, P int
+}
+
+func (k *Scrypt) DeriveKey(password, salt []byte, keyLen int) ([]byte, error) {
+	return scrypt.Key(password, salt, k.N, k.R, k.P, keyLen)
+}
+
+func (k *Scrypt) String() string {
+	return fmt.Sprintf("scrypt$n=%d,r=%d,p=%d", k.N, k.R, k.P)
+}
+
+// Argon2id derives keys with Argon2id, making Time passes over Memory KiB with Threads threads.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+func (k *Argon2id) DeriveKey(password, salt []byte, keyLen int) ([]byte, error) {
+	return argon2.IDKey(password, salt, k.Time, k.Memory, k.Threads, uint32(keyLen)), nil
+}
+
+func (k *Argon2id) String() string {
+	return fmt.Sprintf("argon2id$t=%d,m=%d,p=%d", k.Time, k.Memory, k.Threads)
+}
+
+// LegacyKDF is what every key was derived with before KDFs were recorded, and what the empty
+// string names.
+var LegacyKDF KDF = &Scrypt{N: 16384, R: 8, P: 1}
+
+// ParseKDF returns the KDF named by s, which is the String of a KDF or empty.
+func ParseKDF(s string) (KDF, error) {
+	if s == "" {
+		return LegacyKDF, nil
+	}
+
+	name, paramString := s, ""
+	if i := strings.IndexByte(s, '$'); i >= 0 {
+		name, paramString = s[:i], s[i+1:]
+	}
+	params := make(map[string]uint64)
+	for _, param := range strings.Split(paramString, ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("crypto: malformed KDF parameter %q", param)
+		}
+		v, err := strconv.ParseUint(kv[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("crypto: malformed KDF parameter %q", param)
+		}
+		params[kv[0]] = v
+	}
+	get := func(keys ...string) ([]uint64, error) {
+		if len(params) != len(keys) {
+			return nil, fmt.Errorf("crypto: %s takes the parameters %s", name, strings.Join(keys, ", "))
+		}
+		values := make([]uint64, len(keys))
+		for i, key := range keys {
+			v, ok := params[key]
+			if !ok {
+				return nil, fmt.Errorf("crypto: %s takes the parameters %s", name, strings.Join(keys, ", "))
+			}
+			values[i] = v
+		}
+		return values, nil
+	}
+
+	switch name {
+	case "scrypt":
+		v, err := get("n", "r", "p")
+		if err != nil {
+			return nil, err
+		}
+		k := &Scrypt{N: int(v[0]), R: int(v[1]), P: int(v[2])}
+		if k.N <= 1 || k.N&(k.N-1) != 0 {
+			return nil, errors.New("crypto: scrypt's n must be a power of two greater than 1")
+		}
+		if k.R < 1 || k.P < 1 || uint64(k.R)*uint64(k.P) >= 1<<30 {
+			return nil, errors.New("crypto: scrypt's r and p must be at least 1, and their product under 2^30")
+		}
+		return k, nil
+	case "argon2id":
+		v, err := get("t", "m", "p")
+		if err != nil {
+			return nil, err
+		}
+		if v[0] < 1 {
+			return nil, errors.New("crypto: argon2id's t must be at least 1")
+		}
+		if v[2] < 1 || v[2] > 255 {
+			return nil, errors.New("crypto: argon2id's p must be from 1 to 255")
+		}
+		if v[1] < 8*v[2] {
+			return nil, errors.New("crypto: argon2id's m must be at least 8 KiB for each of p")
+		}
+		return &Argon2id{Time: uint32(v[0]), Memory: uint32(v[1]), Threads: uint8(v[2])}, nil
+	}
+	return nil, fmt.Errorf("crypto: unknown KDF %q", name)
+}
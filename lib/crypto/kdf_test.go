@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestParseKDF(t *testing.T) {
+	for _, s := range []string{
+		"scrypt$n=16384,r=8,p=1",
+		"scrypt$n=2,r=1,p=1",
+		"argon2id$t=3,m=65536,p=4",
+		"argon2id$t=1,m=8,p=1",
+	} {
+		k, err := ParseKDF(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if k.String() != s {
+			t.Errorf("%s: parsed as %s", s, k)
+		}
+	}
+
+	if k, err := ParseKDF(""); err != nil || k != LegacyKDF {
+		t.Errorf("the empty string parsed as %v, %v; want the legacy KDF", k, err)
+	}
+
+	for _, s := range []string{
+		"scrypt",
+		"scrypt$",
+		"scrypt$n=16384,r=8",
+		"scrypt$n=16384,r=8,p=1,x=1",
+		"scrypt$n=16384,r=8,r=1",
+		"scrypt$n=16383,r=8,p=1",
+		"scrypt$n=1,r=8,p=1",
+		"scrypt$n=16384,r=0,p=1",
+		"scrypt$n=16384,r=8,p=-1",
+		"argon2id$t=0,m=65536,p=4",
+		"argon2id$t=3,m=65536,p=0",
+		"argon2id$t=3,m=65536,p=256",
+		"argon2id$t=3,m=31,p=4",
+		"argon2id$t=3,m=65536,p=4$",
+		"bcrypt$cost=10",
+	} {
+		if k, err := ParseKDF(s); err == nil {
+			t.Errorf("%s: parsed as %s", s, k)
+		}
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	password, salt := []byte("password"), []byte("salt")
+
+	key, err := LegacyKDF.DeriveKey(password, salt, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keys derived before KDFs were recorded must still be derived alike.
+	want, _ := scrypt.Key(password, salt, 16384, 8, 1, 32)
+	if !bytes.Equal(key, want) {
+		t.Error("the legacy KDF derived a different key from the one scrypt always did")
+	}
+
+	kdfs := []KDF{&Scrypt{N: 1024, R: 8, P: 1}, &Argon2id{Time: 1, Memory: 64, Threads: 1}, &Argon2id{Time: 2, Memory: 64, Threads: 1}}
+	keys := make([][]byte, len(kdfs))
+	for i, k := range kdfs {
+		if keys[i], err = k.DeriveKey(password, salt, 32); err != nil {
+			t.Fatalf("%s: %v", k, err)
+		}
+		if len(keys[i]) != 32 {
+			t.Errorf("%s: derived a key %d bytes long", k, len(keys[i]))
+		}
+		if again, _ := k.DeriveKey(password, salt, 32); !bytes.Equal(again, keys[i]) {
+			t.Errorf("%s: derived different keys from the same password", k)
+		}
+		if other, _ := k.DeriveKey([]byte("passw0rd"), salt, 32); bytes.Equal(other, keys[i]) {
+			t.Errorf("%s: derived the same key from different passwords", k)
+		}
+	}
+	if bytes.Equal(keys[1], keys[2]) {
+		t.Error("argon2id derived the same key at different costs")
+	}
+}
@@ -0,0 +1,234 @@
+// Package diff produces line-oriented unified diffs.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxEditDistance bounds the work done to find a minimal diff; inputs that differ by more
+// lines than this are reported as a wholesale replacement.
+const maxEditDistance = 4096
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+type op struct {
+	kind opKind
+	a, b int // line indices into the old and new inputs
+}
+
+// SplitLines splits s into lines, each retaining its trailing newline.
+func SplitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// editScript computes a minimal sequence of operations transforming a into b using
+// Myers' O(ND) algorithm.
+func editScript(a, b []string) []op {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > maxEditDistance {
+		max = maxEditDistance
+	}
+
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		snapshot := make([]int, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+			}
+		}
+		copy(snapshot, v[offset-d:offset+d+1])
+		trace = append(trace, snapshot)
+	}
+
+	if !found {
+		ops := make([]op, 0, n+m)
+		for i := range a {
+			ops = append(ops, op{opDelete, i, 0})
+		}
+		for j := range b {
+			ops = append(ops, op{opInsert, n, j})
+		}
+		return ops
+	}
+
+	// Walk the trace backwards to recover the path.
+	var ops []op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		k := x - y
+		var prevK int
+		if d == 0 {
+			prevK = 0
+		} else {
+			prev := trace[d-1]
+			at := func(k int) int { return prev[k+d-1] }
+			if k == -d || (k != d && at(k-1) < at(k+1)) {
+				prevK = k + 1
+			} else {
+				prevK = k - 1
+			}
+		}
+
+		var prevX int
+		if d == 0 {
+			prevX = 0
+		} else {
+			prevX = trace[d-1][prevK+d-1]
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, op{opEqual, x, y})
+		}
+
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, op{opInsert, x, y})
+			} else {
+				x--
+				ops = append(ops, op{opDelete, x, y})
+			}
+		}
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+func writeLine(w io.Writer, prefix byte, line string) {
+	io.WriteString(w, string(prefix))
+	io.WriteString(w, line)
+	if !strings.HasSuffix(line, "\n") {
+		io.WriteString(w, "\n\\ No newline at end of file\n")
+	}
+}
+
+// hunkRange formats a unified diff range: the 1-based first line and the line count.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// Unified writes a unified diff with the given number of context lines, transforming
+// the text a (named aName) into b (named bName), to w.
+// Nothing is written when the inputs are equal.
+func Unified(w io.Writer, aName, bName, a, b string, context int) error {
+	aLines, bLines := SplitLines(a), SplitLines(b)
+	ops := editScript(aLines, bLines)
+
+	buf := &bytes.Buffer{}
+	wroteHeader := false
+	for i := 0; i < len(ops); {
+		// Find the next change.
+		for i < len(ops) && ops[i].kind == opEqual {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk while changes are separated by no more than 2*context equal lines.
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != opEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == opEqual {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				end += context
+				if end > run {
+					end = run
+				}
+				break
+			}
+			end = run
+		}
+
+		aStart, bStart := ops[start].a, ops[start].b
+		aCount, bCount := 0, 0
+		for _, o := range ops[start:end] {
+			switch o.kind {
+			case opEqual:
+				aCount++
+				bCount++
+			case opDelete:
+				aCount++
+			case opInsert:
+				bCount++
+			}
+		}
+
+		if !wroteHeader {
+			fmt.Fprintf(buf, "--- %s\n+++ %s\n", aName, bName)
+			wroteHeader = true
+		}
+		fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, o := range ops[start:end] {
+			switch o.kind {
+			case opEqual:
+				writeLine(buf, ' ', aLines[o.a])
+			case opDelete:
+				writeLine(buf, '-', aLines[o.a])
+			case opInsert:
+				writeLine(buf, '+', bLines[o.b])
+			}
+		}
+		i = end
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,70 @@
+package diff
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		context  int
+		expected string
+	}{
+		{
+			name:     "Equal",
+			a:        "a\nb\n",
+			b:        "a\nb\n",
+			context:  3,
+			expected: "",
+		},
+		{
+			name:     "Create",
+			a:        "",
+			b:        "a\nb\n",
+			context:  3,
+			expected: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:     "Change",
+			a:        "a\nb\nc\n",
+			b:        "a\nx\nc\n",
+			context:  3,
+			expected: "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name:     "Insert",
+			a:        "1\n2\n3\n4\n5\n6\n",
+			b:        "1\n2\n3\n3.5\n4\n5\n6\n",
+			context:  1,
+			expected: "--- a\n+++ b\n@@ -3,2 +3,3 @@\n 3\n+3.5\n 4\n",
+		},
+		{
+			name:     "TwoHunks",
+			a:        "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:        "0\n2\n3\n4\n5\n6\n7\n9\n",
+			context:  1,
+			expected: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n-1\n+0\n 2\n@@ -7,2 +7,2 @@\n 7\n-8\n+9\n",
+		},
+		{
+			name:     "NoTrailingNewline",
+			a:        "a",
+			b:        "b",
+			context:  3,
+			expected: "--- a\n+++ b\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := Unified(buf, "a", "b", test.a, test.b, test.context); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.expected {
+				t.Errorf("expected\n%s\ngot\n%s", test.expected, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Package objectstore implements a minimal client for S3-compatible object stores.
+//
+// Only the operations needed to keep whole objects are supported: Get, Put and Delete.
+// Requests use path-style addressing and are signed with AWS Signature Version 4, which
+// is accepted by S3 itself as well as by most compatible stores (MinIO, Ceph, etc.).
+package objectstore
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("objectstore: object not found")
+
+// ResponseError is returned when the object store rejects a request.
+type ResponseError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("objectstore: unexpected status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("objectstore: %s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// Client stores objects in a single bucket.
+type Client struct {
+	// Endpoint is the base URL of the object store, such as https://s3.amazonaws.com.
+	Endpoint string
+	Bucket   string
+	// Region defaults to us-east-1, which most compatible stores expect.
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	now func() time.Time
+}
+
+func (c *Client) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.Bucket + "/" + key
+	u.RawPath = ""
+	return u, nil
+}
+
+func (c *Client) do(method, key string, body []byte) (*http.Response, error) {
+	u, err := c.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = nil
+		req.ContentLength = 0
+	}
+
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	c.sign(req, body, now())
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// responseError describes a failed request. A missing object is reported as ErrNotFound,
+// but a missing bucket is not.
+func responseError(resp *http.Response) error {
+	rerr := &ResponseError{StatusCode: resp.StatusCode}
+	var body struct {
+		Code    string
+		Message string
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if xml.Unmarshal(data, &body) == nil {
+		rerr.Code, rerr.Message = body.Code, body.Message
+	}
+
+	if rerr.StatusCode == http.StatusNotFound && (rerr.Code == "" || rerr.Code == "NoSuchKey") {
+		return ErrNotFound
+	}
+	return rerr
+}
+
+// Get returns the contents of the object named by key, or ErrNotFound.
+func (c *Client) Get(key string) (io.ReadCloser, error) {
+	resp, err := c.do("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp.Body, nil
+}
+
+// Put stores data as the object named by key, replacing it if it exists.
+func (c *Client) Put(key string, data []byte) error {
+	if data == nil {
+		data = []byte{}
+	}
+	resp, err := c.do("PUT", key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// Delete removes the object named by key. Deleting an object that does not exist is not an error.
+func (c *Client) Delete(key string) error {
+	resp, err := c.do("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		if err := responseError(resp); err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package objectstore_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/DHowett/ghostbin/lib/objectstore"
+	"github.com/DHowett/ghostbin/lib/objectstore/objectstoretest"
+)
+
+func TestClient(t *testing.T) {
+	s := objectstoretest.NewServer("bodies")
+	defer s.Close()
+	c := s.Client()
+
+	if _, err := c.Get("missing"); err != objectstore.ErrNotFound {
+		t.Errorf("got %v reading a missing object", err)
+	}
+
+	if err := c.Put("a/b c", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Get("a/b c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(data) != "hello" {
+		t.Errorf("read back <%s>", data)
+	}
+
+	if err := c.Delete("a/b c"); err != nil {
+		t.Error(err)
+	}
+	if err := c.Delete("a/b c"); err != nil {
+		t.Error("deleting a missing object:", err)
+	}
+	if _, err := c.Get("a/b c"); err != objectstore.ErrNotFound {
+		t.Errorf("got %v reading a deleted object", err)
+	}
+
+	c.Bucket = "other"
+	err = c.Put("x", nil)
+	if rerr, ok := err.(*objectstore.ResponseError); !ok || rerr.StatusCode != http.StatusNotFound || rerr.Code != "NoSuchBucket" {
+		t.Errorf("got %v writing to a missing bucket", err)
+	}
+}
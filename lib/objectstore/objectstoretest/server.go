@@ -0,0 +1,98 @@
+// Package objectstoretest provides an in-memory stand-in for an S3-compatible object store.
+package objectstoretest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/DHowett/ghostbin/lib/objectstore"
+)
+
+// Server keeps the objects of a single bucket in memory. It checks that requests are
+// signed, but not that their signatures are valid.
+type Server struct {
+	*httptest.Server
+	Bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// NewServer starts a stand-in serving bucket. Callers should Close it when finished.
+func NewServer(bucket string) *Server {
+	s := &Server{
+		Bucket:  bucket,
+		objects: make(map[string][]byte),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Client returns a client for the stand-in's bucket.
+func (s *Server) Client() *objectstore.Client {
+	return &objectstore.Client{
+		Endpoint:  s.URL,
+		Bucket:    s.Bucket,
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "secret",
+	}
+}
+
+// Objects returns the keys of every stored object.
+func (s *Server) Objects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte("<Error><Code>" + code + "</Code><Message>" + message + "</Message></Error>"))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		writeError(w, http.StatusForbidden, "AccessDenied", "Request was not signed.")
+		return
+	}
+
+	prefix := "/" + s.Bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case "GET":
+		data, ok := s.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		w.Write(data)
+	case "PUT":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		s.objects[key] = data
+	case "DELETE":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed.")
+	}
+}
@@ -0,0 +1,81 @@
+// Package spool collects data of unknown length so that it can be read back, possibly more
+// than once, without holding all of it in memory.
+package spool
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Spool keeps what is written to it in memory until it outgrows its memory limit, and in a
+// temporary file after that. The file is unlinked as soon as it is created, so nothing is
+// left behind even if the spool is never closed.
+type Spool struct {
+	memoryLimit int
+	buf         bytes.Buffer
+	file        *os.File
+	size        int64
+}
+
+// New returns an empty spool that will hold up to memoryLimit bytes in memory.
+func New(memoryLimit int) *Spool {
+	return &Spool{memoryLimit: memoryLimit}
+}
+
+func (s *Spool) spill() error {
+	f, err := ioutil.TempFile("", "spool-")
+	if err != nil {
+		return err
+	}
+	os.Remove(f.Name())
+
+	if _, err := f.Write(s.buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	s.buf = bytes.Buffer{}
+	s.file = f
+	return nil
+}
+
+func (s *Spool) Write(p []byte) (int, error) {
+	if s.file == nil && s.buf.Len()+len(p) > s.memoryLimit {
+		if err := s.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if s.file != nil {
+		n, err = s.file.WriteAt(p, s.size)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// Len returns the number of bytes written to the spool.
+func (s *Spool) Len() int64 {
+	return s.size
+}
+
+// Reader returns a reader over everything written to the spool so far.
+func (s *Spool) Reader() io.Reader {
+	if s.file == nil {
+		return bytes.NewReader(s.buf.Bytes())
+	}
+	return io.NewSectionReader(s.file, 0, s.size)
+}
+
+// Close releases the spool's temporary file, if it has one.
+func (s *Spool) Close() error {
+	s.buf = bytes.Buffer{}
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package spool
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, s *Spool) string {
+	data, err := ioutil.ReadAll(s.Reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSpool(t *testing.T) {
+	s := New(8)
+	defer s.Close()
+
+	s.Write([]byte("hello"))
+	if s.file != nil {
+		t.Error("spilled to a file while under the memory limit")
+	}
+	if got := readAll(t, s); got != "hello" {
+		t.Errorf("read <%s> from memory", got)
+	}
+
+	s.Write([]byte(", world"))
+	if s.file == nil {
+		t.Error("didn't spill to a file past the memory limit")
+	}
+	if got := readAll(t, s); got != "hello, world" {
+		t.Errorf("read <%s> from the file", got)
+	}
+
+	// Reading doesn't disturb where the next write goes, and the spool can be read again.
+	s.Write([]byte(strings.Repeat("!", 3)))
+	if got := readAll(t, s); got != "hello, world!!!" {
+		t.Errorf("read <%s> after a further write", got)
+	}
+	if s.Len() != 15 {
+		t.Errorf("spool reports length %d", s.Len())
+	}
+}
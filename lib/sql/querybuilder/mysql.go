@@ -0,0 +1,93 @@
+package querybuilder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type mysqlQueryBuilder struct{}
+
+func (b *mysqlQueryBuilder) escapeTable(table string) string {
+	return "`" + table + "`"
+}
+
+func (b *mysqlQueryBuilder) escapeField(field string) string {
+	return "`" + field + "`"
+}
+
+func (b *mysqlQueryBuilder) placeholder(n int) string {
+	return "?"
+}
+
+func (b *mysqlQueryBuilder) limitOffset(limit, offset int) string {
+	if offset == 0 {
+		return fmt.Sprintf("LIMIT %d", limit)
+	}
+	if limit == 0 {
+		// MySQL has no OFFSET without LIMIT; this is the largest limit it accepts.
+		return fmt.Sprintf("LIMIT 18446744073709551615 OFFSET %d", offset)
+	}
+	return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+}
+
+func (b *mysqlQueryBuilder) buildUpsert(q *UpsertQuery) (string, error) {
+	conflicts := map[string]struct{}{}
+	for _, v := range q.ConflictKeys {
+		conflicts[v] = struct{}{}
+	}
+
+	escapedFields := make([]string, len(q.Fields))
+	values := make([]string, len(q.Fields))
+	replacements := make([]string, 0, len(q.Fields))
+	for i, v := range q.Fields {
+		escapedFields[i] = b.escapeField(v)
+		values[i] = "?"
+		if _, present := conflicts[v]; !present {
+			replacements = append(replacements, fmt.Sprintf(`%s = VALUES(%s)`, b.escapeField(v), b.escapeField(v)))
+		}
+	}
+	// The conflict keys are implied by the table's unique indexes.
+	return fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s) ON DUPLICATE KEY UPDATE %s", b.escapeTable(q.Table), strings.Join(escapedFields, ","), strings.Join(values, ","), strings.Join(replacements, ",")), nil
+}
+
+func (b *mysqlQueryBuilder) buildFullTextMatch(q *FullTextMatchQuery) (string, error) {
+	if len(q.Fields) == 0 {
+		return "", errors.New("querybuilder: MySQL full-text matches require the indexed fields")
+	}
+	fields := make([]string, len(q.Fields))
+	for i, v := range q.Fields {
+		fields[i] = b.escapeTable(q.Table) + "." + b.escapeField(v)
+	}
+	return fmt.Sprintf(`MATCH (%s) AGAINST (? IN BOOLEAN MODE)`, strings.Join(fields, ", ")), nil
+}
+
+func (b *mysqlQueryBuilder) Build(q Query) (string, error) {
+	switch q := q.(type) {
+	case *UpsertQuery:
+		return b.buildUpsert(q)
+	case UpsertQuery:
+		return b.buildUpsert(&q)
+	case *FullTextMatchQuery:
+		return b.buildFullTextMatch(q)
+	case FullTextMatchQuery:
+		return b.buildFullTextMatch(&q)
+	case *SelectQuery, SelectQuery, *InsertQuery, InsertQuery, *UpdateQuery, UpdateQuery, *DeleteQuery, DeleteQuery:
+		return "", fmt.Errorf("querybuilder: %T carries values; use BuildStatement", q)
+	default:
+		return "", errors.New("querybuilder: I don't know what that query is.")
+	}
+}
+
+func (b *mysqlQueryBuilder) BuildStatement(q Query) (*Statement, error) {
+	switch q := q.(type) {
+	case *UpsertQuery:
+		sql, err := b.buildUpsert(q)
+		return &Statement{SQL: sql}, err
+	case UpsertQuery:
+		sql, err := b.buildUpsert(&q)
+		return &Statement{SQL: sql}, err
+	default:
+		return buildStatement(b, q)
+	}
+}
@@ -17,6 +17,21 @@ func (b *postgresQueryBuilder) escapeField(field string) string {
 	return `"` + field + `"`
 }
 
+func (b *postgresQueryBuilder) placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (b *postgresQueryBuilder) limitOffset(limit, offset int) string {
+	switch {
+	case offset == 0:
+		return fmt.Sprintf("LIMIT %d", limit)
+	case limit == 0:
+		return fmt.Sprintf("OFFSET %d", offset)
+	default:
+		return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+	}
+}
+
 func (b *postgresQueryBuilder) buildUpsert(q *UpsertQuery) (string, error) {
 	escapedConflictKeys := make([]string, len(q.ConflictKeys))
 	conflicts := map[string]struct{}{}
@@ -30,7 +45,7 @@ func (b *postgresQueryBuilder) buildUpsert(q *UpsertQuery) (string, error) {
 	replacements := make([]string, 0, len(q.Fields))
 	for i, v := range q.Fields {
 		escapedFields[i] = b.escapeField(v)
-		values[i] = "$" + strconv.Itoa(i+1)
+		values[i] = b.placeholder(i + 1)
 		if _, present := conflicts[v]; !present {
 			replacements = append(replacements, fmt.Sprintf(`%s = EXCLUDED.%s`, b.escapeField(v), b.escapeField(v)))
 		}
@@ -38,13 +53,36 @@ func (b *postgresQueryBuilder) buildUpsert(q *UpsertQuery) (string, error) {
 	return fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s) ON CONFLICT(%s) DO UPDATE SET %s", b.escapeTable(q.Table), strings.Join(escapedFields, ","), strings.Join(values, ","), strings.Join(escapedConflictKeys, ","), strings.Join(replacements, ",")), nil
 }
 
+func (b *postgresQueryBuilder) buildFullTextMatch(q *FullTextMatchQuery) (string, error) {
+	return fmt.Sprintf(`%s.%s @@ plainto_tsquery('simple', ?)`, b.escapeTable(q.Table), b.escapeField(q.Index)), nil
+}
+
 func (b *postgresQueryBuilder) Build(q Query) (string, error) {
 	switch q := q.(type) {
 	case *UpsertQuery:
 		return b.buildUpsert(q)
 	case UpsertQuery:
 		return b.buildUpsert(&q)
+	case *FullTextMatchQuery:
+		return b.buildFullTextMatch(q)
+	case FullTextMatchQuery:
+		return b.buildFullTextMatch(&q)
+	case *SelectQuery, SelectQuery, *InsertQuery, InsertQuery, *UpdateQuery, UpdateQuery, *DeleteQuery, DeleteQuery:
+		return "", fmt.Errorf("querybuilder: %T carries values; use BuildStatement", q)
 	default:
 		return "", errors.New("querybuilder: I don't know what that query is.")
 	}
 }
+
+func (b *postgresQueryBuilder) BuildStatement(q Query) (*Statement, error) {
+	switch q := q.(type) {
+	case *UpsertQuery:
+		sql, err := b.buildUpsert(q)
+		return &Statement{SQL: sql}, err
+	case UpsertQuery:
+		sql, err := b.buildUpsert(&q)
+		return &Statement{SQL: sql}, err
+	default:
+		return buildStatement(b, q)
+	}
+}
@@ -7,3 +7,62 @@ type UpsertQuery struct {
 	ConflictKeys []string
 	Fields       []string
 }
+
+// FullTextMatchQuery builds a condition that holds for rows of Table whose full-text
+// index matches the search terms bound to its single parameter.
+//
+// On PostgreSQL, Index names a tsvector column of Table built with the 'simple' text search
+// configuration. On SQLite, the index is the FTS4 table named Table_Index whose docids are the
+// rowids of Table. On MySQL, the index is a FULLTEXT index over exactly Fields, and the terms
+// are in boolean mode syntax.
+type FullTextMatchQuery struct {
+	Table  string
+	Index  string
+	Fields []string
+}
+
+// Condition is a boolean SQL expression using ? placeholders for its arguments. An argument
+// that is a slice (other than []byte) expands to a parenthesised list, so that
+// Cond("id IN ?", ids) matches any of ids. An empty list matches nothing.
+type Condition struct {
+	SQL  string
+	Args []interface{}
+}
+
+func Cond(sql string, args ...interface{}) Condition {
+	return Condition{SQL: sql, Args: args}
+}
+
+// SelectQuery selects Fields (or every field, if empty) from the rows of Table matching
+// all of Where. A Limit or Offset of zero is not applied.
+type SelectQuery struct {
+	Table   string
+	Fields  []string
+	Where   []Condition
+	OrderBy []string
+	Limit   int
+	Offset  int
+}
+
+// InsertQuery inserts one row into Table for each of Rows, whose values correspond to Fields.
+type InsertQuery struct {
+	Table  string
+	Fields []string
+	Rows   [][]interface{}
+}
+
+// UpdateQuery sets Fields to Values in the rows of Table matching all of Where.
+// With no conditions, every row is updated.
+type UpdateQuery struct {
+	Table  string
+	Fields []string
+	Values []interface{}
+	Where  []Condition
+}
+
+// DeleteQuery deletes the rows of Table matching all of Where.
+// With no conditions, every row is deleted.
+type DeleteQuery struct {
+	Table string
+	Where []Condition
+}
@@ -1,7 +1,19 @@
 package querybuilder
 
 type QueryBuilder interface {
+	// Build returns the SQL for a query that carries no values of its own: an UpsertQuery,
+	// whose values the caller binds in the order of its fields, or a FullTextMatchQuery.
 	Build(Query) (string, error)
+
+	// BuildStatement returns the SQL and arguments for an UpsertQuery, SelectQuery, InsertQuery,
+	// UpdateQuery or DeleteQuery, with placeholders written as the dialect requires.
+	BuildStatement(Query) (*Statement, error)
+}
+
+// Statement is a query ready to be executed: Args are bound, in order, to the placeholders in SQL.
+type Statement struct {
+	SQL  string
+	Args []interface{}
 }
 
 func New(dialect string) QueryBuilder {
@@ -10,6 +22,8 @@ func New(dialect string) QueryBuilder {
 		return &sqliteQueryBuilder{}
 	case "postgres", "pgsql", "postgresql":
 		return &postgresQueryBuilder{}
+	case "mysql", "mariadb":
+		return &mysqlQueryBuilder{}
 	default:
 		return nil
 	}
@@ -1,6 +1,150 @@
 package querybuilder
 
-import "testing"
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type statementTest struct {
+	name  string
+	query Query
+	sql   string
+	args  []interface{}
+	err   bool
+}
+
+func runStatementTests(t *testing.T, dialect string, tests []statementTest) {
+	qb := New(dialect)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := qb.BuildStatement(tt.query)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %q", stmt.SQL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stmt.SQL != tt.sql {
+				t.Errorf("got SQL\n\t%s\nexpected\n\t%s", stmt.SQL, tt.sql)
+			}
+			if !reflect.DeepEqual(stmt.Args, tt.args) {
+				t.Errorf("got args %#v; expected %#v", stmt.Args, tt.args)
+			}
+		})
+	}
+}
+
+// commonStatementTests are dialect-independent queries, whose expected SQL is written with ?
+// placeholders and passed through placeholders.
+func commonStatementTests(placeholders func(string) string) []statementTest {
+	tests := []statementTest{
+		{
+			name:  "SelectAll",
+			query: SelectQuery{Table: "people"},
+			sql:   `SELECT * FROM "people"`,
+		},
+		{
+			name: "SelectWhere",
+			query: &SelectQuery{
+				Table:   "people",
+				Fields:  []string{"id", "people.name", "COUNT(*)"},
+				Where:   []Condition{Cond("age > ?", 30), Cond("name <> 'what?' OR name = ?", "who")},
+				OrderBy: []string{"name DESC", "id"},
+			},
+			sql:  `SELECT "id", "people"."name", COUNT(*) FROM "people" WHERE (age > ?) AND (name <> 'what?' OR name = ?) ORDER BY name DESC, id`,
+			args: []interface{}{30, "who"},
+		},
+		{
+			name:  "SelectIn",
+			query: SelectQuery{Table: "people", Where: []Condition{Cond("id IN ? AND data = ?", []string{"a", "b", "c"}, []byte("blob"))}},
+			sql:   `SELECT * FROM "people" WHERE id IN (?, ?, ?) AND data = ?`,
+			args:  []interface{}{"a", "b", "c", []byte("blob")},
+		},
+		{
+			name:  "SelectInEmpty",
+			query: SelectQuery{Table: "people", Where: []Condition{Cond("id IN ?", []int{})}},
+			sql:   `SELECT * FROM "people" WHERE id IN (NULL)`,
+		},
+		{
+			name:  "SelectLimit",
+			query: SelectQuery{Table: "people", Limit: 10},
+			sql:   `SELECT * FROM "people" LIMIT 10`,
+		},
+		{
+			name:  "SelectLimitOffset",
+			query: SelectQuery{Table: "people", Limit: 10, Offset: 20},
+			sql:   `SELECT * FROM "people" LIMIT 10 OFFSET 20`,
+		},
+		{
+			name:  "SelectTooFewArgs",
+			query: SelectQuery{Table: "people", Where: []Condition{Cond("a = ? AND b = ?", 1)}},
+			err:   true,
+		},
+		{
+			name:  "SelectTooManyArgs",
+			query: SelectQuery{Table: "people", Where: []Condition{Cond("a = ?", 1, 2)}},
+			err:   true,
+		},
+		{
+			name:  "Insert",
+			query: InsertQuery{Table: "people", Fields: []string{"id", "name"}, Rows: [][]interface{}{{1, "one"}}},
+			sql:   `INSERT INTO "people" ("id", "name") VALUES (?, ?)`,
+			args:  []interface{}{1, "one"},
+		},
+		{
+			name:  "InsertBatch",
+			query: &InsertQuery{Table: "people", Fields: []string{"id", "name"}, Rows: [][]interface{}{{1, "one"}, {2, "two"}, {3, "three"}}},
+			sql:   `INSERT INTO "people" ("id", "name") VALUES (?, ?), (?, ?), (?, ?)`,
+			args:  []interface{}{1, "one", 2, "two", 3, "three"},
+		},
+		{
+			name:  "InsertRaggedRows",
+			query: InsertQuery{Table: "people", Fields: []string{"id", "name"}, Rows: [][]interface{}{{1, "one"}, {2}}},
+			err:   true,
+		},
+		{
+			name:  "InsertNoRows",
+			query: InsertQuery{Table: "people", Fields: []string{"id"}},
+			err:   true,
+		},
+		{
+			name:  "Update",
+			query: UpdateQuery{Table: "people", Fields: []string{"name", "age"}, Values: []interface{}{"x", 5}, Where: []Condition{Cond("id IN ?", []int{1, 2})}},
+			sql:   `UPDATE "people" SET "name" = ?, "age" = ? WHERE id IN (?, ?)`,
+			args:  []interface{}{"x", 5, 1, 2},
+		},
+		{
+			name:  "UpdateMismatchedValues",
+			query: UpdateQuery{Table: "people", Fields: []string{"name", "age"}, Values: []interface{}{"x"}},
+			err:   true,
+		},
+		{
+			name:  "Delete",
+			query: &DeleteQuery{Table: "people", Where: []Condition{Cond("id = ?", 1)}},
+			sql:   `DELETE FROM "people" WHERE id = ?`,
+			args:  []interface{}{1},
+		},
+		{
+			name:  "DeleteAll",
+			query: DeleteQuery{Table: "people"},
+			sql:   `DELETE FROM "people"`,
+		},
+		{
+			name:  "Unknown",
+			query: struct{}{},
+			err:   true,
+		},
+	}
+	for i := range tests {
+		tests[i].sql = placeholders(tests[i].sql)
+	}
+	return tests
+}
 
 func TestSqlite3(t *testing.T) {
 	qb := New("sqlite3")
@@ -11,7 +155,23 @@ func TestSqlite3(t *testing.T) {
 		Fields:       []string{"id", "name"},
 	}
 
-	t.Log(qb.Build(upsert))
+	q, err := qb.Build(upsert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `INSERT OR REPLACE INTO people(id,name) VALUES(?,?)`; q != expected {
+		t.Errorf("got %s; expected %s", q, expected)
+	}
+}
+
+func TestSqlite3Statements(t *testing.T) {
+	tests := commonStatementTests(func(sql string) string { return sql })
+	tests = append(tests, statementTest{
+		name:  "SelectOffset",
+		query: SelectQuery{Table: "people", Offset: 20},
+		sql:   `SELECT * FROM "people" LIMIT -1 OFFSET 20`,
+	})
+	runStatementTests(t, "sqlite3", tests)
 }
 
 func TestPostgres(t *testing.T) {
@@ -23,5 +183,105 @@ func TestPostgres(t *testing.T) {
 		Fields:       []string{"id", "name"},
 	}
 
-	t.Log(qb.Build(upsert))
+	q, err := qb.Build(upsert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `INSERT INTO "people"("id","name") VALUES($1,$2) ON CONFLICT("id") DO UPDATE SET "name" = EXCLUDED."name"`; q != expected {
+		t.Errorf("got %s; expected %s", q, expected)
+	}
+}
+
+// postgresPlaceholders numbers the ? placeholders in sql, skipping those in quotes.
+func postgresPlaceholders(sql string) string {
+	out := []byte{}
+	n := 0
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			out = append(out, '$')
+			out = append(out, strconv.Itoa(n)...)
+			continue
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
+
+func TestPostgresStatements(t *testing.T) {
+	tests := commonStatementTests(postgresPlaceholders)
+	tests = append(tests, statementTest{
+		name:  "SelectOffset",
+		query: SelectQuery{Table: "people", Offset: 20},
+		sql:   `SELECT * FROM "people" OFFSET 20`,
+	})
+	runStatementTests(t, "postgres", tests)
+}
+
+func TestMySQL(t *testing.T) {
+	qb := New("mysql")
+
+	upsert := UpsertQuery{
+		Table:        "people",
+		ConflictKeys: []string{"id"},
+		Fields:       []string{"id", "name", "age"},
+	}
+
+	q, err := qb.Build(upsert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "INSERT INTO `people`(`id`,`name`,`age`) VALUES(?,?,?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`),`age` = VALUES(`age`)"; q != expected {
+		t.Errorf("got %s; expected %s", q, expected)
+	}
+}
+
+func TestMySQLStatements(t *testing.T) {
+	tests := commonStatementTests(func(sql string) string { return strings.Replace(sql, `"`, "`", -1) })
+	tests = append(tests, statementTest{
+		name:  "SelectOffset",
+		query: SelectQuery{Table: "people", Offset: 20},
+		sql:   "SELECT * FROM `people` LIMIT 18446744073709551615 OFFSET 20",
+	})
+	runStatementTests(t, "mysql", tests)
+}
+
+func TestBuildRejectsValues(t *testing.T) {
+	for _, dialect := range []string{"sqlite3", "postgres", "mysql"} {
+		if _, err := New(dialect).Build(SelectQuery{Table: "people"}); err == nil {
+			t.Errorf("%s: Build accepted a query that carries values", dialect)
+		}
+	}
+}
+
+func TestFullTextMatch(t *testing.T) {
+	match := FullTextMatchQuery{
+		Table:  "documents",
+		Index:  "fts",
+		Fields: []string{"title", "body"},
+	}
+
+	for dialect, expected := range map[string]string{
+		"sqlite3":  `"documents".rowid IN (SELECT docid FROM "documents_fts" WHERE "documents_fts" MATCH ?)`,
+		"postgres": `"documents"."fts" @@ plainto_tsquery('simple', ?)`,
+		"mysql":    "MATCH (`documents`.`title`, `documents`.`body`) AGAINST (? IN BOOLEAN MODE)",
+	} {
+		q, err := New(dialect).Build(match)
+		if err != nil {
+			t.Errorf("%s: %v", dialect, err)
+			continue
+		}
+		if q != expected {
+			t.Errorf("%s: got %s; expected %s", dialect, q, expected)
+		}
+	}
 }
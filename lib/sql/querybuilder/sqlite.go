@@ -8,6 +8,29 @@ import (
 
 type sqliteQueryBuilder struct{}
 
+func (b *sqliteQueryBuilder) escapeTable(table string) string {
+	return `"` + table + `"`
+}
+
+func (b *sqliteQueryBuilder) escapeField(field string) string {
+	return `"` + field + `"`
+}
+
+func (b *sqliteQueryBuilder) placeholder(n int) string {
+	return "?"
+}
+
+func (b *sqliteQueryBuilder) limitOffset(limit, offset int) string {
+	if offset == 0 {
+		return fmt.Sprintf("LIMIT %d", limit)
+	}
+	if limit == 0 {
+		// SQLite has no OFFSET without LIMIT; a negative limit is unbounded.
+		limit = -1
+	}
+	return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+}
+
 func (b *sqliteQueryBuilder) buildUpsert(qt *UpsertQuery) (string, error) {
 	values := make([]string, len(qt.Fields))
 	for i, _ := range qt.Fields {
@@ -16,6 +39,11 @@ func (b *sqliteQueryBuilder) buildUpsert(qt *UpsertQuery) (string, error) {
 	return fmt.Sprintf("INSERT OR REPLACE INTO %s(%s) VALUES(%s)", qt.Table, strings.Join(qt.Fields, ","), strings.Join(values, ",")), nil
 }
 
+func (b *sqliteQueryBuilder) buildFullTextMatch(q *FullTextMatchQuery) (string, error) {
+	index := q.Table + "_" + q.Index
+	return fmt.Sprintf(`"%s".rowid IN (SELECT docid FROM "%s" WHERE "%s" MATCH ?)`, q.Table, index, index), nil
+}
+
 func (b *sqliteQueryBuilder) Build(q Query) (string, error) {
 
 	switch q := q.(type) {
@@ -23,7 +51,26 @@ func (b *sqliteQueryBuilder) Build(q Query) (string, error) {
 		return b.buildUpsert(q)
 	case UpsertQuery:
 		return b.buildUpsert(&q)
+	case *FullTextMatchQuery:
+		return b.buildFullTextMatch(q)
+	case FullTextMatchQuery:
+		return b.buildFullTextMatch(&q)
+	case *SelectQuery, SelectQuery, *InsertQuery, InsertQuery, *UpdateQuery, UpdateQuery, *DeleteQuery, DeleteQuery:
+		return "", fmt.Errorf("querybuilder: %T carries values; use BuildStatement", q)
 	default:
 		return "", errors.New("querybuilder: I don't know what that query is.")
 	}
 }
+
+func (b *sqliteQueryBuilder) BuildStatement(q Query) (*Statement, error) {
+	switch q := q.(type) {
+	case *UpsertQuery:
+		sql, err := b.buildUpsert(q)
+		return &Statement{SQL: sql}, err
+	case UpsertQuery:
+		sql, err := b.buildUpsert(&q)
+		return &Statement{SQL: sql}, err
+	default:
+		return buildStatement(b, q)
+	}
+}
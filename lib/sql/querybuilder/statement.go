@@ -0,0 +1,260 @@
+package querybuilder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// dialect describes how a database spells the parts of a statement that differ between them.
+type dialect interface {
+	escapeTable(string) string
+	escapeField(string) string
+	// placeholder returns the marker for the nth (1-based) argument.
+	placeholder(n int) string
+	// limitOffset returns a LIMIT/OFFSET clause; at least one of limit and offset is nonzero.
+	limitOffset(limit, offset int) string
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type statementWriter struct {
+	d    dialect
+	sql  bytes.Buffer
+	args []interface{}
+}
+
+// field writes the escaped name of a field. Anything other than a plain or table-qualified
+// name, such as "COUNT(*)", is assumed to be an expression and written as-is.
+func (w *statementWriter) field(f string) {
+	parts := strings.Split(f, ".")
+	for _, p := range parts {
+		if !identifierPattern.MatchString(p) {
+			w.sql.WriteString(f)
+			return
+		}
+	}
+	if len(parts) == 2 {
+		w.sql.WriteString(w.d.escapeTable(parts[0]) + ".")
+	}
+	w.sql.WriteString(w.d.escapeField(parts[len(parts)-1]))
+}
+
+func (w *statementWriter) fields(fs []string) {
+	for i, f := range fs {
+		if i > 0 {
+			w.sql.WriteString(", ")
+		}
+		w.field(f)
+	}
+}
+
+func isList(v interface{}) bool {
+	if _, ok := v.([]byte); ok {
+		return false
+	}
+	k := reflect.ValueOf(v).Kind()
+	return k == reflect.Slice || k == reflect.Array
+}
+
+func (w *statementWriter) value(v interface{}) {
+	if !isList(v) {
+		w.args = append(w.args, v)
+		w.sql.WriteString(w.d.placeholder(len(w.args)))
+		return
+	}
+
+	l := reflect.ValueOf(v)
+	if l.Len() == 0 {
+		w.sql.WriteString("(NULL)")
+		return
+	}
+	w.sql.WriteString("(")
+	for i := 0; i < l.Len(); i++ {
+		if i > 0 {
+			w.sql.WriteString(", ")
+		}
+		w.args = append(w.args, l.Index(i).Interface())
+		w.sql.WriteString(w.d.placeholder(len(w.args)))
+	}
+	w.sql.WriteString(")")
+}
+
+func (w *statementWriter) values(vs []interface{}) {
+	w.sql.WriteString("(")
+	for i, v := range vs {
+		if i > 0 {
+			w.sql.WriteString(", ")
+		}
+		w.value(v)
+	}
+	w.sql.WriteString(")")
+}
+
+// bind writes a Condition, replacing each ? outside of quotes with its argument.
+func (w *statementWriter) bind(c Condition) error {
+	n := 0
+	var quote rune
+	for _, r := range c.SQL {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			if n == len(c.Args) {
+				return fmt.Errorf("querybuilder: too few arguments for %q", c.SQL)
+			}
+			w.value(c.Args[n])
+			n++
+			continue
+		}
+		w.sql.WriteRune(r)
+	}
+	if n != len(c.Args) {
+		return fmt.Errorf("querybuilder: too many arguments for %q", c.SQL)
+	}
+	return nil
+}
+
+func (w *statementWriter) where(conds []Condition) error {
+	for i, c := range conds {
+		if i == 0 {
+			w.sql.WriteString(" WHERE ")
+		} else {
+			w.sql.WriteString(" AND ")
+		}
+		if len(conds) > 1 {
+			w.sql.WriteString("(")
+		}
+		if err := w.bind(c); err != nil {
+			return err
+		}
+		if len(conds) > 1 {
+			w.sql.WriteString(")")
+		}
+	}
+	return nil
+}
+
+func (w *statementWriter) statement() *Statement {
+	return &Statement{SQL: w.sql.String(), Args: w.args}
+}
+
+func buildSelect(d dialect, q *SelectQuery) (*Statement, error) {
+	if q.Table == "" {
+		return nil, errors.New("querybuilder: select from no table")
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return nil, errors.New("querybuilder: negative limit or offset")
+	}
+
+	w := &statementWriter{d: d}
+	w.sql.WriteString("SELECT ")
+	if len(q.Fields) == 0 {
+		w.sql.WriteString("*")
+	} else {
+		w.fields(q.Fields)
+	}
+	w.sql.WriteString(" FROM " + d.escapeTable(q.Table))
+	if err := w.where(q.Where); err != nil {
+		return nil, err
+	}
+	if len(q.OrderBy) > 0 {
+		w.sql.WriteString(" ORDER BY " + strings.Join(q.OrderBy, ", "))
+	}
+	if q.Limit > 0 || q.Offset > 0 {
+		w.sql.WriteString(" " + d.limitOffset(q.Limit, q.Offset))
+	}
+	return w.statement(), nil
+}
+
+func buildInsert(d dialect, q *InsertQuery) (*Statement, error) {
+	if q.Table == "" || len(q.Fields) == 0 {
+		return nil, errors.New("querybuilder: insert into no table or fields")
+	}
+	if len(q.Rows) == 0 {
+		return nil, errors.New("querybuilder: insert of no rows")
+	}
+
+	w := &statementWriter{d: d}
+	w.sql.WriteString("INSERT INTO " + d.escapeTable(q.Table) + " (")
+	w.fields(q.Fields)
+	w.sql.WriteString(") VALUES ")
+	for i, row := range q.Rows {
+		if len(row) != len(q.Fields) {
+			return nil, fmt.Errorf("querybuilder: insert row %d has %d values for %d fields", i, len(row), len(q.Fields))
+		}
+		if i > 0 {
+			w.sql.WriteString(", ")
+		}
+		w.values(row)
+	}
+	return w.statement(), nil
+}
+
+func buildUpdate(d dialect, q *UpdateQuery) (*Statement, error) {
+	if q.Table == "" || len(q.Fields) == 0 {
+		return nil, errors.New("querybuilder: update of no table or fields")
+	}
+	if len(q.Values) != len(q.Fields) {
+		return nil, fmt.Errorf("querybuilder: update has %d values for %d fields", len(q.Values), len(q.Fields))
+	}
+
+	w := &statementWriter{d: d}
+	w.sql.WriteString("UPDATE " + d.escapeTable(q.Table) + " SET ")
+	for i, f := range q.Fields {
+		if i > 0 {
+			w.sql.WriteString(", ")
+		}
+		w.field(f)
+		w.sql.WriteString(" = ")
+		w.value(q.Values[i])
+	}
+	if err := w.where(q.Where); err != nil {
+		return nil, err
+	}
+	return w.statement(), nil
+}
+
+func buildDelete(d dialect, q *DeleteQuery) (*Statement, error) {
+	if q.Table == "" {
+		return nil, errors.New("querybuilder: delete from no table")
+	}
+
+	w := &statementWriter{d: d}
+	w.sql.WriteString("DELETE FROM " + d.escapeTable(q.Table))
+	if err := w.where(q.Where); err != nil {
+		return nil, err
+	}
+	return w.statement(), nil
+}
+
+// buildStatement builds the query types that are common to every dialect.
+func buildStatement(d dialect, q Query) (*Statement, error) {
+	switch q := q.(type) {
+	case *SelectQuery:
+		return buildSelect(d, q)
+	case SelectQuery:
+		return buildSelect(d, &q)
+	case *InsertQuery:
+		return buildInsert(d, q)
+	case InsertQuery:
+		return buildInsert(d, &q)
+	case *UpdateQuery:
+		return buildUpdate(d, q)
+	case UpdateQuery:
+		return buildUpdate(d, &q)
+	case *DeleteQuery:
+		return buildDelete(d, q)
+	case DeleteQuery:
+		return buildDelete(d, &q)
+	default:
+		return nil, errors.New("querybuilder: I don't know what that query is.")
+	}
+}
@@ -0,0 +1,402 @@
+package model
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/DHowett/ghostbin/lib/backup"
+	"github.com/DHowett/ghostbin/lib/spool"
+	"github.com/DHowett/ghostbin/lib/sql/querybuilder"
+	"github.com/jinzhu/gorm"
+)
+
+// An exported database is made of these archive entries, in this order:
+//
+//	db/schema.json          the schema version the records were exported from
+//	db/<entity>.jsonl       one JSON object per line for each row of each backupEntity
+//	bodies/<body ID>        each stored body, exactly as it is stored
+const (
+	backupSchemaEntry  = "db/schema.json"
+	backupRecordPrefix = "db/"
+	backupRecordSuffix = ".jsonl"
+	backupBodyPrefix   = "bodies/"
+)
+
+type backupSchema struct {
+	Version int `json:"version"`
+}
+
+// backupEntity is a table that is exported record by record.
+type backupEntity struct {
+	name  string
+	new   func() interface{}
+	order string
+}
+
+// backupEntities are the tables that make up an instance. Search documents are exported
+// along with the rest, as rebuilding them would mean reading every paste's body again.
+var backupEntities = []backupEntity{
+	{"users", func() interface{} { return &dbUser{} }, "id"},
+	{"user_paste_permissions", func() interface{} { return &dbUserPastePermission{} }, "user_id, paste_id"},
+	{"grants", func() interface{} { return &dbGrant{} }, "id"},
+	{"pastes", func() interface{} { return &dbPaste{} }, "id"},
+	{"paste_revisions", func() interface{} { return &dbPasteRevision{} }, "id"},
+	{"paste_attachments", func() interface{} { return &dbPasteAttachment{} }, "id"},
+	{"paste_blobs", func() interface{} { return &dbPasteBlob{} }, "hash"},
+	{"paste_search_documents", func() interface{} { return &dbPasteSearchDocument{} }, "paste_id"},
+}
+
+// backupSerialTables are the tables whose IDs a sequence hands out. Imported rows keep their
+// IDs, so the sequences have to be moved past them.
+var backupSerialTables = []string{"db_users", "db_paste_revisions", "db_paste_attachments"}
+
+func backupEntityNamed(name string) *backupEntity {
+	for i := range backupEntities {
+		if backupEntities[i].name == name {
+			return &backupEntities[i]
+		}
+	}
+	return nil
+}
+
+// BackupMismatchError is returned when an instance doesn't hold what an archive says it should.
+type BackupMismatchError []string
+
+func (e BackupMismatchError) Error() string {
+	return "model: the database doesn't match the archive:\n\t" + strings.Join(e, "\n\t")
+}
+
+// transactionBodyStore returns bodies, made to read and write through db if it keeps bodies
+// in the database.
+func transactionBodyStore(db *gorm.DB, bodies PasteBodyStore) PasteBodyStore {
+	if s, ok := bodies.(*dbPasteBodyStore); ok {
+		return &dbPasteBodyStore{db: db, qb: s.qb}
+	}
+	return bodies
+}
+
+// ExportDatabase writes every record in sqlDb and every body in bodies to w. The records, and
+// bodies kept in the database, are read in a single transaction, so that they are consistent
+// with one another; bodies kept elsewhere are read as they are found afterwards.
+func ExportDatabase(dialect string, sqlDb *sql.DB, bodies PasteBodyStore, w *backup.Writer) error {
+	opts := &sql.TxOptions{}
+	if dialect != "sqlite3" {
+		// SQLite transactions are already serializable.
+		opts.Isolation = sql.LevelRepeatableRead
+		opts.ReadOnly = true
+	}
+	tx, err := sqlDb.BeginTx(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	db, err := gorm.Open(dialect, tx)
+	if err != nil {
+		return err
+	}
+	db.LogMode(false)
+
+	// The records are written the way this build sees them.
+	migrator, err := newSchemaMigrator(dialect, db)
+	if err != nil {
+		return err
+	}
+	if err := migrator.Check(); err != nil {
+		return err
+	}
+	schema, _ := json.Marshal(&backupSchema{Version: LatestSchemaVersion()})
+	if err := w.Add(backupSchemaEntry, strings.NewReader(string(schema))); err != nil {
+		return err
+	}
+
+	for _, e := range backupEntities {
+		if err := exportRecords(db, &e, w); err != nil {
+			return fmt.Errorf("exporting %s: %v", e.name, err)
+		}
+	}
+
+	bodyIDs, err := storedBodyIDs(db)
+	if err != nil {
+		return err
+	}
+	bodies = transactionBodyStore(db, bodies)
+	for _, id := range bodyIDs {
+		r, err := bodies.GetBody(id)
+		if err == PasteBodyNotFoundError {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = w.Add(backupBodyPrefix+id.String(), r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportRecords(db *gorm.DB, e *backupEntity, w *backup.Writer) error {
+	rows, err := db.Model(e.new()).Order(e.order).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	s := spool.New(pasteBodySpoolMemory)
+	defer s.Close()
+	enc := json.NewEncoder(s)
+	for rows.Next() {
+		record := e.new()
+		if err := db.ScanRows(rows, record); err != nil {
+			return err
+		}
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Add(backupRecordPrefix+e.name+backupRecordSuffix, s.Reader())
+}
+
+// insertRecord inserts record as it is, without the callbacks gorm would run to fill in its
+// keys and times.
+func insertRecord(db *gorm.DB, qb querybuilder.QueryBuilder, record interface{}) error {
+	scope := db.NewScope(record)
+	var fields []string
+	var values []interface{}
+	for _, field := range scope.Fields() {
+		if !field.IsNormal || field.IsIgnored {
+			continue
+		}
+		fields = append(fields, field.DBName)
+		values = append(values, field.Field.Interface())
+	}
+	return execQuery(db, qb, &querybuilder.InsertQuery{
+		Table:  scope.TableName(),
+		Fields: fields,
+		Rows:   [][]interface{}{values},
+	})
+}
+
+// DatabaseImporter restores an exported database into an empty one, which may use another
+// dialect or body store. An import that fails part of the way through leaves part of the
+// instance behind; start again with an empty database.
+type DatabaseImporter struct {
+	dialect string
+	db      *gorm.DB
+	qb      querybuilder.QueryBuilder
+	bodies  PasteBodyStore
+	schema  bool
+}
+
+// NewDatabaseImporter returns an importer that restores into sqlDb, whose schema must be up
+// to date and which must hold no pastes or users, and bodies.
+func NewDatabaseImporter(dialect string, sqlDb *sql.DB, bodies PasteBodyStore) (*DatabaseImporter, error) {
+	qb := querybuilder.New(dialect)
+	if qb == nil {
+		return nil, errors.New("model: unsupported database dialect " + dialect)
+	}
+
+	db, err := gorm.Open(dialect, sqlDb)
+	if err != nil {
+		return nil, err
+	}
+	db.LogMode(false)
+
+	migrator, err := newSchemaMigrator(dialect, db)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrator.Check(); err != nil {
+		return nil, err
+	}
+
+	for _, model := range []interface{}{&dbPaste{}, &dbUser{}} {
+		var n int
+		if err := db.Model(model).Count(&n).Error; err != nil {
+			return nil, err
+		}
+		if n != 0 {
+			return nil, errors.New("model: the database to import into must be empty")
+		}
+	}
+	return &DatabaseImporter{dialect: dialect, db: db, qb: qb, bodies: bodies}, nil
+}
+
+// checkBackupSchema reads a schema entry, and ensures that its records can be read by this build.
+func checkBackupSchema(r io.Reader) error {
+	var schema backupSchema
+	if err := json.NewDecoder(r).Decode(&schema); err != nil {
+		return err
+	}
+	if schema.Version != LatestSchemaVersion() {
+		return fmt.Errorf("model: the archive was exported at schema version %d; this build uses version %d", schema.Version, LatestSchemaVersion())
+	}
+	return nil
+}
+
+// Import restores the archive entry called name from r. It returns false, having read
+// nothing, if the entry doesn't belong to the database.
+func (i *DatabaseImporter) Import(name string, r io.Reader) (bool, error) {
+	if name == backupSchemaEntry {
+		i.schema = true
+		return true, checkBackupSchema(r)
+	}
+	if !strings.HasPrefix(name, backupRecordPrefix) && !strings.HasPrefix(name, backupBodyPrefix) {
+		return false, nil
+	}
+	if !i.schema {
+		return true, errors.New("model: the archive doesn't begin with its schema version")
+	}
+
+	if strings.HasPrefix(name, backupBodyPrefix) {
+		id := PasteID(strings.TrimPrefix(name, backupBodyPrefix))
+		if id == "" || strings.ContainsAny(id.String(), "/\\") {
+			return true, fmt.Errorf("model: invalid body %q", name)
+		}
+		return true, i.bodies.PutBody(id, r)
+	}
+
+	e := backupEntityNamed(strings.TrimSuffix(strings.TrimPrefix(name, backupRecordPrefix), backupRecordSuffix))
+	if e == nil || !strings.HasSuffix(name, backupRecordSuffix) {
+		return true, fmt.Errorf("model: unknown records %q", name)
+	}
+	tx := i.db.Begin()
+	dec := json.NewDecoder(r)
+	for {
+		record := e.new()
+		err := dec.Decode(record)
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = insertRecord(tx, i.qb, record)
+		}
+		if err != nil {
+			tx.Rollback()
+			return true, fmt.Errorf("importing %s: %v", e.name, err)
+		}
+	}
+	return true, tx.Commit().Error
+}
+
+// Finish completes the import.
+func (i *DatabaseImporter) Finish() error {
+	if !i.schema {
+		return errors.New("model: the archive holds no database")
+	}
+	if i.dialect != "postgres" {
+		// SQLite and MySQL move their sequences past the IDs they are given.
+		return nil
+	}
+	for _, table := range backupSerialTables {
+		if err := i.db.Exec(fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE((SELECT MAX(id) FROM "%s"), 0) + 1, false)`, table, table)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DatabaseVerifier checks an instance against an archive: that it holds as many records of
+// each kind, and the same bodies. Records are only counted, because they are stored
+// differently from one dialect to the next.
+type DatabaseVerifier struct {
+	db       *gorm.DB
+	bodies   PasteBodyStore
+	records  map[string]int
+	problems BackupMismatchError
+}
+
+// NewDatabaseVerifier returns a verifier that checks sqlDb and bodies.
+func NewDatabaseVerifier(dialect string, sqlDb *sql.DB, bodies PasteBodyStore) (*DatabaseVerifier, error) {
+	db, err := gorm.Open(dialect, sqlDb)
+	if err != nil {
+		return nil, err
+	}
+	db.LogMode(false)
+	return &DatabaseVerifier{db: db, bodies: bodies, records: make(map[string]int)}, nil
+}
+
+// Verify checks the archive entry called name, read from r. It returns false, having read
+// nothing, if the entry doesn't belong to the database.
+func (v *DatabaseVerifier) Verify(name string, r io.Reader) (bool, error) {
+	switch {
+	case name == backupSchemaEntry:
+		return true, checkBackupSchema(r)
+
+	case strings.HasPrefix(name, backupRecordPrefix):
+		e := backupEntityNamed(strings.TrimSuffix(strings.TrimPrefix(name, backupRecordPrefix), backupRecordSuffix))
+		if e == nil {
+			return true, fmt.Errorf("model: unknown records %q", name)
+		}
+		n := 0
+		br := bufio.NewReader(r)
+		for {
+			_, err := br.ReadBytes('\n')
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return true, err
+			}
+			n++
+		}
+		v.records[e.name] = n
+		return true, nil
+
+	case strings.HasPrefix(name, backupBodyPrefix):
+		id := PasteID(strings.TrimPrefix(name, backupBodyPrefix))
+		archived := sha256.New()
+		if _, err := io.Copy(archived, r); err != nil {
+			return true, err
+		}
+		body, err := v.bodies.GetBody(id)
+		if err == PasteBodyNotFoundError {
+			v.problems = append(v.problems, fmt.Sprintf("body %s is missing", id))
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		defer body.Close()
+		stored := sha256.New()
+		if _, err := io.Copy(stored, body); err != nil {
+			return true, err
+		}
+		if string(stored.Sum(nil)) != string(archived.Sum(nil)) {
+			v.problems = append(v.problems, fmt.Sprintf("body %s differs", id))
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Finish compares the number of records of each kind, and returns a BackupMismatchError
+// describing everything that doesn't match.
+func (v *DatabaseVerifier) Finish() error {
+	for _, e := range backupEntities {
+		var n int
+		if err := v.db.Model(e.new()).Count(&n).Error; err != nil {
+			return err
+		}
+		if n != v.records[e.name] {
+			v.problems = append(v.problems, fmt.Sprintf("%s: %d records; the archive holds %d", e.name, n, v.records[e.name]))
+		}
+	}
+	if len(v.problems) > 0 {
+		return v.problems
+	}
+	return nil
+}
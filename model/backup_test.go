@@ -0,0 +1,202 @@
+package model
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/DHowett/ghostbin/lib/backup"
+)
+
+func exportTestBroker(t *testing.T, b Broker) []byte {
+	buf := &bytes.Buffer{}
+	w := backup.NewWriter(buf)
+	if err := ExportDatabase("sqlite3", b.(*dbBroker).DB.DB(), b.(*dbBroker).Bodies, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readTestArchive hands every entry in data to f, which must accept it.
+func readTestArchive(t *testing.T, data []byte, f func(string, io.Reader) (bool, error)) {
+	r, err := backup.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		name, content, err := r.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := f(name, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("entry %s was left over", name)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	srcDb := openTestDatabase(t)
+	defer srcDb.Close()
+	src, err := NewDatabaseBroker("sqlite3", srcDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := src.CreateUser("exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := src.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTitle("Exported")
+	writePasteBody(t, p, "shared body")
+	writePasteBody(t, p, "exported body")
+	if err := src.(*dbBroker).Create(&dbUserPastePermission{UserID: u.GetID(), PasteID: p.GetID().String(), Permissions: PastePermissionAll}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Attach("notes.txt", "text/plain", strings.NewReader("attached")); err != nil {
+		t.Fatal(err)
+	}
+	grant, err := src.CreateGrant(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	twin, err := src.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, twin, "shared body")
+	ep, err := src.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, ep, "secret body")
+
+	data := exportTestBroker(t, src)
+
+	dstDb := openTestDatabase(t)
+	defer dstDb.Close()
+	dst, err := NewDatabaseBroker("sqlite3", dstDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	importer, err := NewDatabaseImporter("sqlite3", dstDb, dst.(*dbBroker).Bodies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readTestArchive(t, data, importer.Import)
+	if err := importer.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := NewDatabaseVerifier("sqlite3", dstDb, dst.(*dbBroker).Bodies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readTestArchive(t, data, verifier.Verify)
+	if err := verifier.Finish(); err != nil {
+		t.Error(err)
+	}
+
+	ip, err := dst.GetPaste(p.GetID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, ip); body != "exported body" || ip.GetTitle() != "Exported" {
+		t.Errorf("imported %q as <%s>", ip.GetTitle(), body)
+	}
+	rev, err := ip.GetRevision(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRevisionBody(t, rev); body != "shared body" {
+		t.Errorf("imported the first revision as <%s>", body)
+	}
+	a, err := ip.GetAttachment("notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readAttachmentBody(t, a); body != "attached" {
+		t.Errorf("imported the attachment as <%s>", body)
+	}
+	if g, err := dst.GetGrant(grant.GetID()); err != nil || g.GetPasteID() != p.GetID() {
+		t.Errorf("imported the grant for %v (%v)", g, err)
+	}
+	iep, err := dst.GetPaste(ep.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, iep); body != "secret body" {
+		t.Errorf("imported the encrypted paste as <%s>", body)
+	}
+
+	iu, err := dst.GetUserNamed("exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := dst.SearchPastes(&PasteSearch{Terms: "exported", UserID: iu.GetID()})
+	if err != nil || len(found) != 1 {
+		t.Errorf("found %d imported pastes (%v)", len(found), err)
+	}
+
+	// New records don't collide with imported ones.
+	if _, err := dst.CreateUser("newcomer"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ip.Attach("more.txt", "text/plain", strings.NewReader("more")); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewDatabaseImporter("sqlite3", dstDb, dst.(*dbBroker).Bodies); err == nil {
+		t.Error("prepared to import into a database that isn't empty")
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "original")
+	data := exportTestBroker(t, b)
+
+	writePasteBody(t, p, "changed")
+	if _, err := b.CreatePaste(); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := NewDatabaseVerifier("sqlite3", sqlDb, b.(*dbBroker).Bodies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readTestArchive(t, data, verifier.Verify)
+	err = verifier.Finish()
+	problems, ok := err.(BackupMismatchError)
+	if !ok {
+		t.Fatalf("verified a changed database with %v", err)
+	}
+	// The new paste, revision and shared body are counted; the old body is still there, as
+	// the first revision shares it.
+	if len(problems) != 3 {
+		t.Errorf("found %d problems: %v", len(problems), err)
+	}
+}
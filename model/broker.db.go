@@ -3,22 +3,38 @@ package model
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/DHowett/ghostbin/lib/crypto"
 	"github.com/DHowett/ghostbin/lib/sql/querybuilder"
+	"github.com/golang/glog"
 	"github.com/jinzhu/gorm"
 )
 
 type dbBroker struct {
 	*gorm.DB
+	pasteEventBus
 	QB                querybuilder.QueryBuilder
 	ChallengeProvider crypto.ChallengeProvider
+	Bodies            PasteBodyStore
+	PasteIDs          *PasteIDGenerator
+	// Compression is how bodies are compressed as they are written.
+	Compression PasteCompressionMethod
+	// PasteKDF is what the keys of newly encrypted pastes are derived with.
+	PasteKDF crypto.KDF
+
+	views *pasteViewCounter
+
+	bodyLocks pasteBodyLocks
 }
 
 // User
 func (broker *dbBroker) getUserWithQuery(query string, args ...interface{}) (User, error) {
 	var u dbUser
 	if err := broker.Where(query, args...).First(&u).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, UserNotFoundError
+		}
 		return nil, err
 	}
 	u.broker = broker
@@ -47,53 +63,95 @@ func (broker *dbBroker) CreateUser(name string) (User, error) {
 
 // Paste
 func (broker *dbBroker) GenerateNewPasteID(encrypted bool) PasteID {
-	nbytes, idlen := 4, 5
-	if encrypted {
-		nbytes, idlen = 5, 8
-	}
+	return broker.PasteIDs.generate(encrypted, 0)
+}
 
-	for {
-		s, _ := generateRandomBase32String(nbytes, idlen)
-		return PasteIDFromString(s)
+// createPaste stores paste under id or, if id is empty, under a random ID. Random IDs that
+// turn out to be taken are replaced until a free one is found.
+func (broker *dbBroker) createPaste(paste *dbPaste, id PasteID) error {
+	for attempt := 0; ; attempt++ {
+		paste.ID = id.String()
+		if id == "" {
+			paste.ID = broker.PasteIDs.generate(paste.IsEncrypted(), attempt).String()
+		}
+		if paste.IsEncrypted() {
+			paste.HMAC = getPasteEncryptionCodec(paste.EncryptionMethod).GenerateHMAC(paste.GetID(), paste.EncryptionSalt, paste.encryptionKey)
+		}
+
+		err := broker.Create(paste).Error
+		if err == nil {
+			broker.publish(PasteCreatedEvent, paste)
+			return nil
+		}
+
+		// A taken ID fails the insert on a unique constraint. Rather than pick apart each
+		// database's errors, look for the paste holding it.
+		var n int
+		if cerr := broker.Model(&dbPaste{}).Where("id = ?", paste.ID).Count(&n).Error; cerr != nil || n == 0 {
+			return err
+		}
+		if id != "" {
+			return PasteIDUnavailableError
+		}
+		if attempt+1 == maxPasteIDAttempts {
+			return err
+		}
 	}
 }
 
 func (broker *dbBroker) CreatePaste() (Paste, error) {
-	paste := dbPaste{broker: broker}
-	for {
-		if err := broker.Create(&paste).Error; err != nil {
-			panic(err)
-		}
-		paste.broker = broker
-		return &paste, nil
+	paste := &dbPaste{broker: broker}
+	if err := broker.createPaste(paste, ""); err != nil {
+		return nil, err
 	}
+	return paste, nil
+}
+
+func (broker *dbBroker) CreatePasteWithID(id PasteID) (Paste, error) {
+	if err := ValidatePasteID(id); err != nil {
+		return nil, err
+	}
+	paste := &dbPaste{broker: broker}
+	if err := broker.createPaste(paste, id); err != nil {
+		return nil, err
+	}
+	return paste, nil
 }
 
 func (broker *dbBroker) CreateEncryptedPaste(method PasteEncryptionMethod, passphraseMaterial []byte) (Paste, error) {
 	if passphraseMaterial == nil {
 		return nil, errors.New("FilesystemPasteStore: unacceptable encryption material")
 	}
-	paste := dbPaste{broker: broker}
+	if _, ok := pasteEncryptionCodecs[method]; !ok {
+		return nil, errors.New("model: unsupported encryption method")
+	}
+	paste := &dbPaste{broker: broker}
 	paste.EncryptionSalt, _ = generateRandomBytes(16)
-	paste.EncryptionMethod = PasteEncryptionMethodAES_CTR
-	key, err := getPasteEncryptionCodec(method).DeriveKey(passphraseMaterial, paste.EncryptionSalt)
+	paste.EncryptionMethod = method
+	paste.KDF = broker.PasteKDF.String()
+	key, err := getPasteEncryptionCodec(method).DeriveKey(broker.PasteKDF, passphraseMaterial, paste.EncryptionSalt)
 	if err != nil {
 		return nil, err
 	}
 	paste.encryptionKey = key
 
-	for {
-		if err := broker.Create(&paste).Error; err != nil {
-			panic(err)
-		}
-		paste.broker = broker
-		return &paste, nil
+	if err := broker.createPaste(paste, ""); err != nil {
+		return nil, err
 	}
+	return paste, nil
+}
+
+func (broker *dbBroker) CreateClientEncryptedPaste() (Paste, error) {
+	paste := &dbPaste{EncryptionMethod: PasteEncryptionMethodClient, broker: broker}
+	if err := broker.createPaste(paste, ""); err != nil {
+		return nil, err
+	}
+	return paste, nil
 }
 
 func (broker *dbBroker) GetPaste(id PasteID, passphraseMaterial []byte) (Paste, error) {
 	var paste dbPaste
-	if err := broker.Find(&paste, "id = ?", id.String()).Error; err != nil {
+	if err := broker.Find(&paste, "id = ? AND trashed_at IS NULL", id.String()).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, PasteNotFoundError
 		}
@@ -102,56 +160,72 @@ func (broker *dbBroker) GetPaste(id PasteID, passphraseMaterial []byte) (Paste,
 	}
 	paste.broker = broker
 
-	// This paste is encrypted
-	if paste.IsEncrypted() {
+	// This paste is encrypted, and the key is ours to check.
+	if paste.IsEncrypted() && !paste.IsClientEncrypted() {
 		// If they haven't requested decryption, we can
 		// still tell them that a paste exists.
 		// It will be a stub/placeholder that only has an ID.
 		if passphraseMaterial == nil {
 			return &encryptedPastePlaceholder{
-				ID: id,
+				ID:         id,
+				Expiration: paste.GetExpiration(),
 			}, PasteEncryptedError
 		}
 
-		key, err := getPasteEncryptionCodec(paste.EncryptionMethod).DeriveKey(passphraseMaterial, paste.EncryptionSalt)
+		key, err := unlockPaste(id, paste.EncryptionMethod, paste.KDF, paste.EncryptionSalt, paste.HMAC, passphraseMaterial)
 		if err != nil {
-			return nil, PasteEncryptedError
+			return nil, err
 		}
+		paste.encryptionKey = key
 
-		ok := getPasteEncryptionCodec(paste.EncryptionMethod).Authenticate(id, paste.EncryptionSalt, key, paste.HMAC)
-		if !ok {
-			return nil, PasteInvalidKeyError
+		if paste.ReencryptMethod != PasteEncryptionMethodNone {
+			// The paste stays readable as it is if it can't be upgraded.
+			if err := broker.upgradePasteEncryption(&paste, passphraseMaterial); err != nil {
+				glog.Errorf("paste %s: failed to re-encrypt: %v", paste.ID, err)
+			}
+		}
+		if err := paste.unsealMetadata(); err != nil {
+			return nil, err
+		}
+		// The paste stays readable as it is if its metadata can't be sealed.
+		if err := broker.sealLegacyPasteMetadata(&paste); err != nil {
+			glog.Errorf("paste %s: failed to seal metadata: %v", paste.ID, err)
 		}
-
-		paste.encryptionKey = key
 	}
 
 	return &paste, nil
 }
 
 func (broker *dbBroker) GetPastes(ids []PasteID) ([]Paste, error) {
-	stringIDs := make([]string, len(ids))
-	for i, v := range ids {
-		stringIDs[i] = string(v)
-	}
-
 	var ps []*dbPaste
-	if err := broker.Find(&ps, "id in (?)", stringIDs).Error; err != nil {
+	if err := broker.Order("created_at").Find(&ps, "id in (?) AND trashed_at IS NULL", pasteIDStrings(ids)).Error; err != nil {
 		return nil, err
 	}
 
+	return broker.wrapPastes(ps), nil
+}
+
+// wrapPastes binds ps to the broker, replacing encrypted pastes (for which no key is available)
+// with placeholders. Lists show those by their IDs alone: unlocking each with a remembered
+// passphrase would derive a key per paste every time a list is loaded.
+func (broker *dbBroker) wrapPastes(ps []*dbPaste) []Paste {
 	iPastes := make([]Paste, len(ps))
 	for i, p := range ps {
 		p.broker = broker
 		if p.IsEncrypted() {
 			iPastes[i] = &encryptedPastePlaceholder{
-				ID: p.GetID(),
+				ID:         p.GetID(),
+				Expiration: p.GetExpiration(),
 			}
 		} else {
 			iPastes[i] = p
 		}
 	}
-	return iPastes, nil
+	return iPastes
+}
+
+func (broker *dbBroker) RecordPasteView(id PasteID) {
+	broker.views.record(id)
 }
 
 func (broker *dbBroker) CreateGrant(paste Paste) (Grant, error) {
@@ -168,34 +242,180 @@ func (broker *dbBroker) CreateGrant(paste Paste) (Grant, error) {
 func (broker *dbBroker) GetGrant(id GrantID) (Grant, error) {
 	var grant dbGrant
 	if err := broker.Find(&grant, "id = ?", string(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, GrantNotFoundError
+		}
 		return nil, err
 	}
 	grant.broker = broker
 	return &grant, nil
 }
 
-func NewDatabaseBroker(dialect string, sqlDb *sql.DB, challengeProvider crypto.ChallengeProvider) (Broker, error) {
+// DatabaseLogLevel controls how much of its database activity a broker reports.
+type DatabaseLogLevel int
+
+const (
+	// DatabaseLogErrors reports only failed queries.
+	DatabaseLogErrors DatabaseLogLevel = iota
+	// DatabaseLogNone reports nothing.
+	DatabaseLogNone
+	// DatabaseLogAll reports every query.
+	DatabaseLogAll
+)
+
+type databaseBrokerOptions struct {
+	logLevel        DatabaseLogLevel
+	manualMigration bool
+	bodyStore       PasteBodyStore
+	pasteIDs        *PasteIDGenerator
+	viewInterval    *time.Duration
+	compression     *PasteCompressionMethod
+	pasteKDF        crypto.KDF
+}
+
+// DatabaseBrokerOption configures optional behaviour of a broker created by NewDatabaseBroker.
+type DatabaseBrokerOption func(*databaseBrokerOptions)
+
+// DatabaseBrokerLogLevel sets the level at which database activity is logged.
+func DatabaseBrokerLogLevel(level DatabaseLogLevel) DatabaseBrokerOption {
+	return func(o *databaseBrokerOptions) {
+		o.logLevel = level
+	}
+}
+
+// DatabaseBrokerManualMigration stops NewDatabaseBroker from bringing an outdated schema
+// up to date; it will instead refuse to use the database until it has been migrated.
+func DatabaseBrokerManualMigration(manual bool) DatabaseBrokerOption {
+	return func(o *databaseBrokerOptions) {
+		o.manualMigration = manual
+	}
+}
+
+// DatabaseBrokerBodyStore keeps paste bodies in store instead of the database.
+func DatabaseBrokerBodyStore(store PasteBodyStore) DatabaseBrokerOption {
+	return func(o *databaseBrokerOptions) {
+		o.bodyStore = store
+	}
+}
+
+// DatabaseBrokerPasteIDs sets how the IDs of new pastes are generated.
+func DatabaseBrokerPasteIDs(g *PasteIDGenerator) DatabaseBrokerOption {
+	return func(o *databaseBrokerOptions) {
+		o.pasteIDs = g
+	}
+}
+
+// DatabaseBrokerViewFlushInterval sets how long views of pastes are gathered before they
+// are written to the database. An interval of zero writes every view as it happens.
+func DatabaseBrokerViewFlushInterval(interval time.Duration) DatabaseBrokerOption {
+	return func(o *databaseBrokerOptions) {
+		o.viewInterval = &interval
+	}
+}
+
+// DatabaseBrokerCompression sets how paste bodies are compressed as they are written. Bodies
+// are gzipped unless another method is chosen; see CompressPasteBodies for those written before.
+func DatabaseBrokerCompression(method PasteCompressionMethod) DatabaseBrokerOption {
+	return func(o *databaseBrokerOptions) {
+		o.compression = &method
+	}
+}
+
+// DatabaseBrokerPasteKDF sets what the keys of newly encrypted pastes are derived with. It is
+// crypto.LegacyKDF unless another is chosen; every paste records its own.
+func DatabaseBrokerPasteKDF(kdf crypto.KDF) DatabaseBrokerOption {
+	return func(o *databaseBrokerOptions) {
+		o.pasteKDF = kdf
+	}
+}
+
+// execQuery runs q, built by qb, on db. It goes around gorm, which would otherwise take the
+// placeholders qb has already written for the dialect as its own.
+func execQuery(db *gorm.DB, qb querybuilder.QueryBuilder, q querybuilder.Query) error {
+	stmt, err := qb.BuildStatement(q)
+	if err != nil {
+		return err
+	}
+	_, err = db.CommonDB().Exec(stmt.SQL, stmt.Args...)
+	return err
+}
+
+func NewDatabaseBroker(dialect string, sqlDb *sql.DB, challengeProvider crypto.ChallengeProvider, options ...DatabaseBrokerOption) (Broker, error) {
+	var opts databaseBrokerOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	qb := querybuilder.New(dialect)
+	if qb == nil {
+		return nil, errors.New("model: unsupported database dialect " + dialect)
+	}
+
 	db, err := gorm.Open(dialect, sqlDb)
 	if err != nil {
 		return nil, err
 	}
-	db = db.Debug()
 
-	interfacesToMigrate := []interface{}{
-		&dbPaste{},
-		&dbPasteBody{},
-		&dbUser{},
-		&dbUserPastePermission{},
-		&dbGrant{},
+	switch opts.logLevel {
+	case DatabaseLogNone:
+		db = db.LogMode(false)
+	case DatabaseLogAll:
+		db = db.LogMode(true)
 	}
 
-	if err := db.AutoMigrate(interfacesToMigrate...).Error; err != nil {
+	migrator, err := newSchemaMigrator(dialect, db)
+	if err != nil {
 		return nil, err
 	}
 
+	if opts.manualMigration {
+		err = migrator.Check()
+	} else {
+		err = migrator.Up(0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bodies := opts.bodyStore
+	if bodies == nil {
+		bodies = &dbPasteBodyStore{db: db, qb: qb}
+	}
+
+	pasteIDs := opts.pasteIDs
+	if pasteIDs == nil {
+		pasteIDs = NewPasteIDGenerator()
+	}
+	if err := pasteIDs.Validate(); err != nil {
+		return nil, err
+	}
+
+	viewInterval := defaultPasteViewFlushInterval
+	if opts.viewInterval != nil {
+		viewInterval = *opts.viewInterval
+	}
+
+	compression := PasteCompressionMethodGzip
+	if opts.compression != nil {
+		compression = *opts.compression
+	}
+	if _, ok := pasteCompressionMethodNames[compression]; !ok {
+		return nil, errors.New("model: unknown paste compression method")
+	}
+
+	pasteKDF := opts.pasteKDF
+	if pasteKDF == nil {
+		pasteKDF = crypto.LegacyKDF
+	}
+
 	return &dbBroker{
 		DB:                db,
-		QB:                querybuilder.New(dialect),
+		QB:                qb,
 		ChallengeProvider: challengeProvider,
+		Bodies:            bodies,
+		PasteIDs:          pasteIDs,
+		Compression:       compression,
+		PasteKDF:          pasteKDF,
+		views:             newPasteViewCounter(db, viewInterval),
 	}, nil
 }
@@ -9,9 +9,37 @@ type Broker interface {
 	// Pastes
 	GenerateNewPasteID(bool) PasteID
 	CreatePaste() (Paste, error)
+	// CreatePasteWithID creates a paste with a chosen ID instead of a random one.
+	CreatePasteWithID(PasteID) (Paste, error)
 	CreateEncryptedPaste(PasteEncryptionMethod, []byte) (Paste, error)
+	// CreateClientEncryptedPaste creates a paste whose client encrypts its body; see
+	// Paste.IsClientEncrypted.
+	CreateClientEncryptedPaste() (Paste, error)
 	GetPaste(PasteID, []byte) (Paste, error)
 	GetPastes([]PasteID) ([]Paste, error)
+	// ReencryptPaste encrypts a paste and all its revisions again, under a new salt, with a key
+	// derived from a new passphrase and the given method. It returns PasteNotEncryptedError for
+	// pastes that aren't encrypted, PasteClientEncryptedError for those encrypted by their
+	// clients, and PasteInvalidKeyError if the old passphrase is wrong.
+	// Either every body is re-encrypted, or none is; writes and commits to the paste read with
+	// the old key fail with PasteKeyChangedError.
+	ReencryptPaste(id PasteID, passphrase, newPassphrase []byte, method PasteEncryptionMethod) error
+	SearchPastes(*PasteSearch) ([]Paste, error)
+	// RecordPasteView counts a read of a paste. It is cheap enough to call on every request.
+	RecordPasteView(PasteID)
+	SubscribePasteEvents(PasteEventHandler, PasteEventDelivery) (unsubscribe func())
+
+	// Trash
+	// Erased pastes are kept in the trash, hidden from everything above, until they are purged.
+	// GetTrash returns every paste in the trash and GetTrashedPastes those among ids, most
+	// recently erased first.
+	GetTrash() ([]*TrashedPaste, error)
+	GetTrashedPastes([]PasteID) ([]*TrashedPaste, error)
+	// RestorePaste takes a paste out of the trash. As it may have been erased by expiring,
+	// it no longer expires.
+	RestorePaste(PasteID) error
+	// PurgePaste destroys a paste in the trash, along with its revisions, permissions and grants.
+	PurgePaste(PasteID) error
 
 	// Grants
 	CreateGrant(Paste) (Grant, error)
@@ -0,0 +1,323 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DHowett/ghostbin/lib/crypto"
+)
+
+// memoryBroker keeps everything in memory, and forgets it all when the process exits. It is
+// meant for tests and ephemeral deployments, and behaves as the database broker does: the
+// objects it hands out are copies, and changes to them are seen by others only once they
+// have been committed or written.
+type memoryBroker struct {
+	pasteEventBus
+	ChallengeProvider crypto.ChallengeProvider
+	PasteIDs          *PasteIDGenerator
+	PasteKDF          crypto.KDF
+
+	mu sync.RWMutex
+
+	users      map[uint]*memoryUser
+	userNames  map[string]uint
+	lastUserID uint
+
+	pastes       map[PasteID]*memoryPasteRecord
+	lastPasteSeq uint64
+
+	pastePermissions map[memoryPastePermissionKey]Permission
+
+	grants map[GrantID]PasteID
+}
+
+type memoryBrokerOptions struct {
+	pasteIDs *PasteIDGenerator
+	pasteKDF crypto.KDF
+}
+
+// MemoryBrokerOption configures optional behaviour of a broker created by NewMemoryBroker.
+type MemoryBrokerOption func(*memoryBrokerOptions)
+
+// MemoryBrokerPasteIDs sets how the IDs of new pastes are generated.
+func MemoryBrokerPasteIDs(g *PasteIDGenerator) MemoryBrokerOption {
+	return func(o *memoryBrokerOptions) {
+		o.pasteIDs = g
+	}
+}
+
+// MemoryBrokerPasteKDF sets what the keys of newly encrypted pastes are derived with; see
+// DatabaseBrokerPasteKDF.
+func MemoryBrokerPasteKDF(kdf crypto.KDF) MemoryBrokerOption {
+	return func(o *memoryBrokerOptions) {
+		o.pasteKDF = kdf
+	}
+}
+
+// NewMemoryBroker returns a broker that keeps everything in memory.
+func NewMemoryBroker(challengeProvider crypto.ChallengeProvider, options ...MemoryBrokerOption) (Broker, error) {
+	var opts memoryBrokerOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	pasteIDs := opts.pasteIDs
+	if pasteIDs == nil {
+		pasteIDs = NewPasteIDGenerator()
+	}
+	if err := pasteIDs.Validate(); err != nil {
+		return nil, err
+	}
+
+	pasteKDF := opts.pasteKDF
+	if pasteKDF == nil {
+		pasteKDF = crypto.LegacyKDF
+	}
+
+	return &memoryBroker{
+		ChallengeProvider: challengeProvider,
+		PasteIDs:          pasteIDs,
+		PasteKDF:          pasteKDF,
+		users:             make(map[uint]*memoryUser),
+		userNames:         make(map[string]uint),
+		pastes:            make(map[PasteID]*memoryPasteRecord),
+		pastePermissions:  make(map[memoryPastePermissionKey]Permission),
+		grants:            make(map[GrantID]PasteID),
+	}, nil
+}
+
+// User
+func (broker *memoryBroker) bindUser(record *memoryUser) User {
+	u := *record
+	u.broker = broker
+	return &u
+}
+
+func (broker *memoryBroker) GetUserNamed(name string) (User, error) {
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+	id, ok := broker.userNames[name]
+	if !ok {
+		return nil, UserNotFoundError
+	}
+	return broker.bindUser(broker.users[id]), nil
+}
+
+func (broker *memoryBroker) GetUserByID(id uint) (User, error) {
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+	record, ok := broker.users[id]
+	if !ok {
+		return nil, UserNotFoundError
+	}
+	return broker.bindUser(record), nil
+}
+
+func (broker *memoryBroker) CreateUser(name string) (User, error) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if _, ok := broker.userNames[name]; ok {
+		return nil, fmt.Errorf("model: user %q already exists", name)
+	}
+
+	broker.lastUserID++
+	record := &memoryUser{ID: broker.lastUserID, Name: name}
+	broker.users[record.ID] = record
+	broker.userNames[name] = record.ID
+	return broker.bindUser(record), nil
+}
+
+// Paste
+func (broker *memoryBroker) GenerateNewPasteID(encrypted bool) PasteID {
+	return broker.PasteIDs.generate(encrypted, 0)
+}
+
+// createPaste stores p under id or, if id is empty, under a random ID. Random IDs that turn
+// out to be taken are replaced until a free one is found. The rest of p's metadata is filled in.
+func (broker *memoryBroker) createPaste(p *memoryPaste, id PasteID) error {
+	now := time.Now()
+	p.CreatedAt, p.UpdatedAt = now, now
+	p.LanguageName = "text"
+	p.Size, p.LineCount = -1, -1
+
+	broker.mu.Lock()
+	for attempt := 0; ; attempt++ {
+		p.ID = id
+		if id == "" {
+			p.ID = broker.PasteIDs.generate(p.IsEncrypted(), attempt)
+		}
+		if _, ok := broker.pastes[p.ID]; !ok {
+			break
+		}
+		if id != "" || attempt+1 == maxPasteIDAttempts {
+			broker.mu.Unlock()
+			return PasteIDUnavailableError
+		}
+	}
+	if p.IsEncrypted() {
+		p.HMAC = getPasteEncryptionCodec(p.EncryptionMethod).GenerateHMAC(p.ID, p.EncryptionSalt, p.encryptionKey)
+	}
+	broker.lastPasteSeq++
+	broker.pastes[p.ID] = &memoryPasteRecord{
+		memoryPasteMetadata: p.memoryPasteMetadata,
+		seq:                 broker.lastPasteSeq,
+	}
+	broker.mu.Unlock()
+
+	broker.publish(PasteCreatedEvent, p)
+	return nil
+}
+
+func (broker *memoryBroker) CreatePaste() (Paste, error) {
+	p := &memoryPaste{broker: broker}
+	if err := broker.createPaste(p, ""); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (broker *memoryBroker) CreatePasteWithID(id PasteID) (Paste, error) {
+	if err := ValidatePasteID(id); err != nil {
+		return nil, err
+	}
+	p := &memoryPaste{broker: broker}
+	if err := broker.createPaste(p, id); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (broker *memoryBroker) CreateEncryptedPaste(method PasteEncryptionMethod, passphraseMaterial []byte) (Paste, error) {
+	if passphraseMaterial == nil {
+		return nil, errors.New("MemoryBroker: unacceptable encryption material")
+	}
+	if _, ok := pasteEncryptionCodecs[method]; !ok {
+		return nil, errors.New("model: unsupported encryption method")
+	}
+	p := &memoryPaste{broker: broker}
+	p.EncryptionSalt, _ = generateRandomBytes(16)
+	p.EncryptionMethod = method
+	p.KDF = broker.PasteKDF.String()
+	key, err := getPasteEncryptionCodec(method).DeriveKey(broker.PasteKDF, passphraseMaterial, p.EncryptionSalt)
+	if err != nil {
+		return nil, err
+	}
+	p.encryptionKey = key
+
+	if err := broker.createPaste(p, ""); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (broker *memoryBroker) CreateClientEncryptedPaste() (Paste, error) {
+	p := &memoryPaste{broker: broker}
+	p.EncryptionMethod = PasteEncryptionMethodClient
+	if err := broker.createPaste(p, ""); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (broker *memoryBroker) GetPaste(id PasteID, passphraseMaterial []byte) (Paste, error) {
+	broker.mu.RLock()
+	record, ok := broker.pastes[id]
+	ok = ok && !record.inTrash()
+	var metadata memoryPasteMetadata
+	if ok {
+		metadata = record.memoryPasteMetadata
+	}
+	broker.mu.RUnlock()
+	if !ok {
+		return nil, PasteNotFoundError
+	}
+
+	p := &memoryPaste{memoryPasteMetadata: metadata, broker: broker}
+
+	if p.IsEncrypted() && !p.IsClientEncrypted() {
+		if passphraseMaterial == nil {
+			return &encryptedPastePlaceholder{
+				ID:         id,
+				Expiration: p.GetExpiration(),
+			}, PasteEncryptedError
+		}
+
+		key, err := unlockPaste(id, p.EncryptionMethod, p.KDF, p.EncryptionSalt, p.HMAC, passphraseMaterial)
+		if err != nil {
+			return nil, err
+		}
+		p.encryptionKey = key
+	}
+
+	return p, nil
+}
+
+// wrapPastes binds copies of records to the broker, replacing encrypted pastes with
+// placeholders. The caller must hold the broker's lock.
+func (broker *memoryBroker) wrapPastes(records []*memoryPasteRecord) []Paste {
+	iPastes := make([]Paste, len(records))
+	for i, record := range records {
+		if record.IsEncrypted() {
+			iPastes[i] = &encryptedPastePlaceholder{
+				ID:         record.ID,
+				Expiration: record.Expiration,
+			}
+		} else {
+			iPastes[i] = &memoryPaste{memoryPasteMetadata: record.memoryPasteMetadata, broker: broker}
+		}
+	}
+	return iPastes
+}
+
+func (broker *memoryBroker) GetPastes(ids []PasteID) ([]Paste, error) {
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+	seen := make(map[PasteID]bool, len(ids))
+	records := make([]*memoryPasteRecord, 0, len(ids))
+	for _, id := range ids {
+		if record, ok := broker.pastes[id]; ok && !record.inTrash() && !seen[id] {
+			seen[id] = true
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq < records[j].seq
+	})
+	return broker.wrapPastes(records), nil
+}
+
+func (broker *memoryBroker) RecordPasteView(id PasteID) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if record, ok := broker.pastes[id]; ok {
+		record.ViewCount++
+		record.LastViewedAt = time.Now()
+	}
+}
+
+// Grant
+func (broker *memoryBroker) CreateGrant(paste Paste) (Grant, error) {
+	s, err := generateRandomBase32String(20, 32)
+	if err != nil {
+		return nil, err
+	}
+	grant := &memoryGrant{ID: GrantID(s), PasteID: paste.GetID(), broker: broker}
+
+	broker.mu.Lock()
+	broker.grants[grant.ID] = grant.PasteID
+	broker.mu.Unlock()
+	return grant, nil
+}
+
+func (broker *memoryBroker) GetGrant(id GrantID) (Grant, error) {
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+	pasteID, ok := broker.grants[id]
+	if !ok {
+		return nil, GrantNotFoundError
+	}
+	return &memoryGrant{ID: id, PasteID: pasteID, broker: broker}, nil
+}
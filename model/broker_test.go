@@ -0,0 +1,1183 @@
+package model
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// brokerFactory returns a new, empty broker that generates paste IDs with ids, or with the
+// default generator if ids is nil.
+type brokerFactory func(t *testing.T, ids *PasteIDGenerator) Broker
+
+// testBrokerConformance runs the tests every Broker implementation must pass, giving each
+// its own broker from newBroker.
+func testBrokerConformance(t *testing.T, newBroker brokerFactory) {
+	for _, tc := range []struct {
+		name string
+		test func(*testing.T, Broker)
+	}{
+		{"Users", testBrokerUsers},
+		{"UserPermissions", testBrokerUserPermissions},
+		{"PastePermissions", testBrokerPastePermissions},
+		{"Pastes", testBrokerPastes},
+		{"Encryption", testBrokerEncryption},
+		{"Reencryption", testBrokerReencryption},
+		{"ClientEncryption", testBrokerClientEncryption},
+		{"Revisions", testBrokerRevisions},
+		{"Metadata", testBrokerMetadata},
+		{"Bundles", testBrokerBundles},
+		{"Forks", testBrokerForks},
+		{"Search", testBrokerSearch},
+		{"Grants", testBrokerGrants},
+		{"Trash", testBrokerTrash},
+		{"Attachments", testBrokerAttachments},
+		{"Events", testBrokerEvents},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, newBroker(t, nil))
+		})
+	}
+
+	t.Run("PasteIDs", func(t *testing.T) {
+		testBrokerPasteIDs(t, newBroker)
+	})
+}
+
+func TestDatabaseBrokerConformance(t *testing.T) {
+	testBrokerConformance(t, func(t *testing.T, ids *PasteIDGenerator) Broker {
+		// Views are counted as they happen, so that they can be seen at once.
+		b, err := NewDatabaseBroker("sqlite3", openTestDatabase(t), &noopChallengeProvider{}, DatabaseBrokerPasteIDs(ids), DatabaseBrokerViewFlushInterval(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
+
+func TestMemoryBrokerConformance(t *testing.T) {
+	testBrokerConformance(t, func(t *testing.T, ids *PasteIDGenerator) Broker {
+		b, err := NewMemoryBroker(&noopChallengeProvider{}, MemoryBrokerPasteIDs(ids))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
+
+func readPasteBody(t *testing.T, p Paste) string {
+	r, err := p.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func readAttachmentBody(t *testing.T, a PasteAttachment) string {
+	r, err := a.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func testBrokerUsers(t *testing.T, b Broker) {
+	first, err := b.CreateUser("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.CreateUser("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first user to sign up is made an administrator.
+	if first.GetID() != 1 || second.GetID() != 2 {
+		t.Errorf("users were given IDs %d and %d", first.GetID(), second.GetID())
+	}
+
+	if _, err := b.CreateUser("first"); err == nil {
+		t.Error("created two users with the same name")
+	}
+
+	if u, err := b.GetUserNamed("second"); err != nil || u.GetID() != 2 {
+		t.Errorf("looked up %v by name (%v)", u, err)
+	}
+	if u, err := b.GetUserByID(1); err != nil || u.GetName() != "first" {
+		t.Errorf("looked up %v by ID (%v)", u, err)
+	}
+	if u, err := b.GetUserNamed("third"); u != nil || err != UserNotFoundError {
+		t.Errorf("looked up missing user as %v (%v)", u, err)
+	}
+	if u, err := b.GetUserByID(3); u != nil || err != UserNotFoundError {
+		t.Errorf("looked up missing user as %v (%v)", u, err)
+	}
+
+	if first.Check("") {
+		t.Error("user without a password passed a check")
+	}
+	first.UpdateChallenge("hello world")
+	if !first.Check("hello world") {
+		t.Error("user failed a check with the right password")
+	}
+	first, _ = b.GetUserByID(1)
+	if !first.Check("hello world") {
+		t.Error("password was not kept across lookups")
+	}
+}
+
+func testBrokerUserPermissions(t *testing.T, b Broker) {
+	u, err := b.CreateUser("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Permissions(PermissionClassUser).Has(UserPermissionAdmin) {
+		t.Error("new user is an administrator")
+	}
+	if err := u.Permissions(PermissionClassUser).Grant(UserPermissionAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if !u.Permissions(PermissionClassUser).Has(UserPermissionAdmin) {
+		t.Error("granted permission missing")
+	}
+	u, _ = b.GetUserNamed("admin")
+	if !u.Permissions(PermissionClassUser).Has(UserPermissionAdmin) {
+		t.Error("granted permission missing across lookups")
+	}
+
+	if err := u.Permissions(PermissionClassUser).Revoke(UserPermissionAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if u.Permissions(PermissionClassUser).Has(UserPermissionAdmin) {
+		t.Error("revoked permission still held")
+	}
+	u, _ = b.GetUserNamed("admin")
+	if u.Permissions(PermissionClassUser).Has(UserPermissionAdmin) {
+		t.Error("revoked permission still held across lookups")
+	}
+}
+
+func testBrokerPastePermissions(t *testing.T, b Broker) {
+	u, err := b.CreateUser("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scope := u.Permissions(PermissionClassPaste, "abcde")
+	if scope.Has(PastePermissionEdit) {
+		t.Error("new user can edit a paste")
+	}
+	if err := scope.Grant(PastePermissionEdit); err != nil {
+		t.Fatal(err)
+	}
+	if err := scope.Grant(PastePermissionGrant); err != nil {
+		t.Fatal(err)
+	}
+	if !scope.Has(PastePermissionEdit) || !scope.Has(PastePermissionGrant) {
+		t.Error("granted permissions missing")
+	}
+	if err := u.Permissions(PermissionClassPaste, PasteID("fghjk")).Grant(PastePermissionEdit); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ = b.GetUserNamed("owner")
+	scope = u.Permissions(PermissionClassPaste, PasteID("abcde"))
+	if !scope.Has(PastePermissionEdit) || !scope.Has(PastePermissionGrant) {
+		t.Error("granted permissions missing across lookups")
+	}
+	if ids, err := u.GetPastes(); err != nil || len(ids) != 2 {
+		t.Errorf("user holds permissions on %v (%v); expected 2 pastes", ids, err)
+	}
+
+	if err := scope.Revoke(PastePermissionEdit); err != nil {
+		t.Fatal(err)
+	}
+	if scope.Has(PastePermissionEdit) || !scope.Has(PastePermissionGrant) {
+		t.Error("revoked the wrong permissions")
+	}
+	if err := scope.Revoke(PastePermissionAll); err != nil {
+		t.Fatal(err)
+	}
+	// Granting again after revoking everything must recreate what was removed.
+	if err := scope.Grant(PastePermissionEdit); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Permissions(PermissionClassPaste, "fghjk").Revoke(PastePermissionAll); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ = b.GetUserNamed("owner")
+	if !u.Permissions(PermissionClassPaste, "abcde").Has(PastePermissionEdit) {
+		t.Error("regranted permission missing across lookups")
+	}
+	if ids, err := u.GetPastes(); err != nil || !reflect.DeepEqual(ids, []PasteID{"abcde"}) {
+		t.Errorf("user holds permissions on %v (%v); expected only abcde", ids, err)
+	}
+}
+
+func testBrokerPastes(t *testing.T, b Broker) {
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GetID() == "" || p.IsEncrypted() {
+		t.Errorf("created paste %q (encrypted: %v)", p.GetID(), p.IsEncrypted())
+	}
+	if p.GetLanguageName() != "text" {
+		t.Errorf("new paste has language %q", p.GetLanguageName())
+	}
+	if body := readPasteBody(t, p); body != "" {
+		t.Errorf("new paste has body <%s>", body)
+	}
+
+	p.SetTitle("title")
+	p.SetLanguageName("go")
+	p.SetExpiration("10m")
+	writePasteBody(t, p, "hello")
+
+	p, err = b.GetPaste(p.GetID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GetTitle() != "title" || p.GetLanguageName() != "go" || p.GetExpiration() != "10m" {
+		t.Errorf("written paste has title %q, language %q, expiration %q", p.GetTitle(), p.GetLanguageName(), p.GetExpiration())
+	}
+	if body := readPasteBody(t, p); body != "hello" {
+		t.Errorf("written paste has body <%s>", body)
+	}
+	written := p.GetModificationTime()
+
+	p.SetTitle("retitled")
+	if other, _ := b.GetPaste(p.GetID(), nil); other.GetTitle() != "title" {
+		t.Errorf("uncommitted title %q seen", other.GetTitle())
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	p, _ = b.GetPaste(p.GetID(), nil)
+	if p.GetTitle() != "retitled" {
+		t.Errorf("committed paste has title %q", p.GetTitle())
+	}
+	if !p.GetModificationTime().After(written) {
+		t.Error("committing a paste did not change its modification time")
+	}
+
+	writePasteBody(t, p, "goodbye")
+	if body := readPasteBody(t, p); body != "goodbye" {
+		t.Errorf("rewritten paste has body <%s>", body)
+	}
+
+	encrypted, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted.SetTitle("secret title")
+	encrypted.SetExpiration("1h")
+	if err := encrypted.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := b.GetPastes([]PasteID{"missing", p.GetID(), encrypted.GetID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 || ps[0].GetID() != p.GetID() || ps[0].GetTitle() != "retitled" {
+		t.Fatalf("looked up %v", ps)
+	}
+	if _, ok := ps[1].(*encryptedPastePlaceholder); !ok || ps[1].GetID() != encrypted.GetID() {
+		t.Errorf("encrypted paste %v was not a placeholder", ps[1].GetID())
+	}
+	// Only what is kept in the clear shows through.
+	if ps[1].GetTitle() != "" || ps[1].GetExpiration() != "1h" {
+		t.Errorf("placeholder has title %q and expiration %q", ps[1].GetTitle(), ps[1].GetExpiration())
+	}
+
+	if err := p.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if other, err := b.GetPaste(p.GetID(), nil); other != nil || err != PasteNotFoundError {
+		t.Errorf("looked up erased paste as %v (%v)", other, err)
+	}
+	if err := b.PurgePaste(p.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, p); body != "" {
+		t.Errorf("purged paste has body <%s>", body)
+	}
+	if revs, err := p.GetRevisions(); len(revs) != 0 || err != nil {
+		t.Errorf("purged paste has %d revisions (%v)", len(revs), err)
+	}
+}
+
+func testBrokerPasteIDs(t *testing.T, newBroker brokerFactory) {
+	// With two letters to choose from, the IDs tried for a paste must soon grow.
+	b := newBroker(t, &PasteIDGenerator{Alphabet: "ab", Length: 1, EncryptedLength: 2})
+	seen := make(map[PasteID]bool)
+	for i := 0; i < 12; i++ {
+		p, err := b.CreatePaste()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := p.GetID()
+		if seen[id] || strings.Trim(id.String(), "ab") != "" {
+			t.Fatalf("created paste %q after %v", id, seen)
+		}
+		seen[id] = true
+	}
+	if p, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase")); err != nil || len(p.GetID()) < 2 {
+		t.Errorf("created encrypted paste %v (%v)", p, err)
+	}
+	// Those grown for one paste aren't for the next.
+	if id := b.GenerateNewPasteID(false); len(id) != 1 {
+		t.Errorf("generated paste ID %q after IDs had to grow", id)
+	}
+
+	b = newBroker(t, nil)
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.GetID()) != 5 {
+		t.Errorf("default paste ID %q is not 5 characters long", p.GetID())
+	}
+
+	vanity, err := b.CreatePasteWithID("my-Vanity_slug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, vanity, "vain")
+	if found, err := b.GetPaste("my-Vanity_slug", nil); err != nil || readPasteBody(t, found) != "vain" {
+		t.Errorf("looked up vanity paste %v (%v)", found, err)
+	}
+	if _, err := b.CreatePasteWithID("my-Vanity_slug"); err != PasteIDUnavailableError {
+		t.Errorf("reused a vanity ID (%v)", err)
+	}
+	if _, err := b.CreatePasteWithID(p.GetID()); err != PasteIDUnavailableError {
+		t.Errorf("reused a random ID (%v)", err)
+	}
+	for _, id := range []PasteID{"", "a/b", "a@1", "a~b", "../x", PasteID(strings.Repeat("x", MaxPasteIDLength+1))} {
+		if _, err := b.CreatePasteWithID(id); err != PasteIDInvalidError {
+			t.Errorf("created a paste with ID %q (%v)", id, err)
+		}
+	}
+}
+
+func testBrokerEncryption(t *testing.T, b Broker) {
+	if _, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, nil); err == nil {
+		t.Error("created an encrypted paste without a passphrase")
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsEncrypted() {
+		t.Error("encrypted paste isn't")
+	}
+	p.SetTitle("secret title")
+	writePasteBody(t, p, "secret data!")
+
+	if pBad, err := b.GetPaste(p.GetID(), []byte("bad!")); pBad != nil || err != PasteInvalidKeyError {
+		t.Errorf("looked up %v with a bad passphrase (%v)", pBad, err)
+	}
+
+	pFacade, err := b.GetPaste(p.GetID(), nil)
+	if err != PasteEncryptedError || pFacade == nil {
+		t.Fatalf("looked up %v without a passphrase (%v)", pFacade, err)
+	}
+	if pFacade.GetID() != p.GetID() || pFacade.GetTitle() != "" {
+		t.Errorf("placeholder has ID %v, title %q", pFacade.GetID(), pFacade.GetTitle())
+	}
+	if r, err := pFacade.Reader(); r != nil || err == nil {
+		t.Error("placeholder is readable")
+	}
+
+	pReal, err := b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pReal.GetTitle() != "secret title" {
+		t.Errorf("decrypted paste has title %q", pReal.GetTitle())
+	}
+	if body := readPasteBody(t, pReal); body != "secret data!" {
+		t.Errorf("decrypted paste has body <%s>", body)
+	}
+
+	// Methods that would encrypt a second body with the keystream of the first are left
+	// behind before it is written.
+	writePasteBody(t, pReal, "more secret data!")
+	pReal, err = b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := pReal.GetRevision(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRevisionBody(t, rev); body != "secret data!" {
+		t.Errorf("first revision of a paste written again has body <%s>", body)
+	}
+	if body := readPasteBody(t, pReal); body != "more secret data!" {
+		t.Errorf("paste written again has body <%s>", body)
+	}
+	if _, err := p.Writer(); err != PasteKeyChangedError {
+		t.Errorf("wrote a paste with a method it no longer uses (%v)", err)
+	}
+}
+
+func testBrokerReencryption(t *testing.T, b Broker) {
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTitle("secret title")
+	writePasteBody(t, p, "first secret")
+	writePasteBody(t, p, "second secret")
+
+	if err := b.ReencryptPaste(p.GetID(), []byte("wrong"), []byte("new"), PasteEncryptionMethodXChaCha20_Poly1305); err != PasteInvalidKeyError {
+		t.Errorf("re-encrypted a paste with the wrong passphrase (%v)", err)
+	}
+	plain, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ReencryptPaste(plain.GetID(), []byte("old"), []byte("new"), PasteEncryptionMethodXChaCha20_Poly1305); err != PasteNotEncryptedError {
+		t.Errorf("re-encrypted an unencrypted paste (%v)", err)
+	}
+	if err := b.ReencryptPaste("nonexistent", []byte("old"), []byte("new"), PasteEncryptionMethodXChaCha20_Poly1305); err != PasteNotFoundError {
+		t.Errorf("re-encrypted a paste that doesn't exist (%v)", err)
+	}
+
+	if err := b.ReencryptPaste(p.GetID(), []byte("old"), []byte("new"), PasteEncryptionMethodXChaCha20_Poly1305); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetPaste(p.GetID(), []byte("old")); err != PasteInvalidKeyError {
+		t.Errorf("looked up a re-encrypted paste with its old passphrase (%v)", err)
+	}
+	pNew, err := b.GetPaste(p.GetID(), []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, pNew); body != "second secret" {
+		t.Errorf("re-encrypted paste has body <%s>", body)
+	}
+	revs, err := pNew.GetRevisions()
+	if err != nil || len(revs) != 2 {
+		t.Fatalf("re-encrypted paste has %d revisions (%v)", len(revs), err)
+	}
+	if body := readRevisionBody(t, revs[0]); body != "first secret" || revs[0].GetTitle() != "secret title" {
+		t.Errorf("re-encrypted revision %q has body <%s>", revs[0].GetTitle(), body)
+	}
+
+	// The paste read with the old passphrase can no longer be written or committed; its
+	// title would be sealed under the old key.
+	w, err := p.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("written with the old key"))
+	if err := w.Close(); err != PasteKeyChangedError {
+		t.Errorf("wrote a re-encrypted paste with its old key (%v)", err)
+	}
+	p.SetTitle("retitled")
+	if err := p.Commit(); err != PasteKeyChangedError {
+		t.Errorf("committed a re-encrypted paste with its old key (%v)", err)
+	}
+	pNew, err = b.GetPaste(p.GetID(), []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, pNew); body != "second secret" || pNew.GetTitle() != "secret title" {
+		t.Errorf("re-encrypted paste %q has body <%s>", pNew.GetTitle(), body)
+	}
+	writePasteBody(t, pNew, "third secret")
+	if body := readPasteBody(t, pNew); body != "third secret" {
+		t.Errorf("re-encrypted paste was written as <%s>", body)
+	}
+}
+
+func testBrokerClientEncryption(t *testing.T, b Broker) {
+	if _, err := b.CreateEncryptedPaste(PasteEncryptionMethodClient, []byte("passphrase")); err == nil {
+		t.Error("created a paste for the server to encrypt for its client")
+	}
+
+	p, err := b.CreateClientEncryptedPaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsEncrypted() || !p.IsClientEncrypted() {
+		t.Errorf("client-encrypted paste is encrypted: %v, by its client: %v", p.IsEncrypted(), p.IsClientEncrypted())
+	}
+	ciphertext := "\x00\xffopaque\nciphertext\n"
+	p.SetTitle("visible title")
+	writePasteBody(t, p, ciphertext)
+
+	got, err := b.GetPaste(p.GetID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsClientEncrypted() || got.GetTitle() != "visible title" {
+		t.Errorf("looked up paste %q, encrypted by its client: %v", got.GetTitle(), got.IsClientEncrypted())
+	}
+	if body := readPasteBody(t, got); body != ciphertext {
+		t.Errorf("client-encrypted paste has body %q", body)
+	}
+	if got.GetSize() != int64(len(ciphertext)) || got.GetLineCount() != -1 {
+		t.Errorf("client-encrypted paste has %d bytes in %d lines", got.GetSize(), got.GetLineCount())
+	}
+	if got, err := b.GetPaste(p.GetID(), []byte("anything")); err != nil || readPasteBody(t, got) != ciphertext {
+		t.Errorf("looked up a client-encrypted paste with a passphrase (%v)", err)
+	}
+
+	ps, err := b.GetPastes([]PasteID{p.GetID()})
+	if err != nil || len(ps) != 1 || ps[0].GetTitle() != "" {
+		t.Errorf("listed client-encrypted pastes %v (%v)", ps, err)
+	}
+	if err := b.ReencryptPaste(p.GetID(), nil, []byte("new"), PasteEncryptionMethodXChaCha20_Poly1305); err != PasteClientEncryptedError {
+		t.Errorf("re-encrypted a client-encrypted paste (%v)", err)
+	}
+}
+
+func testBrokerRevisions(t *testing.T, b Broker) {
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.SetTitle("first")
+	p.SetEditor(PasteEditor{Session: "abc"})
+	writePasteBody(t, p, "one")
+
+	p.SetTitle("second")
+	p.SetLanguageName("go")
+	p.SetEditor(PasteEditor{UserID: 1})
+	writePasteBody(t, p, "two")
+
+	p, _ = b.GetPaste(p.GetID(), nil)
+	revs, err := p.GetRevisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("expected 2 revisions, got %d", len(revs))
+	}
+	if revs[0].GetNumber() != 1 || revs[0].GetTitle() != "first" || revs[0].GetEditor() != (PasteEditor{Session: "abc"}) {
+		t.Errorf("revision 1 has wrong metadata: %d %q %+v", revs[0].GetNumber(), revs[0].GetTitle(), revs[0].GetEditor())
+	}
+	if revs[1].GetNumber() != 2 || revs[1].GetTitle() != "second" || revs[1].GetLanguageName() != "go" || revs[1].GetEditor() != (PasteEditor{UserID: 1}) {
+		t.Errorf("revision 2 has wrong metadata: %d %q %q %+v", revs[1].GetNumber(), revs[1].GetTitle(), revs[1].GetLanguageName(), revs[1].GetEditor())
+	}
+	if revs[0].GetPasteID() != p.GetID() || revs[1].GetTime().Before(revs[0].GetTime()) {
+		t.Errorf("revisions belong to %v at %v, %v", revs[0].GetPasteID(), revs[0].GetTime(), revs[1].GetTime())
+	}
+	if body := readRevisionBody(t, revs[0]); body != "one" {
+		t.Errorf("revision 1 has body <%s>", body)
+	}
+
+	rev, err := p.GetRevision(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRevisionBody(t, rev); body != "two" {
+		t.Errorf("revision 2 has body <%s>", body)
+	}
+	if _, err := p.GetRevision(3); err != PasteRevisionNotFoundError {
+		t.Errorf("expected PasteRevisionNotFoundError, got %v", err)
+	}
+
+	encrypted, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, encrypted, "secret one")
+	writePasteBody(t, encrypted, "secret two")
+	encrypted, _ = b.GetPaste(encrypted.GetID(), []byte("passphrase"))
+	rev, err = encrypted.GetRevision(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRevisionBody(t, rev); body != "secret one" {
+		t.Errorf("encrypted revision 1 has body <%s>", body)
+	}
+}
+
+func testBrokerMetadata(t *testing.T, b Broker) {
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GetSize() != -1 || p.GetLineCount() != -1 || p.GetViewCount() != 0 || !p.GetLastViewTime().IsZero() {
+		t.Errorf("new paste has size %d, %d lines, %d views at %v", p.GetSize(), p.GetLineCount(), p.GetViewCount(), p.GetLastViewTime())
+	}
+
+	p.SetEditor(PasteEditor{UserID: 7})
+	writePasteBody(t, p, "one\ntwo\nthree")
+	p.SetEditor(PasteEditor{Session: "abc"})
+	writePasteBody(t, p, "one\ntwo\n")
+
+	b.RecordPasteView(p.GetID())
+	b.RecordPasteView(p.GetID())
+	b.RecordPasteView("missing")
+
+	p, _ = b.GetPaste(p.GetID(), nil)
+	if p.GetCreator() != (PasteEditor{UserID: 7}) {
+		t.Errorf("paste created by %+v", p.GetCreator())
+	}
+	if p.GetSize() != 8 || p.GetLineCount() != 2 {
+		t.Errorf("paste has size %d and %d lines", p.GetSize(), p.GetLineCount())
+	}
+	if p.GetViewCount() != 2 || time.Since(p.GetLastViewTime()) > time.Minute {
+		t.Errorf("paste has %d views, last at %v", p.GetViewCount(), p.GetLastViewTime())
+	}
+
+	// Saving a paste that was read before a view must not lose the view.
+	stale, _ := b.GetPaste(p.GetID(), nil)
+	b.RecordPasteView(p.GetID())
+	stale.SetTitle("retitled")
+	if err := stale.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, stale, "")
+	p, _ = b.GetPaste(p.GetID(), nil)
+	if p.GetViewCount() != 3 || p.GetSize() != 0 || p.GetLineCount() != 0 || p.GetCreator() != (PasteEditor{UserID: 7}) {
+		t.Errorf("saved paste has %d views, size %d, %d lines, creator %+v", p.GetViewCount(), p.GetSize(), p.GetLineCount(), p.GetCreator())
+	}
+
+	// Encrypted pastes aren't counted, lest their lengths give them away.
+	encrypted, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, encrypted, "secret\n")
+	encrypted, _ = b.GetPaste(encrypted.GetID(), []byte("passphrase"))
+	if encrypted.GetSize() != -1 || encrypted.GetLineCount() != -1 {
+		t.Errorf("encrypted paste has size %d and %d lines", encrypted.GetSize(), encrypted.GetLineCount())
+	}
+}
+
+func testBrokerBundles(t *testing.T, b Broker) {
+	plain, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []Paste{plain, encrypted} {
+		p.SetBundle(true)
+		writePasteBody(t, p, "not really a bundle")
+		p.SetBundle(false)
+		writePasteBody(t, p, "one file")
+		// Whether it is a bundle is never read from the body.
+		writePasteBody(t, p, "--ghostbin-bundle-")
+
+		p, err := b.GetPaste(p.GetID(), []byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.IsBundle() {
+			t.Errorf("paste %s is a bundle", p.GetID())
+		}
+		revs, err := p.GetRevisions()
+		if err != nil || len(revs) != 3 {
+			t.Fatalf("paste %s has %d revisions (%v)", p.GetID(), len(revs), err)
+		}
+		for i, want := range []bool{true, false, false} {
+			if revs[i].IsBundle() != want {
+				t.Errorf("revision %d of %s is a bundle: %v", i+1, p.GetID(), revs[i].IsBundle())
+			}
+		}
+	}
+
+	encrypted.SetBundle(true)
+	if err := encrypted.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := b.GetPaste(encrypted.GetID(), []byte("passphrase")); err != nil || !p.IsBundle() {
+		t.Errorf("encrypted paste lost that it is a bundle (%v)", err)
+	}
+}
+
+func testBrokerForks(t *testing.T, b Broker) {
+	parent, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, parent, "original")
+
+	fork, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork.SetParentID(parent.GetID())
+	writePasteBody(t, fork, "changed")
+
+	encryptedFork, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedFork.SetParentID(parent.GetID())
+	writePasteBody(t, encryptedFork, "secret")
+
+	fork, _ = b.GetPaste(fork.GetID(), nil)
+	if fork.GetParentID() != parent.GetID() {
+		t.Errorf("fork has parent %q; expected %q", fork.GetParentID(), parent.GetID())
+	}
+
+	forks, err := parent.GetForks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forks) != 2 {
+		t.Fatalf("expected 2 forks, got %d", len(forks))
+	}
+	if forks[0].GetID() != fork.GetID() {
+		t.Errorf("first fork was %v; expected %v", forks[0].GetID(), fork.GetID())
+	}
+	if _, ok := forks[1].(*encryptedPastePlaceholder); !ok || forks[1].GetID() != encryptedFork.GetID() {
+		t.Errorf("encrypted fork %v was not a placeholder", forks[1].GetID())
+	}
+	if forks, err := fork.GetForks(); len(forks) != 0 || err != nil {
+		t.Errorf("fork has %d forks of its own (%v)", len(forks), err)
+	}
+}
+
+func testBrokerSearch(t *testing.T, b Broker) {
+	u, err := b.CreateUser("searcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newPaste := func(title, language, body string) Paste {
+		p, err := b.CreatePaste()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.SetTitle(title)
+		p.SetLanguageName(language)
+		writePasteBody(t, p, body)
+		return p
+	}
+
+	owned := newPaste("Widget Notes", "go", "the quick brown fox")
+	u.Permissions(PermissionClassPaste, owned.GetID()).Grant(PastePermissionEdit)
+	inSession := newPaste("", "text", "quick silver")
+	newPaste("", "text", "quick but nobody's")
+
+	encrypted, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, encrypted, "quick secret")
+	u.Permissions(PermissionClassPaste, encrypted.GetID()).Grant(PastePermissionEdit)
+
+	search := func(s PasteSearch) []PasteID {
+		ps, err := b.SearchPastes(&s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]PasteID, len(ps))
+		for i, p := range ps {
+			ids[i] = p.GetID()
+		}
+		return ids
+	}
+
+	userID, session := u.GetID(), []PasteID{inSession.GetID()}
+	for _, tc := range []struct {
+		name     string
+		search   PasteSearch
+		expected []Paste
+	}{
+		{"User", PasteSearch{Terms: "quick", UserID: userID}, []Paste{owned}},
+		{"Session", PasteSearch{Terms: "quick", PasteIDs: session}, []Paste{inSession}},
+		{"NoScope", PasteSearch{Terms: "quick"}, nil},
+		{"NoTerms", PasteSearch{Terms: `""`, UserID: userID}, nil},
+		{"Title", PasteSearch{Terms: "WIDGET", UserID: userID}, []Paste{owned}},
+		{"AllTermsMustMatch", PasteSearch{Terms: "quick silver", UserID: userID, PasteIDs: session}, []Paste{inSession}},
+		{"Language", PasteSearch{Terms: "quick", LanguageName: "text", UserID: userID, PasteIDs: session}, []Paste{inSession}},
+		{"ModifiedAfter", PasteSearch{Terms: "quick", ModifiedAfter: time.Now().Add(time.Hour), UserID: userID}, nil},
+		{"ModifiedBefore", PasteSearch{Terms: "quick", ModifiedBefore: time.Now().Add(time.Hour), UserID: userID}, []Paste{owned}},
+		{"Encrypted", PasteSearch{Terms: "secret", UserID: userID}, nil},
+		// The most recently modified paste comes first.
+		{"Order", PasteSearch{Terms: "quick", UserID: userID, PasteIDs: session}, []Paste{inSession, owned}},
+		{"Limit", PasteSearch{Terms: "quick", UserID: userID, PasteIDs: session, Limit: 1}, []Paste{inSession}},
+		{"Offset", PasteSearch{Terms: "quick", UserID: userID, PasteIDs: session, Offset: 1}, []Paste{owned}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ids := search(tc.search)
+			expected := make([]PasteID, len(tc.expected))
+			for i, p := range tc.expected {
+				expected[i] = p.GetID()
+			}
+			if len(ids) != 0 || len(expected) != 0 {
+				if !reflect.DeepEqual(ids, expected) {
+					t.Errorf("found %v; expected %v", ids, expected)
+				}
+			}
+		})
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	writePasteBody(t, owned, "the slow brown fox")
+	if ids := search(PasteSearch{Terms: "quick", UserID: userID}); len(ids) != 0 {
+		t.Errorf("stale body found: %v", ids)
+	}
+	owned.SetTitle("Gadget Notes")
+	if err := owned.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search(PasteSearch{Terms: "gadget slow", UserID: userID}); len(ids) != 1 {
+		t.Errorf("retitled paste not found: %v", ids)
+	}
+
+	if err := inSession.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search(PasteSearch{Terms: "silver", PasteIDs: session}); len(ids) != 0 {
+		t.Errorf("erased paste found: %v", ids)
+	}
+}
+
+func testBrokerGrants(t *testing.T, b Broker) {
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grant, err := b.CreateGrant(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.GetID() == "" || grant.GetPasteID() != p.GetID() {
+		t.Errorf("created grant %q for %v", grant.GetID(), grant.GetPasteID())
+	}
+
+	found, err := b.GetGrant(grant.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.GetPasteID() != p.GetID() {
+		t.Errorf("looked up grant for %v", found.GetPasteID())
+	}
+
+	if err := found.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := b.GetGrant(grant.GetID()); found != nil || err != GrantNotFoundError {
+		t.Errorf("looked up destroyed grant as %v (%v)", found, err)
+	}
+}
+
+func testBrokerTrash(t *testing.T, b Broker) {
+	u, err := b.CreateUser("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTitle("doomed")
+	p.SetExpiration("10m")
+	writePasteBody(t, p, "doomed body")
+	if err := u.Permissions(PermissionClassPaste, p.GetID()).Grant(PastePermissionAll); err != nil {
+		t.Fatal(err)
+	}
+	grant, err := b.CreateGrant(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork.SetParentID(p.GetID())
+	if err := fork.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fork.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if forks, err := p.GetForks(); len(forks) != 0 || err != nil {
+		t.Errorf("erased fork was listed (%v)", err)
+	}
+	if err := p.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Erase(); err != nil {
+		t.Errorf("erasing an erased paste failed: %v", err)
+	}
+
+	if other, err := b.GetPaste(p.GetID(), nil); other != nil || err != PasteNotFoundError {
+		t.Errorf("looked up erased paste as %v (%v)", other, err)
+	}
+	if ps, _ := b.GetPastes([]PasteID{p.GetID()}); len(ps) != 0 {
+		t.Error("erased paste was looked up")
+	}
+	if found, _ := b.SearchPastes(&PasteSearch{Terms: "doomed", PasteIDs: []PasteID{p.GetID()}}); len(found) != 0 {
+		t.Error("erased paste was found")
+	}
+	if _, err := b.CreatePasteWithID(p.GetID()); err != PasteIDUnavailableError {
+		t.Errorf("reused the ID of an erased paste (%v)", err)
+	}
+
+	trash, err := b.GetTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 2 || trash[0].GetID() != p.GetID() || trash[1].GetID() != fork.GetID() || trash[0].GetTitle() != "doomed" {
+		t.Fatalf("trash holds %v", trash)
+	}
+	if time.Since(trash[0].TrashedAt) > time.Minute || trash[0].TrashedAt.Before(trash[1].TrashedAt) {
+		t.Errorf("pastes trashed at %v and %v", trash[0].TrashedAt, trash[1].TrashedAt)
+	}
+	if trash, _ := b.GetTrashedPastes([]PasteID{p.GetID(), "missing"}); len(trash) != 1 || trash[0].GetID() != p.GetID() {
+		t.Errorf("looked up trash %v", trash)
+	}
+
+	if err := b.RestorePaste(p.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RestorePaste(p.GetID()); err != PasteNotFoundError {
+		t.Errorf("restored a paste that wasn't erased (%v)", err)
+	}
+	p, err = b.GetPaste(p.GetID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, p); body != "doomed body" || p.GetTitle() != "doomed" || p.GetExpiration() != "" {
+		t.Errorf("restored paste has body <%s>, title %q and expiration %q", body, p.GetTitle(), p.GetExpiration())
+	}
+	if revs, _ := p.GetRevisions(); len(revs) != 1 {
+		t.Errorf("restored paste has %d revisions", len(revs))
+	}
+	if found, _ := b.SearchPastes(&PasteSearch{Terms: "doomed", PasteIDs: []PasteID{p.GetID()}}); len(found) != 1 {
+		t.Error("restored paste wasn't found")
+	}
+
+	if err := b.PurgePaste(p.GetID()); err != PasteNotFoundError {
+		t.Errorf("purged a paste that wasn't erased (%v)", err)
+	}
+	if err := p.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PurgePaste(p.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RestorePaste(p.GetID()); err != PasteNotFoundError {
+		t.Errorf("restored a purged paste (%v)", err)
+	}
+	if trash, _ := b.GetTrash(); len(trash) != 1 {
+		t.Errorf("trash holds %d pastes after a purge", len(trash))
+	}
+	if body := readPasteBody(t, p); body != "" {
+		t.Errorf("purged paste has body <%s>", body)
+	}
+	if u.Permissions(PermissionClassPaste, p.GetID()).Has(PastePermissionEdit) {
+		t.Error("permissions on a purged paste survived")
+	}
+	if _, err := b.GetGrant(grant.GetID()); err != GrantNotFoundError {
+		t.Errorf("grant for a purged paste survived (%v)", err)
+	}
+
+	encrypted, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encrypted.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	trash, _ = b.GetTrashedPastes([]PasteID{encrypted.GetID()})
+	if len(trash) != 1 {
+		t.Fatalf("trash holds %v", trash)
+	}
+	if _, ok := trash[0].Paste.(*encryptedPastePlaceholder); !ok {
+		t.Error("erased encrypted paste was not a placeholder")
+	}
+}
+
+func testBrokerAttachments(t *testing.T, b Broker) {
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "see attached")
+
+	if as, err := p.GetAttachments(); len(as) != 0 || err != nil {
+		t.Errorf("new paste has %d attachments (%v)", len(as), err)
+	}
+	if _, err := p.GetAttachment("missing.png"); err != PasteAttachmentNotFoundError {
+		t.Errorf("looked up a missing attachment (%v)", err)
+	}
+
+	shot := "\x89PNG\r\n\x1a\n\x00binary"
+	a, err := p.Attach("shot.png", "image/png", strings.NewReader(shot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.GetPasteID() != p.GetID() || a.GetName() != "shot.png" || a.GetMIMEType() != "image/png" || a.GetSize() != int64(len(shot)) {
+		t.Errorf("attached %s to %s as %s, %d bytes", a.GetName(), a.GetPasteID(), a.GetMIMEType(), a.GetSize())
+	}
+	if time.Since(a.GetCreationTime()) > time.Minute {
+		t.Errorf("attachment created at %v", a.GetCreationTime())
+	}
+	if _, err := p.Attach("logs.tar.gz", "application/gzip", strings.NewReader("old logs")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Attach("logs.tar.gz", "application/x-gzip", strings.NewReader("new logs")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", ".", "..", "dir/file", "dir\\file", "bell\a", strings.Repeat("x", 192)} {
+		if _, err := p.Attach(name, "text/plain", strings.NewReader("bad")); err != PasteAttachmentNameInvalidError {
+			t.Errorf("attached %q (%v)", name, err)
+		}
+	}
+
+	p, err = b.GetPaste(p.GetID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	as, err := p.GetAttachments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(as) != 2 || as[0].GetName() != "logs.tar.gz" || as[1].GetName() != "shot.png" {
+		t.Fatalf("paste has attachments %v", as)
+	}
+	if body := readAttachmentBody(t, as[0]); body != "new logs" || as[0].GetMIMEType() != "application/x-gzip" {
+		t.Errorf("replaced attachment is %s <%s>", as[0].GetMIMEType(), body)
+	}
+	a, err = p.GetAttachment("shot.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readAttachmentBody(t, a); body != shot {
+		t.Errorf("attachment has body <%q>", body)
+	}
+	if body := readPasteBody(t, p); body != "see attached" {
+		t.Errorf("attaching changed the body to <%s>", body)
+	}
+	if revs, _ := p.GetRevisions(); len(revs) != 1 {
+		t.Errorf("attaching left %d revisions", len(revs))
+	}
+
+	if err := p.Detach("logs.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Detach("logs.tar.gz"); err != nil {
+		t.Errorf("detaching a missing attachment failed: %v", err)
+	}
+	if as, _ := p.GetAttachments(); len(as) != 1 {
+		t.Errorf("paste has %d attachments after detaching one", len(as))
+	}
+
+	if err := p.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Attach("late.txt", "text/plain", strings.NewReader("too late")); err != PasteNotFoundError {
+		t.Errorf("attached to an erased paste (%v)", err)
+	}
+	if err := b.RestorePaste(p.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if as, _ := p.GetAttachments(); len(as) != 1 {
+		t.Errorf("restored paste has %d attachments", len(as))
+	}
+	if err := p.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PurgePaste(p.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if as, _ := p.GetAttachments(); len(as) != 0 {
+		t.Errorf("purged paste has %d attachments", len(as))
+	}
+
+	encrypted, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encrypted.Attach("secret.txt", "text/plain", strings.NewReader("secret")); err != PasteEncryptedError {
+		t.Errorf("attached to an encrypted paste (%v)", err)
+	}
+}
+
+func testBrokerEvents(t *testing.T, b Broker) {
+	var synchronous []PasteEventType
+	b.SubscribePasteEvents(func(e *PasteEvent) {
+		synchronous = append(synchronous, e.Type)
+	}, SynchronousDelivery)
+
+	asynchronous := make(chan *PasteEvent)
+	unsubscribe := b.SubscribePasteEvents(func(e *PasteEvent) {
+		// Blocks until the test receives the event, as a slow subscriber would.
+		asynchronous <- e
+	}, AsynchronousDelivery)
+
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "eventful")
+	p.SetTitle("news")
+	if err := p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RestorePaste(p.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PurgePaste(p.GetID()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []PasteEventType{PasteCreatedEvent, PasteUpdatedEvent, PasteUpdatedEvent, PasteErasedEvent, PasteRestoredEvent, PasteErasedEvent, PastePurgedEvent}
+	if !reflect.DeepEqual(synchronous, expected) {
+		t.Errorf("delivered %v synchronously; expected %v", synchronous, expected)
+	}
+
+	for _, typ := range expected {
+		select {
+		case e := <-asynchronous:
+			if e.Type != typ || e.ID != p.GetID() {
+				t.Errorf("delivered %s %s asynchronously; expected %s %s", e.Type, e.ID, typ, p.GetID())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s event was never delivered asynchronously", typ)
+		}
+	}
+
+	unsubscribe()
+	if _, err := b.CreatePaste(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-asynchronous:
+		t.Errorf("delivered %s after unsubscribing", e.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if len(synchronous) != len(expected)+1 {
+		t.Errorf("remaining subscriber saw %d events; expected %d", len(synchronous), len(expected)+1)
+	}
+}
@@ -6,4 +6,31 @@ var (
 	PasteInvalidKeyError = errors.New("invalid password")
 	PasteEncryptedError  = errors.New("paste encrypted")
 	PasteNotFoundError   = errors.New("paste not found")
+
+	PasteNotEncryptedError = errors.New("paste not encrypted")
+	// PasteClientEncryptedError is returned for operations that need the key to a paste
+	// encrypted by its client.
+	PasteClientEncryptedError = errors.New("paste encrypted by its client")
+	// PasteKeyChangedError is returned when a paste is written or committed with a key it was
+	// re-encrypted away from since it was read.
+	PasteKeyChangedError = errors.New("paste re-encrypted during write")
+
+	PasteIDInvalidError     = errors.New("invalid paste ID")
+	PasteIDUnavailableError = errors.New("paste ID is taken")
+
+	PasteRevisionNotFoundError = errors.New("paste revision not found")
+
+	PasteBodyNotFoundError = errors.New("paste body not found")
+	// PasteBodyTamperedError is returned while reading an encrypted body that fails
+	// authentication: it has been changed or cut short since it was written.
+	PasteBodyTamperedError = errors.New("paste body failed authentication")
+	// PasteMetadataTamperedError is returned when the sealed title and language of an
+	// encrypted paste fail authentication.
+	PasteMetadataTamperedError = errors.New("paste metadata failed authentication")
+
+	PasteAttachmentNotFoundError    = errors.New("paste attachment not found")
+	PasteAttachmentNameInvalidError = errors.New("invalid paste attachment name")
+
+	UserNotFoundError  = errors.New("user not found")
+	GrantNotFoundError = errors.New("grant not found")
 )
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// PasteEventType identifies what happened to a paste.
+type PasteEventType int
+
+const (
+	// PasteCreatedEvent is published once a new paste has been stored. It has no body yet.
+	PasteCreatedEvent PasteEventType = iota + 1
+	// PasteUpdatedEvent is published once a change to a paste's body or metadata has been committed.
+	PasteUpdatedEvent
+	// PasteErasedEvent is published once a paste has been erased, and moved to the trash.
+	PasteErasedEvent
+	// PasteRestoredEvent is published once an erased paste has been restored from the trash.
+	PasteRestoredEvent
+	// PastePurgedEvent is published once an erased paste has been destroyed for good.
+	PastePurgedEvent
+)
+
+func (t PasteEventType) String() string {
+	switch t {
+	case PasteCreatedEvent:
+		return "created"
+	case PasteUpdatedEvent:
+		return "updated"
+	case PasteErasedEvent:
+		return "erased"
+	case PasteRestoredEvent:
+		return "restored"
+	case PastePurgedEvent:
+		return "purged"
+	}
+	return "unknown"
+}
+
+// PasteEvent describes a change to a paste. Paste is the paste as the broker saw it when the
+// event was published; the paste named by an erased or purged event can no longer be read,
+// so only its ID should be relied upon.
+type PasteEvent struct {
+	Type  PasteEventType
+	ID    PasteID
+	Paste Paste
+}
+
+// PasteEventHandler receives the events a subscriber has asked for.
+type PasteEventHandler func(*PasteEvent)
+
+// PasteEventDelivery controls how events are handed to a subscriber.
+type PasteEventDelivery int
+
+const (
+	// SynchronousDelivery calls the handler before the operation that published the event
+	// returns, so that anything derived from the paste (such as a cache) is never observed
+	// to be stale. Handlers must be quick, and must not change pastes themselves.
+	SynchronousDelivery PasteEventDelivery = iota
+	// AsynchronousDelivery queues events for the handler, which is called with each in turn on
+	// a goroutine of its own. A slow handler delays neither the broker nor other subscribers.
+	AsynchronousDelivery
+)
+
+type pasteEventSubscriber struct {
+	handler PasteEventHandler
+
+	// For asynchronous delivery
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []*PasteEvent
+	closed bool
+}
+
+func (s *pasteEventSubscriber) handle(e *PasteEvent) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("paste %s: %s event handler panicked: %v", e.ID, e.Type, err)
+		}
+	}()
+	s.handler(e)
+}
+
+func (s *pasteEventSubscriber) enqueue(e *PasteEvent) {
+	s.mu.Lock()
+	if !s.closed {
+		s.queue = append(s.queue, e)
+		s.cond.Signal()
+	}
+	s.mu.Unlock()
+}
+
+// run delivers queued events until the subscriber is closed and its queue is drained.
+func (s *pasteEventSubscriber) run() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		e := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		s.handle(e)
+	}
+}
+
+func (s *pasteEventSubscriber) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Signal()
+	s.mu.Unlock()
+}
+
+// pasteEventBus fans paste events out to their subscribers. Brokers embed it to provide
+// SubscribePasteEvents.
+type pasteEventBus struct {
+	mu           sync.RWMutex
+	synchronous  []*pasteEventSubscriber
+	asynchronous []*pasteEventSubscriber
+}
+
+// SubscribePasteEvents arranges for handler to receive every paste event published from now
+// on, delivered as requested. The returned function cancels the subscription; events already
+// queued for an asynchronous subscriber are still delivered.
+func (b *pasteEventBus) SubscribePasteEvents(handler PasteEventHandler, delivery PasteEventDelivery) func() {
+	s := &pasteEventSubscriber{handler: handler}
+
+	b.mu.Lock()
+	if delivery == AsynchronousDelivery {
+		s.cond = sync.NewCond(&s.mu)
+		b.asynchronous = append(b.asynchronous, s)
+		go s.run()
+	} else {
+		b.synchronous = append(b.synchronous, s)
+	}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			b.synchronous = removePasteEventSubscriber(b.synchronous, s)
+			b.asynchronous = removePasteEventSubscriber(b.asynchronous, s)
+			b.mu.Unlock()
+			if s.cond != nil {
+				s.close()
+			}
+		})
+	}
+}
+
+func removePasteEventSubscriber(l []*pasteEventSubscriber, s *pasteEventSubscriber) []*pasteEventSubscriber {
+	for i, v := range l {
+		if v == s {
+			// Copy, so that a publisher ranging over the old list is undisturbed.
+			return append(l[:i:i], l[i+1:]...)
+		}
+	}
+	return l
+}
+
+func (b *pasteEventBus) publish(t PasteEventType, p Paste) {
+	e := &PasteEvent{Type: t, ID: p.GetID(), Paste: p}
+
+	b.mu.RLock()
+	synchronous, asynchronous := b.synchronous, b.asynchronous
+	b.mu.RUnlock()
+
+	for _, s := range asynchronous {
+		s.enqueue(e)
+	}
+	for _, s := range synchronous {
+		s.handle(e)
+	}
+}
@@ -3,8 +3,8 @@ package model
 import "github.com/jinzhu/gorm"
 
 type dbGrant struct {
-	ID      string `gorm:"primary_key;type:varchar(256);unique"`
-	PasteID string `gorm:"type:varchar(256);index:idx_grant_by_paste"`
+	ID      string `gorm:"primary_key;type:varchar(191);unique"`
+	PasteID string `gorm:"type:varchar(191);index:idx_grant_by_paste"`
 
 	broker *dbBroker
 }
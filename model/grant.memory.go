@@ -0,0 +1,23 @@
+package model
+
+type memoryGrant struct {
+	ID      GrantID
+	PasteID PasteID
+
+	broker *memoryBroker
+}
+
+func (g *memoryGrant) GetID() GrantID {
+	return g.ID
+}
+
+func (g *memoryGrant) GetPasteID() PasteID {
+	return g.PasteID
+}
+
+func (g *memoryGrant) Destroy() error {
+	g.broker.mu.Lock()
+	delete(g.broker.grants, g.ID)
+	g.broker.mu.Unlock()
+	return nil
+}
@@ -6,12 +6,17 @@ import (
 	"os"
 	"testing"
 
+	"github.com/DHowett/ghostbin/lib/crypto"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 )
 
 type noopChallengeProvider struct{}
 
-func (n *noopChallengeProvider) DeriveKey(string, []byte) []byte {
+func (n *noopChallengeProvider) KDF() crypto.KDF {
+	return crypto.LegacyKDF
+}
+
+func (n *noopChallengeProvider) DeriveKey(string, []byte, crypto.KDF) []byte {
 	return []byte{'a'}
 }
 
@@ -23,6 +28,32 @@ func (n *noopChallengeProvider) Challenge(message []byte, key []byte) []byte {
 	return append(message, key...)
 }
 
+// kdfChallengeProvider derives keys with whichever KDF it is set to, and notes every KDF it
+// derives a key with.
+type kdfChallengeProvider struct {
+	kdf     crypto.KDF
+	derived []string
+}
+
+func (p *kdfChallengeProvider) KDF() crypto.KDF {
+	return p.kdf
+}
+
+func (p *kdfChallengeProvider) DeriveKey(password string, salt []byte, kdf crypto.KDF) []byte {
+	p.derived = append(p.derived, kdf.String())
+	key, _ := kdf.DeriveKey([]byte(password), salt, 32)
+	return key
+}
+
+func (p *kdfChallengeProvider) RandomSalt() []byte {
+	salt, _ := generateRandomBytes(16)
+	return salt
+}
+
+func (p *kdfChallengeProvider) Challenge(message []byte, key []byte) []byte {
+	return append(message, key...)
+}
+
 var broker Broker
 
 func TestMain(m *testing.M) {
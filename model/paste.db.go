@@ -2,23 +2,22 @@ package model
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"time"
 
-	"github.com/DHowett/ghostbin/lib/sql/querybuilder"
+	"github.com/DHowett/ghostbin/lib/spool"
 	"github.com/golang/glog"
 	"github.com/jinzhu/gorm"
 )
 
-type dbPasteBody struct {
-	PasteID string `gorm:"primary_key;type:varchar(256);unique"`
-	Data    []byte
-}
-
+// Indexed string columns are limited to 191 characters, so that their indexes fit in
+// MySQL's 767-byte key limit with utf8mb4.
 type dbPaste struct {
-	ID        string `gorm:"type:varchar(256);unique"`
+	ID        string `gorm:"type:varchar(191);unique"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
 
@@ -26,26 +25,59 @@ type dbPaste struct {
 
 	LanguageName sql.NullString `gorm:"type:varchar(128);default:'text'"`
 	Expiration   sql.NullString `gorm:"type:varchar(64);null"`
+	// Bundle is false for pastes encrypted by the server, whose metadata says instead.
+	Bundle bool `gorm:"not null"`
+
+	ParentID sql.NullString `gorm:"type:varchar(191);index:idx_paste_parent"`
+
+	CreatorUserID  uint
+	CreatorSession string `gorm:"type:varchar(64)"`
+
+	// Size and LineCount are null for pastes last written before they were recorded, and for
+	// pastes encrypted by the server; see Paste.GetSize.
+	Size      sql.NullInt64
+	LineCount sql.NullInt64
+
+	ViewCount    int64
+	LastViewedAt *time.Time
+
+	// TrashedAt is set while the paste is in the trash.
+	TrashedAt *time.Time
 
 	HMAC             []byte `gorm:"null"`
 	EncryptionSalt   []byte `gorm:"null"`
 	EncryptionMethod PasteEncryptionMethod
+	// KDF names what the key of an encrypted paste was derived with; see crypto.ParseKDF.
+	KDF string `gorm:"type:varchar(128)"`
 
-	encryptionKey []byte `gorm:"-"`
-	broker        *dbBroker
-}
+	// CompressionMethod is how the paste's current body is compressed; each revision records
+	// its own.
+	CompressionMethod PasteCompressionMethod
 
-// gorm
-func (p *dbPaste) BeforeCreate(scope *gorm.Scope) error {
-	id := p.broker.GenerateNewPasteID(p.IsEncrypted())
-	scope.SetColumn("ID", id)
+	// BodyHash names the shared body the current body of an unencrypted paste is kept in.
+	// It is null for encrypted pastes, and pastes last written before bodies were shared,
+	// whose bodies are kept under their IDs.
+	BodyHash sql.NullString `gorm:"type:varchar(64)"`
 
-	if p.IsEncrypted() {
-		hmac := getPasteEncryptionCodec(p.EncryptionMethod).GenerateHMAC(id, p.EncryptionSalt, p.encryptionKey)
-		scope.SetColumn("HMAC", hmac)
-	}
+	// BodyKey names the current body of an encrypted paste, which is written under a name of
+	// its own each time it is written or re-encrypted; see rekeyedBodyID. It is empty for
+	// pastes last written before then, whose bodies are kept under their IDs.
+	BodyKey string `gorm:"type:varchar(32)"`
+	// BodyRevision, if set, is the revision whose body is the current body of an encrypted
+	// paste; the body is stored once, under the revision's name. It is null for pastes whose
+	// bodies are kept under names of their own.
+	BodyRevision sql.NullInt64
+	// ReencryptMethod, if set, is the method an encrypted paste is to be re-encrypted with
+	// the next time it is unlocked; see PasteEncryptionUpgrader.
+	ReencryptMethod PasteEncryptionMethod
+	// Metadata holds the title, language and bundledness of a paste encrypted by the server,
+	// sealed under its key, and Title and LanguageName are null; see pasteMetadata. Pastes last written
+	// before metadata was sealed keep theirs in the clear until they are next unlocked.
+	Metadata []byte `gorm:"null"`
 
-	return nil
+	encryptionKey []byte `gorm:"-"`
+	editor        PasteEditor
+	broker        *dbBroker
 }
 
 func (p *dbPaste) GetID() PasteID {
@@ -61,11 +93,15 @@ func (p *dbPaste) GetLanguageName() string {
 	return ""
 }
 func (p *dbPaste) SetLanguageName(language string) {
+	p.LanguageName.Valid = (language != "")
 	p.LanguageName.String = language
 }
 func (p *dbPaste) IsEncrypted() bool {
 	return p.EncryptionMethod != PasteEncryptionMethodNone
 }
+func (p *dbPaste) IsClientEncrypted() bool {
+	return p.EncryptionMethod == PasteEncryptionMethodClient
+}
 func (p *dbPaste) GetExpiration() string {
 	if p.Expiration.Valid {
 		return p.Expiration.String
@@ -88,85 +124,492 @@ func (p *dbPaste) SetTitle(title string) {
 	p.Title.String = title
 }
 
+func (p *dbPaste) IsBundle() bool {
+	return p.Bundle
+}
+func (p *dbPaste) SetBundle(bundle bool) {
+	p.Bundle = bundle
+}
+
+func (p *dbPaste) GetParentID() PasteID {
+	if p.ParentID.Valid {
+		return PasteID(p.ParentID.String)
+	}
+	return ""
+}
+func (p *dbPaste) SetParentID(id PasteID) {
+	p.ParentID.Valid = (id != "")
+	p.ParentID.String = id.String()
+}
+
+func (p *dbPaste) GetCreator() PasteEditor {
+	return PasteEditor{UserID: p.CreatorUserID, Session: p.CreatorSession}
+}
+
+func (p *dbPaste) GetSize() int64 {
+	if p.Size.Valid {
+		return p.Size.Int64
+	}
+	return -1
+}
+func (p *dbPaste) GetLineCount() int64 {
+	if p.LineCount.Valid {
+		return p.LineCount.Int64
+	}
+	return -1
+}
+
+func (p *dbPaste) GetViewCount() int64 {
+	return p.ViewCount
+}
+func (p *dbPaste) GetLastViewTime() time.Time {
+	if p.LastViewedAt != nil {
+		return *p.LastViewedAt
+	}
+	return time.Time{}
+}
+
+func (p *dbPaste) GetForks() ([]Paste, error) {
+	var ps []*dbPaste
+	if err := p.broker.Where("parent_id = ? AND trashed_at IS NULL", p.ID).Order("created_at").Find(&ps).Error; err != nil {
+		return nil, err
+	}
+	return p.broker.wrapPastes(ps), nil
+}
+
+func (p *dbPaste) SetEditor(editor PasteEditor) {
+	p.editor = editor
+}
+
+func (p *dbPaste) GetRevisions() ([]PasteRevision, error) {
+	var revs []*dbPasteRevision
+	if err := p.broker.Select(dbPasteRevisionMetadataColumns).Where("paste_id = ?", p.ID).Order("revision").Find(&revs).Error; err != nil {
+		return nil, err
+	}
+
+	iRevs := make([]PasteRevision, len(revs))
+	for i, r := range revs {
+		r.paste = p
+		if err := r.unsealMetadata(); err != nil {
+			return nil, err
+		}
+		iRevs[i] = r
+	}
+	return iRevs, nil
+}
+
+func (p *dbPaste) GetRevision(n int) (PasteRevision, error) {
+	var rev dbPasteRevision
+	if err := p.broker.Where("paste_id = ? AND revision = ?", p.ID, n).First(&rev).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, PasteRevisionNotFoundError
+		}
+		return nil, err
+	}
+	rev.paste = p
+	if err := rev.unsealMetadata(); err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// dbPasteManagedColumns are written only by the broker, and are left alone when the rest of
+// a paste is saved: views are counted apart from it, a paste may have been erased since it
+// was read, and its body may have been rewritten, recompressed or encrypted again.
+var dbPasteManagedColumns = []string{"view_count", "last_viewed_at", "trashed_at", "compression_method", "body_hash", "hmac", "encryption_salt", "encryption_method", "kdf", "body_key", "body_revision", "reencrypt_method", "metadata"}
+
+// sealsMetadata reports whether p's title and language are sealed under its key. Those of
+// pastes imported without their keys can't be until the pastes are re-encrypted, and those of
+// pastes encrypted by their clients are the clients' to seal, as the browser does in the bodies.
+func (p *dbPaste) sealsMetadata() bool {
+	return p.IsEncrypted() && !p.IsClientEncrypted() && p.encryptionKey != nil
+}
+
+// pasteKey returns the key material p, which must be unlocked, is encrypted with.
+func (p *dbPaste) pasteKey() *pasteKey {
+	return &pasteKey{method: p.EncryptionMethod, kdf: p.KDF, salt: p.EncryptionSalt, key: p.encryptionKey, hmac: p.HMAC}
+}
+
+// sealMetadata seals p's title and language under its key.
+func (p *dbPaste) sealMetadata() ([]byte, error) {
+	return sealPasteMetadata(p.GetID(), p.encryptionKey, &pasteMetadata{Title: p.GetTitle(), Language: p.GetLanguageName(), Bundle: p.Bundle})
+}
+
+// unsealMetadata opens p's sealed metadata, if it has any. p must be unlocked.
+func (p *dbPaste) unsealMetadata() error {
+	if p.Metadata == nil {
+		return nil
+	}
+	m, err := openPasteMetadata(p.GetID(), p.encryptionKey, p.Metadata)
+	if err != nil {
+		return err
+	}
+	p.SetTitle(m.Title)
+	p.SetLanguageName(m.Language)
+	p.Bundle = m.Bundle
+	return nil
+}
+
+// save writes p's row in tx. The metadata of a paste encrypted by the server is sealed under
+// the key it was read with, and it returns PasteKeyChangedError if that is no longer the paste's.
+func (p *dbPaste) save(tx *gorm.DB) error {
+	if !p.sealsMetadata() {
+		return tx.Omit(dbPasteManagedColumns...).Save(p).Error
+	}
+
+	sealed, err := p.sealMetadata()
+	if err != nil {
+		return err
+	}
+	row := *p
+	row.Title, row.LanguageName, row.Bundle = sql.NullString{}, sql.NullString{}, false
+	if err := tx.Omit(dbPasteManagedColumns...).Save(&row).Error; err != nil {
+		return err
+	}
+	p.CreatedAt, p.UpdatedAt = row.CreatedAt, row.UpdatedAt
+	db := tx.Model(&dbPaste{}).Where("id = ? AND encryption_method = ? AND encryption_salt = ?", p.ID, p.EncryptionMethod, p.EncryptionSalt).UpdateColumn("metadata", sealed)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return PasteKeyChangedError
+	}
+	p.Metadata = sealed
+	return nil
+}
+
 func (p *dbPaste) Commit() error {
-	return p.broker.DB.Save(p).Error
+	tx := p.broker.Begin()
+	if err := p.save(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Model(&dbPasteSearchDocument{}).Where("paste_id = ?", p.ID).Update("title", p.GetTitle()).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	p.broker.publish(PasteUpdatedEvent, p)
+	return nil
 }
 
 func (p *dbPaste) Erase() error {
-	return p.broker.Delete(p).Delete(&dbPasteBody{PasteID: p.ID}).Error
+	now := time.Now()
+	db := p.broker.Model(&dbPaste{}).Where("id = ? AND trashed_at IS NULL", p.ID).UpdateColumn("trashed_at", now)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		// It's already gone.
+		return nil
+	}
+	p.TrashedAt = &now
+
+	p.broker.publish(PasteErasedEvent, p)
+	return nil
+}
+
+// bodyID names the paste's current body in the broker's PasteBodyStore.
+func (p *dbPaste) bodyID() PasteID {
+	if p.BodyHash.Valid {
+		return blobBodyID(p.BodyHash.String)
+	}
+	if p.BodyRevision.Valid {
+		return rekeyedBodyID(revisionBodyID(p.GetID(), int(p.BodyRevision.Int64)), p.BodyKey)
+	}
+	return p.encryptedBodyID()
+}
+
+// encryptedBodyID names the body an encrypted paste's current body was encrypted as, wherever
+// it is stored.
+func (p *dbPaste) encryptedBodyID() PasteID {
+	return rekeyedBodyID(p.GetID(), p.BodyKey)
 }
 
 func (p *dbPaste) Reader() (io.ReadCloser, error) {
-	var b dbPasteBody
-	if err := p.broker.Model(p).Related(&b, "PasteID").Error; err != nil {
-		glog.Errorln(err)
+	r, err := p.broker.Bodies.GetBody(p.bodyID())
+	if err != nil {
+		if err != PasteBodyNotFoundError {
+			glog.Errorln(err)
+		}
 		return devZero, nil
 	}
-	r := ioutil.NopCloser(bytes.NewReader(b.Data))
 	if p.IsEncrypted() {
-		return getPasteEncryptionCodec(p.EncryptionMethod).Reader(p.encryptionKey, r), nil
+		r = getPasteEncryptionCodec(p.EncryptionMethod).Reader(p.encryptedBodyID(), p.encryptionKey, r)
 	}
-	return r, nil
+	return getPasteCompressionCodec(p.CompressionMethod).Reader(r), nil
 }
 
+// written reports whether p has ever had a body written.
+func (p *dbPaste) written() (bool, error) {
+	var n int
+	if err := p.broker.Model(&dbPasteRevision{}).Where("paste_id = ?", p.ID).Count(&n).Error; err != nil || n > 0 {
+		return n > 0, err
+	}
+	// Pastes written before revisions were kept may have a body all the same.
+	r, err := p.broker.Bodies.GetBody(p.bodyID())
+	if err == PasteBodyNotFoundError {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	r.Close()
+	return true, nil
+}
+
+// pasteBodySpoolMemory is how much of a body being written is held in memory before the
+// rest is spooled to disk.
+const pasteBodySpoolMemory = 1024 * 1024
+
+// pasteWriter collects a body until it is closed, so that nothing is stored for a write
+// that is abandoned part of the way through.
 type pasteWriter struct {
-	bytes.Buffer
-	p      *dbPaste // for UpdatedAt
-	b      *dbPasteBody
-	broker *dbBroker
+	*spool.Spool
+	p           *dbPaste // for UpdatedAt
+	broker      *dbBroker
+	stats       pasteBodyStats
+	compression PasteCompressionMethod
+	bodyKey     string // for encrypted bodies; see dbPaste.BodyKey
 }
 
 func newPasteWriter(broker *dbBroker, p *dbPaste) (*pasteWriter, error) {
-	var b dbPasteBody
-	err := broker.FirstOrInit(&b, dbPasteBody{PasteID: p.ID}).Error
-	if err != nil {
-		return nil, err
-	}
 	return &pasteWriter{
-		p:      p,
-		b:      &b,
-		broker: broker,
+		Spool:       spool.New(pasteBodySpoolMemory),
+		p:           p,
+		broker:      broker,
+		compression: broker.Compression,
 	}, nil
 }
 
 func (pw *pasteWriter) Close() error {
-	newData := pw.Buffer.Bytes()
-	tx := pw.broker.Begin()
+	defer pw.Spool.Close()
 
-	scope := tx.NewScope(pw.b)
-	modelStruct := scope.GetModelStruct()
-	table := modelStruct.TableName(tx)
+	// Every write is kept as an immutable revision alongside the current body.
+	revision := &dbPasteRevision{
+		PasteID:       pw.p.ID,
+		EditorUserID:  pw.p.editor.UserID,
+		EditorSession: pw.p.editor.Session,
+		Title:         pw.p.Title,
+		LanguageName:  pw.p.LanguageName,
+		Bundle:        pw.p.Bundle,
 
-	query, err := pw.broker.QB.Build(&querybuilder.UpsertQuery{
-		Table:        table,
-		ConflictKeys: []string{"paste_id"},
-		Fields:       []string{"paste_id", "data"},
-	})
+		CompressionMethod: pw.compression,
+	}
 
+	var searchable []byte
+	var err error
+	if !pw.p.IsEncrypted() {
+		searchable, err = searchableBody(getPasteCompressionCodec(pw.compression).Reader(ioutil.NopCloser(pw.Reader())), pw.p.IsBundle())
+		if err != nil {
+			return err
+		}
+	} else {
+		revision.BodyKey = pw.bodyKey
+	}
+
+	var old *dbPaste
+	for attempt := 1; ; attempt++ {
+		old, err = pw.commit(revision, searchable)
+		if err != errPasteBlobBusy || attempt == maxPasteBlobAttempts {
+			break
+		}
+		time.Sleep(time.Duration(attempt) * 10 * time.Millisecond)
+	}
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
-	_, err = tx.CommonDB().Exec(query, pw.b.PasteID, newData)
+	// The bodies replaced are deleted once the paste no longer names them; those of
+	// revisions are kept with the revisions.
+	if old.BodyHash.Valid {
+		pw.broker.deleteUnreferencedPasteBlobs([]string{old.BodyHash.String})
+	} else if !old.BodyRevision.Valid && (revision.BodyHash.Valid || old.BodyKey != revision.BodyKey) {
+		// The paste's body was kept under a name of its own, until now.
+		pw.broker.deletePasteBodies(pw.p.ID, []PasteID{old.bodyID()})
+	}
+
+	pw.broker.publish(PasteUpdatedEvent, pw.p)
+	return nil
+}
+
+// commit stores the body and records it, and revision, as the paste's latest, with its
+// bodies locked. It returns the paste as it was before, naming the body it replaced.
+func (pw *pasteWriter) commit(revision *dbPasteRevision, searchable []byte) (*dbPaste, error) {
+	defer pw.broker.bodyLocks.lock(pw.p.GetID())()
+
+	// A write that is tried again records its revision afresh.
+	revision.ID = 0
+
+	// Revisions are numbered in the order they are written. Another broker sharing the
+	// database could still take the same number, but only one of them can record it.
+	err := pw.broker.Model(&dbPasteRevision{}).Where("paste_id = ?", pw.p.ID).Select("COALESCE(MAX(revision), 0) + 1").Row().Scan(&revision.Revision)
+	if err != nil {
+		return nil, err
+	}
+	if pw.p.sealsMetadata() {
+		revision.Metadata, err = sealPasteMetadata(revisionBodyID(pw.p.GetID(), revision.Revision), pw.p.encryptionKey, &pasteMetadata{Title: pw.p.GetTitle(), Language: pw.p.GetLanguageName(), Bundle: pw.p.Bundle})
+		if err != nil {
+			return nil, err
+		}
+		revision.Title, revision.LanguageName, revision.Bundle = sql.NullString{}, sql.NullString{}, false
+	}
+
+	// The paste held here may be out of date; the body it is replacing is the one in the database.
+	var old dbPaste
+	if err := pw.broker.Select("id, body_hash, body_key, body_revision, encryption_method, encryption_salt").Where("id = ?", pw.p.ID).First(&old).Error; err != nil {
+		return nil, err
+	}
+	if pw.p.IsEncrypted() && (old.EncryptionMethod != pw.p.EncryptionMethod || !bytes.Equal(old.EncryptionSalt, pw.p.EncryptionSalt)) {
+		// The body was encrypted with a key the paste no longer uses.
+		return nil, PasteKeyChangedError
+	}
+
+	// The body store may not be the database, so the bodies can't be part of the transaction.
+	// They are stored first so that the paste is never newer than its body.
+	var written []PasteID
+	var hash string
+	stored := false
+	if pw.stats.digest != nil {
+		// Unencrypted bodies are shared, and kept however the first of them was compressed.
+		hash = hex.EncodeToString(pw.stats.digest.Sum(nil))
+		defer pw.broker.bodyLocks.lock(blobBodyID(hash))()
+		revision.CompressionMethod, stored, err = pw.broker.putPasteBlob(hash, pw.compression, pw.Reader())
+		if err != nil {
+			return nil, err
+		}
+		revision.BodyHash = sql.NullString{String: hash, Valid: true}
+	} else {
+		// The paste's body is the revision's, and is stored once, under the revision's name.
+		if err := pw.broker.Bodies.PutBody(revision.bodyID(), pw.Reader()); err != nil {
+			return nil, err
+		}
+		written = append(written, revision.bodyID())
+	}
+
+	pw.p.CompressionMethod = revision.CompressionMethod
+	pw.p.BodyHash = revision.BodyHash
+	pw.p.BodyKey = revision.BodyKey
+	pw.p.BodyRevision = sql.NullInt64{}
+	if !revision.BodyHash.Valid {
+		pw.p.BodyRevision = sql.NullInt64{Int64: int64(revision.Revision), Valid: true}
+	}
+	// The length of a body the server encrypts is as secret as the body; ciphertext from a
+	// client has no lines to count.
+	pw.p.Size, pw.p.LineCount = sql.NullInt64{}, sql.NullInt64{}
+	if !pw.p.IsEncrypted() || pw.p.IsClientEncrypted() {
+		pw.p.Size = sql.NullInt64{Int64: pw.stats.size, Valid: true}
+	}
+	if !pw.p.IsEncrypted() {
+		pw.p.LineCount = sql.NullInt64{Int64: pw.stats.lineCount(), Valid: true}
+	}
+	if revision.Revision == 1 {
+		pw.p.CreatorUserID = pw.p.editor.UserID
+		pw.p.CreatorSession = pw.p.editor.Session
+	}
+
+	tx := pw.broker.Begin()
+	err = pw.save(tx, revision, old.BodyHash, stored, searchable)
 	if err != nil {
 		tx.Rollback()
+	} else {
+		err = tx.Commit().Error
+	}
+	if err != nil {
+		pw.p.BodyKey, pw.p.BodyRevision = old.BodyKey, old.BodyRevision
+		pw.broker.deletePasteBodies(pw.p.ID, written)
+		if stored && pw.broker.abandonPasteBlob(hash, pw.compression, pw.Reader()) {
+			// Another write stored the same body at the same time.
+			return nil, errPasteBlobBusy
+		}
+		return nil, err
+	}
+	return &old, nil
+}
+
+// save records the new body and revision in tx, moving the paste's reference from the shared
+// body named by oldHash, if any, to the new one; stored is whether the new one was just stored.
+func (pw *pasteWriter) save(tx *gorm.DB, revision *dbPasteRevision, oldHash sql.NullString, stored bool, searchable []byte) error {
+	if err := pw.p.save(tx); err != nil {
+		return err
+	}
+	if err := tx.Model(&dbPaste{}).Where("id = ?", pw.p.ID).UpdateColumns(map[string]interface{}{
+		"compression_method": pw.p.CompressionMethod,
+		"body_hash":          pw.p.BodyHash,
+		"body_key":           pw.p.BodyKey,
+		"body_revision":      pw.p.BodyRevision,
+	}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Create(revision).Error; err != nil {
 		return err
 	}
 
-	tx.Save(pw.p)
-	err = tx.Commit().Error
-	return err
+	if revision.BodyHash.Valid {
+		// One reference for the paste, and one for the revision.
+		if err := refPasteBlob(tx, revision.BodyHash.String, revision.CompressionMethod, 2, stored); err != nil {
+			return err
+		}
+	}
+	if oldHash.Valid {
+		if err := unrefPasteBlobs(tx, []string{oldHash.String}); err != nil {
+			return err
+		}
+	}
+
+	return indexPaste(tx, pw.p, searchable)
+}
+
+// deletePasteBodies deletes the bodies ids, which belonged to the paste id, logging those it
+// can't.
+func (broker *dbBroker) deletePasteBodies(id string, ids []PasteID) {
+	for _, bodyID := range ids {
+		if err := broker.Bodies.DeleteBody(bodyID); err != nil {
+			glog.Errorf("paste %s: failed to delete body %s: %v", id, bodyID, err)
+		}
+	}
 }
 
 func (p *dbPaste) Writer() (io.WriteCloser, error) {
+	if p.IsEncrypted() && p.EncryptionMethod.reusesKeystream() && p.encryptionKey != nil {
+		// The paste is encrypted with a method that can't encrypt another body under its key;
+		// once it has a body, it is encrypted with one that can first.
+		written, err := p.written()
+		if err != nil {
+			return nil, err
+		}
+		if written {
+			if err := p.broker.reencryptPaste(p, aeadPasteKey(p.GetID(), p.pasteKey()), p.ReencryptMethod); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	w, err := newPasteWriter(p.broker, p)
 	if err != nil {
 		return nil, err
 	}
 
+	// Bodies are compressed before they are encrypted; ciphertext doesn't compress.
+	if p.IsClientEncrypted() {
+		w.compression = PasteCompressionMethodNone
+	}
+	var wc io.WriteCloser = w
 	if p.IsEncrypted() {
-		return getPasteEncryptionCodec(p.EncryptionMethod).Writer(p.encryptionKey, w), nil
+		// The bodies of encrypted pastes are written under names no other write uses, so
+		// that a write that loses a race with another can't overwrite what the winner wrote.
+		if w.bodyKey, err = generateRandomBase32String(10, -1); err != nil {
+			return nil, err
+		}
+		wc = getPasteEncryptionCodec(p.EncryptionMethod).Writer(rekeyedBodyID(p.GetID(), w.bodyKey), p.encryptionKey, wc)
+	} else {
+		// Unencrypted bodies are shared by their content; see dbPasteBlob.
+		w.stats.digest = sha256.New()
 	}
-	return w, nil
+	wc = getPasteCompressionCodec(w.compression).Writer(wc)
+	return &pasteStatsWriter{WriteCloser: wc, stats: &w.stats}, nil
 }
@@ -22,6 +22,10 @@ type Paste interface {
 	SetLanguageName(string)
 
 	IsEncrypted() bool
+	// IsClientEncrypted reports whether the paste was encrypted by its client. Such pastes
+	// are encrypted, but need no passphrase to be read: their bodies are stored and read back
+	// exactly as they were written, and only the client can make sense of them.
+	IsClientEncrypted() bool
 
 	GetExpiration() string
 	SetExpiration(string)
@@ -29,17 +33,88 @@ type Paste interface {
 	GetTitle() string
 	SetTitle(string)
 
+	// IsBundle reports whether the paste's body is a bundle of files (see lib/bundle) rather
+	// than a single one. It is recorded by whoever writes the body, never read from it.
+	IsBundle() bool
+	SetBundle(bool)
+
 	GetModificationTime() time.Time
 
+	// GetCreator returns the editor of the paste's first revision.
+	GetCreator() PasteEditor
+	// GetSize and GetLineCount describe the paste's current body. They return -1 for pastes
+	// that have not been written since the counts were introduced, and for pastes encrypted
+	// by the server, whose lengths would give away those of their bodies. Pastes encrypted
+	// by their clients have sizes, but no line counts.
+	GetSize() int64
+	GetLineCount() int64
+	// GetViewCount and GetLastViewTime report how often and how recently the paste was read.
+	// Views are recorded with Broker.RecordPasteView, and may take a while to be counted.
+	GetViewCount() int64
+	GetLastViewTime() time.Time
+
+	// GetParentID returns the ID of the paste this one was forked from, or an empty ID.
+	GetParentID() PasteID
+	SetParentID(PasteID)
+	// GetForks returns the pastes forked from this one. Encrypted forks are placeholders.
+	GetForks() ([]Paste, error)
+
 	Reader() (io.ReadCloser, error)
 	Writer() (io.WriteCloser, error)
 
+	// GetAttachments returns the files attached to the paste, ordered by name.
+	GetAttachments() ([]PasteAttachment, error)
+	// GetAttachment returns the attachment called name, or PasteAttachmentNotFoundError.
+	GetAttachment(name string) (PasteAttachment, error)
+	// Attach stores everything read from r as an attachment called name, replacing any
+	// attachment already called that. Encrypted pastes can't have attachments.
+	Attach(name, mimeType string, r io.Reader) (PasteAttachment, error)
+	// Detach removes the attachment called name. Removing a missing attachment is not an error.
+	Detach(name string) error
+
+	// SetEditor records who is responsible for the next revision written.
+	SetEditor(PasteEditor)
+	GetRevisions() ([]PasteRevision, error)
+	GetRevision(int) (PasteRevision, error)
+
 	Commit() error
+	// Erase moves the paste to the trash.
 	Erase() error
 }
 
+// PasteEditor identifies the author of a paste revision.
+type PasteEditor struct {
+	// UserID is zero for anonymous editors.
+	UserID uint
+	// Session is an opaque token identifying an anonymous editor's session.
+	Session string
+}
+
+func (e PasteEditor) IsAnonymous() bool {
+	return e.UserID == 0
+}
+
+// A PasteRevision is an immutable snapshot of a paste, recorded every time its body is written.
+type PasteRevision interface {
+	GetPasteID() PasteID
+	// GetNumber returns the revision's 1-based position in the paste's history.
+	GetNumber() int
+	GetTime() time.Time
+	GetEditor() PasteEditor
+
+	GetLanguageName() string
+	GetTitle() string
+	IsBundle() bool
+
+	Reader() (io.ReadCloser, error)
+}
+
+// encryptedPastePlaceholder stands in for an encrypted paste when its key isn't at hand. It
+// holds only what may be shown to anyone who can name the paste; the title and language of a
+// paste encrypted by the server are sealed under its key.
 type encryptedPastePlaceholder struct {
-	ID PasteID
+	ID         PasteID
+	Expiration string
 }
 
 func (e *encryptedPastePlaceholder) GetID() PasteID {
@@ -56,8 +131,12 @@ func (e *encryptedPastePlaceholder) IsEncrypted() bool {
 	return true
 }
 
+func (e *encryptedPastePlaceholder) IsClientEncrypted() bool {
+	return false
+}
+
 func (e *encryptedPastePlaceholder) GetExpiration() string {
-	return ""
+	return e.Expiration
 }
 
 func (e *encryptedPastePlaceholder) SetExpiration(string) {}
@@ -68,11 +147,48 @@ func (e *encryptedPastePlaceholder) GetTitle() string {
 
 func (e *encryptedPastePlaceholder) SetTitle(string) {}
 
+func (e *encryptedPastePlaceholder) IsBundle() bool {
+	return false
+}
+
+func (e *encryptedPastePlaceholder) SetBundle(bool) {}
+
 func (e *encryptedPastePlaceholder) GetModificationTime() time.Time {
 	var t time.Time
 	return t
 }
 
+func (e *encryptedPastePlaceholder) GetCreator() PasteEditor {
+	return PasteEditor{}
+}
+
+func (e *encryptedPastePlaceholder) GetSize() int64 {
+	return -1
+}
+
+func (e *encryptedPastePlaceholder) GetLineCount() int64 {
+	return -1
+}
+
+func (e *encryptedPastePlaceholder) GetViewCount() int64 {
+	return 0
+}
+
+func (e *encryptedPastePlaceholder) GetLastViewTime() time.Time {
+	var t time.Time
+	return t
+}
+
+func (e *encryptedPastePlaceholder) GetParentID() PasteID {
+	return ""
+}
+
+func (e *encryptedPastePlaceholder) SetParentID(PasteID) {}
+
+func (e *encryptedPastePlaceholder) GetForks() ([]Paste, error) {
+	return nil, PasteEncryptedError
+}
+
 func (e *encryptedPastePlaceholder) Reader() (io.ReadCloser, error) {
 	return nil, PasteEncryptedError
 }
@@ -81,6 +197,32 @@ func (e *encryptedPastePlaceholder) Writer() (io.WriteCloser, error) {
 	return nil, PasteEncryptedError
 }
 
+func (e *encryptedPastePlaceholder) GetAttachments() ([]PasteAttachment, error) {
+	return nil, PasteEncryptedError
+}
+
+func (e *encryptedPastePlaceholder) GetAttachment(string) (PasteAttachment, error) {
+	return nil, PasteEncryptedError
+}
+
+func (e *encryptedPastePlaceholder) Attach(string, string, io.Reader) (PasteAttachment, error) {
+	return nil, PasteEncryptedError
+}
+
+func (e *encryptedPastePlaceholder) Detach(string) error {
+	return PasteEncryptedError
+}
+
+func (e *encryptedPastePlaceholder) SetEditor(PasteEditor) {}
+
+func (e *encryptedPastePlaceholder) GetRevisions() ([]PasteRevision, error) {
+	return nil, PasteEncryptedError
+}
+
+func (e *encryptedPastePlaceholder) GetRevision(int) (PasteRevision, error) {
+	return nil, PasteEncryptedError
+}
+
 func (e *encryptedPastePlaceholder) Commit() error {
 	return PasteEncryptedError
 }
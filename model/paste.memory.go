@@ -0,0 +1,402 @@
+package model
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// memoryPasteMetadata is everything about a paste but its body and revisions.
+type memoryPasteMetadata struct {
+	ID        PasteID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Title        string
+	LanguageName string
+	Expiration   string
+	ParentID     PasteID
+	Bundle       bool
+
+	Creator   PasteEditor
+	Size      int64
+	LineCount int64
+
+	ViewCount    int64
+	LastViewedAt time.Time
+
+	HMAC             []byte
+	EncryptionSalt   []byte
+	EncryptionMethod PasteEncryptionMethod
+	KDF              string
+}
+
+func (m *memoryPasteMetadata) IsEncrypted() bool {
+	return m.EncryptionMethod != PasteEncryptionMethodNone
+}
+
+func (m *memoryPasteMetadata) IsClientEncrypted() bool {
+	return m.EncryptionMethod == PasteEncryptionMethodClient
+}
+
+// memoryPasteRecord is a paste as the memory broker stores it. Bodies are kept exactly as
+// written, so those of encrypted pastes remain encrypted.
+type memoryPasteRecord struct {
+	memoryPasteMetadata
+	seq uint64 // creation order
+
+	body        []byte
+	bodyKey     string   // for encrypted bodies; see dbPaste.BodyKey
+	bodyTokens  []string // the indexed words of the body; none for binary and encrypted bodies
+	revisions   []*memoryPasteRevision
+	attachments map[string]*memoryPasteAttachment
+
+	trashedAt time.Time // set while the paste is in the trash
+}
+
+func (r *memoryPasteRecord) inTrash() bool {
+	return !r.trashedAt.IsZero()
+}
+
+type memoryPaste struct {
+	memoryPasteMetadata
+
+	encryptionKey []byte
+	editor        PasteEditor
+	broker        *memoryBroker
+}
+
+func (p *memoryPaste) GetID() PasteID {
+	return p.ID
+}
+func (p *memoryPaste) GetModificationTime() time.Time {
+	return p.UpdatedAt
+}
+func (p *memoryPaste) GetLanguageName() string {
+	return p.LanguageName
+}
+func (p *memoryPaste) SetLanguageName(language string) {
+	p.LanguageName = language
+}
+func (p *memoryPaste) GetExpiration() string {
+	return p.Expiration
+}
+func (p *memoryPaste) SetExpiration(expiration string) {
+	p.Expiration = expiration
+}
+
+func (p *memoryPaste) GetTitle() string {
+	return p.Title
+}
+func (p *memoryPaste) SetTitle(title string) {
+	p.Title = title
+}
+
+func (p *memoryPaste) IsBundle() bool {
+	return p.Bundle
+}
+func (p *memoryPaste) SetBundle(bundle bool) {
+	p.Bundle = bundle
+}
+
+func (p *memoryPaste) GetParentID() PasteID {
+	return p.ParentID
+}
+func (p *memoryPaste) SetParentID(id PasteID) {
+	p.ParentID = id
+}
+
+func (p *memoryPaste) GetCreator() PasteEditor {
+	return p.Creator
+}
+
+func (p *memoryPaste) GetSize() int64 {
+	return p.Size
+}
+func (p *memoryPaste) GetLineCount() int64 {
+	return p.LineCount
+}
+
+func (p *memoryPaste) GetViewCount() int64 {
+	return p.ViewCount
+}
+func (p *memoryPaste) GetLastViewTime() time.Time {
+	return p.LastViewedAt
+}
+
+func (p *memoryPaste) GetForks() ([]Paste, error) {
+	p.broker.mu.RLock()
+	defer p.broker.mu.RUnlock()
+	var records []*memoryPasteRecord
+	for _, record := range p.broker.pastes {
+		if record.ParentID == p.ID && !record.inTrash() {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq < records[j].seq
+	})
+	return p.broker.wrapPastes(records), nil
+}
+
+func (p *memoryPaste) SetEditor(editor PasteEditor) {
+	p.editor = editor
+}
+
+func (p *memoryPaste) GetRevisions() ([]PasteRevision, error) {
+	p.broker.mu.RLock()
+	defer p.broker.mu.RUnlock()
+	record, ok := p.broker.pastes[p.ID]
+	if !ok {
+		return []PasteRevision{}, nil
+	}
+
+	iRevs := make([]PasteRevision, len(record.revisions))
+	for i, r := range record.revisions {
+		rev := *r
+		rev.paste = p
+		iRevs[i] = &rev
+	}
+	return iRevs, nil
+}
+
+func (p *memoryPaste) GetRevision(n int) (PasteRevision, error) {
+	p.broker.mu.RLock()
+	defer p.broker.mu.RUnlock()
+	record, ok := p.broker.pastes[p.ID]
+	if !ok || n < 1 || n > len(record.revisions) {
+		return nil, PasteRevisionNotFoundError
+	}
+	rev := *record.revisions[n-1]
+	rev.paste = p
+	return &rev, nil
+}
+
+// save replaces the stored metadata of p, which must still exist, with its own, returning
+// PasteKeyChangedError if p was read with a key it no longer uses. The caller must hold the
+// broker's lock.
+func (p *memoryPaste) save() (*memoryPasteRecord, error) {
+	record, ok := p.broker.pastes[p.ID]
+	if !ok {
+		return nil, PasteNotFoundError
+	}
+	if p.IsEncrypted() && (record.EncryptionMethod != p.EncryptionMethod || !bytes.Equal(record.EncryptionSalt, p.EncryptionSalt)) {
+		// It was read with a key it no longer uses.
+		return nil, PasteKeyChangedError
+	}
+	p.CreatedAt = record.CreatedAt
+	p.ViewCount, p.LastViewedAt = record.ViewCount, record.LastViewedAt
+	p.UpdatedAt = time.Now()
+	record.memoryPasteMetadata = p.memoryPasteMetadata
+	return record, nil
+}
+
+func (p *memoryPaste) Commit() error {
+	p.broker.mu.Lock()
+	_, err := p.save()
+	p.broker.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	p.broker.publish(PasteUpdatedEvent, p)
+	return nil
+}
+
+func (p *memoryPaste) Erase() error {
+	p.broker.mu.Lock()
+	record, ok := p.broker.pastes[p.ID]
+	erased := ok && !record.inTrash()
+	if erased {
+		record.trashedAt = time.Now()
+	}
+	p.broker.mu.Unlock()
+
+	if erased {
+		p.broker.publish(PasteErasedEvent, p)
+	}
+	return nil
+}
+
+func (p *memoryPaste) Reader() (io.ReadCloser, error) {
+	p.broker.mu.RLock()
+	var body []byte
+	var bodyKey string
+	if record, ok := p.broker.pastes[p.ID]; ok {
+		body, bodyKey = record.body, record.bodyKey
+	}
+	p.broker.mu.RUnlock()
+
+	var r io.ReadCloser = devZero
+	if body != nil {
+		r = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if p.IsEncrypted() {
+		return getPasteEncryptionCodec(p.EncryptionMethod).Reader(rekeyedBodyID(p.ID, bodyKey), p.encryptionKey, r), nil
+	}
+	return r, nil
+}
+
+// memoryPasteWriter collects a body until it is closed, so that nothing is stored for a
+// write that is abandoned part of the way through.
+type memoryPasteWriter struct {
+	bytes.Buffer
+	p       *memoryPaste
+	stats   pasteBodyStats
+	bodyKey string // for encrypted bodies; see dbPaste.BodyKey
+}
+
+func (pw *memoryPasteWriter) Close() error {
+	body := pw.Bytes()
+	var tokens []string
+	if !pw.p.IsEncrypted() {
+		// searchableBody can't fail reading from memory.
+		indexed, _ := searchableBody(bytes.NewReader(body), pw.p.IsBundle())
+		if utf8.Valid(indexed) {
+			tokens = searchTokens(string(indexed))
+		}
+	}
+
+	// See pasteWriter.commit.
+	pw.p.Size, pw.p.LineCount = -1, -1
+	if !pw.p.IsEncrypted() || pw.p.IsClientEncrypted() {
+		pw.p.Size = pw.stats.size
+	}
+	if !pw.p.IsEncrypted() {
+		pw.p.LineCount = pw.stats.lineCount()
+	}
+
+	pw.p.broker.mu.Lock()
+	record, ok := pw.p.broker.pastes[pw.p.ID]
+	if ok && len(record.revisions) == 0 {
+		pw.p.Creator = pw.p.editor
+	}
+	record, err := pw.p.save()
+	if err == nil {
+		record.body, record.bodyKey = body, pw.bodyKey
+		record.bodyTokens = tokens
+		// Every write is kept as an immutable revision, which shares the current body.
+		record.revisions = append(record.revisions, &memoryPasteRevision{
+			pasteID:      pw.p.ID,
+			number:       len(record.revisions) + 1,
+			time:         pw.p.UpdatedAt,
+			editor:       pw.p.editor,
+			title:        pw.p.Title,
+			languageName: pw.p.LanguageName,
+			bundle:       pw.p.Bundle,
+			body:         body,
+			bodyKey:      pw.bodyKey,
+		})
+	}
+	pw.p.broker.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	pw.p.broker.publish(PasteUpdatedEvent, pw.p)
+	return nil
+}
+
+func (p *memoryPaste) Writer() (io.WriteCloser, error) {
+	if p.IsEncrypted() && p.EncryptionMethod.reusesKeystream() && p.encryptionKey != nil {
+		// See dbPaste.Writer.
+		if err := p.reencryptForWrite(); err != nil {
+			return nil, err
+		}
+	}
+
+	w := &memoryPasteWriter{p: p}
+	var wc io.WriteCloser = w
+	if p.IsEncrypted() {
+		// Bodies are bound to names of their own, as the database broker's are.
+		var err error
+		if w.bodyKey, err = generateRandomBase32String(10, -1); err != nil {
+			return nil, err
+		}
+		wc = getPasteEncryptionCodec(p.EncryptionMethod).Writer(rekeyedBodyID(p.ID, w.bodyKey), p.encryptionKey, w)
+	}
+	return &pasteStatsWriter{WriteCloser: wc, stats: &w.stats}, nil
+}
+
+// reencryptForWrite encrypts p, which must be unlocked, with XChaCha20-Poly1305 under the same
+// key if it already has a body.
+func (p *memoryPaste) reencryptForWrite() error {
+	p.broker.mu.Lock()
+	record, ok := p.broker.pastes[p.ID]
+	if !ok {
+		p.broker.mu.Unlock()
+		return PasteNotFoundError
+	}
+	if record.EncryptionMethod != p.EncryptionMethod || !bytes.Equal(record.EncryptionSalt, p.EncryptionSalt) {
+		p.broker.mu.Unlock()
+		return PasteKeyChangedError
+	}
+	if record.body == nil && len(record.revisions) == 0 {
+		p.broker.mu.Unlock()
+		return nil
+	}
+	from := &pasteKey{method: p.EncryptionMethod, kdf: p.KDF, salt: p.EncryptionSalt, key: p.encryptionKey, hmac: p.HMAC}
+	to := aeadPasteKey(p.ID, from)
+	err := reencryptPasteRecord(record, from, to)
+	p.broker.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	p.EncryptionMethod, p.HMAC = to.method, to.hmac
+	p.broker.publish(PasteUpdatedEvent, p)
+	return nil
+}
+
+type memoryPasteRevision struct {
+	pasteID      PasteID
+	number       int
+	time         time.Time
+	editor       PasteEditor
+	title        string
+	languageName string
+	bundle       bool
+	body         []byte
+	bodyKey      string // for encrypted bodies; see dbPasteRevision.BodyKey
+
+	paste *memoryPaste
+}
+
+func (r *memoryPasteRevision) GetPasteID() PasteID {
+	return r.pasteID
+}
+
+func (r *memoryPasteRevision) GetNumber() int {
+	return r.number
+}
+
+func (r *memoryPasteRevision) GetTime() time.Time {
+	return r.time
+}
+
+func (r *memoryPasteRevision) GetEditor() PasteEditor {
+	return r.editor
+}
+
+func (r *memoryPasteRevision) GetLanguageName() string {
+	return r.languageName
+}
+
+func (r *memoryPasteRevision) GetTitle() string {
+	return r.title
+}
+
+func (r *memoryPasteRevision) IsBundle() bool {
+	return r.bundle
+}
+
+func (r *memoryPasteRevision) Reader() (io.ReadCloser, error) {
+	reader := ioutil.NopCloser(bytes.NewReader(r.body))
+	if r.paste.IsEncrypted() {
+		return getPasteEncryptionCodec(r.paste.EncryptionMethod).Reader(rekeyedBodyID(r.pasteID, r.bodyKey), r.paste.encryptionKey, reader), nil
+	}
+	return reader, nil
+}
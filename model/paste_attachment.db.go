@@ -0,0 +1,178 @@
+package model
+
+import (
+	"io"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
+)
+
+type dbPasteAttachment struct {
+	ID        uint   `gorm:"primary_key"`
+	PasteID   string `gorm:"type:varchar(191);unique_index:uix_paste_attachment"`
+	Name      string `gorm:"type:varchar(191);unique_index:uix_paste_attachment"`
+	CreatedAt time.Time
+
+	MIMEType string `gorm:"column:mime_type;type:varchar(128)"`
+	Size     int64
+
+	// BodyKey names the attachment's body in the paste body store; see attachmentBodyID.
+	BodyKey string `gorm:"type:varchar(32)"`
+
+	broker *dbBroker
+}
+
+func (a *dbPasteAttachment) GetPasteID() PasteID {
+	return PasteIDFromString(a.PasteID)
+}
+
+func (a *dbPasteAttachment) GetName() string {
+	return a.Name
+}
+
+func (a *dbPasteAttachment) GetMIMEType() string {
+	return a.MIMEType
+}
+
+func (a *dbPasteAttachment) GetSize() int64 {
+	return a.Size
+}
+
+func (a *dbPasteAttachment) GetCreationTime() time.Time {
+	return a.CreatedAt
+}
+
+func (a *dbPasteAttachment) Reader() (io.ReadCloser, error) {
+	r, err := a.broker.Bodies.GetBody(attachmentBodyID(a.GetPasteID(), a.BodyKey))
+	if err == PasteBodyNotFoundError {
+		return devZero, nil
+	}
+	return r, err
+}
+
+func (p *dbPaste) GetAttachments() ([]PasteAttachment, error) {
+	var as []*dbPasteAttachment
+	if err := p.broker.Where("paste_id = ?", p.ID).Order("name").Find(&as).Error; err != nil {
+		return nil, err
+	}
+
+	iAttachments := make([]PasteAttachment, len(as))
+	for i, a := range as {
+		a.broker = p.broker
+		iAttachments[i] = a
+	}
+	return iAttachments, nil
+}
+
+func (p *dbPaste) GetAttachment(name string) (PasteAttachment, error) {
+	var a dbPasteAttachment
+	if err := p.broker.Where("paste_id = ? AND name = ?", p.ID, name).First(&a).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, PasteAttachmentNotFoundError
+		}
+		return nil, err
+	}
+	a.broker = p.broker
+	return &a, nil
+}
+
+func (p *dbPaste) Attach(name, mimeType string, r io.Reader) (PasteAttachment, error) {
+	if p.IsEncrypted() {
+		return nil, PasteEncryptedError
+	}
+	if !validPasteAttachmentName(name) {
+		return nil, PasteAttachmentNameInvalidError
+	}
+
+	key, err := newAttachmentBodyKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// As with paste bodies, the attachment's body is stored before the row that points to it.
+	var stats pasteBodyStats
+	bodyID := attachmentBodyID(p.GetID(), key)
+	if err := p.broker.Bodies.PutBody(bodyID, io.TeeReader(r, &stats)); err != nil {
+		return nil, err
+	}
+
+	a, replaced, err := p.saveAttachment(name, mimeType, stats.size, key)
+	if err != nil {
+		if derr := p.broker.Bodies.DeleteBody(bodyID); derr != nil {
+			glog.Errorf("failed to remove the body of an abandoned attachment to paste %s: %v", p.ID, derr)
+		}
+		return nil, err
+	}
+	if replaced != "" {
+		if err := p.broker.Bodies.DeleteBody(attachmentBodyID(p.GetID(), replaced)); err != nil {
+			glog.Errorf("failed to remove the replaced body of attachment %q to paste %s: %v", name, p.ID, err)
+		}
+	}
+
+	p.broker.publish(PasteUpdatedEvent, p)
+	return a, nil
+}
+
+// saveAttachment records an attachment whose body is stored under key, returning the key
+// of the body it replaced, if any.
+func (p *dbPaste) saveAttachment(name, mimeType string, size int64, key string) (*dbPasteAttachment, string, error) {
+	tx := p.broker.Begin()
+
+	var n int
+	if err := tx.Model(&dbPaste{}).Where("id = ? AND trashed_at IS NULL", p.ID).Count(&n).Error; err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return nil, "", PasteNotFoundError
+	}
+
+	var a dbPasteAttachment
+	err := tx.Where("paste_id = ? AND name = ?", p.ID, name).First(&a).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		tx.Rollback()
+		return nil, "", err
+	}
+	replaced := a.BodyKey
+
+	a.PasteID, a.Name = p.ID, name
+	a.CreatedAt = time.Now()
+	a.MIMEType, a.Size, a.BodyKey = mimeType, size, key
+	if err := tx.Save(&a).Error; err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, "", err
+	}
+
+	a.broker = p.broker
+	return &a, replaced, nil
+}
+
+func (p *dbPaste) Detach(name string) error {
+	var a dbPasteAttachment
+	if err := p.broker.Where("paste_id = ? AND name = ?", p.ID, name).First(&a).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+
+	db := p.broker.Delete(dbPasteAttachment{}, "id = ? AND body_key = ?", a.ID, a.BodyKey)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		// It was replaced or removed in the meantime.
+		return nil
+	}
+	if err := p.broker.Bodies.DeleteBody(attachmentBodyID(p.GetID(), a.BodyKey)); err != nil {
+		return err
+	}
+
+	p.broker.publish(PasteUpdatedEvent, p)
+	return nil
+}
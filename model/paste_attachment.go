@@ -0,0 +1,51 @@
+package model
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A PasteAttachment is a file kept alongside the body of a paste. Attachments are not part
+// of the paste's revisions: replacing or removing one takes effect in every revision.
+type PasteAttachment interface {
+	GetPasteID() PasteID
+	GetName() string
+	// GetMIMEType returns the type recorded when the attachment was stored. It is not
+	// checked against the content.
+	GetMIMEType() string
+	GetSize() int64
+	GetCreationTime() time.Time
+
+	Reader() (io.ReadCloser, error)
+}
+
+// maxPasteAttachmentNameLength keeps attachment names within an indexed string column.
+const maxPasteAttachmentNameLength = 191
+
+// validPasteAttachmentName reports whether name can name an attachment. Names are file
+// names, not paths, and can be shown to users as they are.
+func validPasteAttachmentName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > maxPasteAttachmentNameLength || !utf8.ValidString(name) {
+		return false
+	}
+	if strings.ContainsAny(name, "/\\") {
+		return false
+	}
+	return strings.IndexFunc(name, unicode.IsControl) == -1
+}
+
+// attachmentBodyID names the body of a paste attachment in a PasteBodyStore. Each version
+// of an attachment is stored under a new key, so that replacing it never exposes a
+// half-written body.
+func attachmentBodyID(id PasteID, key string) PasteID {
+	return PasteID(fmt.Sprintf("%s+%s", id, key))
+}
+
+// newAttachmentBodyKey returns a key under which to store a new attachment body.
+func newAttachmentBodyKey() (string, error) {
+	return generateRandomBase32String(10, -1)
+}
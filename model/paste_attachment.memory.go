@@ -0,0 +1,123 @@
+package model
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+type memoryPasteAttachment struct {
+	pasteID  PasteID
+	name     string
+	time     time.Time
+	mimeType string
+	body     []byte
+}
+
+func (a *memoryPasteAttachment) GetPasteID() PasteID {
+	return a.pasteID
+}
+
+func (a *memoryPasteAttachment) GetName() string {
+	return a.name
+}
+
+func (a *memoryPasteAttachment) GetMIMEType() string {
+	return a.mimeType
+}
+
+func (a *memoryPasteAttachment) GetSize() int64 {
+	return int64(len(a.body))
+}
+
+func (a *memoryPasteAttachment) GetCreationTime() time.Time {
+	return a.time
+}
+
+func (a *memoryPasteAttachment) Reader() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(a.body)), nil
+}
+
+func (p *memoryPaste) GetAttachments() ([]PasteAttachment, error) {
+	p.broker.mu.RLock()
+	defer p.broker.mu.RUnlock()
+	record, ok := p.broker.pastes[p.ID]
+	if !ok {
+		return []PasteAttachment{}, nil
+	}
+
+	iAttachments := make([]PasteAttachment, 0, len(record.attachments))
+	for _, a := range record.attachments {
+		iAttachments = append(iAttachments, a)
+	}
+	sort.Slice(iAttachments, func(i, j int) bool {
+		return iAttachments[i].GetName() < iAttachments[j].GetName()
+	})
+	return iAttachments, nil
+}
+
+func (p *memoryPaste) GetAttachment(name string) (PasteAttachment, error) {
+	p.broker.mu.RLock()
+	defer p.broker.mu.RUnlock()
+	record, ok := p.broker.pastes[p.ID]
+	if !ok || record.attachments[name] == nil {
+		return nil, PasteAttachmentNotFoundError
+	}
+	return record.attachments[name], nil
+}
+
+func (p *memoryPaste) Attach(name, mimeType string, r io.Reader) (PasteAttachment, error) {
+	if p.IsEncrypted() {
+		return nil, PasteEncryptedError
+	}
+	if !validPasteAttachmentName(name) {
+		return nil, PasteAttachmentNameInvalidError
+	}
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// Attachments are never modified once stored; replacing one swaps it out whole.
+	a := &memoryPasteAttachment{
+		pasteID:  p.ID,
+		name:     name,
+		time:     time.Now(),
+		mimeType: mimeType,
+		body:     body,
+	}
+
+	p.broker.mu.Lock()
+	record, ok := p.broker.pastes[p.ID]
+	attached := ok && !record.inTrash()
+	if attached {
+		if record.attachments == nil {
+			record.attachments = make(map[string]*memoryPasteAttachment)
+		}
+		record.attachments[name] = a
+	}
+	p.broker.mu.Unlock()
+	if !attached {
+		return nil, PasteNotFoundError
+	}
+
+	p.broker.publish(PasteUpdatedEvent, p)
+	return a, nil
+}
+
+func (p *memoryPaste) Detach(name string) error {
+	p.broker.mu.Lock()
+	record, ok := p.broker.pastes[p.ID]
+	detached := ok && record.attachments[name] != nil
+	if detached {
+		delete(record.attachments, name)
+	}
+	p.broker.mu.Unlock()
+
+	if detached {
+		p.broker.publish(PasteUpdatedEvent, p)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
+)
+
+// dbPasteBlob is a body shared by every unencrypted paste and revision whose content hashes
+// to Hash. RefCount counts the rows that name it: each paste whose current body it is, and
+// each revision.
+type dbPasteBlob struct {
+	Hash              string `gorm:"type:varchar(64);primary_key"`
+	RefCount          int64
+	CompressionMethod PasteCompressionMethod
+	CreatedAt         time.Time
+}
+
+// blobBodyID names a shared body in a PasteBodyStore, by the SHA-256 of its content. No paste
+// ID contains '='.
+func blobBodyID(hash string) PasteID {
+	return PasteID("=" + hash)
+}
+
+// pasteBlobDeleting is the reference count of a blob whose body is being deleted. Only blobs
+// without references are deleted, and they are claimed first, so that a broker sharing the
+// database can't start referring to one whose body is about to go; see deletePasteBlob.
+const pasteBlobDeleting = -1
+
+// errPasteBlobBusy is returned by a write whose body is shared with a blob being deleted, or
+// stored at the same moment by another write; it is tried again.
+var errPasteBlobBusy = errors.New("model: shared body is busy")
+
+// maxPasteBlobAttempts bounds the times a write is tried while the blob its body is shared
+// with is busy.
+const maxPasteBlobAttempts = 5
+
+// putPasteBlob stores body, compressed with compression, as the blob hash unless it is already
+// stored. It returns the compression method of the stored blob, and whether it had to be
+// stored. The caller must hold the lock on the blob's body until the blob is referenced, or
+// abandoned if it can't be.
+func (broker *dbBroker) putPasteBlob(hash string, compression PasteCompressionMethod, body io.Reader) (PasteCompressionMethod, bool, error) {
+	var blob dbPasteBlob
+	err := broker.Where("hash = ?", hash).First(&blob).Error
+	if err == nil {
+		if blob.RefCount == pasteBlobDeleting {
+			return 0, false, errPasteBlobBusy
+		}
+		return blob.CompressionMethod, false, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return 0, false, err
+	}
+	if err := broker.Bodies.PutBody(blobBodyID(hash), body); err != nil {
+		return 0, false, err
+	}
+	return compression, true, nil
+}
+
+// refPasteBlob adds n references to the blob hash in tx, recording it if it is new; stored is
+// whether its body was just stored by putPasteBlob. A blob that was found stored but has been
+// claimed for deletion since is busy.
+func refPasteBlob(tx *gorm.DB, hash string, compression PasteCompressionMethod, n int64, stored bool) error {
+	db := tx.Model(&dbPasteBlob{}).Where("hash = ? AND ref_count >= 0", hash).UpdateColumn("ref_count", gorm.Expr("ref_count + ?", n))
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected > 0 {
+		return nil
+	}
+	if !stored {
+		return errPasteBlobBusy
+	}
+	return tx.Create(&dbPasteBlob{Hash: hash, RefCount: n, CompressionMethod: compression}).Error
+}
+
+// abandonPasteBlob deletes the body hash, compressed with compression, which was stored for a
+// write that failed. Another write may have stored the same body since, compressed its own
+// way, and be referring to it, so it is only deleted through a blob of its own; if the other
+// write's blob is there instead, the body is stored again as that blob records. It returns
+// whether it found another write's blob. The caller must hold the lock on the blob's body.
+func (broker *dbBroker) abandonPasteBlob(hash string, compression PasteCompressionMethod, body io.Reader) bool {
+	if err := broker.Create(&dbPasteBlob{Hash: hash, CompressionMethod: compression}).Error; err == nil {
+		broker.deletePasteBlob(hash)
+		return false
+	}
+	var blob dbPasteBlob
+	if err := broker.Where("hash = ?", hash).First(&blob).Error; err != nil {
+		glog.Errorf("blob %s: failed to abandon body: %v", hash, err)
+		return false
+	}
+	if blob.CompressionMethod != compression {
+		if err := broker.putPasteBlobAs(hash, blob.CompressionMethod, compression, body); err != nil {
+			glog.Errorf("blob %s: failed to store body again: %v", hash, err)
+		}
+	}
+	return true
+}
+
+// putPasteBlobAs stores body, compressed with from, as the blob hash compressed with to.
+func (broker *dbBroker) putPasteBlobAs(hash string, to, from PasteCompressionMethod, body io.Reader) error {
+	s, err := recompress(body, from, to)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return broker.Bodies.PutBody(blobBodyID(hash), s.Reader())
+}
+
+// unrefPasteBlobs removes a reference to the blob named by each of hashes in tx; a hash that
+// appears more than once loses a reference for each time. Blobs left unreferenced stay behind
+// until deleteUnreferencedPasteBlobs removes them.
+func unrefPasteBlobs(tx *gorm.DB, hashes []string) error {
+	counts := make(map[string]int64, len(hashes))
+	for _, hash := range hashes {
+		counts[hash]++
+	}
+	for hash, n := range counts {
+		if err := tx.Model(&dbPasteBlob{}).Where("hash = ?", hash).UpdateColumn("ref_count", gorm.Expr("ref_count - ?", n)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteUnreferencedPasteBlobs deletes those of hashes that are no longer referenced, along with
+// their bodies.
+func (broker *dbBroker) deleteUnreferencedPasteBlobs(hashes []string) {
+	for _, hash := range hashes {
+		unlock := broker.bodyLocks.lock(blobBodyID(hash))
+		broker.deletePasteBlob(hash)
+		unlock()
+	}
+}
+
+// deletePasteBlob deletes the blob hash and its body if it is no longer referenced. The blob is
+// claimed before its body is deleted, and put back should that fail. A broker that dies before
+// it is done leaves the blob claimed, and writes of the same body fail until it is deleted by
+// hand. The caller must hold the lock on the blob's body.
+func (broker *dbBroker) deletePasteBlob(hash string) {
+	db := broker.Model(&dbPasteBlob{}).Where("hash = ? AND ref_count = 0", hash).UpdateColumn("ref_count", pasteBlobDeleting)
+	if db.Error != nil {
+		glog.Errorf("blob %s: failed to delete: %v", hash, db.Error)
+		return
+	}
+	if db.RowsAffected == 0 {
+		return
+	}
+	if err := broker.Bodies.DeleteBody(blobBodyID(hash)); err != nil {
+		glog.Errorf("blob %s: failed to delete body: %v", hash, err)
+		if err := broker.Model(&dbPasteBlob{}).Where("hash = ? AND ref_count = ?", hash, pasteBlobDeleting).UpdateColumn("ref_count", 0).Error; err != nil {
+			glog.Errorf("blob %s: failed to release: %v", hash, err)
+		}
+		return
+	}
+	if err := broker.Delete(dbPasteBlob{}, "hash = ? AND ref_count = ?", hash, pasteBlobDeleting).Error; err != nil {
+		glog.Errorf("blob %s: failed to delete: %v", hash, err)
+	}
+}
@@ -0,0 +1,221 @@
+package model
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+func pasteBlobRefs(t *testing.T, b Broker, p Paste) int64 {
+	var blob dbPasteBlob
+	if err := b.(*dbBroker).Where("hash = ?", p.(*dbPaste).BodyHash.String).First(&blob).Error; err != nil {
+		t.Fatal(err)
+	}
+	return blob.RefCount
+}
+
+func purgePaste(t *testing.T, b Broker, p Paste) {
+	if err := p.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PurgePaste(p.GetID()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSharedPasteBodies(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p1, "shared")
+	p2, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p2, "shared")
+
+	shared := p1.(*dbPaste).bodyID()
+	if p2.(*dbPaste).bodyID() != shared {
+		t.Fatal("identical bodies were stored apart")
+	}
+	if n := pasteBlobRefs(t, b, p1); n != 4 {
+		t.Errorf("shared body has %d references; expected 4", n)
+	}
+
+	// The first paste moves on, but its first revision still refers to the shared body.
+	writePasteBody(t, p1, "changed")
+	if n := pasteBlobRefs(t, b, p2); n != 3 {
+		t.Errorf("shared body has %d references; expected 3", n)
+	}
+
+	purgePaste(t, b, p2)
+	rev, err := p1.GetRevision(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRevisionBody(t, rev); body != "shared" {
+		t.Errorf("read <%s> from a revision sharing the body of a purged paste", body)
+	}
+
+	// The body goes once nothing refers to it.
+	purgePaste(t, b, p1)
+	if _, err := b.(*dbBroker).Bodies.GetBody(shared); err != PasteBodyNotFoundError {
+		t.Error("purging every paste left their shared body behind")
+	}
+	var n int
+	if err := b.(*dbBroker).Model(&dbPasteBlob{}).Count(&n).Error; err != nil || n != 0 {
+		t.Errorf("%d shared bodies survived (%v)", n, err)
+	}
+
+	ep, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, ep, "shared")
+	if ep.(*dbPaste).BodyHash.Valid {
+		t.Error("an encrypted body was shared")
+	}
+}
+
+func TestSharedPasteBodiesConcurrently(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, kept, "contended")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				p, err := b.CreatePaste()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				w, _ := p.Writer()
+				w.Write([]byte("contended"))
+				if err := w.Close(); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := p.Erase(); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := b.PurgePaste(p.GetID()); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := pasteBlobRefs(t, b, kept); n != 2 {
+		t.Errorf("shared body has %d references; expected 2", n)
+	}
+	if body := readPasteBody(t, kept); body != "contended" {
+		t.Errorf("read <%s> from a paste whose body was shared and released", body)
+	}
+}
+
+func TestSharedPasteBodiesAcrossBrokers(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	// Brokers sharing a database don't share their locks.
+	b1, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{}, DatabaseBrokerCompression(PasteCompressionMethodNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1, err := b1.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p1, "shared")
+	hash := p1.(*dbPaste).BodyHash.String
+	purgePaste(t, b1, p1)
+
+	// A body being deleted by one broker isn't shared by a write on another.
+	if err := b1.(*dbBroker).Create(&dbPasteBlob{Hash: hash, RefCount: pasteBlobDeleting}).Error; err != nil {
+		t.Fatal(err)
+	}
+	p2, err := b2.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := p2.Writer()
+	w.Write([]byte("shared"))
+	if err := w.Close(); err != errPasteBlobBusy {
+		t.Errorf("wrote a body being deleted (%v)", err)
+	}
+	b1.(*dbBroker).deletePasteBlob(hash)
+	if err := b1.(*dbBroker).Delete(dbPasteBlob{}, "hash = ?", hash).Error; err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p2, "shared")
+	if body := readPasteBody(t, p2); body != "shared" {
+		t.Errorf("read <%s> from a body written after another was deleted", body)
+	}
+
+	// A write that stored the same body as another, compressed its own way, before the other
+	// recorded its blob, and then failed leaves the body as the other's blob records it.
+	if err := b1.(*dbBroker).Bodies.PutBody(blobBodyID(hash), gzipped(t, "shared")); err != nil {
+		t.Fatal(err)
+	}
+	if !b1.(*dbBroker).abandonPasteBlob(hash, PasteCompressionMethodGzip, gzipped(t, "shared")) {
+		t.Error("abandoned a body another write's blob refers to")
+	}
+	if body := readPasteBody(t, p2); body != "shared" {
+		t.Errorf("read <%s> from a body stored twice at once", body)
+	}
+
+	// A body nothing else refers to is deleted when it is abandoned.
+	purgePaste(t, b2, p2)
+	if _, stored, err := b1.(*dbBroker).putPasteBlob(hash, PasteCompressionMethodGzip, gzipped(t, "shared")); !stored || err != nil {
+		t.Fatalf("didn't store the body again (%v)", err)
+	}
+	if b1.(*dbBroker).abandonPasteBlob(hash, PasteCompressionMethodGzip, gzipped(t, "shared")) {
+		t.Error("found another write's blob where there is none")
+	}
+	if _, err := b1.(*dbBroker).Bodies.GetBody(blobBodyID(hash)); err != PasteBodyNotFoundError {
+		t.Error("abandoned body was left behind")
+	}
+}
+
+func gzipped(t *testing.T, body string) io.Reader {
+	var buf bytes.Buffer
+	w := getPasteCompressionCodec(PasteCompressionMethodGzip).Writer(nopWriteCloser{&buf})
+	if _, err := io.WriteString(w, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
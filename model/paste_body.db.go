@@ -0,0 +1,190 @@
+package model
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/DHowett/ghostbin/lib/sql/querybuilder"
+	"github.com/jinzhu/gorm"
+)
+
+// dbPasteBodyStore keeps paste bodies in the db_paste_body_chunks table.
+type dbPasteBodyStore struct {
+	db *gorm.DB
+	qb querybuilder.QueryBuilder
+}
+
+// NewDatabasePasteBodyStore returns a store that keeps paste bodies alongside the rest of
+// the paste data in sqlDb. It is the store used by a broker that isn't given another.
+func NewDatabasePasteBodyStore(dialect string, sqlDb *sql.DB) (PasteBodyStore, error) {
+	qb := querybuilder.New(dialect)
+	if qb == nil {
+		return nil, errors.New("model: unsupported database dialect " + dialect)
+	}
+
+	db, err := gorm.Open(dialect, sqlDb)
+	if err != nil {
+		return nil, err
+	}
+	return &dbPasteBodyStore{db: db, qb: qb}, nil
+}
+
+func (s *dbPasteBodyStore) getChunk(id PasteID, seq int) (io.ReadCloser, error) {
+	stmt, err := s.qb.BuildStatement(&querybuilder.SelectQuery{
+		Table:  "db_paste_body_chunks",
+		Fields: []string{"data"},
+		Where:  []querybuilder.Condition{querybuilder.Cond("paste_id = ? AND seq = ?", id.String(), seq)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if err := s.db.CommonDB().QueryRow(stmt.SQL, stmt.Args...).Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// deleteChunks deletes every chunk of the body called id.
+func (s *dbPasteBodyStore) deleteChunks(db *gorm.DB, id string) error {
+	return execQuery(db, s.qb, &querybuilder.DeleteQuery{
+		Table: "db_paste_body_chunks",
+		Where: []querybuilder.Condition{querybuilder.Cond("paste_id = ?", id)},
+	})
+}
+
+func (s *dbPasteBodyStore) GetBody(id PasteID) (io.ReadCloser, error) {
+	first, err := s.getChunk(id, 0)
+	if err != nil {
+		if err == io.EOF {
+			return nil, PasteBodyNotFoundError
+		}
+		return nil, err
+	}
+
+	return &chunkReader{
+		next: func(seq int) (io.ReadCloser, error) {
+			if seq == 0 {
+				return first, nil
+			}
+			return s.getChunk(id, seq)
+		},
+	}, nil
+}
+
+// PutBody writes the new body's chunks under a temporary name, one statement at a time, and
+// only swaps them in for the old ones once they are all written. No transaction is held open
+// while the body is being read.
+func (s *dbPasteBodyStore) PutBody(id PasteID, r io.Reader) error {
+	suffix, err := generateRandomBase32String(5, 8)
+	if err != nil {
+		return err
+	}
+	staging := id.String() + "~" + suffix
+
+	_, err = writeChunks(r, func(seq int, data []byte) error {
+		return execQuery(s.db, s.qb, &querybuilder.InsertQuery{
+			Table:  "db_paste_body_chunks",
+			Fields: []string{"paste_id", "seq", "data"},
+			Rows:   [][]interface{}{{staging, seq, data}},
+		})
+	})
+	if err == nil {
+		tx := s.db.Begin()
+		err = s.deleteChunks(tx, id.String())
+		if err == nil {
+			err = execQuery(tx, s.qb, &querybuilder.UpdateQuery{
+				Table:  "db_paste_body_chunks",
+				Fields: []string{"paste_id"},
+				Values: []interface{}{id.String()},
+				Where:  []querybuilder.Condition{querybuilder.Cond("paste_id = ?", staging)},
+			})
+		}
+		if err == nil {
+			err = tx.Commit().Error
+		} else {
+			tx.Rollback()
+		}
+	}
+
+	if err != nil {
+		s.deleteChunks(s.db, staging)
+	}
+	return err
+}
+
+func (s *dbPasteBodyStore) DeleteBody(id PasteID) error {
+	return s.deleteChunks(s.db, id.String())
+}
+
+// MigratePasteBodies moves the bodies of every paste in sqlDb, of their revisions and attachments, and the bodies
+// they share from one store to another. It returns the number of bodies moved. Bodies are only removed from the source
+// once they have been stored in the destination, so an interrupted migration can simply be run again.
+func MigratePasteBodies(dialect string, sqlDb *sql.DB, from, to PasteBodyStore) (int, error) {
+	db, err := gorm.Open(dialect, sqlDb)
+	if err != nil {
+		return 0, err
+	}
+
+	bodyIDs, err := storedBodyIDs(db)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range bodyIDs {
+		moved, err := movePasteBody(id, from, to)
+		if err != nil {
+			return n, err
+		}
+		if moved {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// storedBodyIDs lists the IDs of every body db refers to: the bodies of pastes and revisions
+// that aren't shared, the shared bodies, and the bodies of attachments. The bodies of pastes
+// that are stored as their revisions' are listed once, with the revisions.
+func storedBodyIDs(db *gorm.DB) ([]PasteID, error) {
+	var pastes []*dbPaste
+	if err := db.Select("id, body_key").Where("body_hash IS NULL AND body_revision IS NULL").Order("id").Find(&pastes).Error; err != nil {
+		return nil, err
+	}
+	var revs []*dbPasteRevision
+	if err := db.Select("paste_id, revision, body_key").Where("data IS NULL AND body_hash IS NULL").Order("paste_id, revision").Find(&revs).Error; err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	if err := db.Model(&dbPasteBlob{}).Order("hash").Pluck("hash", &hashes).Error; err != nil {
+		return nil, err
+	}
+
+	var attachments []*dbPasteAttachment
+	if err := db.Select("paste_id, body_key").Order("paste_id, name").Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+
+	bodyIDs := make([]PasteID, 0, len(pastes)+len(revs)+len(hashes)+len(attachments))
+	for _, p := range pastes {
+		bodyIDs = append(bodyIDs, rekeyedBodyID(p.GetID(), p.BodyKey))
+	}
+	for _, rev := range revs {
+		bodyIDs = append(bodyIDs, rev.bodyID())
+	}
+	for _, hash := range hashes {
+		bodyIDs = append(bodyIDs, blobBodyID(hash))
+	}
+	for _, a := range attachments {
+		bodyIDs = append(bodyIDs, attachmentBodyID(a.GetPasteID(), a.BodyKey))
+	}
+	return bodyIDs, nil
+}
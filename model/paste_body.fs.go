@@ -0,0 +1,93 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fsPasteBodyStore keeps each paste body in a file of its own. Files are spread across
+// two levels of directories named for the leading bytes of a digest of the paste's ID,
+// so that no one directory grows too large.
+type fsPasteBodyStore struct {
+	root string
+}
+
+// NewFilesystemPasteBodyStore returns a store that keeps paste bodies in files under root.
+func NewFilesystemPasteBodyStore(root string) (PasteBodyStore, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return &fsPasteBodyStore{root: root}, nil
+}
+
+func (s *fsPasteBodyStore) path(id PasteID) (string, error) {
+	name := id.String()
+	if name == "" || name[0] == '.' || strings.ContainsAny(name, "/\\\x00") {
+		return "", errors.New("model: paste ID " + name + " is not a valid file name")
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	shard := hex.EncodeToString(sum[:2])
+	return filepath.Join(s.root, shard[0:2], shard[2:4], name), nil
+}
+
+func (s *fsPasteBodyStore) GetBody(id PasteID) (io.ReadCloser, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, PasteBodyNotFoundError
+	}
+	return f, err
+}
+
+// PutBody writes the new body alongside the old one and renames it into place.
+func (s *fsPasteBodyStore) PutBody(id PasteID, r io.Reader) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".body-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (s *fsPasteBodyStore) DeleteBody(id PasteID) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"fmt"
+	"io"
+)
+
+// PasteBodyStore holds the bodies of pastes apart from the rest of their data.
+// Bodies are stored exactly as written, so those of encrypted pastes remain encrypted.
+type PasteBodyStore interface {
+	// GetBody returns the body of the paste named by id, or PasteBodyNotFoundError if it has none.
+	// The body is streamed from the store as it is read.
+	GetBody(id PasteID) (io.ReadCloser, error)
+	// PutBody replaces the body of the paste named by id with everything read from r.
+	// Readers see either the old body or the new one, never a partially-written body.
+	PutBody(id PasteID, r io.Reader) error
+	// DeleteBody removes the body of the paste named by id. Removing a missing body is not an error.
+	DeleteBody(id PasteID) error
+}
+
+// pasteBodyChunkSize is the size of the pieces in which stores that can't stream a whole
+// body at once keep it.
+const pasteBodyChunkSize = 512 * 1024
+
+// revisionBodyID names the body of a paste revision in a PasteBodyStore.
+func revisionBodyID(id PasteID, revision int) PasteID {
+	return PasteID(fmt.Sprintf("%s@%d", id, revision))
+}
+
+// rekeyedBodyID names a body, of a paste or one of its revisions, that was encrypted again
+// under a new key; key sets it apart from the bodies it replaces. Paste IDs never hold a dot.
+func rekeyedBodyID(id PasteID, key string) PasteID {
+	if key == "" {
+		return id
+	}
+	return PasteID(id.String() + "." + key)
+}
+
+// writeChunks reads r to the end, passing it to put in pasteBodyChunkSize pieces. An empty
+// body is stored as a single empty chunk, so that it can be told apart from a missing one.
+// It returns the number of chunks written.
+func writeChunks(r io.Reader, put func(seq int, data []byte) error) (int, error) {
+	buf := make([]byte, pasteBodyChunkSize)
+	seq := 0
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || seq == 0 {
+			if perr := put(seq, buf[:n]); perr != nil {
+				return seq, perr
+			}
+			seq++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return seq, nil
+		}
+		if err != nil {
+			return seq, err
+		}
+	}
+}
+
+// chunkReader reads a body kept as a sequence of chunks, fetching each chunk only once
+// the one before it has been read. next returns io.EOF once there are no more chunks.
+type chunkReader struct {
+	next func(seq int) (io.ReadCloser, error)
+	seq  int
+	cur  io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			r, err := c.next(c.seq)
+			if err != nil {
+				return 0, err
+			}
+			c.cur = r
+			c.seq++
+		}
+
+		n, err := c.cur.Read(p)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.cur != nil {
+		return c.cur.Close()
+	}
+	return nil
+}
+
+// movePasteBody streams the body of the paste named by id from one store to another,
+// removing it from the first only once the copy is complete. It reports whether there was
+// a body to move.
+func movePasteBody(id PasteID, from, to PasteBodyStore) (bool, error) {
+	r, err := from.GetBody(id)
+	if err != nil {
+		if err == PasteBodyNotFoundError {
+			return false, nil
+		}
+		return false, err
+	}
+	err = to.PutBody(id, r)
+	r.Close()
+	if err != nil {
+		return false, err
+	}
+	return true, from.DeleteBody(id)
+}
@@ -0,0 +1,144 @@
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/DHowett/ghostbin/lib/objectstore"
+)
+
+// objectPasteBodyStore keeps each paste body in an S3-compatible bucket as a series of chunk
+// objects, along with a manifest naming them:
+//
+//	<prefix><id>/manifest
+//	<prefix><id>/<generation>/<seq>
+//
+// Every body written gets a new generation, and its manifest is written only once all of its
+// chunks have been, so a reader never sees a partially-written body. Bodies stored before
+// chunking was introduced are single objects named <prefix><id>, and are still read.
+type objectPasteBodyStore struct {
+	client *objectstore.Client
+	prefix string
+}
+
+type objectPasteBodyManifest struct {
+	Generation string `json:"generation"`
+	Chunks     int    `json:"chunks"`
+}
+
+// NewObjectPasteBodyStore returns a store that keeps paste bodies in the bucket served by
+// client, under keys beginning with prefix and the paste ID.
+func NewObjectPasteBodyStore(client *objectstore.Client, prefix string) PasteBodyStore {
+	return &objectPasteBodyStore{client: client, prefix: prefix}
+}
+
+func (s *objectPasteBodyStore) key(id PasteID) string {
+	return s.prefix + id.String()
+}
+
+func (s *objectPasteBodyStore) manifestKey(id PasteID) string {
+	return s.key(id) + "/manifest"
+}
+
+func (s *objectPasteBodyStore) chunkKey(id PasteID, generation string, seq int) string {
+	return s.key(id) + "/" + generation + "/" + strconv.Itoa(seq)
+}
+
+// manifest returns the manifest of the body of the paste named by id, or nil if it has none.
+func (s *objectPasteBodyStore) manifest(id PasteID) (*objectPasteBodyManifest, error) {
+	r, err := s.client.Get(s.manifestKey(id))
+	if err != nil {
+		if err == objectstore.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+
+	var m objectPasteBodyManifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (s *objectPasteBodyStore) GetBody(id PasteID) (io.ReadCloser, error) {
+	m, err := s.manifest(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		r, err := s.client.Get(s.key(id))
+		if err == objectstore.ErrNotFound {
+			return nil, PasteBodyNotFoundError
+		}
+		return r, err
+	}
+
+	return &chunkReader{
+		next: func(seq int) (io.ReadCloser, error) {
+			if seq >= m.Chunks {
+				return nil, io.EOF
+			}
+			return s.client.Get(s.chunkKey(id, m.Generation, seq))
+		},
+	}, nil
+}
+
+func (s *objectPasteBodyStore) deleteChunks(id PasteID, m *objectPasteBodyManifest) error {
+	for seq := 0; seq < m.Chunks; seq++ {
+		if err := s.client.Delete(s.chunkKey(id, m.Generation, seq)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *objectPasteBodyStore) PutBody(id PasteID, r io.Reader) error {
+	old, err := s.manifest(id)
+	if err != nil {
+		return err
+	}
+
+	generation, err := generateRandomBase32String(5, 8)
+	if err != nil {
+		return err
+	}
+	m := &objectPasteBodyManifest{Generation: generation}
+	m.Chunks, err = writeChunks(r, func(seq int, data []byte) error {
+		return s.client.Put(s.chunkKey(id, generation, seq), data)
+	})
+	if err != nil {
+		s.deleteChunks(id, m)
+		return err
+	}
+
+	data, _ := json.Marshal(m)
+	if err := s.client.Put(s.manifestKey(id), data); err != nil {
+		s.deleteChunks(id, m)
+		return err
+	}
+
+	if old != nil {
+		return s.deleteChunks(id, old)
+	}
+	return s.client.Delete(s.key(id))
+}
+
+func (s *objectPasteBodyStore) DeleteBody(id PasteID) error {
+	m, err := s.manifest(id)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		if err := s.client.Delete(s.manifestKey(id)); err != nil {
+			return err
+		}
+		if err := s.deleteChunks(id, m); err != nil {
+			return err
+		}
+	}
+	return s.client.Delete(s.key(id))
+}
@@ -0,0 +1,44 @@
+package model
+
+import "sync"
+
+// pasteBodyLocks serializes changes to stored bodies one name at a time: the bodies of a paste
+// by its ID, and shared bodies by their blobBodyID, so that writes to different pastes don't
+// wait for one another. The bodies of a paste may be locked while a shared body is, but never
+// the other way around, and no more than one shared body is locked at once.
+type pasteBodyLocks struct {
+	mu    sync.Mutex
+	locks map[PasteID]*pasteBodyLock
+}
+
+type pasteBodyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the bodies named by id, and returns a function that unlocks them.
+func (l *pasteBodyLocks) lock(id PasteID) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[PasteID]*pasteBodyLock)
+	}
+	bl, ok := l.locks[id]
+	if !ok {
+		bl = &pasteBodyLock{}
+		l.locks[id] = bl
+	}
+	bl.refs++
+	l.mu.Unlock()
+
+	bl.Lock()
+	return func() {
+		bl.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		// Locks are forgotten once nobody holds or waits for them.
+		if bl.refs--; bl.refs == 0 {
+			delete(l.locks, id)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPasteBodyLocks(t *testing.T) {
+	var l pasteBodyLocks
+	unlock := l.lock("abcde")
+
+	// Other pastes' bodies aren't held up.
+	l.lock("fghjk")()
+
+	locked := make(chan struct{})
+	go func() {
+		l.lock("abcde")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("locked bodies that were already locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+
+	if len(l.locks) != 0 {
+		t.Errorf("%d locks remain after being unlocked", len(l.locks))
+	}
+}
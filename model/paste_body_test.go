@@ -0,0 +1,201 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DHowett/ghostbin/lib/objectstore/objectstoretest"
+)
+
+func readBody(t *testing.T, s PasteBodyStore, id PasteID) string {
+	r, err := s.GetBody(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func testPasteBodyStore(t *testing.T, s PasteBodyStore) {
+	if _, err := s.GetBody("missing"); err != PasteBodyNotFoundError {
+		t.Errorf("got %v reading a missing body", err)
+	}
+
+	if err := s.PutBody("abcde", strings.NewReader("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutBody("abcde", strings.NewReader("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutBody("fghjk", strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := readBody(t, s, "abcde"); body != "second" {
+		t.Errorf("read back <%s> after replacing the body", body)
+	}
+	if body := readBody(t, s, "fghjk"); body != "" {
+		t.Errorf("read back <%s> from an empty body", body)
+	}
+
+	large := strings.Repeat("0123456789abcdef", pasteBodyChunkSize/8+3)
+	if err := s.PutBody("lmnop", strings.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(t, s, "lmnop"); body != large {
+		t.Errorf("read back %d bytes of a %d-byte body", len(body), len(large))
+	}
+	if err := s.DeleteBody("lmnop"); err != nil {
+		t.Error(err)
+	}
+
+	if err := s.DeleteBody("abcde"); err != nil {
+		t.Error(err)
+	}
+	if err := s.DeleteBody("abcde"); err != nil {
+		t.Error("deleting a missing body:", err)
+	}
+	if _, err := s.GetBody("abcde"); err != PasteBodyNotFoundError {
+		t.Errorf("got %v reading a deleted body", err)
+	}
+}
+
+func openTestBodyDatabase(t *testing.T) PasteBodyStore {
+	sqlDb := openTestDatabase(t)
+	m, err := NewSchemaMigrator("sqlite3", sqlDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewDatabasePasteBodyStore("sqlite3", sqlDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPasteBodyStores(t *testing.T) {
+	t.Run("Database", func(t *testing.T) {
+		testPasteBodyStore(t, openTestBodyDatabase(t))
+	})
+
+	t.Run("Filesystem", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "ghostbin-bodies")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s, err := NewFilesystemPasteBodyStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testPasteBodyStore(t, s)
+
+		if err := s.PutBody("../escape", strings.NewReader("x")); err == nil {
+			t.Error("stored a body outside of the store")
+		}
+	})
+
+	t.Run("Object", func(t *testing.T) {
+		server := objectstoretest.NewServer("ghostbin")
+		defer server.Close()
+		testPasteBodyStore(t, NewObjectPasteBodyStore(server.Client(), "bodies/"))
+
+		for _, key := range server.Objects() {
+			if !strings.HasPrefix(key, "bodies/fghjk/") {
+				t.Errorf("bucket holds a stray object %s", key)
+			}
+		}
+	})
+}
+
+func TestMigratePasteBodies(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	server := objectstoretest.NewServer("ghostbin")
+	defer server.Close()
+	objects := NewObjectPasteBodyStore(server.Client(), "")
+
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "moving house")
+	if _, err := p.Attach("boxes.txt", "text/plain", strings.NewReader("packed")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.CreatePaste(); err != nil { // no body
+		t.Fatal(err)
+	}
+
+	db, err := NewDatabasePasteBodyStore("sqlite3", sqlDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := MigratePasteBodies("sqlite3", sqlDb, db, objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 { // the body the paste shares with its first revision, and its attachment
+		t.Errorf("moved %d bodies; expected 2", n)
+	}
+	if _, err := db.GetBody(p.(*dbPaste).bodyID()); err != PasteBodyNotFoundError {
+		t.Error("body was left in the database")
+	}
+
+	b, err = NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{}, DatabaseBrokerBodyStore(objects))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = b.GetPaste(p.GetID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := p.Reader()
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(data) != "moving house" {
+		t.Errorf("read <%s> from the moved body", data)
+	}
+	rev, err := p.GetRevision(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRevisionBody(t, rev); body != "moving house" {
+		t.Errorf("read <%s> from the moved revision", body)
+	}
+	a, err := p.GetAttachment("boxes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readAttachmentBody(t, a); body != "packed" {
+		t.Errorf("read <%s> from the moved attachment", body)
+	}
+
+	if err := p.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PurgePaste(p.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := objects.GetBody(p.(*dbPaste).bodyID()); err != PasteBodyNotFoundError {
+		t.Error("purging the paste left its body behind")
+	}
+	if _, err := objects.GetBody(attachmentBodyID(p.GetID(), a.(*dbPasteAttachment).BodyKey)); err != PasteBodyNotFoundError {
+		t.Error("purging the paste left its attachment behind")
+	}
+}
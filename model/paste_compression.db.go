@@ -0,0 +1,196 @@
+package model
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/DHowett/ghostbin/lib/spool"
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// recompressBody reads the stored body id, compressed with from, and returns it compressed
+// the way the broker compresses new bodies. It returns a nil spool if there is no such body.
+func (broker *dbBroker) recompressBody(id PasteID, from PasteCompressionMethod) (*spool.Spool, error) {
+	r, err := broker.Bodies.GetBody(id)
+	if err == PasteBodyNotFoundError {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return recompress(r, from, broker.Compression)
+}
+
+// recompress returns the body read from r, compressed with from, compressed with to instead.
+func recompress(r io.Reader, from, to PasteCompressionMethod) (*spool.Spool, error) {
+	s := spool.New(pasteBodySpoolMemory)
+	w := getPasteCompressionCodec(to).Writer(nopWriteCloser{s})
+	_, err := io.Copy(w, getPasteCompressionCodec(from).Reader(ioutil.NopCloser(r)))
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (broker *dbBroker) recompressPasteBody(p *dbPaste) error {
+	body, err := broker.recompressBody(p.GetID(), p.CompressionMethod)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		defer body.Close()
+	}
+
+	defer broker.bodyLocks.lock(p.GetID())()
+
+	// The paste may have been edited or purged since its body was read.
+	var current dbPaste
+	if err := broker.Select("id, updated_at, compression_method").Where("id = ?", p.ID).First(&current).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+	if !current.UpdatedAt.Equal(p.UpdatedAt) || current.CompressionMethod != p.CompressionMethod {
+		return nil
+	}
+
+	if body != nil {
+		if err := broker.Bodies.PutBody(p.GetID(), body.Reader()); err != nil {
+			return err
+		}
+	}
+	return broker.Model(&dbPaste{}).Where("id = ?", p.ID).UpdateColumn("compression_method", broker.Compression).Error
+}
+
+func (broker *dbBroker) recompressRevisionBody(rev *dbPasteRevision) error {
+	body, err := broker.recompressBody(revisionBodyID(rev.GetPasteID(), rev.Revision), rev.CompressionMethod)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		defer body.Close()
+	}
+
+	defer broker.bodyLocks.lock(rev.GetPasteID())()
+
+	// Revisions never change, but their paste may have been purged since.
+	var n int
+	if err := broker.Model(&dbPasteRevision{}).Where("id = ?", rev.ID).Count(&n).Error; err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+
+	if body != nil {
+		if err := broker.Bodies.PutBody(revisionBodyID(rev.GetPasteID(), rev.Revision), body.Reader()); err != nil {
+			return err
+		}
+	}
+	return broker.Model(&dbPasteRevision{}).Where("id = ?", rev.ID).UpdateColumn("compression_method", broker.Compression).Error
+}
+
+func (broker *dbBroker) recompressPasteBlob(blob *dbPasteBlob) error {
+	body, err := broker.recompressBody(blobBodyID(blob.Hash), blob.CompressionMethod)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		defer body.Close()
+	}
+
+	defer broker.bodyLocks.lock(blobBodyID(blob.Hash))()
+
+	// The blob may have been deleted, or be being deleted, since its body was read.
+	var n int
+	if err := broker.Model(&dbPasteBlob{}).Where("hash = ? AND ref_count >= 0", blob.Hash).Count(&n).Error; err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+
+	if body != nil {
+		if err := broker.Bodies.PutBody(blobBodyID(blob.Hash), body.Reader()); err != nil {
+			return err
+		}
+	}
+
+	// Everything that shares the body records how it is compressed.
+	tx := broker.Begin()
+	for _, db := range []*gorm.DB{
+		tx.Model(&dbPasteBlob{}).Where("hash = ?", blob.Hash),
+		tx.Model(&dbPaste{}).Where("body_hash = ?", blob.Hash),
+		tx.Model(&dbPasteRevision{}).Where("body_hash = ?", blob.Hash),
+	} {
+		if err := db.UpdateColumn("compression_method", broker.Compression).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// CompressPasteBodies implements PasteBodyCompressor. Bodies that can't be read are logged
+// and skipped.
+func (broker *dbBroker) CompressPasteBodies(limit int) (int, error) {
+	var ps []*dbPaste
+	if err := broker.Select("id, updated_at, compression_method").Where("compression_method <> ? AND encryption_method = ? AND body_hash IS NULL", broker.Compression, PasteEncryptionMethodNone).Order("id").Limit(limit).Find(&ps).Error; err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, p := range ps {
+		if err := broker.recompressPasteBody(p); err != nil {
+			glog.Errorf("paste %s: failed to recompress body: %v", p.ID, err)
+			continue
+		}
+		n++
+	}
+	if len(ps) == limit {
+		return n, nil
+	}
+
+	// Revisions whose bodies are still kept in the database were written before compression.
+	var revs []*dbPasteRevision
+	if err := broker.Select("id, paste_id, revision, compression_method").Where("compression_method <> ? AND data IS NULL AND body_hash IS NULL AND paste_id IN (SELECT id FROM db_pastes WHERE encryption_method = ?)", broker.Compression, PasteEncryptionMethodNone).Order("id").Limit(limit - len(ps)).Find(&revs).Error; err != nil {
+		return n, err
+	}
+	for _, rev := range revs {
+		if err := broker.recompressRevisionBody(rev); err != nil {
+			glog.Errorf("paste %s: failed to recompress revision %d: %v", rev.PasteID, rev.Revision, err)
+			continue
+		}
+		n++
+	}
+	if len(ps)+len(revs) == limit {
+		return n, nil
+	}
+
+	var blobs []*dbPasteBlob
+	if err := broker.Where("compression_method <> ?", broker.Compression).Order("hash").Limit(limit - len(ps) - len(revs)).Find(&blobs).Error; err != nil {
+		return n, err
+	}
+	for _, blob := range blobs {
+		if err := broker.recompressPasteBlob(blob); err != nil {
+			glog.Errorf("blob %s: failed to recompress body: %v", blob.Hash, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+)
+
+// PasteCompressionMethod records how a stored body was compressed. Bodies are compressed
+// before they are encrypted, and decompressed after they are decrypted.
+type PasteCompressionMethod uint
+
+const (
+	PasteCompressionMethodNone PasteCompressionMethod = iota
+	PasteCompressionMethodGzip
+)
+
+var pasteCompressionMethodNames = map[PasteCompressionMethod]string{
+	PasteCompressionMethodNone: "none",
+	PasteCompressionMethodGzip: "gzip",
+}
+
+func (m PasteCompressionMethod) String() string {
+	if name, ok := pasteCompressionMethodNames[m]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParsePasteCompressionMethod returns the compression method called name.
+func ParsePasteCompressionMethod(name string) (PasteCompressionMethod, error) {
+	for m, n := range pasteCompressionMethodNames {
+		if strings.EqualFold(n, name) {
+			return m, nil
+		}
+	}
+	return PasteCompressionMethodNone, errors.New("model: unknown paste compression method " + name)
+}
+
+type PasteCompressionCodec interface {
+	// Reader decompresses what is read from r. Corrupt data is reported by Read.
+	Reader(io.ReadCloser) io.ReadCloser
+	// Writer compresses what is written to w. Closing it flushes the compressed data, then
+	// closes w.
+	Writer(io.WriteCloser) io.WriteCloser
+}
+
+type noopCompressionCodec struct{}
+
+func (c *noopCompressionCodec) Reader(r io.ReadCloser) io.ReadCloser {
+	return r
+}
+
+func (c *noopCompressionCodec) Writer(w io.WriteCloser) io.WriteCloser {
+	return w
+}
+
+type gzipCompressionCodec struct{}
+
+// gzipReader opens its gzip stream on the first read, so that creating it can't fail.
+type gzipReader struct {
+	r   io.ReadCloser
+	zr  *gzip.Reader
+	err error
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	if g.zr == nil && g.err == nil {
+		g.zr, g.err = gzip.NewReader(g.r)
+	}
+	if g.err != nil {
+		return 0, g.err
+	}
+	return g.zr.Read(p)
+}
+
+func (g *gzipReader) Close() error {
+	return g.r.Close()
+}
+
+func (c *gzipCompressionCodec) Reader(r io.ReadCloser) io.ReadCloser {
+	return &gzipReader{r: r}
+}
+
+type gzipWriter struct {
+	*gzip.Writer
+	w io.WriteCloser
+}
+
+// Close leaves w open if the compressed data can't be flushed, so that a paste isn't saved
+// with part of its body.
+func (g *gzipWriter) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		return err
+	}
+	return g.w.Close()
+}
+
+func (c *gzipCompressionCodec) Writer(w io.WriteCloser) io.WriteCloser {
+	return &gzipWriter{Writer: gzip.NewWriter(w), w: w}
+}
+
+var pasteCompressionCodecs = map[PasteCompressionMethod]PasteCompressionCodec{
+	PasteCompressionMethodGzip: &gzipCompressionCodec{},
+}
+
+func getPasteCompressionCodec(m PasteCompressionMethod) PasteCompressionCodec {
+	c, ok := pasteCompressionCodecs[m]
+	if !ok {
+		return &noopCompressionCodec{}
+	}
+	return c
+}
+
+// PasteBodyCompressor is implemented by brokers that can recompress the bodies they already
+// hold, such as those written before compression was turned on.
+type PasteBodyCompressor interface {
+	// CompressPasteBodies recompresses up to limit bodies of unencrypted pastes and their
+	// revisions that aren't compressed the way new bodies are, returning how many it
+	// recompressed. Encrypted bodies are left as they are.
+	CompressPasteBodies(limit int) (int, error)
+}
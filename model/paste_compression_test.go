@@ -0,0 +1,214 @@
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func readStoredBody(t *testing.T, b Broker, id PasteID) []byte {
+	r, err := b.(*dbBroker).Bodies.GetBody(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func TestPasteCompressionCodecs(t *testing.T) {
+	body := strings.Repeat("INFO request served in 3ms\n", 100)
+	for m := range pasteCompressionMethodNames {
+		buf := &bytes.Buffer{}
+		w := getPasteCompressionCodec(m).Writer(nopWriteCloser{buf})
+		w.Write([]byte(body))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if m != PasteCompressionMethodNone && buf.Len() >= len(body) {
+			t.Errorf("%v: compressed %d bytes to %d", m, len(body), buf.Len())
+		}
+		data, err := ioutil.ReadAll(getPasteCompressionCodec(m).Reader(ioutil.NopCloser(buf)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != body {
+			t.Errorf("%v: read back <%s>", m, data)
+		}
+
+		parsed, err := ParsePasteCompressionMethod(m.String())
+		if err != nil || parsed != m {
+			t.Errorf("%v: parsed its name as %v, %v", m, parsed, err)
+		}
+	}
+
+	r := getPasteCompressionCodec(PasteCompressionMethodGzip).Reader(ioutil.NopCloser(strings.NewReader("not gzip")))
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("read a corrupt body without an error")
+	}
+}
+
+func TestPasteCompression(t *testing.T) {
+	p, err := broker.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Erase()
+
+	writePasteBody(t, p, "compressed body")
+	if stored := readStoredBody(t, broker, p.(*dbPaste).bodyID()); !bytes.HasPrefix(stored, gzipMagic) {
+		t.Errorf("stored body <%x> isn't compressed", stored)
+	}
+	if body := readPasteBody(t, p); body != "compressed body" {
+		t.Errorf("read <%s> from a compressed body", body)
+	}
+
+	ep, err := broker.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Erase()
+	writePasteBody(t, ep, "compressed, then encrypted")
+
+	ep, err = broker.GetPaste(ep.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, ep); body != "compressed, then encrypted" {
+		t.Errorf("read <%s> from a compressed, encrypted body", body)
+	}
+	rev, err := ep.GetRevision(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRevisionBody(t, rev); body != "compressed, then encrypted" {
+		t.Errorf("read <%s> from a compressed, encrypted revision", body)
+	}
+}
+
+func TestCompressPasteBodies(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{}, DatabaseBrokerCompression(PasteCompressionMethodNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "first")
+	writePasteBody(t, p, "second")
+	if stored := readStoredBody(t, b, p.(*dbPaste).bodyID()); string(stored) != "second" {
+		t.Fatalf("stored <%s> without compression", stored)
+	}
+
+	// A paste whose bodies were written before they were shared keeps them under its ID.
+	lp, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []PasteID{lp.GetID(), revisionBodyID(lp.GetID(), 1)} {
+		if err := b.(*dbBroker).Bodies.PutBody(id, strings.NewReader("legacy")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sqlDb.Exec(`INSERT INTO db_paste_revisions (paste_id, revision) VALUES (?, 1)`, lp.GetID().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, ep, "secret")
+
+	compress := func(b Broker, expected int) {
+		total := 0
+		for {
+			n, err := b.(PasteBodyCompressor).CompressPasteBodies(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			total += n
+			if n < 2 {
+				break
+			}
+		}
+		if total != expected {
+			t.Errorf("recompressed %d bodies; expected %d", total, expected)
+		}
+	}
+
+	check := func(b Broker, compressed bool) {
+		for _, c := range []struct {
+			id        PasteID
+			revisions []string
+		}{
+			{p.GetID(), []string{"first", "second"}},
+			{lp.GetID(), []string{"legacy"}},
+		} {
+			p, err := b.GetPaste(c.id, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			current := c.revisions[len(c.revisions)-1]
+			if body := readPasteBody(t, p); body != current {
+				t.Errorf("read <%s> from paste %s", body, c.id)
+			}
+			bodyIDs := []PasteID{p.(*dbPaste).bodyID()}
+			for i, expected := range c.revisions {
+				rev, err := p.GetRevision(i + 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body := readRevisionBody(t, rev); body != expected {
+					t.Errorf("read <%s> from revision %d of paste %s", body, i+1, c.id)
+				}
+				bodyIDs = append(bodyIDs, rev.(*dbPasteRevision).bodyID())
+			}
+			for _, id := range bodyIDs {
+				if stored := readStoredBody(t, b, id); bytes.HasPrefix(stored, gzipMagic) != compressed {
+					t.Errorf("stored body %s is <%x>", id, stored)
+				}
+			}
+		}
+
+		ep, err := b.GetPaste(ep.GetID(), []byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body := readPasteBody(t, ep); body != "secret" {
+			t.Errorf("read <%s> from the encrypted paste", body)
+		}
+	}
+
+	b, err = NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compress(b, 4) // both shared bodies, and the legacy paste and its revision
+	check(b, true)
+
+	// Saving a paste read before its body was recompressed mustn't undo that.
+	lp.SetTitle("stale")
+	if err := lp.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	check(b, true)
+
+	// Turning compression off decompresses everything again.
+	b, err = NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{}, DatabaseBrokerCompression(PasteCompressionMethodNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compress(b, 4)
+	check(b, false)
+}
@@ -0,0 +1,348 @@
+package model
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/DHowett/ghostbin/lib/crypto"
+	"github.com/DHowett/ghostbin/lib/spool"
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
+)
+
+func (broker *dbBroker) ReencryptPaste(id PasteID, passphraseMaterial, newPassphraseMaterial []byte, method PasteEncryptionMethod) error {
+	var paste dbPaste
+	if err := broker.Find(&paste, "id = ? AND trashed_at IS NULL", id.String()).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return PasteNotFoundError
+		}
+		return err
+	}
+	paste.broker = broker
+	if !paste.IsEncrypted() {
+		return PasteNotEncryptedError
+	}
+	if paste.IsClientEncrypted() {
+		return PasteClientEncryptedError
+	}
+	key, err := unlockPaste(id, paste.EncryptionMethod, paste.KDF, paste.EncryptionSalt, paste.HMAC, passphraseMaterial)
+	if err != nil {
+		return err
+	}
+	paste.encryptionKey = key
+	to, err := newPasteKey(id, method, broker.PasteKDF, newPassphraseMaterial)
+	if err != nil {
+		return err
+	}
+	return broker.reencryptPaste(&paste, to, PasteEncryptionMethodNone)
+}
+
+// reencryptPaste encrypts the bodies of paste, which must be unlocked, and of its revisions
+// with to, updating paste to match and leaving it marked to be re-encrypted with
+// reencryptMethod. The new bodies are each written under a new name before the paste is
+// switched over to them, so that the paste is readable with one key or the other however far
+// this gets. Only the switch is made with the paste's bodies locked; should the paste have been
+// written or re-encrypted since it was read, it fails with PasteKeyChangedError.
+func (broker *dbBroker) reencryptPaste(paste *dbPaste, to *pasteKey, reencryptMethod PasteEncryptionMethod) error {
+	from := paste.pasteKey()
+	bodyKey, err := generateRandomBase32String(10, -1)
+	if err != nil {
+		return err
+	}
+
+	var revs []*dbPasteRevision
+	if err := broker.Select(dbPasteRevisionMetadataColumns).Where("paste_id = ?", paste.ID).Order("revision").Find(&revs).Error; err != nil {
+		return err
+	}
+
+	var written, replaced []PasteID
+	reencrypt := func(oldID, oldEncryptedID, newID, newEncryptedID PasteID, data []byte) error {
+		var r io.ReadCloser
+		if data != nil {
+			r = ioutil.NopCloser(bytes.NewReader(data))
+		} else {
+			var err error
+			if r, err = broker.Bodies.GetBody(oldID); err == PasteBodyNotFoundError {
+				// It was never written.
+				return nil
+			} else if err != nil {
+				return err
+			}
+			replaced = append(replaced, oldID)
+		}
+
+		s := spool.New(pasteBodySpoolMemory)
+		defer s.Close()
+		if err := reencryptBody(nopWriteCloser{s}, r, oldEncryptedID, from, newEncryptedID, to); err != nil {
+			return err
+		}
+		if err := broker.Bodies.PutBody(newID, s.Reader()); err != nil {
+			return err
+		}
+		written = append(written, newID)
+		return nil
+	}
+
+	// A paste's body that is stored as one of its revisions' is re-encrypted along with the
+	// revision, and stays the revision's.
+	var current *dbPasteRevision
+	for _, rev := range revs {
+		if paste.BodyRevision.Valid && int64(rev.Revision) == paste.BodyRevision.Int64 {
+			current = rev
+		}
+	}
+	bodyRevision := sql.NullInt64{}
+	if current != nil {
+		bodyRevision = paste.BodyRevision
+	} else {
+		newBodyID := rekeyedBodyID(paste.GetID(), bodyKey)
+		err = reencrypt(paste.bodyID(), paste.encryptedBodyID(), newBodyID, newBodyID, nil)
+	}
+	// Every other revision is given a name of its own to be encrypted as.
+	revisionBodyKeys := make(map[uint]string, len(revs))
+	for _, rev := range revs {
+		if err != nil {
+			break
+		}
+		rev.paste = paste
+		newRev := &dbPasteRevision{PasteID: rev.PasteID, Revision: rev.Revision, BodyKey: bodyKey}
+		if rev != current {
+			if newRev.BodyKey, err = generateRandomBase32String(10, -1); err != nil {
+				break
+			}
+		}
+		revisionBodyKeys[rev.ID] = newRev.BodyKey
+		var data dbPasteRevision
+		if err = broker.Select("data").First(&data, "id = ?", rev.ID).Error; err == nil {
+			err = reencrypt(rev.bodyID(), rev.encryptedBodyID(), newRev.bodyID(), newRev.encryptedBodyID(), data.Data)
+		}
+	}
+	var metadata []byte
+	if err == nil {
+		unlock := broker.bodyLocks.lock(paste.GetID())
+		metadata, err = broker.switchPasteKey(paste.GetID(), from, to, paste.BodyKey, bodyKey, bodyRevision, revisionBodyKeys, reencryptMethod)
+		unlock()
+	}
+	if err != nil {
+		for _, id := range written {
+			if derr := broker.Bodies.DeleteBody(id); derr != nil {
+				glog.Errorf("paste %s: failed to delete body %s after failing to re-encrypt: %v", paste.ID, id, derr)
+			}
+		}
+		return err
+	}
+
+	for _, id := range replaced {
+		if err := broker.Bodies.DeleteBody(id); err != nil {
+			glog.Errorf("paste %s: failed to delete body %s after re-encrypting it: %v", paste.ID, id, err)
+		}
+	}
+
+	paste.EncryptionMethod, paste.KDF, paste.EncryptionSalt, paste.HMAC, paste.encryptionKey = to.method, to.kdf, to.salt, to.hmac, to.key
+	paste.BodyKey, paste.BodyRevision, paste.Metadata = bodyKey, bodyRevision, metadata
+	paste.ReencryptMethod = reencryptMethod
+	broker.publish(PasteUpdatedEvent, paste)
+	return nil
+}
+
+// switchPasteKey points the paste id at the body written with to under bodyKey, stored as
+// that of bodyRevision if it is set, and its revisions at theirs, under the keys revisionBodyKeys holds by their row IDs, and seals its
+// metadata and that of its revisions, which were sealed with from or not at all, under to, all
+// at once. Revision bodies that were kept in the database row are now kept in the body store,
+// and the paste is left marked to be re-encrypted with reencryptMethod. It fails with
+// PasteKeyChangedError unless the paste is still encrypted with from and its current body is
+// still the one under fromBodyKey. It returns the paste's newly sealed metadata.
+func (broker *dbBroker) switchPasteKey(id PasteID, from, to *pasteKey, fromBodyKey, bodyKey string, bodyRevision sql.NullInt64, revisionBodyKeys map[uint]string, reencryptMethod PasteEncryptionMethod) ([]byte, error) {
+	tx := broker.Begin()
+	// The paste is switched first so that its metadata can't be saved under from while it is
+	// being sealed again; see dbPaste.save.
+	db := tx.Model(&dbPaste{}).Where("id = ? AND encryption_method = ? AND encryption_salt = ? AND body_key = ?", id.String(), from.method, from.salt, fromBodyKey).UpdateColumns(map[string]interface{}{
+		"encryption_method": to.method,
+		"kdf":               to.kdf,
+		"encryption_salt":   to.salt,
+		"hmac":              to.hmac,
+		"body_key":          bodyKey,
+		"body_revision":     bodyRevision,
+		"reencrypt_method":  reencryptMethod,
+	})
+	if db.Error != nil {
+		tx.Rollback()
+		return nil, db.Error
+	}
+	if db.RowsAffected == 0 {
+		// It was written, or re-encrypted, since its bodies were.
+		tx.Rollback()
+		return nil, PasteKeyChangedError
+	}
+	for revID, revBodyKey := range revisionBodyKeys {
+		if err := tx.Model(&dbPasteRevision{}).Where("id = ?", revID).UpdateColumns(map[string]interface{}{
+			"data":     nil,
+			"body_key": revBodyKey,
+		}).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	metadata, err := resealPasteMetadata(tx, id, from, to)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return metadata, tx.Commit().Error
+}
+
+// resealPasteMetadata seals the metadata of the paste id and of its revisions, as it is in tx,
+// under to instead of from, returning the paste's.
+func resealPasteMetadata(tx *gorm.DB, id PasteID, from, to *pasteKey) ([]byte, error) {
+	sealed := &dbPaste{encryptionKey: from.key}
+	if err := tx.Select("id, title, language_name, bundle, metadata").Where("id = ?", id.String()).First(sealed).Error; err != nil {
+		return nil, err
+	}
+	if err := sealed.unsealMetadata(); err != nil {
+		return nil, err
+	}
+	sealed.encryptionKey = to.key
+	metadata, err := sealed.sealMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&dbPaste{}).Where("id = ?", id.String()).UpdateColumns(map[string]interface{}{
+		"title":         nil,
+		"language_name": nil,
+		"bundle":        false,
+		"metadata":      metadata,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	var revs []*dbPasteRevision
+	if err := tx.Select("id, paste_id, revision, title, language_name, bundle, metadata").Where("paste_id = ?", id.String()).Find(&revs).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range revs {
+		r.paste = &dbPaste{encryptionKey: from.key}
+		if err := r.unsealMetadata(); err != nil {
+			return nil, err
+		}
+		revMetadata, err := sealPasteMetadata(revisionBodyID(id, r.Revision), to.key, &pasteMetadata{Title: r.GetTitle(), Language: r.GetLanguageName(), Bundle: r.Bundle})
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&dbPasteRevision{}).Where("id = ?", r.ID).UpdateColumns(map[string]interface{}{
+			"title":         nil,
+			"language_name": nil,
+			"bundle":        false,
+			"metadata":      revMetadata,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return metadata, nil
+}
+
+// sealLegacyPasteMetadata seals the metadata of paste, which must be unlocked, and of its
+// revisions, should any of it still be in the clear from before metadata was sealed. It is left
+// alone should the paste have been re-encrypted since it was read.
+func (broker *dbBroker) sealLegacyPasteMetadata(paste *dbPaste) error {
+	var n int
+	if err := broker.Model(&dbPasteRevision{}).Where("paste_id = ? AND metadata IS NULL", paste.ID).Count(&n).Error; err != nil {
+		return err
+	}
+	if paste.Metadata != nil && n == 0 {
+		return nil
+	}
+
+	metadata, err := paste.sealMetadata()
+	if err != nil {
+		return err
+	}
+	tx := broker.Begin()
+	// The paste is sealed first so that it can't be re-encrypted while its revisions are; see
+	// switchPasteKey.
+	db := tx.Model(&dbPaste{}).Where("id = ? AND encryption_method = ? AND encryption_salt = ?", paste.ID, paste.EncryptionMethod, paste.EncryptionSalt).UpdateColumns(map[string]interface{}{
+		"title":         nil,
+		"language_name": nil,
+		"bundle":        false,
+		"metadata":      metadata,
+	})
+	if db.Error != nil || db.RowsAffected == 0 {
+		tx.Rollback()
+		return db.Error
+	}
+
+	var revs []*dbPasteRevision
+	if err := tx.Select("id, paste_id, revision, title, language_name, bundle").Where("paste_id = ? AND metadata IS NULL", paste.ID).Find(&revs).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, r := range revs {
+		revMetadata, err := sealPasteMetadata(revisionBodyID(paste.GetID(), r.Revision), paste.encryptionKey, &pasteMetadata{Title: r.GetTitle(), Language: r.GetLanguageName(), Bundle: r.Bundle})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Model(&dbPasteRevision{}).Where("id = ?", r.ID).UpdateColumns(map[string]interface{}{
+			"title":         nil,
+			"language_name": nil,
+			"bundle":        false,
+			"metadata":      revMetadata,
+		}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	paste.Metadata = metadata
+	return nil
+}
+
+// upgradePasteEncryption re-encrypts paste, which must be unlocked with passphraseMaterial,
+// with the method it was marked for by UpgradePasteEncryption. Should it have been written or
+// re-encrypted since it was read, it is only reloaded, and left to be upgraded another time.
+func (broker *dbBroker) upgradePasteEncryption(paste *dbPaste, passphraseMaterial []byte) error {
+	to, err := newPasteKey(paste.GetID(), paste.ReencryptMethod, broker.PasteKDF, passphraseMaterial)
+	if err != nil {
+		return err
+	}
+	err = broker.reencryptPaste(paste, to, PasteEncryptionMethodNone)
+	if err != PasteKeyChangedError {
+		return err
+	}
+
+	var current dbPaste
+	if err := broker.Find(&current, "id = ?", paste.ID).Error; err != nil {
+		return err
+	}
+	current.broker = broker
+	current.encryptionKey = paste.encryptionKey
+	if current.EncryptionMethod != paste.EncryptionMethod || !bytes.Equal(current.EncryptionSalt, paste.EncryptionSalt) {
+		key, err := unlockPaste(current.GetID(), current.EncryptionMethod, current.KDF, current.EncryptionSalt, current.HMAC, passphraseMaterial)
+		if err != nil {
+			return err
+		}
+		current.encryptionKey = key
+	}
+	*paste = current
+	return nil
+}
+
+// UpgradePasteEncryption implements PasteEncryptionUpgrader.
+func (broker *dbBroker) UpgradePasteEncryption(method PasteEncryptionMethod) (int, error) {
+	if _, ok := pasteEncryptionCodecs[method]; !ok {
+		return 0, errors.New("model: unsupported encryption method")
+	}
+	kdfs := []string{broker.PasteKDF.String()}
+	if kdfs[0] == crypto.LegacyKDF.String() {
+		// Keys derived before KDFs were recorded were derived with it.
+		kdfs = append(kdfs, "")
+	}
+	// Pastes encrypted by their clients are the clients' to re-encrypt. Those whose metadata,
+	// or that of any of their revisions, isn't sealed were written before it was.
+	db := broker.Model(&dbPaste{}).Where("encryption_method NOT IN (?) AND reencrypt_method <> ? AND (encryption_method <> ? OR kdf NOT IN (?) OR metadata IS NULL OR id IN (SELECT paste_id FROM db_paste_revisions WHERE metadata IS NULL))", []PasteEncryptionMethod{PasteEncryptionMethodNone, PasteEncryptionMethodClient}, method, method, kdfs).UpdateColumn("reencrypt_method", method)
+	return int(db.RowsAffected), db.Error
+}
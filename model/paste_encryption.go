@@ -4,10 +4,14 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"io"
 
-	"golang.org/x/crypto/scrypt"
+	"github.com/DHowett/ghostbin/lib/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 type PasteEncryptionMethod uint
@@ -16,14 +20,21 @@ const (
 	PasteEncryptionMethodNone    PasteEncryptionMethod = iota
 	PasteEncryptionMethodAES_OFB                       // deprecated
 	PasteEncryptionMethodAES_CTR
+	// PasteEncryptionMethodXChaCha20_Poly1305 authenticates the whole body; see aeadEncryptionCodec.
+	PasteEncryptionMethodXChaCha20_Poly1305
+	// PasteEncryptionMethodClient marks pastes encrypted by their clients, with keys the
+	// server never sees; see Broker.CreateClientEncryptedPaste. It has no codec.
+	PasteEncryptionMethodClient
 )
 
 type PasteEncryptionCodec interface {
 	Authenticate(PasteID, []byte, []byte, []byte) bool
 	GenerateHMAC(PasteID, []byte, []byte) []byte
-	Reader([]byte, io.ReadCloser) io.ReadCloser
-	Writer([]byte, io.WriteCloser) io.WriteCloser
-	DeriveKey([]byte, []byte) ([]byte, error)
+	// Reader and Writer are given the name of the body they read or write, which codecs that
+	// authenticate bodies bind them to.
+	Reader(PasteID, []byte, io.ReadCloser) io.ReadCloser
+	Writer(PasteID, []byte, io.WriteCloser) io.WriteCloser
+	DeriveKey(crypto.KDF, []byte, []byte) ([]byte, error)
 }
 
 type noopEncryptionCodec struct{}
@@ -36,20 +47,20 @@ func (eh *noopEncryptionCodec) GenerateHMAC(id PasteID, salt []byte, key []byte)
 	return nil
 }
 
-func (eh *noopEncryptionCodec) Reader(key []byte, r io.ReadCloser) io.ReadCloser {
+func (eh *noopEncryptionCodec) Reader(bodyID PasteID, key []byte, r io.ReadCloser) io.ReadCloser {
 	return r
 }
 
-func (eh *noopEncryptionCodec) Writer(key []byte, w io.WriteCloser) io.WriteCloser {
+func (eh *noopEncryptionCodec) Writer(bodyID PasteID, key []byte, w io.WriteCloser) io.WriteCloser {
 	return w
 }
 
-func (eh *noopEncryptionCodec) DeriveKey(material []byte, salt []byte) ([]byte, error) {
+func (eh *noopEncryptionCodec) DeriveKey(kdf crypto.KDF, material []byte, salt []byte) ([]byte, error) {
 	return nil, nil
 }
 
-func _scryptDeriveKey(material []byte, salt []byte) ([]byte, error) {
-	return scrypt.Key(material, salt, 16384, 8, 1, 32)
+func _deriveKey(kdf crypto.KDF, material []byte, salt []byte) ([]byte, error) {
+	return kdf.DeriveKey(material, salt, 32)
 }
 
 type ghostbinLegacyEncryptionCodec struct{}
@@ -63,7 +74,7 @@ func (eh *ghostbinLegacyEncryptionCodec) GenerateHMAC(id PasteID, salt []byte, k
 	return mac.Sum(nil)
 }
 
-func (eh *ghostbinLegacyEncryptionCodec) Reader(key []byte, r io.ReadCloser) io.ReadCloser {
+func (eh *ghostbinLegacyEncryptionCodec) Reader(bodyID PasteID, key []byte, r io.ReadCloser) io.ReadCloser {
 	blockCipher, _ := aes.NewCipher(key)
 	var iv [aes.BlockSize]byte
 	stream := cipher.NewOFB(blockCipher, iv[:])
@@ -71,7 +82,7 @@ func (eh *ghostbinLegacyEncryptionCodec) Reader(key []byte, r io.ReadCloser) io.
 	return &readCloser{Reader: streamReader, Closer: r}
 }
 
-func (eh *ghostbinLegacyEncryptionCodec) Writer(key []byte, w io.WriteCloser) io.WriteCloser {
+func (eh *ghostbinLegacyEncryptionCodec) Writer(bodyID PasteID, key []byte, w io.WriteCloser) io.WriteCloser {
 	blockCipher, _ := aes.NewCipher(key)
 	var iv [aes.BlockSize]byte
 	stream := cipher.NewOFB(blockCipher, iv[:])
@@ -79,8 +90,8 @@ func (eh *ghostbinLegacyEncryptionCodec) Writer(key []byte, w io.WriteCloser) io
 	return &writeCloser{Writer: streamWriter, Closer: w}
 }
 
-func (eh *ghostbinLegacyEncryptionCodec) DeriveKey(material []byte, salt []byte) ([]byte, error) {
-	return _scryptDeriveKey(material, salt)
+func (eh *ghostbinLegacyEncryptionCodec) DeriveKey(kdf crypto.KDF, material []byte, salt []byte) ([]byte, error) {
+	return _deriveKey(kdf, material, salt)
 }
 
 type aesCtrEncryptionCodec struct{}
@@ -95,7 +106,7 @@ func (eh *aesCtrEncryptionCodec) GenerateHMAC(id PasteID, salt []byte, key []byt
 	return mac.Sum(nil)
 }
 
-func (eh *aesCtrEncryptionCodec) Reader(key []byte, r io.ReadCloser) io.ReadCloser {
+func (eh *aesCtrEncryptionCodec) Reader(bodyID PasteID, key []byte, r io.ReadCloser) io.ReadCloser {
 	blockCipher, _ := aes.NewCipher(key)
 	var iv [aes.BlockSize]byte
 	stream := cipher.NewCTR(blockCipher, iv[:])
@@ -103,7 +114,7 @@ func (eh *aesCtrEncryptionCodec) Reader(key []byte, r io.ReadCloser) io.ReadClos
 	return &readCloser{Reader: streamReader, Closer: r}
 }
 
-func (eh *aesCtrEncryptionCodec) Writer(key []byte, w io.WriteCloser) io.WriteCloser {
+func (eh *aesCtrEncryptionCodec) Writer(bodyID PasteID, key []byte, w io.WriteCloser) io.WriteCloser {
 	blockCipher, _ := aes.NewCipher(key)
 	var iv [aes.BlockSize]byte
 	stream := cipher.NewCTR(blockCipher, iv[:])
@@ -111,13 +122,213 @@ func (eh *aesCtrEncryptionCodec) Writer(key []byte, w io.WriteCloser) io.WriteCl
 	return &writeCloser{Writer: streamWriter, Closer: w}
 }
 
-func (eh *aesCtrEncryptionCodec) DeriveKey(material []byte, salt []byte) ([]byte, error) {
-	return _scryptDeriveKey(material, salt)
+func (eh *aesCtrEncryptionCodec) DeriveKey(kdf crypto.KDF, material []byte, salt []byte) ([]byte, error) {
+	return _deriveKey(kdf, material, salt)
+}
+
+// aeadEncryptionCodec seals a body in chunks with XChaCha20-Poly1305, so that it can be
+// streamed and still be authenticated from end to end. A sealed body is a version byte and a
+// random nonce prefix, chosen afresh on every write, followed by the chunks. Each chunk's
+// nonce is the prefix, the chunk's number, and a byte marking the last chunk, so that chunks
+// can't be reordered, dropped or cut off without the body failing to open. Every chunk but
+// the last holds aeadChunkSize bytes; the last holds fewer, and may be empty. Every chunk is
+// also bound to the name of the body it was written as, so that one body can't be passed off
+// as another; bodies written before that, marked with aeadUnboundVersion, are still read.
+type aeadEncryptionCodec struct{}
+
+const (
+	aeadUnboundVersion = 1
+	aeadVersion        = 2
+	aeadPrefixSize     = chacha20poly1305.NonceSizeX - 5
+	aeadChunkSize      = 64 * 1024
+)
+
+func aeadChunkNonce(prefix []byte, n uint32, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[aeadPrefixSize:], n)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func (eh *aeadEncryptionCodec) Authenticate(id PasteID, salt []byte, key []byte, messageMAC []byte) bool {
+	return hmac.Equal(messageMAC, eh.GenerateHMAC(id, salt, key))
+}
+
+// GenerateHMAC only proves knowledge of the key; the body is authenticated as it is read.
+func (eh *aeadEncryptionCodec) GenerateHMAC(id PasteID, salt []byte, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id.String()))
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+func (eh *aeadEncryptionCodec) Reader(bodyID PasteID, key []byte, r io.ReadCloser) io.ReadCloser {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return &readCloser{Reader: &errorReader{err}, Closer: r}
+	}
+	return &readCloser{Reader: &aeadReader{aead: aead, r: r, ad: []byte(bodyID.String()), sealed: make([]byte, aeadChunkSize+aead.Overhead())}, Closer: r}
+}
+
+func (eh *aeadEncryptionCodec) Writer(bodyID PasteID, key []byte, w io.WriteCloser) io.WriteCloser {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return &aeadWriter{err: err, w: w}
+	}
+	prefix := make([]byte, aeadPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return &aeadWriter{err: err, w: w}
+	}
+	return &aeadWriter{aead: aead, w: w, prefix: prefix, ad: []byte(bodyID.String()), buf: make([]byte, 0, aeadChunkSize)}
+}
+
+func (eh *aeadEncryptionCodec) DeriveKey(kdf crypto.KDF, material []byte, salt []byte) ([]byte, error) {
+	return _deriveKey(kdf, material, salt)
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+type aeadWriter struct {
+	aead   cipher.AEAD
+	w      io.WriteCloser
+	prefix []byte
+	ad     []byte
+	n      uint32
+	buf    []byte
+	header bool
+	err    error
+}
+
+func (w *aeadWriter) seal(last bool) error {
+	if !w.header {
+		if _, err := w.w.Write(append([]byte{aeadVersion}, w.prefix...)); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	if w.n == ^uint32(0) {
+		return errors.New("model: paste body is too long to encrypt")
+	}
+	_, err := w.w.Write(w.aead.Seal(nil, aeadChunkNonce(w.prefix, w.n, last), w.buf, w.ad))
+	w.n++
+	w.buf = w.buf[:0]
+	return err
+}
+
+func (w *aeadWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more follows it, as the last chunk must be short.
+		if len(w.buf) == aeadChunkSize {
+			if w.err = w.seal(false); w.err != nil {
+				return written, w.err
+			}
+		}
+		n := copy(w.buf[len(w.buf):aeadChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *aeadWriter) Close() error {
+	if w.err == nil && len(w.buf) == aeadChunkSize {
+		w.err = w.seal(false)
+	}
+	if w.err == nil {
+		w.err = w.seal(true)
+	}
+	if w.err != nil {
+		// The body is abandoned rather than closed, so that no part of it is kept.
+		return w.err
+	}
+	w.err = w.w.Close()
+	return w.err
+}
+
+type aeadReader struct {
+	aead   cipher.AEAD
+	r      io.Reader
+	prefix []byte
+	ad     []byte
+	n      uint32
+	sealed []byte
+	buf    []byte
+	done   bool
+	err    error
+}
+
+// next opens the next chunk. Anything that doesn't open is reported as tampering.
+func (r *aeadReader) next() error {
+	if r.prefix == nil {
+		header := make([]byte, 1+aeadPrefixSize)
+		if _, err := io.ReadFull(r.r, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return PasteBodyTamperedError
+			}
+			return err
+		}
+		switch header[0] {
+		case aeadVersion:
+		case aeadUnboundVersion:
+			r.ad = nil
+		default:
+			return PasteBodyTamperedError
+		}
+		r.prefix = header[1:]
+	}
+
+	n, err := io.ReadFull(r.r, r.sealed)
+	last := false
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+	opened, err := r.aead.Open(r.sealed[:0], aeadChunkNonce(r.prefix, r.n, last), r.sealed[:n], r.ad)
+	if err != nil {
+		return PasteBodyTamperedError
+	}
+	r.n++
+	r.buf = opened
+	r.done = last
+	return nil
+}
+
+func (r *aeadReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
 }
 
 var pasteEncryptionCodecs = map[PasteEncryptionMethod]PasteEncryptionCodec{
-	PasteEncryptionMethodAES_OFB: &ghostbinLegacyEncryptionCodec{},
-	PasteEncryptionMethodAES_CTR: &aesCtrEncryptionCodec{},
+	PasteEncryptionMethodAES_OFB:            &ghostbinLegacyEncryptionCodec{},
+	PasteEncryptionMethodAES_CTR:            &aesCtrEncryptionCodec{},
+	PasteEncryptionMethodXChaCha20_Poly1305: &aeadEncryptionCodec{},
 }
 
 func getPasteEncryptionCodec(e PasteEncryptionMethod) PasteEncryptionCodec {
@@ -127,3 +338,89 @@ func getPasteEncryptionCodec(e PasteEncryptionMethod) PasteEncryptionCodec {
 	}
 	return eh
 }
+
+// unlockPaste derives the key to an encrypted paste from passphraseMaterial with the KDF named
+// by kdfName, returning PasteInvalidKeyError if it isn't the paste's.
+func unlockPaste(id PasteID, method PasteEncryptionMethod, kdfName string, salt, messageMAC, passphraseMaterial []byte) ([]byte, error) {
+	kdf, err := crypto.ParseKDF(kdfName)
+	if err != nil {
+		return nil, err
+	}
+	codec := getPasteEncryptionCodec(method)
+	key, err := codec.DeriveKey(kdf, passphraseMaterial, salt)
+	if err != nil {
+		return nil, PasteEncryptedError
+	}
+	if !codec.Authenticate(id, salt, key, messageMAC) {
+		return nil, PasteInvalidKeyError
+	}
+	return key, nil
+}
+
+// pasteKey is the key material an encrypted paste is stored with.
+type pasteKey struct {
+	method PasteEncryptionMethod
+	kdf    string
+	salt   []byte
+	key    []byte
+	hmac   []byte
+}
+
+// reusesKeystream reports whether method encrypts every body of a paste with the same
+// keystream, as the legacy methods do. No more than one body may be encrypted with such a
+// method under a key: two of them XORed together give away both.
+func (m PasteEncryptionMethod) reusesKeystream() bool {
+	return m == PasteEncryptionMethodAES_OFB || m == PasteEncryptionMethodAES_CTR
+}
+
+// aeadPasteKey returns the key material of the paste id, encrypted with k, once it is
+// encrypted with XChaCha20-Poly1305 instead. Every method derives keys alike, so the key and
+// salt are kept, and the passphrase isn't needed.
+func aeadPasteKey(id PasteID, k *pasteKey) *pasteKey {
+	to := &pasteKey{method: PasteEncryptionMethodXChaCha20_Poly1305, kdf: k.kdf, salt: k.salt, key: k.key}
+	to.hmac = getPasteEncryptionCodec(to.method).GenerateHMAC(id, to.salt, to.key)
+	return to
+}
+
+// newPasteKey derives a key for the paste id from passphraseMaterial with kdf, under a new salt.
+func newPasteKey(id PasteID, method PasteEncryptionMethod, kdf crypto.KDF, passphraseMaterial []byte) (*pasteKey, error) {
+	if passphraseMaterial == nil {
+		return nil, errors.New("model: unacceptable encryption material")
+	}
+	codec, ok := pasteEncryptionCodecs[method]
+	if !ok {
+		return nil, errors.New("model: unsupported encryption method")
+	}
+	salt, err := generateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	key, err := codec.DeriveKey(kdf, passphraseMaterial, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &pasteKey{method: method, kdf: kdf.String(), salt: salt, key: key, hmac: codec.GenerateHMAC(id, salt, key)}, nil
+}
+
+// reencryptBody decrypts the body r holds, named fromID, with from and writes it to w encrypted
+// with to as the body toID. It closes r, and closes w only if the body was read whole.
+func reencryptBody(w io.WriteCloser, r io.ReadCloser, fromID PasteID, from *pasteKey, toID PasteID, to *pasteKey) error {
+	plaintext := getPasteEncryptionCodec(from.method).Reader(fromID, from.key, r)
+	defer plaintext.Close()
+	ew := getPasteEncryptionCodec(to.method).Writer(toID, to.key, w)
+	if _, err := io.Copy(ew, plaintext); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+// PasteEncryptionUpgrader is implemented by brokers that can move encrypted pastes to a newer
+// encryption method. As a paste's key can't be had without its passphrase, pastes are only
+// marked, and are re-encrypted the next time they are unlocked.
+type PasteEncryptionUpgrader interface {
+	// UpgradePasteEncryption marks every paste encrypted by the server, even those in the
+	// trash, that isn't encrypted with method, whose key wasn't derived with the broker's KDF,
+	// or whose title and language are kept in the clear, to be re-encrypted with both,
+	// returning how many were marked.
+	UpgradePasteEncryption(method PasteEncryptionMethod) (int, error)
+}
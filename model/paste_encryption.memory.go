@@ -0,0 +1,85 @@
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+)
+
+func (broker *memoryBroker) ReencryptPaste(id PasteID, passphraseMaterial, newPassphraseMaterial []byte, method PasteEncryptionMethod) error {
+	broker.mu.Lock()
+	record, ok := broker.pastes[id]
+	if !ok || record.inTrash() {
+		broker.mu.Unlock()
+		return PasteNotFoundError
+	}
+	if !record.IsEncrypted() {
+		broker.mu.Unlock()
+		return PasteNotEncryptedError
+	}
+	if record.IsClientEncrypted() {
+		broker.mu.Unlock()
+		return PasteClientEncryptedError
+	}
+	key, err := unlockPaste(id, record.EncryptionMethod, record.KDF, record.EncryptionSalt, record.HMAC, passphraseMaterial)
+	if err != nil {
+		broker.mu.Unlock()
+		return err
+	}
+	from := &pasteKey{method: record.EncryptionMethod, kdf: record.KDF, salt: record.EncryptionSalt, key: key, hmac: record.HMAC}
+	to, err := newPasteKey(id, method, broker.PasteKDF, newPassphraseMaterial)
+	if err == nil {
+		err = reencryptPasteRecord(record, from, to)
+	}
+	if err != nil {
+		broker.mu.Unlock()
+		return err
+	}
+	p := &memoryPaste{memoryPasteMetadata: record.memoryPasteMetadata, encryptionKey: to.key, broker: broker}
+	broker.mu.Unlock()
+
+	broker.publish(PasteUpdatedEvent, p)
+	return nil
+}
+
+// reencryptPasteRecord encrypts the bodies of record and of its revisions, encrypted with from,
+// with to, and records to as its key. Each body is encrypted under a new name, as those of the
+// database broker are, and the paste's body stays shared with the revision it was written as.
+// The caller must hold the broker's lock.
+func reencryptPasteRecord(record *memoryPasteRecord, from, to *pasteKey) error {
+	// Everything is re-encrypted before any of it is replaced.
+	reencrypt := func(body []byte, bodyKey string) ([]byte, string, error) {
+		if body == nil {
+			return nil, bodyKey, nil
+		}
+		newBodyKey, err := generateRandomBase32String(10, -1)
+		if err != nil {
+			return nil, "", err
+		}
+		buf := &bytes.Buffer{}
+		err = reencryptBody(nopWriteCloser{buf}, ioutil.NopCloser(bytes.NewReader(body)), rekeyedBodyID(record.ID, bodyKey), from, rekeyedBodyID(record.ID, newBodyKey), to)
+		return buf.Bytes(), newBodyKey, err
+	}
+	body, bodyKey, err := reencrypt(record.body, record.bodyKey)
+	revisionBodies := make([][]byte, len(record.revisions))
+	revisionBodyKeys := make([]string, len(record.revisions))
+	for i, rev := range record.revisions {
+		if err != nil {
+			break
+		}
+		if record.body != nil && rev.bodyKey == record.bodyKey {
+			revisionBodies[i], revisionBodyKeys[i] = body, bodyKey
+			continue
+		}
+		revisionBodies[i], revisionBodyKeys[i], err = reencrypt(rev.body, rev.bodyKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	record.EncryptionMethod, record.KDF, record.EncryptionSalt, record.HMAC = to.method, to.kdf, to.salt, to.hmac
+	record.body, record.bodyKey = body, bodyKey
+	for i, rev := range record.revisions {
+		rev.body, rev.bodyKey = revisionBodies[i], revisionBodyKeys[i]
+	}
+	return nil
+}
@@ -0,0 +1,563 @@
+package model
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"testing"
+
+	"github.com/DHowett/ghostbin/lib/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func sealTestBody(t *testing.T, key, body []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := getPasteEncryptionCodec(PasteEncryptionMethodXChaCha20_Poly1305).Writer("abcde", key, nopWriteCloser{buf})
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openTestBody(key, sealed []byte) ([]byte, error) {
+	return openTestBodyAs("abcde", key, sealed)
+}
+
+func openTestBodyAs(bodyID PasteID, key, sealed []byte) ([]byte, error) {
+	r := getPasteEncryptionCodec(PasteEncryptionMethodXChaCha20_Poly1305).Reader(bodyID, key, ioutil.NopCloser(bytes.NewReader(sealed)))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func TestAEADEncryptionCodec(t *testing.T) {
+	key := bytes.Repeat([]byte{'k'}, 32)
+	for _, n := range []int{0, 1, aeadChunkSize - 1, aeadChunkSize, aeadChunkSize + 1, 3 * aeadChunkSize} {
+		body := make([]byte, n)
+		for i := range body {
+			body[i] = byte(i * 7)
+		}
+		sealed := sealTestBody(t, key, body)
+		opened, err := openTestBody(key, sealed)
+		if err != nil || !bytes.Equal(opened, body) {
+			t.Errorf("%d bytes: opened %d bytes (%v)", n, len(opened), err)
+		}
+	}
+
+	body := []byte("the same body, twice")
+	first, second := sealTestBody(t, key, body), sealTestBody(t, key, body)
+	if bytes.Equal(first, second) {
+		t.Error("two writes of the same body were sealed with the same nonce")
+	}
+	if bytes.Contains(first, body) {
+		t.Error("sealed body holds its plaintext")
+	}
+}
+
+func TestAEADEncryptionCodecTampering(t *testing.T) {
+	key := bytes.Repeat([]byte{'k'}, 32)
+	body := bytes.Repeat([]byte("tamper-evident "), aeadChunkSize/5)
+	sealed := sealTestBody(t, key, body)
+	chunk := aeadChunkSize + 16
+	header := 1 + aeadPrefixSize
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[len(flipped)/2] ^= 1
+
+	swapped := append([]byte(nil), sealed[:header]...)
+	swapped = append(swapped, sealed[header+chunk:header+2*chunk]...)
+	swapped = append(swapped, sealed[header:header+chunk]...)
+	swapped = append(swapped, sealed[header+2*chunk:]...)
+
+	for name, data := range map[string][]byte{
+		"a flipped bit":          flipped,
+		"swapped chunks":         swapped,
+		"its last chunk dropped": sealed[:header+chunk],
+		"its last byte cut off":  sealed[:len(sealed)-1],
+		"its header cut off":     sealed[:header-1],
+		"nothing":                nil,
+		"an unknown version":     append([]byte{aeadVersion + 1}, sealed[1:]...),
+		"trailing data":          append(append([]byte(nil), sealed...), 0),
+	} {
+		if _, err := openTestBody(key, data); err != PasteBodyTamperedError {
+			t.Errorf("opened a body with %s (%v)", name, err)
+		}
+	}
+	if _, err := openTestBody(bytes.Repeat([]byte{'x'}, 32), sealed); err != PasteBodyTamperedError {
+		t.Errorf("opened a body with the wrong key (%v)", err)
+	}
+	if _, err := openTestBodyAs("fghjk", key, sealed); err != PasteBodyTamperedError {
+		t.Errorf("opened a body as another (%v)", err)
+	}
+}
+
+func TestAEADEncryptionCodecUnboundBodies(t *testing.T) {
+	key := bytes.Repeat([]byte{'k'}, 32)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A body written before bodies were bound to their names, which any name opens.
+	prefix := make([]byte, aeadPrefixSize)
+	sealed := append([]byte{aeadUnboundVersion}, prefix...)
+	sealed = aead.Seal(sealed, aeadChunkNonce(prefix, 0, true), []byte("unbound"), nil)
+	for _, id := range []PasteID{"abcde", "fghjk"} {
+		if opened, err := openTestBodyAs(id, key, sealed); err != nil || string(opened) != "unbound" {
+			t.Errorf("opened %q as %s (%v)", opened, id, err)
+		}
+	}
+}
+
+func TestPasteEncryptionTampering(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "authenticated secret")
+	if p.(*dbPaste).EncryptionMethod != PasteEncryptionMethodXChaCha20_Poly1305 {
+		t.Fatalf("created a paste encrypted with method %d", p.(*dbPaste).EncryptionMethod)
+	}
+
+	p, err = b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, p); body != "authenticated secret" {
+		t.Errorf("read <%s>", body)
+	}
+
+	stored := readStoredBody(t, b, p.(*dbPaste).bodyID())
+	stored[len(stored)-1] ^= 1
+	if err := b.(*dbBroker).Bodies.PutBody(p.(*dbPaste).bodyID(), bytes.NewReader(stored)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err != PasteBodyTamperedError {
+		t.Errorf("read a tampered body (%v)", err)
+	}
+
+	if _, err := b.CreateEncryptedPaste(PasteEncryptionMethod(99), []byte("passphrase")); err == nil {
+		t.Error("created a paste with an unknown encryption method")
+	}
+}
+
+func TestPasteEncryptionSwappedBodies(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "first")
+	writePasteBody(t, p, "second")
+
+	// Revisions re-encrypted together are bound to bodies of their own all the same.
+	for _, reencrypt := range []bool{false, true} {
+		if reencrypt {
+			if err := b.ReencryptPaste(p.GetID(), []byte("passphrase"), []byte("passphrase"), PasteEncryptionMethodXChaCha20_Poly1305); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p, err = b.GetPaste(p.GetID(), []byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs, err := p.GetRevisions()
+		if err != nil || len(revs) != 2 {
+			t.Fatalf("paste has %d revisions (%v)", len(revs), err)
+		}
+		first, second := revs[0].(*dbPasteRevision), revs[1].(*dbPasteRevision)
+		saved := readStoredBody(t, b, second.bodyID())
+		if err := b.(*dbBroker).Bodies.PutBody(second.bodyID(), bytes.NewReader(readStoredBody(t, b, first.bodyID()))); err != nil {
+			t.Fatal(err)
+		}
+		r, err := second.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); err != PasteBodyTamperedError {
+			t.Errorf("read the first revision's body as the second's (re-encrypted: %v, %v)", reencrypt, err)
+		}
+		r.Close()
+		if err := b.(*dbBroker).Bodies.PutBody(second.bodyID(), bytes.NewReader(saved)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryPasteEncryptionSwappedBodies(t *testing.T) {
+	b, err := NewMemoryBroker(&noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "first")
+	writePasteBody(t, p, "second")
+
+	for _, reencrypt := range []bool{false, true} {
+		if reencrypt {
+			if err := b.ReencryptPaste(p.GetID(), []byte("passphrase"), []byte("passphrase"), PasteEncryptionMethodXChaCha20_Poly1305); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p, err = b.GetPaste(p.GetID(), []byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs, err := p.GetRevisions()
+		if err != nil || len(revs) != 2 {
+			t.Fatalf("paste has %d revisions (%v)", len(revs), err)
+		}
+		first, second := revs[0].(*memoryPasteRevision), revs[1].(*memoryPasteRevision)
+		if second.body == nil || second.bodyKey != b.(*memoryBroker).pastes[p.GetID()].bodyKey {
+			t.Errorf("paste doesn't share its body with its latest revision (re-encrypted: %v)", reencrypt)
+		}
+		saved := second.body
+		second.body = first.body
+		r, err := second.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); err != PasteBodyTamperedError {
+			t.Errorf("read the first revision's body as the second's (re-encrypted: %v, %v)", reencrypt, err)
+		}
+		r.Close()
+		second.body = saved
+	}
+}
+
+func TestUpgradePasteEncryption(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_OFB, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "secret")
+	// Revisions were once kept in their rows, and pastes' bodies under names of their own.
+	oldRevs, err := p.GetRevisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRevisionBody := oldRevs[0].(*dbPasteRevision).bodyID()
+	revisionBody := readStoredBody(t, b, oldRevisionBody)
+	if _, err := sqlDb.Exec(`UPDATE db_paste_revisions SET data = ? WHERE paste_id = ? AND revision = 1`, revisionBody, p.GetID().String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqlDb.Exec(`UPDATE db_pastes SET body_revision = NULL WHERE id = ?`, p.GetID().String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.(*dbBroker).Bodies.PutBody(p.(*dbPaste).encryptedBodyID(), bytes.NewReader(revisionBody)); err != nil {
+		t.Fatal(err)
+	}
+	p.(*dbPaste).BodyRevision = sql.NullInt64{}
+	if err := b.(*dbBroker).Bodies.DeleteBody(oldRevisionBody); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, plain, "not secret")
+	client, err := b.CreateClientEncryptedPaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, client, "opaque")
+	if stored := readStoredBody(t, b, client.(*dbPaste).bodyID()); string(stored) != "opaque" {
+		t.Errorf("client-encrypted body was stored as %q", stored)
+	}
+
+	upgrader := b.(PasteEncryptionUpgrader)
+	if n, err := upgrader.UpgradePasteEncryption(PasteEncryptionMethodXChaCha20_Poly1305); n != 1 || err != nil {
+		t.Fatalf("marked %d pastes for re-encryption (%v)", n, err)
+	}
+	if n, err := upgrader.UpgradePasteEncryption(PasteEncryptionMethodXChaCha20_Poly1305); n != 0 || err != nil {
+		t.Errorf("marked %d pastes for re-encryption again (%v)", n, err)
+	}
+
+	// Reading a paste without its passphrase leaves it as it is.
+	before := readStoredBody(t, b, p.(*dbPaste).bodyID())
+	if _, err := b.GetPaste(p.GetID(), nil); err != PasteEncryptedError {
+		t.Fatal(err)
+	}
+	if stored := readStoredBody(t, b, p.(*dbPaste).bodyID()); !bytes.Equal(stored, before) {
+		t.Error("paste was re-encrypted without its passphrase")
+	}
+
+	upgraded, err := b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dp := upgraded.(*dbPaste)
+	if dp.EncryptionMethod != PasteEncryptionMethodXChaCha20_Poly1305 || dp.ReencryptMethod != PasteEncryptionMethodNone || dp.BodyKey == "" {
+		t.Errorf("upgraded paste has method %d, marked for %d, under key %q", dp.EncryptionMethod, dp.ReencryptMethod, dp.BodyKey)
+	}
+	if body := readPasteBody(t, upgraded); body != "secret" {
+		t.Errorf("upgraded paste has body <%s>", body)
+	}
+	revs, err := upgraded.GetRevisions()
+	if err != nil || len(revs) != 1 {
+		t.Fatalf("upgraded paste has %d revisions (%v)", len(revs), err)
+	}
+	if body := readRevisionBody(t, revs[0]); body != "secret" {
+		t.Errorf("upgraded revision has body <%s>", body)
+	}
+
+	var inline int
+	if err := sqlDb.QueryRow(`SELECT COUNT(*) FROM db_paste_revisions WHERE data IS NOT NULL`).Scan(&inline); err != nil || inline != 0 {
+		t.Errorf("%d revisions kept their bodies in their rows (%v)", inline, err)
+	}
+	ids, err := storedBodyIDs(b.(*dbBroker).DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, err := b.(*dbBroker).Bodies.GetBody(id); err != nil {
+			t.Errorf("body %s is missing (%v)", id, err)
+		}
+	}
+	if _, err := b.(*dbBroker).Bodies.GetBody(p.(*dbPaste).bodyID()); err != PasteBodyNotFoundError {
+		t.Errorf("replaced body survived (%v)", err)
+	}
+}
+
+func TestPasteKeystreamReuse(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []PasteEncryptionMethod{PasteEncryptionMethodAES_OFB, PasteEncryptionMethodAES_CTR} {
+		p, err := b.CreateEncryptedPaste(method, []byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePasteBody(t, p, "first")
+		if m := p.(*dbPaste).EncryptionMethod; m != method {
+			t.Errorf("paste first written with method %d is encrypted with %d", method, m)
+		}
+		first := readStoredBody(t, b, p.(*dbPaste).bodyID())
+
+		// A second body isn't encrypted with the keystream of the first.
+		writePasteBody(t, p, "second")
+		if m := p.(*dbPaste).EncryptionMethod; m != PasteEncryptionMethodXChaCha20_Poly1305 {
+			t.Errorf("paste written again with method %d is encrypted with %d", method, m)
+		}
+		revs, err := p.GetRevisions()
+		if err != nil || len(revs) != 2 {
+			t.Fatalf("paste has %d revisions (%v)", len(revs), err)
+		}
+		if stored := readStoredBody(t, b, revs[0].(*dbPasteRevision).bodyID()); bytes.Equal(stored, first) {
+			t.Errorf("first body is still encrypted with method %d", method)
+		}
+
+		p, err = b.GetPaste(p.GetID(), []byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs, err = p.GetRevisions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range []string{"first", "second"} {
+			if body := readRevisionBody(t, revs[i]); body != want {
+				t.Errorf("revision %d has body <%s>", i+1, body)
+			}
+		}
+	}
+}
+
+func TestPasteKDF(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	oldKDF := &crypto.Scrypt{N: 2, R: 1, P: 1}
+	newKDF := &crypto.Argon2id{Time: 1, Memory: 8, Threads: 1}
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{}, DatabaseBrokerPasteKDF(oldKDF))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "secret")
+	legacy, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp, err := legacy.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, lp, "old secret")
+	// Pastes encrypted before KDFs were recorded record none.
+	if _, err := sqlDb.Exec(`UPDATE db_pastes SET kdf = '' WHERE id = ?`, lp.GetID().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pastes are unlocked with the KDF they were encrypted with, whatever the broker's is now.
+	b, err = NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{}, DatabaseBrokerPasteKDF(newKDF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[PasteID]string{p.GetID(): "secret", lp.GetID(): "old secret"} {
+		p, err := b.GetPaste(id, []byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body := readPasteBody(t, p); body != want {
+			t.Errorf("paste %s has body <%s>", id, body)
+		}
+	}
+
+	if n, err := legacy.(PasteEncryptionUpgrader).UpgradePasteEncryption(PasteEncryptionMethodXChaCha20_Poly1305); n != 1 || err != nil {
+		t.Errorf("marked %d pastes for re-encryption with the legacy KDF (%v)", n, err)
+	}
+	// The paste already marked is re-encrypted with whatever the broker's KDF is then.
+	upgrader := b.(PasteEncryptionUpgrader)
+	if n, err := upgrader.UpgradePasteEncryption(PasteEncryptionMethodXChaCha20_Poly1305); n != 1 || err != nil {
+		t.Fatalf("marked %d pastes for re-encryption (%v)", n, err)
+	}
+	upgraded, err := b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kdf := upgraded.(*dbPaste).KDF; kdf != newKDF.String() {
+		t.Errorf("upgraded paste's key was derived with %q", kdf)
+	}
+	if body := readPasteBody(t, upgraded); body != "secret" {
+		t.Errorf("upgraded paste has body <%s>", body)
+	}
+	var stored string
+	if err := sqlDb.QueryRow(`SELECT kdf FROM db_pastes WHERE id = ?`, p.GetID().String()).Scan(&stored); err != nil || stored != newKDF.String() {
+		t.Errorf("upgraded paste was stored with KDF %q (%v)", stored, err)
+	}
+	if n, err := upgrader.UpgradePasteEncryption(PasteEncryptionMethodXChaCha20_Poly1305); n != 0 || err != nil {
+		t.Errorf("marked %d pastes for re-encryption again (%v)", n, err)
+	}
+}
+
+func TestReencryptWrittenPaste(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "first")
+	stale, err := b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "second")
+
+	// The paste is switched over to a new key only if the bodies re-encrypted are still its.
+	sp := stale.(*dbPaste)
+	to, err := newPasteKey(sp.GetID(), PasteEncryptionMethodXChaCha20_Poly1305, crypto.LegacyKDF, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.(*dbBroker).reencryptPaste(sp, to, PasteEncryptionMethodNone); err != PasteKeyChangedError {
+		t.Errorf("re-encrypted a paste written since it was read (%v)", err)
+	}
+	sp.ReencryptMethod = PasteEncryptionMethodAES_CTR
+	if err := b.(*dbBroker).upgradePasteEncryption(sp, []byte("passphrase")); err != nil {
+		t.Error(err)
+	}
+	if body := readPasteBody(t, sp); body != "second" {
+		t.Errorf("paste reloaded instead of re-encrypted has body <%s>", body)
+	}
+
+	current, err := b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, current); body != "second" {
+		t.Errorf("paste has body <%s>", body)
+	}
+	writePasteBody(t, p, "third")
+}
+
+func TestEncryptedPasteBodyStoredOnce(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "first")
+	writePasteBody(t, p, "second")
+	bodyIDs, err := storedBodyIDs(b.(*dbBroker).DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := sqlDb.QueryRow(`SELECT COUNT(DISTINCT paste_id) FROM db_paste_body_chunks`).Scan(&n); err != nil || n != 2 || len(bodyIDs) != 2 {
+		t.Errorf("two writes stored %d bodies, %d of them referred to (%v)", n, len(bodyIDs), err)
+	}
+
+	// Re-encrypting it keeps the paste's body with its latest revision's.
+	if err := b.ReencryptPaste(p.GetID(), []byte("passphrase"), []byte("new"), PasteEncryptionMethodXChaCha20_Poly1305); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlDb.QueryRow(`SELECT COUNT(DISTINCT paste_id) FROM db_paste_body_chunks`).Scan(&n); err != nil || n != 2 {
+		t.Errorf("re-encrypted paste has %d bodies (%v)", n, err)
+	}
+	p, err = b.GetPaste(p.GetID(), []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, p); body != "second" {
+		t.Errorf("paste has body <%s>", body)
+	}
+	revs, err := p.GetRevisions()
+	if err != nil || len(revs) != 2 {
+		t.Fatalf("paste has %d revisions (%v)", len(revs), err)
+	}
+	for i, want := range []string{"first", "second"} {
+		if body := readRevisionBody(t, revs[i]); body != want {
+			t.Errorf("revision %d has body <%s>", revs[i].GetNumber(), body)
+		}
+	}
+}
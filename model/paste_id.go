@@ -0,0 +1,100 @@
+package model
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// pasteIDCharacters are the characters a paste ID may contain. Each is safe in URLs and
+// file names, and none is used by a PasteBodyStore to name anything but a paste.
+const pasteIDCharacters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_-"
+
+// MaxPasteIDLength is the length of the longest paste ID that can be stored.
+const MaxPasteIDLength = 64
+
+// ValidatePasteID returns PasteIDInvalidError if id can't name a paste.
+func ValidatePasteID(id PasteID) error {
+	s := id.String()
+	if s == "" || len(s) > MaxPasteIDLength {
+		return PasteIDInvalidError
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(pasteIDCharacters, c) {
+			return PasteIDInvalidError
+		}
+	}
+	return nil
+}
+
+// PasteIDGenerator describes the random IDs given to new pastes. Whenever a few IDs in a row
+// turn out to be taken for one paste, the IDs tried for it after that are a character longer;
+// the next paste starts from the configured length again.
+type PasteIDGenerator struct {
+	// Alphabet holds the characters IDs are made of.
+	Alphabet string
+	// Length and EncryptedLength are the lengths of the IDs of plain and encrypted pastes.
+	// Encrypted pastes are given longer IDs, so that they are harder to come across.
+	Length          int
+	EncryptedLength int
+}
+
+// NewPasteIDGenerator returns the generator used when none is configured.
+func NewPasteIDGenerator() *PasteIDGenerator {
+	return &PasteIDGenerator{
+		Alphabet:        "abcdefghjkmnopqrstuvwxyz23456789",
+		Length:          5,
+		EncryptedLength: 8,
+	}
+}
+
+// Validate reports whether g can generate valid paste IDs.
+func (g *PasteIDGenerator) Validate() error {
+	if len(g.Alphabet) < 2 {
+		return errors.New("model: a paste ID alphabet needs at least two characters")
+	}
+	for i, c := range g.Alphabet {
+		if !strings.ContainsRune(pasteIDCharacters, c) {
+			return errors.New("model: paste IDs can't contain " + string(c))
+		}
+		if strings.IndexRune(g.Alphabet, c) != i {
+			return errors.New("model: paste ID alphabet repeats " + string(c))
+		}
+	}
+	if g.Length < 1 || g.EncryptedLength < 1 || g.Length > MaxPasteIDLength || g.EncryptedLength > MaxPasteIDLength {
+		return errors.New("model: paste ID lengths must be between 1 and 64")
+	}
+	return nil
+}
+
+// pasteIDAttemptsPerLength is how many IDs of one length may be found taken for a paste
+// before the IDs tried for it grow.
+const pasteIDAttemptsPerLength = 3
+
+// maxPasteIDAttempts bounds the IDs tried for one paste.
+const maxPasteIDAttempts = 5 * pasteIDAttemptsPerLength
+
+// generate returns a random ID. attempt counts the IDs already found taken for the paste
+// that this one is for.
+func (g *PasteIDGenerator) generate(encrypted bool, attempt int) PasteID {
+	length := g.Length
+	if encrypted {
+		length = g.EncryptedLength
+	}
+	length += attempt / pasteIDAttemptsPerLength
+	if length > MaxPasteIDLength {
+		length = MaxPasteIDLength
+	}
+
+	max := big.NewInt(int64(len(g.Alphabet)))
+	id := make([]byte, length)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		id[i] = g.Alphabet[n.Int64()]
+	}
+	return PasteID(id)
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"database/sql"
+	"io"
+
+	"github.com/golang/glog"
+)
+
+// ImportPaste implements PasteImporter. The body is written the way any other is, so that it
+// is compressed, shared and indexed, and the paste's times are put back afterwards.
+func (broker *dbBroker) ImportPaste(ip *ImportedPaste) error {
+	if err := ValidatePasteID(ip.ID); err != nil {
+		return err
+	}
+
+	paste := &dbPaste{
+		ID:               ip.ID.String(),
+		CreatedAt:        ip.CreatedAt,
+		UpdatedAt:        ip.ModifiedAt,
+		EncryptionMethod: ip.EncryptionMethod,
+		EncryptionSalt:   ip.EncryptionSalt,
+		HMAC:             ip.HMAC,
+		broker:           broker,
+	}
+	paste.SetTitle(ip.Title)
+	paste.SetLanguageName(ip.LanguageName)
+	paste.SetExpiration(ip.Expiration)
+
+	if err := broker.Create(paste).Error; err != nil {
+		var n int
+		if cerr := broker.Model(&dbPaste{}).Where("id = ?", paste.ID).Count(&n).Error; cerr != nil || n == 0 {
+			return err
+		}
+		return PasteIDUnavailableError
+	}
+	broker.publish(PasteCreatedEvent, paste)
+
+	if err := broker.importPasteBody(paste, ip); err != nil {
+		// Leave nothing behind, so that the paste can be imported again.
+		perr := paste.Erase()
+		if perr == nil {
+			perr = broker.PurgePaste(paste.GetID())
+		}
+		if perr != nil {
+			glog.Errorf("paste %s: failed to clean up after a failed import: %v", paste.ID, perr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (broker *dbBroker) importPasteBody(paste *dbPaste, ip *ImportedPaste) error {
+	var w io.WriteCloser
+	if paste.IsEncrypted() {
+		// Without the key, the ciphertext is stored as it is; it was never compressed.
+		pw, err := newPasteWriter(broker, paste)
+		if err != nil {
+			return err
+		}
+		pw.compression = PasteCompressionMethodNone
+		w = pw
+	} else {
+		var err error
+		if w, err = paste.Writer(); err != nil {
+			return err
+		}
+	}
+	// A body that can't be read is abandoned without closing the writer, and nothing is stored.
+	if _, err := io.Copy(w, ip.Body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	columns := map[string]interface{}{
+		"created_at": ip.CreatedAt,
+		"updated_at": ip.ModifiedAt,
+	}
+	if paste.IsEncrypted() {
+		// What was counted is the ciphertext.
+		columns["size"] = sql.NullInt64{}
+		columns["line_count"] = sql.NullInt64{}
+	}
+	tx := broker.Begin()
+	if err := tx.Model(&dbPaste{}).Where("id = ?", paste.ID).UpdateColumns(columns).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Model(&dbPasteRevision{}).Where("paste_id = ?", paste.ID).UpdateColumn("created_at", ip.ModifiedAt).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
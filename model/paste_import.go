@@ -0,0 +1,36 @@
+package model
+
+import (
+	"io"
+	"time"
+)
+
+// ImportedPaste describes a paste brought over from another store, such as the filesystem
+// store ghostbin used before it had a database.
+type ImportedPaste struct {
+	ID PasteID
+
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+
+	Title        string
+	LanguageName string
+	Expiration   string
+
+	// An encrypted paste is imported without its passphrase: its body is stored exactly as
+	// it is read, and can be decrypted afterwards by anyone who could decrypt it before.
+	EncryptionMethod PasteEncryptionMethod
+	EncryptionSalt   []byte
+	HMAC             []byte
+
+	Body io.Reader
+}
+
+// PasteImporter is implemented by brokers that can take in pastes from elsewhere, keeping
+// their IDs and times.
+type PasteImporter interface {
+	// ImportPaste recreates p, with a single revision holding its body. It returns
+	// PasteIDUnavailableError, having changed nothing, if a paste with p's ID exists, even
+	// in the trash, so that an import can be run again over the same pastes.
+	ImportPaste(p *ImportedPaste) error
+}
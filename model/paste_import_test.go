@@ -0,0 +1,129 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DHowett/ghostbin/lib/crypto"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("disk on fire")
+}
+
+func TestImportPaste(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	importer := b.(PasteImporter)
+
+	modified := time.Date(2014, 3, 1, 12, 0, 0, 0, time.UTC)
+	ip := &ImportedPaste{
+		ID:           "abcde",
+		CreatedAt:    modified,
+		ModifiedAt:   modified,
+		Title:        "Old Notes",
+		LanguageName: "go",
+		Expiration:   "-1",
+		Body:         strings.NewReader("package legacy"),
+	}
+	if err := importer.ImportPaste(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.GetPaste("abcde", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, p); body != "package legacy" {
+		t.Errorf("imported <%s>", body)
+	}
+	if p.GetTitle() != "Old Notes" || p.GetLanguageName() != "go" || p.GetExpiration() != "-1" {
+		t.Errorf("imported %q in %s, expiring %s", p.GetTitle(), p.GetLanguageName(), p.GetExpiration())
+	}
+	if !p.GetModificationTime().Equal(modified) {
+		t.Errorf("imported a paste modified at %v", p.GetModificationTime())
+	}
+	revs, err := p.GetRevisions()
+	if err != nil || len(revs) != 1 {
+		t.Fatalf("imported %d revisions (%v)", len(revs), err)
+	}
+	if !revs[0].GetTime().Equal(modified) {
+		t.Errorf("imported a revision created at %v", revs[0].GetTime())
+	}
+
+	ip.Body = strings.NewReader("package changed")
+	if err := importer.ImportPaste(ip); err != PasteIDUnavailableError {
+		t.Errorf("imported a paste twice (%v)", err)
+	}
+	if body := readPasteBody(t, p); body != "package legacy" {
+		t.Errorf("importing a paste again changed it to <%s>", body)
+	}
+
+	// A paste whose body can't be read leaves nothing behind.
+	broken := &ImportedPaste{ID: "fghij", CreatedAt: modified, ModifiedAt: modified, Body: io.MultiReader(strings.NewReader("partial"), failingReader{})}
+	if err := importer.ImportPaste(broken); err == nil {
+		t.Error("imported a paste whose body couldn't be read")
+	}
+	broken.Body = strings.NewReader("whole")
+	if err := importer.ImportPaste(broken); err != nil {
+		t.Errorf("couldn't import a paste again after it failed (%v)", err)
+	}
+}
+
+func TestImportEncryptedPaste(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Encrypt a body the way the filesystem store did.
+	codec := getPasteEncryptionCodec(PasteEncryptionMethodAES_OFB)
+	salt := []byte("0123456789abcdef")
+	key, err := codec.DeriveKey(crypto.LegacyKDF, []byte("passphrase"), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := &bytes.Buffer{}
+	w := codec.Writer("secretid", key, nopWriteCloser{ciphertext})
+	w.Write([]byte("old secret"))
+	w.Close()
+
+	modified := time.Date(2014, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := b.(PasteImporter).ImportPaste(&ImportedPaste{
+		ID:               "secretid",
+		CreatedAt:        modified,
+		ModifiedAt:       modified,
+		EncryptionMethod: PasteEncryptionMethodAES_OFB,
+		EncryptionSalt:   salt,
+		HMAC:             codec.GenerateHMAC("secretid", salt, key),
+		Body:             ciphertext,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.GetPaste("secretid", []byte("wrong")); err != PasteInvalidKeyError {
+		t.Errorf("read an imported encrypted paste with the wrong passphrase (%v)", err)
+	}
+	p, err := b.GetPaste("secretid", []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readPasteBody(t, p); body != "old secret" {
+		t.Errorf("decrypted an imported paste as <%s>", body)
+	}
+	if p.GetSize() != -1 {
+		t.Errorf("imported an encrypted paste of %d bytes", p.GetSize())
+	}
+}
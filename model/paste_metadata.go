@@ -0,0 +1,69 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// pasteMetadata is what of a paste encrypted by the server, and of each of its revisions, is
+// sealed under the paste's key instead of being kept in the clear. Whatever the paste's
+// encryption method, it is sealed with XChaCha20-Poly1305 under a key of its own derived
+// from the paste's, and bound to the paste's ID, or for a revision to the name of its body.
+type pasteMetadata struct {
+	Title    string `json:"title,omitempty"`
+	Language string `json:"language,omitempty"`
+	Bundle   bool   `json:"bundle,omitempty"`
+}
+
+const pasteMetadataVersion = 1
+
+// pasteMetadataKey derives the key metadata is sealed with from the key to a paste.
+func pasteMetadataKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("ghostbin paste metadata"))
+	return mac.Sum(nil)
+}
+
+// sealPasteMetadata seals m for the paste or revision body id, under the paste's key.
+func sealPasteMetadata(id PasteID, key []byte, m *pasteMetadata) ([]byte, error) {
+	plaintext, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(pasteMetadataKey(key))
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	sealed[0] = pasteMetadataVersion
+	if _, err := rand.Read(sealed[1:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, sealed[1:], plaintext, []byte(id.String())), nil
+}
+
+// openPasteMetadata opens metadata sealed by sealPasteMetadata, returning
+// PasteMetadataTamperedError if it doesn't authenticate.
+func openPasteMetadata(id PasteID, key []byte, sealed []byte) (*pasteMetadata, error) {
+	aead, err := chacha20poly1305.NewX(pasteMetadataKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < 1+aead.NonceSize() || sealed[0] != pasteMetadataVersion {
+		return nil, PasteMetadataTamperedError
+	}
+	nonce, ciphertext := sealed[1:1+aead.NonceSize()], sealed[1+aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(id.String()))
+	if err != nil {
+		return nil, PasteMetadataTamperedError
+	}
+	var m pasteMetadata
+	if err := json.Unmarshal(plaintext, &m); err != nil {
+		return nil, PasteMetadataTamperedError
+	}
+	return &m, nil
+}
@@ -0,0 +1,230 @@
+package model
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+)
+
+func TestPasteMetadata(t *testing.T) {
+	key := bytes.Repeat([]byte{'k'}, 32)
+	m := &pasteMetadata{Title: "secret title", Language: "go"}
+	sealed, err := sealPasteMetadata("paste", key, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("secret title")) {
+		t.Error("sealed metadata holds the title in the clear")
+	}
+	if again, _ := sealPasteMetadata("paste", key, m); bytes.Equal(again, sealed) {
+		t.Error("sealed the same metadata alike twice")
+	}
+
+	opened, err := openPasteMetadata("paste", key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *opened != *m {
+		t.Errorf("opened metadata as %+v", opened)
+	}
+
+	if _, err := openPasteMetadata("other", key, sealed); err != PasteMetadataTamperedError {
+		t.Errorf("opened another paste's metadata (%v)", err)
+	}
+	if _, err := openPasteMetadata("paste", bytes.Repeat([]byte{'x'}, 32), sealed); err != PasteMetadataTamperedError {
+		t.Errorf("opened metadata with the wrong key (%v)", err)
+	}
+	for _, tampered := range [][]byte{
+		append([]byte{2}, sealed[1:]...),
+		append(append([]byte{}, sealed[:len(sealed)-1]...), sealed[len(sealed)-1]^1),
+		sealed[:10],
+		nil,
+	} {
+		if _, err := openPasteMetadata("paste", key, tampered); err != PasteMetadataTamperedError {
+			t.Errorf("opened tampered metadata (%v)", err)
+		}
+	}
+}
+
+// storedPasteMetadata returns the title, language and sealed metadata stored in the row of
+// table whose column is value.
+func storedPasteMetadata(t *testing.T, sqlDb *sql.DB, table, column string, value interface{}) (title, language sql.NullString, metadata []byte) {
+	if err := sqlDb.QueryRow(`SELECT title, language_name, metadata FROM `+table+` WHERE `+column+` = ?`, value).Scan(&title, &language, &metadata); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestPasteMetadataSealing(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTitle("secret title")
+	p.SetLanguageName("go")
+	p.SetBundle(true)
+	writePasteBody(t, p, "secret")
+	p.SetTitle("retitled")
+	if err := p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	id := p.GetID().String()
+	if title, language, metadata := storedPasteMetadata(t, sqlDb, "db_pastes", "id", id); title.Valid || language.Valid || metadata == nil {
+		t.Errorf("encrypted paste was stored with title %v and language %v", title, language)
+	}
+	if title, language, metadata := storedPasteMetadata(t, sqlDb, "db_paste_revisions", "paste_id", id); title.Valid || language.Valid || metadata == nil {
+		t.Errorf("revision of an encrypted paste was stored with title %v and language %v", title, language)
+	}
+	var bundles int
+	if err := sqlDb.QueryRow(`SELECT (SELECT COUNT(*) FROM db_pastes WHERE bundle) + (SELECT COUNT(*) FROM db_paste_revisions WHERE bundle)`).Scan(&bundles); err != nil || bundles != 0 {
+		t.Errorf("%d rows record in the clear that an encrypted paste is a bundle (%v)", bundles, err)
+	}
+
+	p, err = b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GetTitle() != "retitled" || p.GetLanguageName() != "go" || !p.IsBundle() {
+		t.Errorf("unlocked paste has title %q and language %q, and is a bundle: %v", p.GetTitle(), p.GetLanguageName(), p.IsBundle())
+	}
+	revs, err := p.GetRevisions()
+	if err != nil || len(revs) != 1 {
+		t.Fatalf("paste has %d revisions (%v)", len(revs), err)
+	}
+	if revs[0].GetTitle() != "secret title" || revs[0].GetLanguageName() != "go" || !revs[0].IsBundle() {
+		t.Errorf("revision has title %q and language %q, and is a bundle: %v", revs[0].GetTitle(), revs[0].GetLanguageName(), revs[0].IsBundle())
+	}
+	if _, err := p.GetRevision(1); err != nil {
+		t.Error(err)
+	}
+
+	// Other pastes' metadata isn't sealed.
+	plain, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.SetTitle("public title")
+	writePasteBody(t, plain, "public")
+	if title, _, metadata := storedPasteMetadata(t, sqlDb, "db_pastes", "id", plain.GetID().String()); title.String != "public title" || metadata != nil {
+		t.Errorf("unencrypted paste was stored with title %v", title)
+	}
+
+	// Metadata moved from another paste doesn't open.
+	other, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, other, "other secret")
+	if _, err := sqlDb.Exec(`UPDATE db_pastes SET metadata = (SELECT metadata FROM db_pastes WHERE id = ?) WHERE id = ?`, id, other.GetID().String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetPaste(other.GetID(), []byte("passphrase")); err != PasteMetadataTamperedError {
+		t.Errorf("unlocked a paste with another's metadata (%v)", err)
+	}
+}
+
+func TestPasteMetadataResealing(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTitle("old title")
+	writePasteBody(t, p, "old secret")
+	writePasteBody(t, p, "secret")
+	// Pastes written before metadata was sealed keep theirs in the clear.
+	id := p.GetID().String()
+	clearMetadata := func() {
+		if _, err := sqlDb.Exec(`UPDATE db_pastes SET title = 'old title', language_name = 'text', metadata = NULL WHERE id = ?`, id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sqlDb.Exec(`UPDATE db_paste_revisions SET title = 'old title', metadata = NULL WHERE paste_id = ? AND revision = 1`, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clearMetadata()
+	p, err = b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GetTitle() != "old title" || p.GetLanguageName() != "text" {
+		t.Errorf("paste with metadata in the clear has title %q and language %q", p.GetTitle(), p.GetLanguageName())
+	}
+
+	// Unlocking the paste seals what was in the clear.
+	if title, language, metadata := storedPasteMetadata(t, sqlDb, "db_pastes", "id", id); title.Valid || language.Valid || metadata == nil {
+		t.Errorf("unlocked paste is still stored with title %v and language %v", title, language)
+	}
+	var n int
+	if err := sqlDb.QueryRow(`SELECT COUNT(*) FROM db_paste_revisions WHERE paste_id = ? AND (title IS NOT NULL OR metadata IS NULL)`, id).Scan(&n); err != nil || n != 0 {
+		t.Errorf("%d revisions of an unlocked paste kept their metadata in the clear (%v)", n, err)
+	}
+	p, err = b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GetTitle() != "old title" || p.GetLanguageName() != "text" {
+		t.Errorf("paste with sealed legacy metadata has title %q and language %q", p.GetTitle(), p.GetLanguageName())
+	}
+
+	// Pastes nobody unlocks are sealed when they are re-encrypted.
+	clearMetadata()
+	upgrader := b.(PasteEncryptionUpgrader)
+	if n, err := upgrader.UpgradePasteEncryption(PasteEncryptionMethodXChaCha20_Poly1305); n != 1 || err != nil {
+		t.Fatalf("marked %d pastes for re-encryption (%v)", n, err)
+	}
+	p, err = b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GetTitle() != "old title" || p.GetLanguageName() != "text" {
+		t.Errorf("re-encrypted paste has title %q and language %q", p.GetTitle(), p.GetLanguageName())
+	}
+	if title, _, metadata := storedPasteMetadata(t, sqlDb, "db_pastes", "id", id); title.Valid || metadata == nil {
+		t.Errorf("re-encrypted paste was stored with title %v", title)
+	}
+	revs, err := p.GetRevisions()
+	if err != nil || len(revs) != 2 {
+		t.Fatalf("paste has %d revisions (%v)", len(revs), err)
+	}
+	for _, rev := range revs {
+		if rev.GetTitle() != "old title" {
+			t.Errorf("revision %d has title %q", rev.GetNumber(), rev.GetTitle())
+		}
+	}
+	if err := sqlDb.QueryRow(`SELECT COUNT(*) FROM db_paste_revisions WHERE paste_id = ? AND (title IS NOT NULL OR metadata IS NULL)`, id).Scan(&n); err != nil || n != 0 {
+		t.Errorf("%d revisions kept their metadata in the clear (%v)", n, err)
+	}
+	if n, err := upgrader.UpgradePasteEncryption(PasteEncryptionMethodXChaCha20_Poly1305); n != 0 || err != nil {
+		t.Errorf("marked %d pastes for re-encryption again (%v)", n, err)
+	}
+
+	// Changing the passphrase seals it all again under the new key.
+	if err := b.ReencryptPaste(p.GetID(), []byte("passphrase"), []byte("new"), PasteEncryptionMethodXChaCha20_Poly1305); err != nil {
+		t.Fatal(err)
+	}
+	p, err = b.GetPaste(p.GetID(), []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GetTitle() != "old title" {
+		t.Errorf("paste with a new passphrase has title %q", p.GetTitle())
+	}
+	if revs, err = p.GetRevisions(); err != nil || revs[0].GetTitle() != "old title" {
+		t.Errorf("revision of a paste with a new passphrase couldn't be read (%v)", err)
+	}
+}
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+type dbPasteRevision struct {
+	ID        uint   `gorm:"primary_key"`
+	PasteID   string `gorm:"type:varchar(191);unique_index:uix_paste_revision"`
+	Revision  int    `gorm:"unique_index:uix_paste_revision"`
+	CreatedAt time.Time
+
+	EditorUserID  uint
+	EditorSession string `gorm:"type:varchar(64)"`
+
+	Title        sql.NullString `gorm:"type:text"`
+	LanguageName sql.NullString `gorm:"type:varchar(128)"`
+	Bundle       bool           `gorm:"not null"`
+	// Metadata holds the title, language and bundledness of a revision of a paste encrypted
+	// by the server, sealed like the paste's own; see dbPaste.Metadata.
+	Metadata []byte `gorm:"null"`
+
+	// Data holds the bodies of revisions written before bodies were kept in a PasteBodyStore.
+	// They are never compressed.
+	Data []byte
+
+	CompressionMethod PasteCompressionMethod
+
+	// BodyHash names the shared body of a revision of an unencrypted paste; see dbPasteBlob.
+	BodyHash sql.NullString `gorm:"type:varchar(64)"`
+	// BodyKey names the body of a revision of an encrypted paste, which is written under a
+	// name of its own each time; see rekeyedBodyID.
+	BodyKey string `gorm:"type:varchar(32)"`
+
+	paste *dbPaste
+}
+
+// dbPasteRevisionMetadataColumns are the columns loaded when listing revisions; bodies are loaded on demand.
+const dbPasteRevisionMetadataColumns = "id, paste_id, revision, created_at, editor_user_id, editor_session, title, language_name, bundle, metadata, compression_method, body_hash, body_key"
+
+func (r *dbPasteRevision) GetPasteID() PasteID {
+	return PasteIDFromString(r.PasteID)
+}
+
+func (r *dbPasteRevision) GetNumber() int {
+	return r.Revision
+}
+
+func (r *dbPasteRevision) GetTime() time.Time {
+	return r.CreatedAt
+}
+
+func (r *dbPasteRevision) GetEditor() PasteEditor {
+	return PasteEditor{
+		UserID:  r.EditorUserID,
+		Session: r.EditorSession,
+	}
+}
+
+func (r *dbPasteRevision) GetLanguageName() string {
+	if r.LanguageName.Valid {
+		return r.LanguageName.String
+	}
+	return ""
+}
+
+func (r *dbPasteRevision) GetTitle() string {
+	if r.Title.Valid {
+		return r.Title.String
+	}
+	return ""
+}
+
+func (r *dbPasteRevision) IsBundle() bool {
+	return r.Bundle
+}
+
+// unsealMetadata opens the revision's sealed metadata, if it has any, under the key of its
+// paste, which must be unlocked.
+func (r *dbPasteRevision) unsealMetadata() error {
+	if r.Metadata == nil {
+		return nil
+	}
+	m, err := openPasteMetadata(revisionBodyID(r.GetPasteID(), r.Revision), r.paste.encryptionKey, r.Metadata)
+	if err != nil {
+		return err
+	}
+	r.Title = sql.NullString{String: m.Title, Valid: m.Title != ""}
+	r.LanguageName = sql.NullString{String: m.Language, Valid: m.Language != ""}
+	r.Bundle = m.Bundle
+	return nil
+}
+
+// bodyID names the revision's body in the broker's PasteBodyStore.
+func (r *dbPasteRevision) bodyID() PasteID {
+	if r.BodyHash.Valid {
+		return blobBodyID(r.BodyHash.String)
+	}
+	return rekeyedBodyID(revisionBodyID(r.GetPasteID(), r.Revision), r.BodyKey)
+}
+
+// encryptedBodyID names the body an encrypted revision's body was encrypted as: the paste's
+// current body when the revision was written, which is stored as the revision's.
+func (r *dbPasteRevision) encryptedBodyID() PasteID {
+	return rekeyedBodyID(r.GetPasteID(), r.BodyKey)
+}
+
+func (r *dbPasteRevision) Reader() (io.ReadCloser, error) {
+	data := r.Data
+	if data == nil {
+		var b dbPasteRevision
+		if err := r.paste.broker.Select("data").First(&b, "id = ?", r.ID).Error; err != nil {
+			return nil, err
+		}
+		data = b.Data
+	}
+
+	var reader io.ReadCloser
+	if data != nil {
+		reader = ioutil.NopCloser(bytes.NewReader(data))
+	} else {
+		var err error
+		reader, err = r.paste.broker.Bodies.GetBody(r.bodyID())
+		if err == PasteBodyNotFoundError {
+			reader = devZero
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.paste.IsEncrypted() {
+		reader = getPasteEncryptionCodec(r.paste.EncryptionMethod).Reader(r.encryptedBodyID(), r.paste.encryptionKey, reader)
+	}
+	return getPasteCompressionCodec(r.CompressionMethod).Reader(reader), nil
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"bytes"
+	"hash"
+	"io"
+)
+
+// pasteBodyStats counts the bytes and lines of a body as it is written.
+type pasteBodyStats struct {
+	size     int64
+	newlines int64
+	last     byte
+
+	// digest, if set, is given the body as well.
+	digest hash.Hash
+}
+
+func (s *pasteBodyStats) Write(b []byte) (int, error) {
+	if s.digest != nil {
+		s.digest.Write(b)
+	}
+	if len(b) > 0 {
+		s.size += int64(len(b))
+		s.newlines += int64(bytes.Count(b, []byte{'\n'}))
+		s.last = b[len(b)-1]
+	}
+	return len(b), nil
+}
+
+// lineCount counts an unterminated last line as a line.
+func (s *pasteBodyStats) lineCount() int64 {
+	if s.size > 0 && s.last != '\n' {
+		return s.newlines + 1
+	}
+	return s.newlines
+}
+
+// pasteStatsWriter counts what is written to a paste before it is encrypted, so that the
+// counts describe the body its readers will see.
+type pasteStatsWriter struct {
+	io.WriteCloser
+	stats *pasteBodyStats
+}
+
+func (w *pasteStatsWriter) Write(b []byte) (int, error) {
+	n, err := w.WriteCloser.Write(b)
+	w.stats.Write(b[:n])
+	return n, err
+}
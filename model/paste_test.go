@@ -2,6 +2,8 @@ package model
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"testing"
 )
@@ -241,3 +243,247 @@ func TestPasteEncryption(t *testing.T) {
 
 	p.Erase()
 }
+
+func writePasteBody(t *testing.T, p Paste, body string) {
+	w, err := p.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readRevisionBody(t *testing.T, r PasteRevision) string {
+	reader, err := r.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	buf, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestPasteRevisions(t *testing.T) {
+	p, err := broker.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Erase()
+
+	p.SetTitle("first")
+	p.SetEditor(PasteEditor{Session: "abc"})
+	writePasteBody(t, p, "one")
+
+	p.SetTitle("second")
+	p.SetLanguageName("go")
+	p.SetEditor(PasteEditor{UserID: 1})
+	writePasteBody(t, p, "two")
+
+	p, err = broker.GetPaste(p.GetID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revs, err := p.GetRevisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("expected 2 revisions, got %d", len(revs))
+	}
+
+	if revs[0].GetNumber() != 1 || revs[0].GetTitle() != "first" || revs[0].GetEditor().Session != "abc" || !revs[0].GetEditor().IsAnonymous() {
+		t.Errorf("revision 1 has wrong metadata: %d %q %+v", revs[0].GetNumber(), revs[0].GetTitle(), revs[0].GetEditor())
+	}
+	if revs[1].GetNumber() != 2 || revs[1].GetTitle() != "second" || revs[1].GetLanguageName() != "go" || revs[1].GetEditor().UserID != 1 {
+		t.Errorf("revision 2 has wrong metadata: %d %q %q %+v", revs[1].GetNumber(), revs[1].GetTitle(), revs[1].GetLanguageName(), revs[1].GetEditor())
+	}
+
+	if body := readRevisionBody(t, revs[0]); body != "one" {
+		t.Errorf("revision 1 had body <%s>", body)
+	}
+
+	rev, err := p.GetRevision(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRevisionBody(t, rev); body != "two" {
+		t.Errorf("revision 2 had body <%s>", body)
+	}
+
+	if _, err := p.GetRevision(3); err != PasteRevisionNotFoundError {
+		t.Errorf("expected PasteRevisionNotFoundError, got %v", err)
+	}
+}
+
+func TestPasteRevisionsEncrypted(t *testing.T) {
+	p, err := broker.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Erase()
+
+	writePasteBody(t, p, "secret one")
+	writePasteBody(t, p, "secret two")
+
+	pFacade, _ := broker.GetPaste(p.GetID(), nil)
+	if _, err := pFacade.GetRevisions(); err != PasteEncryptedError {
+		t.Errorf("revisions of an encrypted paste listed without a passphrase (err = %v)", err)
+	}
+
+	pReal, err := broker.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := pReal.GetRevision(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRevisionBody(t, rev); body != "secret one" {
+		t.Errorf("encrypted revision 1 had body <%s>", body)
+	}
+
+	var b dbPasteRevision
+	if err := broker.(*dbBroker).First(&b, "paste_id = ? AND revision = 1", p.GetID().String()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b.Data, []byte("secret one")) {
+		t.Error("encrypted revision stored in plaintext")
+	}
+}
+
+func TestPasteConcurrentWrites(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 8
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			p, err := b.GetPaste(p.GetID(), []byte("passphrase"))
+			if err == nil {
+				var w io.WriteCloser
+				if w, err = p.Writer(); err == nil {
+					fmt.Fprintf(w, "writer %d", i)
+					err = w.Close()
+				}
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	p, err = b.GetPaste(p.GetID(), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revs, err := p.GetRevisions()
+	if err != nil || len(revs) != writers {
+		t.Fatalf("%d writers left %d revisions (%v)", writers, len(revs), err)
+	}
+	// Each write is kept whole, by a revision of its own.
+	seen := make(map[string]bool)
+	for _, rev := range revs {
+		seen[readRevisionBody(t, rev)] = true
+	}
+	for i := 0; i < writers; i++ {
+		if body := fmt.Sprintf("writer %d", i); !seen[body] {
+			t.Errorf("no revision has body <%s>", body)
+		}
+	}
+	if body, last := readPasteBody(t, p), readRevisionBody(t, revs[writers-1]); body != last {
+		t.Errorf("paste has body <%s>; its last revision has <%s>", body, last)
+	}
+}
+
+func TestPastePurgeRemovesRevisions(t *testing.T) {
+	p, err := broker.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "doomed")
+
+	if err := p.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.PurgePaste(p.GetID()); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	broker.(*dbBroker).Model(&dbPasteRevision{}).Where("paste_id = ?", p.GetID().String()).Count(&n)
+	if n != 0 {
+		t.Errorf("%d revisions survived erasure", n)
+	}
+}
+
+func TestPasteForks(t *testing.T) {
+	parent, err := broker.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Erase()
+	writePasteBody(t, parent, "original")
+
+	fork, err := broker.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fork.Erase()
+	fork.SetParentID(parent.GetID())
+	writePasteBody(t, fork, "changed")
+
+	encryptedFork, err := broker.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encryptedFork.Erase()
+	encryptedFork.SetParentID(parent.GetID())
+	writePasteBody(t, encryptedFork, "secret")
+
+	fork, err = broker.GetPaste(fork.GetID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.GetParentID() != parent.GetID() {
+		t.Errorf("fork has parent %q; expected %q", fork.GetParentID(), parent.GetID())
+	}
+
+	forks, err := parent.GetForks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forks) != 2 {
+		t.Fatalf("expected 2 forks, got %d", len(forks))
+	}
+	if forks[0].GetID() != fork.GetID() || forks[0].GetTitle() != fork.GetTitle() {
+		t.Errorf("first fork was %v; expected %v", forks[0].GetID(), fork.GetID())
+	}
+	if _, ok := forks[1].(*encryptedPastePlaceholder); !ok || forks[1].GetID() != encryptedFork.GetID() {
+		t.Errorf("encrypted fork %v was not a placeholder", forks[1].GetID())
+	}
+
+	if forks, err := fork.GetForks(); len(forks) != 0 || err != nil {
+		t.Errorf("fork has %d forks of its own (%v)", len(forks), err)
+	}
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
+)
+
+// defaultPasteViewFlushInterval is how long a database broker gathers views before it
+// writes them out.
+const defaultPasteViewFlushInterval = 30 * time.Second
+
+type pasteViews struct {
+	count int64
+	last  time.Time
+}
+
+func (v *pasteViews) add(o pasteViews) {
+	v.count += o.count
+	if o.last.After(v.last) {
+		v.last = o.last
+	}
+}
+
+// pasteViewCounter gathers the views of pastes in memory and writes them to the database
+// a batch at a time, so that reading a paste doesn't mean writing its row. Views that
+// haven't been written out when the process exits are lost.
+type pasteViewCounter struct {
+	db       *gorm.DB
+	interval time.Duration
+
+	mu        sync.Mutex
+	pending   map[PasteID]pasteViews
+	flushing  bool
+	lastFlush time.Time
+}
+
+func newPasteViewCounter(db *gorm.DB, interval time.Duration) *pasteViewCounter {
+	return &pasteViewCounter{
+		db:        db,
+		interval:  interval,
+		pending:   make(map[PasteID]pasteViews),
+		lastFlush: time.Now(),
+	}
+}
+
+// record counts a view of paste id. With no interval, the view is written immediately.
+func (c *pasteViewCounter) record(id PasteID) {
+	view := pasteViews{count: 1, last: time.Now()}
+	if c.interval <= 0 {
+		if err := c.write(map[PasteID]pasteViews{id: view}); err != nil {
+			glog.Errorf("failed to count a view of paste %s: %v", id, err)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := c.pending[id]
+	v.add(view)
+	c.pending[id] = v
+
+	if !c.flushing && view.last.Sub(c.lastFlush) >= c.interval {
+		batch := c.pending
+		c.pending = make(map[PasteID]pasteViews)
+		c.flushing = true
+		c.lastFlush = view.last
+		go c.flush(batch)
+	}
+}
+
+func (c *pasteViewCounter) flush(batch map[PasteID]pasteViews) {
+	err := c.write(batch)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushing = false
+	if err != nil {
+		// Try again with the next batch.
+		glog.Errorf("failed to count the views of %d pastes: %v", len(batch), err)
+		for id, views := range batch {
+			v := c.pending[id]
+			v.add(views)
+			c.pending[id] = v
+		}
+	}
+}
+
+func (c *pasteViewCounter) write(batch map[PasteID]pasteViews) error {
+	tx := c.db.Begin()
+	for id, v := range batch {
+		err := tx.Model(&dbPaste{}).Where("id = ?", id.String()).UpdateColumns(map[string]interface{}{
+			"view_count":     gorm.Expr("view_count + ?", v.count),
+			"last_viewed_at": v.last,
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// forget drops the views not yet written for paste id, so that they aren't counted against
+// another paste given the same ID.
+func (c *pasteViewCounter) forget(id PasteID) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPasteViewsAreBatched(t *testing.T) {
+	b, err := NewDatabaseBroker("sqlite3", openTestDatabase(t), &noopChallengeProvider{}, DatabaseBrokerViewFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	views := b.(*dbBroker).views
+
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.RecordPasteView(p.GetID())
+	b.RecordPasteView(p.GetID())
+
+	p, _ = b.GetPaste(p.GetID(), nil)
+	if p.GetViewCount() != 0 {
+		t.Errorf("views written before the flush interval passed (%d)", p.GetViewCount())
+	}
+
+	views.mu.Lock()
+	views.lastFlush = time.Time{}
+	views.mu.Unlock()
+	b.RecordPasteView(p.GetID())
+
+	for i := 0; i < 100; i++ {
+		views.mu.Lock()
+		flushing := views.flushing
+		views.mu.Unlock()
+		if !flushing {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p, _ = b.GetPaste(p.GetID(), nil)
+	if p.GetViewCount() != 3 {
+		t.Errorf("expected 3 views once flushed, got %d", p.GetViewCount())
+	}
+}
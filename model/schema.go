@@ -0,0 +1,227 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// schemaMigration describes one numbered step in the evolution of the database schema.
+// Up and Down hold the statements, keyed by dialect, that apply and revert it.
+// MySQL commits every schema change as it is made, so a migration that fails part of
+// the way through there has to be cleaned up by hand.
+type schemaMigration struct {
+	Version int
+	Name    string
+	Up      map[string][]string
+	Down    map[string][]string
+}
+
+// schemaDialects lists every dialect for which migrations must be provided.
+var schemaDialects = []string{"sqlite3", "postgres", "mysql"}
+
+// everyDialect returns a statement map that runs the same statements on every dialect.
+func everyDialect(statements ...string) map[string][]string {
+	m := make(map[string][]string, len(schemaDialects))
+	for _, d := range schemaDialects {
+		m[d] = statements
+	}
+	return m
+}
+
+const createSchemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (version integer PRIMARY KEY, name varchar(255) NOT NULL, applied_at timestamp NOT NULL)`
+
+// LatestSchemaVersion returns the newest schema version this build knows how to use.
+func LatestSchemaVersion() int {
+	return len(schemaMigrations)
+}
+
+// SchemaVersionError is returned when a database's schema is not at the version this build expects.
+type SchemaVersionError struct {
+	Current, Expected int
+}
+
+func (e SchemaVersionError) Error() string {
+	if e.Current > e.Expected {
+		return fmt.Sprintf("model: database schema version %d is newer than the newest this build understands (%d)", e.Current, e.Expected)
+	}
+	return fmt.Sprintf("model: database schema version %d is out of date (expected %d); it must be migrated", e.Current, e.Expected)
+}
+
+// SchemaMigrationStatus reports whether a single migration has been applied to a database.
+type SchemaMigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+
+	// Unknown is set for migrations recorded in the database but not known to this build.
+	Unknown bool
+}
+
+// A SchemaMigrator applies and reverts numbered schema migrations, recording its
+// progress in the schema_version table.
+type SchemaMigrator struct {
+	db      *gorm.DB
+	dialect string
+}
+
+// NewSchemaMigrator returns a SchemaMigrator for the provided database.
+func NewSchemaMigrator(dialect string, sqlDb *sql.DB) (*SchemaMigrator, error) {
+	db, err := gorm.Open(dialect, sqlDb)
+	if err != nil {
+		return nil, err
+	}
+	return newSchemaMigrator(dialect, db)
+}
+
+func newSchemaMigrator(dialect string, db *gorm.DB) (*SchemaMigrator, error) {
+	if _, ok := schemaMigrations[0].Up[dialect]; !ok {
+		return nil, errors.New("model: no schema migrations for dialect " + dialect)
+	}
+
+	if _, err := db.CommonDB().Exec(createSchemaVersionTable); err != nil {
+		return nil, err
+	}
+
+	return &SchemaMigrator{
+		db:      db,
+		dialect: dialect,
+	}, nil
+}
+
+// Version returns the schema version of the database; 0 means no migrations have been applied.
+func (m *SchemaMigrator) Version() (int, error) {
+	var version int
+	err := m.db.Raw("SELECT COALESCE(MAX(version), 0) FROM schema_version").Row().Scan(&version)
+	return version, err
+}
+
+// Check returns a SchemaVersionError unless the database is at LatestSchemaVersion.
+func (m *SchemaMigrator) Check() error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if version != LatestSchemaVersion() {
+		return SchemaVersionError{version, LatestSchemaVersion()}
+	}
+	return nil
+}
+
+// Status returns the state of every known migration, as well as any the database has
+// applied that are unknown to this build.
+func (m *SchemaMigrator) Status() ([]SchemaMigrationStatus, error) {
+	rows, err := m.db.Raw("SELECT version, name, applied_at FROM schema_version ORDER BY version").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make([]SchemaMigrationStatus, len(schemaMigrations))
+	for i, migration := range schemaMigrations {
+		statuses[i] = SchemaMigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+		}
+	}
+
+	for rows.Next() {
+		var status SchemaMigrationStatus
+		if err := rows.Scan(&status.Version, &status.Name, &status.AppliedAt); err != nil {
+			return nil, err
+		}
+		status.Applied = true
+
+		if status.Version >= 1 && status.Version <= len(statuses) {
+			statuses[status.Version-1].Applied = true
+			statuses[status.Version-1].AppliedAt = status.AppliedAt
+		} else {
+			status.Unknown = true
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, rows.Err()
+}
+
+// Up applies, in order, every migration newer than the database's current version up to
+// and including target. A target of 0 migrates to LatestSchemaVersion.
+func (m *SchemaMigrator) Up(target int) error {
+	if target == 0 {
+		target = LatestSchemaVersion()
+	}
+
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+
+	if version > LatestSchemaVersion() {
+		return SchemaVersionError{version, LatestSchemaVersion()}
+	}
+
+	if target < version || target > LatestSchemaVersion() {
+		return fmt.Errorf("model: can't migrate up from schema version %d to %d", version, target)
+	}
+
+	for _, migration := range schemaMigrations[version:target] {
+		if err := m.apply(&migration, migration.Up[m.dialect], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down reverts, newest first, every applied migration newer than target.
+func (m *SchemaMigrator) Down(target int) error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+
+	if version > LatestSchemaVersion() {
+		return SchemaVersionError{version, LatestSchemaVersion()}
+	}
+
+	if target < 0 || target > version {
+		return fmt.Errorf("model: can't migrate down from schema version %d to %d", version, target)
+	}
+
+	for i := version; i > target; i-- {
+		migration := &schemaMigrations[i-1]
+		if err := m.apply(migration, migration.Down[m.dialect], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *SchemaMigrator) apply(migration *schemaMigration, statements []string, up bool) error {
+	tx := m.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.CommonDB().Exec(statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("model: schema migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	var err error
+	if up {
+		err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)", migration.Version, migration.Name, time.Now().UTC()).Error
+	} else {
+		err = tx.Exec("DELETE FROM schema_version WHERE version = ?", migration.Version).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
@@ -0,0 +1,723 @@
+package model
+
+// schemaMigrations holds every schema migration, in order. A migration's Version must
+// be its 1-based position in this list. Migrations that have shipped must never be edited;
+// add a new one instead.
+var schemaMigrations = []schemaMigration{
+	{
+		// Version 1 matches the schema previously produced by gorm's AutoMigrate, and
+		// adopts databases created that way.
+		Version: 1,
+		Name:    "initial schema",
+		Up: map[string][]string{
+			"sqlite3": {
+				`CREATE TABLE IF NOT EXISTS "db_pastes" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer, PRIMARY KEY ("id"))`,
+				`CREATE TABLE IF NOT EXISTS "db_paste_bodies" ("paste_id" varchar(256) UNIQUE,"data" blob, PRIMARY KEY ("paste_id"))`,
+				`CREATE TABLE IF NOT EXISTS "db_users" ("id" integer primary key autoincrement,"updated_at" datetime,"name" varchar(512),"salt" blob,"challenge" blob,"source" integer,"permissions" bigint)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS uix_db_users_name ON "db_users"("name")`,
+				`CREATE TABLE IF NOT EXISTS "db_user_paste_permissions" ("user_id" integer,"paste_id" varchar(256),"permissions" bigint)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS uix_user_paste_perm ON "db_user_paste_permissions"(user_id, paste_id)`,
+				`CREATE TABLE IF NOT EXISTS "db_grants" ("id" varchar(256) UNIQUE,"paste_id" varchar(256), PRIMARY KEY ("id"))`,
+				`CREATE INDEX IF NOT EXISTS idx_grant_by_paste ON "db_grants"(paste_id)`,
+			},
+			"postgres": {
+				`CREATE TABLE IF NOT EXISTS "db_pastes" ("id" varchar(256) UNIQUE,"created_at" timestamp with time zone,"updated_at" timestamp with time zone,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" bytea,"encryption_salt" bytea,"encryption_method" integer, PRIMARY KEY ("id"))`,
+				`CREATE TABLE IF NOT EXISTS "db_paste_bodies" ("paste_id" varchar(256) UNIQUE,"data" bytea, PRIMARY KEY ("paste_id"))`,
+				`CREATE TABLE IF NOT EXISTS "db_users" ("id" serial,"updated_at" timestamp with time zone,"name" varchar(512),"salt" bytea,"challenge" bytea,"source" integer,"permissions" bigint, PRIMARY KEY ("id"))`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS uix_db_users_name ON "db_users"("name")`,
+				`CREATE TABLE IF NOT EXISTS "db_user_paste_permissions" ("user_id" integer,"paste_id" varchar(256),"permissions" bigint)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS uix_user_paste_perm ON "db_user_paste_permissions"(user_id, paste_id)`,
+				`CREATE TABLE IF NOT EXISTS "db_grants" ("id" varchar(256) UNIQUE,"paste_id" varchar(256), PRIMARY KEY ("id"))`,
+				`CREATE INDEX IF NOT EXISTS idx_grant_by_paste ON "db_grants"(paste_id)`,
+			},
+			"mysql": {
+				"CREATE TABLE IF NOT EXISTS `db_pastes` (`id` varchar(191) NOT NULL,`created_at` datetime NULL,`updated_at` datetime NULL,`title` text,`language_name` varchar(128) DEFAULT 'text',`expiration` varchar(64),`hmac` blob,`encryption_salt` blob,`encryption_method` integer, PRIMARY KEY (`id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+				"CREATE TABLE IF NOT EXISTS `db_paste_bodies` (`paste_id` varchar(191) NOT NULL,`data` longblob, PRIMARY KEY (`paste_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+				"CREATE TABLE IF NOT EXISTS `db_users` (`id` int unsigned AUTO_INCREMENT,`updated_at` datetime NULL,`name` varchar(191),`salt` blob,`challenge` blob,`source` integer,`permissions` bigint, PRIMARY KEY (`id`), UNIQUE INDEX uix_db_users_name (`name`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+				"CREATE TABLE IF NOT EXISTS `db_user_paste_permissions` (`user_id` int unsigned,`paste_id` varchar(191),`permissions` bigint, UNIQUE INDEX uix_user_paste_perm (`user_id`,`paste_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+				"CREATE TABLE IF NOT EXISTS `db_grants` (`id` varchar(191) NOT NULL,`paste_id` varchar(191), PRIMARY KEY (`id`), INDEX idx_grant_by_paste (`paste_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+			},
+		},
+		Down: everyDialect(
+			`DROP TABLE IF EXISTS db_grants`,
+			`DROP TABLE IF EXISTS db_user_paste_permissions`,
+			`DROP TABLE IF EXISTS db_users`,
+			`DROP TABLE IF EXISTS db_paste_bodies`,
+			`DROP TABLE IF EXISTS db_pastes`,
+		),
+	},
+	{
+		Version: 2,
+		Name:    "paste revisions",
+		Up: map[string][]string{
+			"sqlite3": {
+				`CREATE TABLE "db_paste_revisions" ("id" integer primary key autoincrement,"paste_id" varchar(256) NOT NULL,"revision" integer NOT NULL,"created_at" datetime,"editor_user_id" integer NOT NULL DEFAULT 0,"editor_session" varchar(64) NOT NULL DEFAULT '',"title" text,"language_name" varchar(128),"data" blob)`,
+				`CREATE UNIQUE INDEX uix_paste_revision ON "db_paste_revisions"(paste_id, revision)`,
+				// Existing pastes start their history with their current body, whose author is unknown.
+				`INSERT INTO "db_paste_revisions" (paste_id, revision, created_at, title, language_name, data) SELECT p.id, 1, p.updated_at, p.title, p.language_name, b.data FROM "db_pastes" p JOIN "db_paste_bodies" b ON b.paste_id = p.id`,
+			},
+			"postgres": {
+				`CREATE TABLE "db_paste_revisions" ("id" serial,"paste_id" varchar(256) NOT NULL,"revision" integer NOT NULL,"created_at" timestamp with time zone,"editor_user_id" integer NOT NULL DEFAULT 0,"editor_session" varchar(64) NOT NULL DEFAULT '',"title" text,"language_name" varchar(128),"data" bytea, PRIMARY KEY ("id"))`,
+				`CREATE UNIQUE INDEX uix_paste_revision ON "db_paste_revisions"(paste_id, revision)`,
+				// Existing pastes start their history with their current body, whose author is unknown.
+				`INSERT INTO "db_paste_revisions" (paste_id, revision, created_at, title, language_name, data) SELECT p.id, 1, p.updated_at, p.title, p.language_name, b.data FROM "db_pastes" p JOIN "db_paste_bodies" b ON b.paste_id = p.id`,
+			},
+			"mysql": {
+				"CREATE TABLE `db_paste_revisions` (`id` int unsigned AUTO_INCREMENT,`paste_id` varchar(191) NOT NULL,`revision` integer NOT NULL,`created_at` datetime NULL,`editor_user_id` int unsigned NOT NULL DEFAULT 0,`editor_session` varchar(64) NOT NULL DEFAULT '',`title` text,`language_name` varchar(128),`data` longblob, PRIMARY KEY (`id`), UNIQUE INDEX uix_paste_revision (`paste_id`,`revision`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+				// Existing pastes start their history with their current body, whose author is unknown.
+				"INSERT INTO `db_paste_revisions` (paste_id, revision, created_at, title, language_name, data) SELECT p.id, 1, p.updated_at, p.title, p.language_name, b.data FROM `db_pastes` p JOIN `db_paste_bodies` b ON b.paste_id = p.id",
+			},
+		},
+		Down: everyDialect(
+			`DROP TABLE db_paste_revisions`,
+		),
+	},
+	{
+		Version: 3,
+		Name:    "paste lineage",
+		Up: map[string][]string{
+			"sqlite3": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "parent_id" varchar(256)`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "parent_id" varchar(256)`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` ADD COLUMN `parent_id` varchar(191), ADD INDEX idx_paste_parent (`parent_id`)",
+			},
+		},
+		Down: map[string][]string{
+			// This version of SQLite cannot drop columns; rebuild the table without it.
+			"sqlite3": {
+				`CREATE TABLE "db_pastes_v2" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer, PRIMARY KEY ("id"))`,
+				`INSERT INTO "db_pastes_v2" SELECT id, created_at, updated_at, title, language_name, expiration, hmac, encryption_salt, encryption_method FROM "db_pastes"`,
+				`DROP TABLE "db_pastes"`,
+				`ALTER TABLE "db_pastes_v2" RENAME TO "db_pastes"`,
+			},
+			"postgres": {
+				`DROP INDEX idx_paste_parent`,
+				`ALTER TABLE "db_pastes" DROP COLUMN "parent_id"`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` DROP INDEX idx_paste_parent, DROP COLUMN `parent_id`",
+			},
+		},
+	},
+	{
+		// The full-text index is kept up to date by the database: by triggers feeding an
+		// external-content FTS4 table on SQLite, by a tsvector column on PostgreSQL, and by
+		// a FULLTEXT index on MySQL.
+		Version: 4,
+		Name:    "paste search",
+		Up: map[string][]string{
+			"sqlite3": {
+				`CREATE TABLE "db_paste_search_documents" ("id" integer primary key autoincrement,"paste_id" varchar(256) NOT NULL UNIQUE,"title" text,"body" text)`,
+				`CREATE VIRTUAL TABLE "db_paste_search_documents_fts" USING fts4(content="db_paste_search_documents", title, body, tokenize=unicode61)`,
+				`CREATE TRIGGER db_paste_search_documents_bu BEFORE UPDATE ON "db_paste_search_documents" BEGIN DELETE FROM "db_paste_search_documents_fts" WHERE docid = old.rowid; END`,
+				`CREATE TRIGGER db_paste_search_documents_bd BEFORE DELETE ON "db_paste_search_documents" BEGIN DELETE FROM "db_paste_search_documents_fts" WHERE docid = old.rowid; END`,
+				`CREATE TRIGGER db_paste_search_documents_au AFTER UPDATE ON "db_paste_search_documents" BEGIN INSERT INTO "db_paste_search_documents_fts"(docid, title, body) VALUES (new.rowid, new.title, new.body); END`,
+				`CREATE TRIGGER db_paste_search_documents_ai AFTER INSERT ON "db_paste_search_documents" BEGIN INSERT INTO "db_paste_search_documents_fts"(docid, title, body) VALUES (new.rowid, new.title, new.body); END`,
+				`INSERT INTO "db_paste_search_documents" (paste_id, title, body) SELECT p.id, p.title, CAST(b.data AS text) FROM "db_pastes" p JOIN "db_paste_bodies" b ON b.paste_id = p.id WHERE COALESCE(p.encryption_method, 0) = 0`,
+			},
+			"postgres": {
+				`CREATE TABLE "db_paste_search_documents" ("paste_id" varchar(256),"title" text,"body" text,"fts" tsvector, PRIMARY KEY ("paste_id"))`,
+				`CREATE INDEX idx_paste_search_fts ON "db_paste_search_documents" USING gin(fts)`,
+				`CREATE TRIGGER db_paste_search_documents_fts BEFORE INSERT OR UPDATE ON "db_paste_search_documents" FOR EACH ROW EXECUTE PROCEDURE tsvector_update_trigger(fts, 'pg_catalog.simple', title, body)`,
+				`INSERT INTO "db_paste_search_documents" (paste_id, title, body) SELECT p.id, p.title, convert_from(b.data, 'UTF8') FROM "db_pastes" p JOIN "db_paste_bodies" b ON b.paste_id = p.id WHERE COALESCE(p.encryption_method, 0) = 0`,
+			},
+			"mysql": {
+				"CREATE TABLE `db_paste_search_documents` (`paste_id` varchar(191) NOT NULL,`title` text,`body` longtext, PRIMARY KEY (`paste_id`), FULLTEXT INDEX fts (`title`,`body`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+				"INSERT INTO `db_paste_search_documents` (paste_id, title, body) SELECT p.id, p.title, CONVERT(b.data USING utf8mb4) FROM `db_pastes` p JOIN `db_paste_bodies` b ON b.paste_id = p.id WHERE COALESCE(p.encryption_method, 0) = 0",
+			},
+		},
+		Down: map[string][]string{
+			"sqlite3": {
+				`DROP TABLE "db_paste_search_documents_fts"`,
+				`DROP TABLE "db_paste_search_documents"`,
+			},
+			"postgres": {
+				`DROP TABLE "db_paste_search_documents"`,
+			},
+			"mysql": {
+				"DROP TABLE `db_paste_search_documents`",
+			},
+		},
+	},
+	{
+		// Bodies become sequences of chunks, so that they can be streamed in and out of the
+		// database. Reverting joins each body's chunks back together, and restores the bodies
+		// of revisions written since.
+		Version: 5,
+		Name:    "chunked paste bodies",
+		Up: map[string][]string{
+			"sqlite3": {
+				`CREATE TABLE "db_paste_body_chunks" ("paste_id" varchar(256) NOT NULL,"seq" integer NOT NULL,"data" blob, PRIMARY KEY ("paste_id","seq"))`,
+				`INSERT INTO "db_paste_body_chunks" (paste_id, seq, data) SELECT paste_id, 0, data FROM "db_paste_bodies"`,
+				`DROP TABLE "db_paste_bodies"`,
+			},
+			"postgres": {
+				`CREATE TABLE "db_paste_body_chunks" ("paste_id" varchar(256) NOT NULL,"seq" integer NOT NULL,"data" bytea, PRIMARY KEY ("paste_id","seq"))`,
+				`INSERT INTO "db_paste_body_chunks" (paste_id, seq, data) SELECT paste_id, 0, data FROM "db_paste_bodies"`,
+				`DROP TABLE "db_paste_bodies"`,
+			},
+			"mysql": {
+				"CREATE TABLE `db_paste_body_chunks` (`paste_id` varchar(191) NOT NULL,`seq` integer NOT NULL,`data` longblob, PRIMARY KEY (`paste_id`,`seq`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+				"INSERT INTO `db_paste_body_chunks` (paste_id, seq, data) SELECT paste_id, 0, data FROM `db_paste_bodies`",
+				"DROP TABLE `db_paste_bodies`",
+			},
+		},
+		Down: map[string][]string{
+			"sqlite3": {
+				`CREATE TABLE "db_paste_bodies" ("paste_id" varchar(256) UNIQUE,"data" blob, PRIMARY KEY ("paste_id"))`,
+				// group_concat stops at the first NUL in a blob; || does not.
+				`WITH RECURSIVE joined(paste_id, seq, data) AS (SELECT paste_id, seq, data FROM "db_paste_body_chunks" WHERE seq = 0 UNION ALL SELECT c.paste_id, c.seq, joined.data || c.data FROM joined JOIN "db_paste_body_chunks" c ON c.paste_id = joined.paste_id AND c.seq = joined.seq + 1) INSERT INTO "db_paste_bodies" (paste_id, data) SELECT paste_id, CAST(data AS blob) FROM joined WHERE seq = (SELECT MAX(seq) FROM "db_paste_body_chunks" c WHERE c.paste_id = joined.paste_id)`,
+				`UPDATE "db_paste_revisions" SET data = (SELECT b.data FROM "db_paste_bodies" b WHERE b.paste_id = "db_paste_revisions".paste_id || '@' || "db_paste_revisions".revision) WHERE data IS NULL`,
+				`DELETE FROM "db_paste_bodies" WHERE paste_id LIKE '%@%'`,
+				`DROP TABLE "db_paste_body_chunks"`,
+			},
+			"postgres": {
+				`CREATE TABLE "db_paste_bodies" ("paste_id" varchar(256) UNIQUE,"data" bytea, PRIMARY KEY ("paste_id"))`,
+				`INSERT INTO "db_paste_bodies" (paste_id, data) SELECT paste_id, string_agg(data, ''::bytea ORDER BY seq) FROM "db_paste_body_chunks" GROUP BY paste_id`,
+				`UPDATE "db_paste_revisions" SET data = b.data FROM "db_paste_bodies" b WHERE b.paste_id = "db_paste_revisions".paste_id || '@' || "db_paste_revisions".revision AND "db_paste_revisions".data IS NULL`,
+				`DELETE FROM "db_paste_bodies" WHERE paste_id LIKE '%@%'`,
+				`DROP TABLE "db_paste_body_chunks"`,
+			},
+			"mysql": {
+				"CREATE TABLE `db_paste_bodies` (`paste_id` varchar(191) NOT NULL,`data` longblob, PRIMARY KEY (`paste_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+				"SET SESSION group_concat_max_len = 4294967295",
+				"INSERT INTO `db_paste_bodies` (paste_id, data) SELECT paste_id, GROUP_CONCAT(data ORDER BY seq SEPARATOR '') FROM `db_paste_body_chunks` GROUP BY paste_id",
+				"UPDATE `db_paste_revisions` r JOIN `db_paste_bodies` b ON b.paste_id = CONCAT(r.paste_id, '@', r.revision) SET r.data = b.data WHERE r.data IS NULL",
+				"DELETE FROM `db_paste_bodies` WHERE paste_id LIKE '%@%'",
+				"DROP TABLE `db_paste_body_chunks`",
+			},
+		},
+	},
+	{
+		// Pastes record who created them, how large they are and how often they are read.
+		// Creators are taken from first revisions, and sizes from bodies kept in the database;
+		// line counts are left for the next write.
+		Version: 6,
+		Name:    "paste metadata",
+		Up: map[string][]string{
+			"sqlite3": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "creator_user_id" integer NOT NULL DEFAULT 0`,
+				`ALTER TABLE "db_pastes" ADD COLUMN "creator_session" varchar(64) NOT NULL DEFAULT ''`,
+				`ALTER TABLE "db_pastes" ADD COLUMN "size" bigint`,
+				`ALTER TABLE "db_pastes" ADD COLUMN "line_count" bigint`,
+				`ALTER TABLE "db_pastes" ADD COLUMN "view_count" bigint NOT NULL DEFAULT 0`,
+				`ALTER TABLE "db_pastes" ADD COLUMN "last_viewed_at" datetime`,
+				`UPDATE "db_pastes" SET creator_user_id = COALESCE((SELECT r.editor_user_id FROM "db_paste_revisions" r WHERE r.paste_id = "db_pastes".id AND r.revision = 1), 0), creator_session = COALESCE((SELECT r.editor_session FROM "db_paste_revisions" r WHERE r.paste_id = "db_pastes".id AND r.revision = 1), '')`,
+				`UPDATE "db_pastes" SET size = (SELECT SUM(LENGTH(c.data)) FROM "db_paste_body_chunks" c WHERE c.paste_id = "db_pastes".id)`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "creator_user_id" integer NOT NULL DEFAULT 0, ADD COLUMN "creator_session" varchar(64) NOT NULL DEFAULT '', ADD COLUMN "size" bigint, ADD COLUMN "line_count" bigint, ADD COLUMN "view_count" bigint NOT NULL DEFAULT 0, ADD COLUMN "last_viewed_at" timestamp with time zone`,
+				`UPDATE "db_pastes" SET creator_user_id = r.editor_user_id, creator_session = r.editor_session FROM "db_paste_revisions" r WHERE r.paste_id = "db_pastes".id AND r.revision = 1`,
+				`UPDATE "db_pastes" SET size = (SELECT SUM(LENGTH(c.data)) FROM "db_paste_body_chunks" c WHERE c.paste_id = "db_pastes".id)`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` ADD COLUMN `creator_user_id` int unsigned NOT NULL DEFAULT 0, ADD COLUMN `creator_session` varchar(64) NOT NULL DEFAULT '', ADD COLUMN `size` bigint, ADD COLUMN `line_count` bigint, ADD COLUMN `view_count` bigint NOT NULL DEFAULT 0, ADD COLUMN `last_viewed_at` datetime NULL",
+				"UPDATE `db_pastes` p JOIN `db_paste_revisions` r ON r.paste_id = p.id AND r.revision = 1 SET p.creator_user_id = r.editor_user_id, p.creator_session = r.editor_session",
+				"UPDATE `db_pastes` p JOIN (SELECT paste_id, SUM(LENGTH(data)) AS size FROM `db_paste_body_chunks` GROUP BY paste_id) c ON c.paste_id = p.id SET p.size = c.size",
+			},
+		},
+		Down: map[string][]string{
+			// This version of SQLite cannot drop columns; rebuild the table without them.
+			"sqlite3": {
+				`CREATE TABLE "db_pastes_v5" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer,"parent_id" varchar(256), PRIMARY KEY ("id"))`,
+				`INSERT INTO "db_pastes_v5" SELECT id, created_at, updated_at, title, language_name, expiration, hmac, encryption_salt, encryption_method, parent_id FROM "db_pastes"`,
+				`DROP TABLE "db_pastes"`,
+				`ALTER TABLE "db_pastes_v5" RENAME TO "db_pastes"`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" DROP COLUMN "creator_user_id", DROP COLUMN "creator_session", DROP COLUMN "size", DROP COLUMN "line_count", DROP COLUMN "view_count", DROP COLUMN "last_viewed_at"`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` DROP COLUMN `creator_user_id`, DROP COLUMN `creator_session`, DROP COLUMN `size`, DROP COLUMN `line_count`, DROP COLUMN `view_count`, DROP COLUMN `last_viewed_at`",
+			},
+		},
+	},
+	{
+		// Erased pastes are kept until they are purged, marked by the time they were erased.
+		Version: 7,
+		Name:    "paste trash",
+		Up: map[string][]string{
+			"sqlite3": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "trashed_at" datetime`,
+				`CREATE INDEX idx_paste_trashed ON "db_pastes"(trashed_at)`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "trashed_at" timestamp with time zone`,
+				`CREATE INDEX idx_paste_trashed ON "db_pastes"(trashed_at)`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` ADD COLUMN `trashed_at` datetime NULL, ADD INDEX idx_paste_trashed (`trashed_at`)",
+			},
+		},
+		Down: map[string][]string{
+			// Pastes in the trash were erased; reverting destroys them, leaving their bodies behind.
+			// This version of SQLite cannot drop columns; rebuild the table without it.
+			"sqlite3": {
+				`DELETE FROM "db_paste_revisions" WHERE paste_id IN (SELECT id FROM "db_pastes" WHERE trashed_at IS NOT NULL)`,
+				`DELETE FROM "db_paste_search_documents" WHERE paste_id IN (SELECT id FROM "db_pastes" WHERE trashed_at IS NOT NULL)`,
+				`CREATE TABLE "db_pastes_v6" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer,"parent_id" varchar(256),"creator_user_id" integer NOT NULL DEFAULT 0,"creator_session" varchar(64) NOT NULL DEFAULT '',"size" bigint,"line_count" bigint,"view_count" bigint NOT NULL DEFAULT 0,"last_viewed_at" datetime, PRIMARY KEY ("id"))`,
+				`INSERT INTO "db_pastes_v6" SELECT id, created_at, updated_at, title, language_name, expiration, hmac, encryption_salt, encryption_method, parent_id, creator_user_id, creator_session, size, line_count, view_count, last_viewed_at FROM "db_pastes" WHERE trashed_at IS NULL`,
+				`DROP TABLE "db_pastes"`,
+				`ALTER TABLE "db_pastes_v6" RENAME TO "db_pastes"`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+			},
+			"postgres": {
+				`DELETE FROM "db_paste_revisions" WHERE paste_id IN (SELECT id FROM "db_pastes" WHERE trashed_at IS NOT NULL)`,
+				`DELETE FROM "db_paste_search_documents" WHERE paste_id IN (SELECT id FROM "db_pastes" WHERE trashed_at IS NOT NULL)`,
+				`DELETE FROM "db_pastes" WHERE trashed_at IS NOT NULL`,
+				`DROP INDEX idx_paste_trashed`,
+				`ALTER TABLE "db_pastes" DROP COLUMN "trashed_at"`,
+			},
+			"mysql": {
+				"DELETE FROM `db_paste_revisions` WHERE paste_id IN (SELECT id FROM `db_pastes` WHERE trashed_at IS NOT NULL)",
+				"DELETE FROM `db_paste_search_documents` WHERE paste_id IN (SELECT id FROM `db_pastes` WHERE trashed_at IS NOT NULL)",
+				"DELETE FROM `db_pastes` WHERE trashed_at IS NOT NULL",
+				"ALTER TABLE `db_pastes` DROP INDEX idx_paste_trashed, DROP COLUMN `trashed_at`",
+			},
+		},
+	},
+	{
+		// Attachment bodies are kept in the paste body store; see attachmentBodyID.
+		Version: 8,
+		Name:    "paste attachments",
+		Up: map[string][]string{
+			"sqlite3": {
+				`CREATE TABLE "db_paste_attachments" ("id" integer primary key autoincrement,"paste_id" varchar(256) NOT NULL,"name" varchar(256) NOT NULL,"created_at" datetime,"mime_type" varchar(128) NOT NULL DEFAULT '',"size" bigint NOT NULL DEFAULT 0,"body_key" varchar(32) NOT NULL)`,
+				`CREATE UNIQUE INDEX uix_paste_attachment ON "db_paste_attachments"(paste_id, name)`,
+			},
+			"postgres": {
+				`CREATE TABLE "db_paste_attachments" ("id" serial,"paste_id" varchar(256) NOT NULL,"name" varchar(256) NOT NULL,"created_at" timestamp with time zone,"mime_type" varchar(128) NOT NULL DEFAULT '',"size" bigint NOT NULL DEFAULT 0,"body_key" varchar(32) NOT NULL, PRIMARY KEY ("id"))`,
+				`CREATE UNIQUE INDEX uix_paste_attachment ON "db_paste_attachments"(paste_id, name)`,
+			},
+			"mysql": {
+				"CREATE TABLE `db_paste_attachments` (`id` int unsigned AUTO_INCREMENT,`paste_id` varchar(191) NOT NULL,`name` varchar(191) NOT NULL,`created_at` datetime NULL,`mime_type` varchar(128) NOT NULL DEFAULT '',`size` bigint NOT NULL DEFAULT 0,`body_key` varchar(32) NOT NULL, PRIMARY KEY (`id`), UNIQUE INDEX uix_paste_attachment (`paste_id`,`name`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+			},
+		},
+		// Reverting forgets every attachment, leaving their bodies behind.
+		Down: everyDialect(
+			`DROP TABLE db_paste_attachments`,
+		),
+	},
+	{
+		// Pastes and revisions record how their bodies are compressed. Bodies written before
+		// were not compressed.
+		Version: 9,
+		Name:    "paste compression",
+		Up: map[string][]string{
+			"sqlite3": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "compression_method" integer NOT NULL DEFAULT 0`,
+				`ALTER TABLE "db_paste_revisions" ADD COLUMN "compression_method" integer NOT NULL DEFAULT 0`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "compression_method" integer NOT NULL DEFAULT 0`,
+				`ALTER TABLE "db_paste_revisions" ADD COLUMN "compression_method" integer NOT NULL DEFAULT 0`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` ADD COLUMN `compression_method` int unsigned NOT NULL DEFAULT 0",
+				"ALTER TABLE `db_paste_revisions` ADD COLUMN `compression_method` int unsigned NOT NULL DEFAULT 0",
+			},
+		},
+		// Reverting leaves compressed bodies unreadable; decompress them first by running the
+		// body compressor with compression turned off.
+		Down: map[string][]string{
+			// This version of SQLite cannot drop columns; rebuild the tables without them.
+			"sqlite3": {
+				`CREATE TABLE "db_pastes_v8" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer,"parent_id" varchar(256),"creator_user_id" integer NOT NULL DEFAULT 0,"creator_session" varchar(64) NOT NULL DEFAULT '',"size" bigint,"line_count" bigint,"view_count" bigint NOT NULL DEFAULT 0,"last_viewed_at" datetime,"trashed_at" datetime, PRIMARY KEY ("id"))`,
+				`INSERT INTO "db_pastes_v8" SELECT id, created_at, updated_at, title, language_name, expiration, hmac, encryption_salt, encryption_method, parent_id, creator_user_id, creator_session, size, line_count, view_count, last_viewed_at, trashed_at FROM "db_pastes"`,
+				`DROP TABLE "db_pastes"`,
+				`ALTER TABLE "db_pastes_v8" RENAME TO "db_pastes"`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+				`CREATE INDEX idx_paste_trashed ON "db_pastes"(trashed_at)`,
+				`CREATE TABLE "db_paste_revisions_v8" ("id" integer primary key autoincrement,"paste_id" varchar(256) NOT NULL,"revision" integer NOT NULL,"created_at" datetime,"editor_user_id" integer NOT NULL DEFAULT 0,"editor_session" varchar(64) NOT NULL DEFAULT '',"title" text,"language_name" varchar(128),"data" blob)`,
+				`INSERT INTO "db_paste_revisions_v8" SELECT id, paste_id, revision, created_at, editor_user_id, editor_session, title, language_name, data FROM "db_paste_revisions"`,
+				`DROP TABLE "db_paste_revisions"`,
+				`ALTER TABLE "db_paste_revisions_v8" RENAME TO "db_paste_revisions"`,
+				`CREATE UNIQUE INDEX uix_paste_revision ON "db_paste_revisions"(paste_id, revision)`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" DROP COLUMN "compression_method"`,
+				`ALTER TABLE "db_paste_revisions" DROP COLUMN "compression_method"`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` DROP COLUMN `compression_method`",
+				"ALTER TABLE `db_paste_revisions` DROP COLUMN `compression_method`",
+			},
+		},
+	},
+	{
+		// Unencrypted bodies are shared by every paste and revision with the same content, and
+		// kept in the paste body store by their SHA-256. Bodies written before stay where they are.
+		Version: 10,
+		Name:    "shared paste bodies",
+		Up: map[string][]string{
+			"sqlite3": {
+				`CREATE TABLE "db_paste_blobs" ("hash" varchar(64),"ref_count" bigint NOT NULL DEFAULT 0,"compression_method" integer NOT NULL DEFAULT 0,"created_at" datetime, PRIMARY KEY ("hash"))`,
+				`ALTER TABLE "db_pastes" ADD COLUMN "body_hash" varchar(64)`,
+				`ALTER TABLE "db_paste_revisions" ADD COLUMN "body_hash" varchar(64)`,
+				`CREATE INDEX idx_paste_body_hash ON "db_pastes"(body_hash)`,
+				`CREATE INDEX idx_paste_revision_body_hash ON "db_paste_revisions"(body_hash)`,
+			},
+			"postgres": {
+				`CREATE TABLE "db_paste_blobs" ("hash" varchar(64),"ref_count" bigint NOT NULL DEFAULT 0,"compression_method" integer NOT NULL DEFAULT 0,"created_at" timestamp with time zone, PRIMARY KEY ("hash"))`,
+				`ALTER TABLE "db_pastes" ADD COLUMN "body_hash" varchar(64)`,
+				`ALTER TABLE "db_paste_revisions" ADD COLUMN "body_hash" varchar(64)`,
+				`CREATE INDEX idx_paste_body_hash ON "db_pastes"(body_hash)`,
+				`CREATE INDEX idx_paste_revision_body_hash ON "db_paste_revisions"(body_hash)`,
+			},
+			"mysql": {
+				"CREATE TABLE `db_paste_blobs` (`hash` varchar(64) NOT NULL,`ref_count` bigint NOT NULL DEFAULT 0,`compression_method` int unsigned NOT NULL DEFAULT 0,`created_at` datetime NULL, PRIMARY KEY (`hash`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+				"ALTER TABLE `db_pastes` ADD COLUMN `body_hash` varchar(64), ADD INDEX idx_paste_body_hash (`body_hash`)",
+				"ALTER TABLE `db_paste_revisions` ADD COLUMN `body_hash` varchar(64), ADD INDEX idx_paste_revision_body_hash (`body_hash`)",
+			},
+		},
+		// Reverting gives every paste and revision its own copy of the body it shares, if bodies
+		// are kept in the database. Other stores are left with the shared bodies, which become
+		// unreachable; move bodies to the database first.
+		Down: map[string][]string{
+			// This version of SQLite cannot drop columns; rebuild the tables without them.
+			"sqlite3": {
+				`DELETE FROM "db_paste_body_chunks" WHERE paste_id IN (SELECT id FROM "db_pastes" WHERE body_hash IS NOT NULL)`,
+				`INSERT INTO "db_paste_body_chunks" (paste_id, seq, data) SELECT p.id, c.seq, c.data FROM "db_pastes" p JOIN "db_paste_body_chunks" c ON c.paste_id = '=' || p.body_hash`,
+				`INSERT INTO "db_paste_body_chunks" (paste_id, seq, data) SELECT r.paste_id || '@' || r.revision, c.seq, c.data FROM "db_paste_revisions" r JOIN "db_paste_body_chunks" c ON c.paste_id = '=' || r.body_hash`,
+				`DELETE FROM "db_paste_body_chunks" WHERE paste_id LIKE '=%'`,
+				`DROP TABLE "db_paste_blobs"`,
+				`CREATE TABLE "db_pastes_v9" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer,"parent_id" varchar(256),"creator_user_id" integer NOT NULL DEFAULT 0,"creator_session" varchar(64) NOT NULL DEFAULT '',"size" bigint,"line_count" bigint,"view_count" bigint NOT NULL DEFAULT 0,"last_viewed_at" datetime,"trashed_at" datetime,"compression_method" integer NOT NULL DEFAULT 0, PRIMARY KEY ("id"))`,
+				`INSERT INTO "db_pastes_v9" SELECT id, created_at, updated_at, title, language_name, expiration, hmac, encryption_salt, encryption_method, parent_id, creator_user_id, creator_session, size, line_count, view_count, last_viewed_at, trashed_at, compression_method FROM "db_pastes"`,
+				`DROP TABLE "db_pastes"`,
+				`ALTER TABLE "db_pastes_v9" RENAME TO "db_pastes"`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+				`CREATE INDEX idx_paste_trashed ON "db_pastes"(trashed_at)`,
+				`CREATE TABLE "db_paste_revisions_v9" ("id" integer primary key autoincrement,"paste_id" varchar(256) NOT NULL,"revision" integer NOT NULL,"created_at" datetime,"editor_user_id" integer NOT NULL DEFAULT 0,"editor_session" varchar(64) NOT NULL DEFAULT '',"title" text,"language_name" varchar(128),"data" blob,"compression_method" integer NOT NULL DEFAULT 0)`,
+				`INSERT INTO "db_paste_revisions_v9" SELECT id, paste_id, revision, created_at, editor_user_id, editor_session, title, language_name, data, compression_method FROM "db_paste_revisions"`,
+				`DROP TABLE "db_paste_revisions"`,
+				`ALTER TABLE "db_paste_revisions_v9" RENAME TO "db_paste_revisions"`,
+				`CREATE UNIQUE INDEX uix_paste_revision ON "db_paste_revisions"(paste_id, revision)`,
+			},
+			"postgres": {
+				`DELETE FROM "db_paste_body_chunks" WHERE paste_id IN (SELECT id FROM "db_pastes" WHERE body_hash IS NOT NULL)`,
+				`INSERT INTO "db_paste_body_chunks" (paste_id, seq, data) SELECT p.id, c.seq, c.data FROM "db_pastes" p JOIN "db_paste_body_chunks" c ON c.paste_id = '=' || p.body_hash`,
+				`INSERT INTO "db_paste_body_chunks" (paste_id, seq, data) SELECT r.paste_id || '@' || r.revision, c.seq, c.data FROM "db_paste_revisions" r JOIN "db_paste_body_chunks" c ON c.paste_id = '=' || r.body_hash`,
+				`DELETE FROM "db_paste_body_chunks" WHERE paste_id LIKE '=%'`,
+				`DROP TABLE "db_paste_blobs"`,
+				`DROP INDEX idx_paste_body_hash`,
+				`DROP INDEX idx_paste_revision_body_hash`,
+				`ALTER TABLE "db_pastes" DROP COLUMN "body_hash"`,
+				`ALTER TABLE "db_paste_revisions" DROP COLUMN "body_hash"`,
+			},
+			"mysql": {
+				"DELETE FROM `db_paste_body_chunks` WHERE paste_id IN (SELECT id FROM `db_pastes` WHERE body_hash IS NOT NULL)",
+				"INSERT INTO `db_paste_body_chunks` (paste_id, seq, data) SELECT p.id, c.seq, c.data FROM `db_pastes` p JOIN `db_paste_body_chunks` c ON c.paste_id = CONCAT('=', p.body_hash)",
+				"INSERT INTO `db_paste_body_chunks` (paste_id, seq, data) SELECT CONCAT(r.paste_id, '@', r.revision), c.seq, c.data FROM `db_paste_revisions` r JOIN `db_paste_body_chunks` c ON c.paste_id = CONCAT('=', r.body_hash)",
+				"DELETE FROM `db_paste_body_chunks` WHERE paste_id LIKE '=%'",
+				"DROP TABLE `db_paste_blobs`",
+				"ALTER TABLE `db_pastes` DROP INDEX idx_paste_body_hash, DROP COLUMN `body_hash`",
+				"ALTER TABLE `db_paste_revisions` DROP INDEX idx_paste_revision_body_hash, DROP COLUMN `body_hash`",
+			},
+		},
+	},
+	{
+		// Encrypted pastes can be encrypted again under a new key, with their bodies and those of
+		// their revisions kept under new names, and marked to be re-encrypted when next unlocked.
+		Version: 11,
+		Name:    "paste re-encryption",
+		Up: map[string][]string{
+			"sqlite3": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "body_key" varchar(32) NOT NULL DEFAULT ''`,
+				`ALTER TABLE "db_pastes" ADD COLUMN "reencrypt_method" integer NOT NULL DEFAULT 0`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "body_key" varchar(32) NOT NULL DEFAULT ''`,
+				`ALTER TABLE "db_pastes" ADD COLUMN "reencrypt_method" integer NOT NULL DEFAULT 0`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` ADD COLUMN `body_key` varchar(32) NOT NULL DEFAULT '', ADD COLUMN `reencrypt_method` int unsigned NOT NULL DEFAULT 0",
+			},
+		},
+		// Reverting gives re-encrypted bodies back their old names, if bodies are kept in the
+		// database. Other stores are left with the renamed bodies, which become unreachable;
+		// move bodies to the database first. Pastes waiting to be re-encrypted are left alone.
+		Down: map[string][]string{
+			// This version of SQLite cannot drop columns; rebuild the table without them.
+			"sqlite3": {
+				`DELETE FROM "db_paste_body_chunks" WHERE paste_id IN (SELECT substr(paste_id, 1, instr(paste_id, '.') - 1) FROM "db_paste_body_chunks" WHERE instr(paste_id, '.') > 0)`,
+				`UPDATE "db_paste_body_chunks" SET paste_id = substr(paste_id, 1, instr(paste_id, '.') - 1) WHERE instr(paste_id, '.') > 0`,
+				`CREATE TABLE "db_pastes_v10" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer,"parent_id" varchar(256),"creator_user_id" integer NOT NULL DEFAULT 0,"creator_session" varchar(64) NOT NULL DEFAULT '',"size" bigint,"line_count" bigint,"view_count" bigint NOT NULL DEFAULT 0,"last_viewed_at" datetime,"trashed_at" datetime,"compression_method" integer NOT NULL DEFAULT 0,"body_hash" varchar(64), PRIMARY KEY ("id"))`,
+				`INSERT INTO "db_pastes_v10" SELECT id, created_at, updated_at, title, language_name, expiration, hmac, encryption_salt, encryption_method, parent_id, creator_user_id, creator_session, size, line_count, view_count, last_viewed_at, trashed_at, compression_method, body_hash FROM "db_pastes"`,
+				`DROP TABLE "db_pastes"`,
+				`ALTER TABLE "db_pastes_v10" RENAME TO "db_pastes"`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+				`CREATE INDEX idx_paste_trashed ON "db_pastes"(trashed_at)`,
+				`CREATE INDEX idx_paste_body_hash ON "db_pastes"(body_hash)`,
+			},
+			"postgres": {
+				`DELETE FROM "db_paste_body_chunks" WHERE paste_id IN (SELECT split_part(paste_id, '.', 1) FROM "db_paste_body_chunks" WHERE paste_id LIKE '%.%')`,
+				`UPDATE "db_paste_body_chunks" SET paste_id = split_part(paste_id, '.', 1) WHERE paste_id LIKE '%.%'`,
+				`ALTER TABLE "db_pastes" DROP COLUMN "body_key"`,
+				`ALTER TABLE "db_pastes" DROP COLUMN "reencrypt_method"`,
+			},
+			"mysql": {
+				"DELETE c FROM `db_paste_body_chunks` c JOIN (SELECT DISTINCT SUBSTRING_INDEX(paste_id, '.', 1) AS old_id FROM `db_paste_body_chunks` WHERE paste_id LIKE '%.%') r ON c.paste_id = r.old_id",
+				"UPDATE `db_paste_body_chunks` SET paste_id = SUBSTRING_INDEX(paste_id, '.', 1) WHERE paste_id LIKE '%.%'",
+				"ALTER TABLE `db_pastes` DROP COLUMN `body_key`, DROP COLUMN `reencrypt_method`",
+			},
+		},
+	},
+	{
+		// The function and parameters each encrypted paste's key and each user's challenge were
+		// derived with are recorded; empty is what was always used before (see crypto.LegacyKDF).
+		Version: 12,
+		Name:    "key derivation parameters",
+		Up: map[string][]string{
+			"sqlite3": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "kdf" varchar(128) NOT NULL DEFAULT ''`,
+				`ALTER TABLE "db_users" ADD COLUMN "kdf" varchar(128) NOT NULL DEFAULT ''`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "kdf" varchar(128) NOT NULL DEFAULT ''`,
+				`ALTER TABLE "db_users" ADD COLUMN "kdf" varchar(128) NOT NULL DEFAULT ''`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` ADD COLUMN `kdf` varchar(128) NOT NULL DEFAULT ''",
+				"ALTER TABLE `db_users` ADD COLUMN `kdf` varchar(128) NOT NULL DEFAULT ''",
+			},
+		},
+		// Pastes and users whose keys were derived with anything but the legacy parameters
+		// can't be unlocked once this is reverted.
+		Down: map[string][]string{
+			// This version of SQLite cannot drop columns; rebuild the tables without them.
+			"sqlite3": {
+				`CREATE TABLE "db_pastes_v11" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer,"parent_id" varchar(256),"creator_user_id" integer NOT NULL DEFAULT 0,"creator_session" varchar(64) NOT NULL DEFAULT '',"size" bigint,"line_count" bigint,"view_count" bigint NOT NULL DEFAULT 0,"last_viewed_at" datetime,"trashed_at" datetime,"compression_method" integer NOT NULL DEFAULT 0,"body_hash" varchar(64),"body_key" varchar(32) NOT NULL DEFAULT '',"reencrypt_method" integer NOT NULL DEFAULT 0, PRIMARY KEY ("id"))`,
+				`INSERT INTO "db_pastes_v11" SELECT id, created_at, updated_at, title, language_name, expiration, hmac, encryption_salt, encryption_method, parent_id, creator_user_id, creator_session, size, line_count, view_count, last_viewed_at, trashed_at, compression_method, body_hash, body_key, reencrypt_method FROM "db_pastes"`,
+				`DROP TABLE "db_pastes"`,
+				`ALTER TABLE "db_pastes_v11" RENAME TO "db_pastes"`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+				`CREATE INDEX idx_paste_trashed ON "db_pastes"(trashed_at)`,
+				`CREATE INDEX idx_paste_body_hash ON "db_pastes"(body_hash)`,
+				`CREATE TABLE "db_users_v11" ("id" integer primary key autoincrement,"updated_at" datetime,"name" varchar(512),"salt" blob,"challenge" blob,"source" integer,"permissions" bigint)`,
+				`INSERT INTO "db_users_v11" SELECT id, updated_at, name, salt, challenge, source, permissions FROM "db_users"`,
+				`DROP TABLE "db_users"`,
+				`ALTER TABLE "db_users_v11" RENAME TO "db_users"`,
+				`CREATE UNIQUE INDEX uix_db_users_name ON "db_users"("name")`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" DROP COLUMN "kdf"`,
+				`ALTER TABLE "db_users" DROP COLUMN "kdf"`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` DROP COLUMN `kdf`",
+				"ALTER TABLE `db_users` DROP COLUMN `kdf`",
+			},
+		},
+	},
+	{
+		// The titles and languages of pastes encrypted by the server, and of their revisions,
+		// are sealed under the pastes' keys instead of being kept in the clear.
+		Version: 13,
+		Name:    "sealed paste metadata",
+		Up: map[string][]string{
+			"sqlite3": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "metadata" blob`,
+				`ALTER TABLE "db_paste_revisions" ADD COLUMN "metadata" blob`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "metadata" bytea`,
+				`ALTER TABLE "db_paste_revisions" ADD COLUMN "metadata" bytea`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` ADD COLUMN `metadata` blob",
+				"ALTER TABLE `db_paste_revisions` ADD COLUMN `metadata` blob",
+			},
+		},
+		// Sealed titles and languages can't be unsealed without the pastes' keys, and are lost
+		// once this is reverted.
+		Down: map[string][]string{
+			// This version of SQLite cannot drop columns; rebuild the tables without them.
+			"sqlite3": {
+				`CREATE TABLE "db_pastes_v12" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer,"parent_id" varchar(256),"creator_user_id" integer NOT NULL DEFAULT 0,"creator_session" varchar(64) NOT NULL DEFAULT '',"size" bigint,"line_count" bigint,"view_count" bigint NOT NULL DEFAULT 0,"last_viewed_at" datetime,"trashed_at" datetime,"compression_method" integer NOT NULL DEFAULT 0,"body_hash" varchar(64),"body_key" varchar(32) NOT NULL DEFAULT '',"reencrypt_method" integer NOT NULL DEFAULT 0,"kdf" varchar(128) NOT NULL DEFAULT '', PRIMARY KEY ("id"))`,
+				`INSERT INTO "db_pastes_v12" SELECT id, created_at, updated_at, title, language_name, expiration, hmac, encryption_salt, encryption_method, parent_id, creator_user_id, creator_session, size, line_count, view_count, last_viewed_at, trashed_at, compression_method, body_hash, body_key, reencrypt_method, kdf FROM "db_pastes"`,
+				`DROP TABLE "db_pastes"`,
+				`ALTER TABLE "db_pastes_v12" RENAME TO "db_pastes"`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+				`CREATE INDEX idx_paste_trashed ON "db_pastes"(trashed_at)`,
+				`CREATE INDEX idx_paste_body_hash ON "db_pastes"(body_hash)`,
+				`CREATE TABLE "db_paste_revisions_v12" ("id" integer primary key autoincrement,"paste_id" varchar(256) NOT NULL,"revision" integer NOT NULL,"created_at" datetime,"editor_user_id" integer NOT NULL DEFAULT 0,"editor_session" varchar(64) NOT NULL DEFAULT '',"title" text,"language_name" varchar(128),"data" blob,"compression_method" integer NOT NULL DEFAULT 0,"body_hash" varchar(64))`,
+				`INSERT INTO "db_paste_revisions_v12" SELECT id, paste_id, revision, created_at, editor_user_id, editor_session, title, language_name, data, compression_method, body_hash FROM "db_paste_revisions"`,
+				`DROP TABLE "db_paste_revisions"`,
+				`ALTER TABLE "db_paste_revisions_v12" RENAME TO "db_paste_revisions"`,
+				`CREATE UNIQUE INDEX uix_paste_revision ON "db_paste_revisions"(paste_id, revision)`,
+				`CREATE INDEX idx_paste_revision_body_hash ON "db_paste_revisions"(body_hash)`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" DROP COLUMN "metadata"`,
+				`ALTER TABLE "db_paste_revisions" DROP COLUMN "metadata"`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` DROP COLUMN `metadata`",
+				"ALTER TABLE `db_paste_revisions` DROP COLUMN `metadata`",
+			},
+		},
+	},
+	{
+		// Each write of an encrypted paste keeps its bodies under names of its own, which its
+		// revision records; those written before were named by the paste's key.
+		Version: 14,
+		Name:    "revision body keys",
+		Up: map[string][]string{
+			"sqlite3": {
+				`ALTER TABLE "db_paste_revisions" ADD COLUMN "body_key" varchar(32) NOT NULL DEFAULT ''`,
+				`UPDATE "db_paste_revisions" SET body_key = (SELECT body_key FROM "db_pastes" WHERE "db_pastes".id = "db_paste_revisions".paste_id) WHERE paste_id IN (SELECT id FROM "db_pastes" WHERE body_key <> '')`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_paste_revisions" ADD COLUMN "body_key" varchar(32) NOT NULL DEFAULT ''`,
+				`UPDATE "db_paste_revisions" SET body_key = p.body_key FROM "db_pastes" p WHERE p.id = "db_paste_revisions".paste_id AND p.body_key <> ''`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_paste_revisions` ADD COLUMN `body_key` varchar(32) NOT NULL DEFAULT ''",
+				"UPDATE `db_paste_revisions` r JOIN `db_pastes` p ON p.id = r.paste_id SET r.body_key = p.body_key WHERE p.body_key <> ''",
+			},
+		},
+		// The bodies of revisions of encrypted pastes written since can't be found once this is
+		// reverted.
+		Down: map[string][]string{
+			// This version of SQLite cannot drop columns; rebuild the table without it.
+			"sqlite3": {
+				`CREATE TABLE "db_paste_revisions_v13" ("id" integer primary key autoincrement,"paste_id" varchar(256) NOT NULL,"revision" integer NOT NULL,"created_at" datetime,"editor_user_id" integer NOT NULL DEFAULT 0,"editor_session" varchar(64) NOT NULL DEFAULT '',"title" text,"language_name" varchar(128),"data" blob,"compression_method" integer NOT NULL DEFAULT 0,"body_hash" varchar(64),"metadata" blob)`,
+				`INSERT INTO "db_paste_revisions_v13" SELECT id, paste_id, revision, created_at, editor_user_id, editor_session, title, language_name, data, compression_method, body_hash, metadata FROM "db_paste_revisions"`,
+				`DROP TABLE "db_paste_revisions"`,
+				`ALTER TABLE "db_paste_revisions_v13" RENAME TO "db_paste_revisions"`,
+				`CREATE UNIQUE INDEX uix_paste_revision ON "db_paste_revisions"(paste_id, revision)`,
+				`CREATE INDEX idx_paste_revision_body_hash ON "db_paste_revisions"(body_hash)`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_paste_revisions" DROP COLUMN "body_key"`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_paste_revisions` DROP COLUMN `body_key`",
+			},
+		},
+	},
+	{
+		// The sizes and line counts of pastes encrypted by the server gave away the lengths of
+		// their bodies, and are no longer recorded.
+		Version: 15,
+		Name:    "encrypted paste sizes",
+		Up: map[string][]string{
+			"sqlite3": {
+				`UPDATE "db_pastes" SET size = NULL, line_count = NULL WHERE encryption_method IN (1, 2, 3)`,
+			},
+			"postgres": {
+				`UPDATE "db_pastes" SET size = NULL, line_count = NULL WHERE encryption_method IN (1, 2, 3)`,
+			},
+			"mysql": {
+				"UPDATE `db_pastes` SET size = NULL, line_count = NULL WHERE encryption_method IN (1, 2, 3)",
+			},
+		},
+		// The counts can't be recovered; they are recorded again as the pastes are written.
+		Down: map[string][]string{
+			"sqlite3": {
+				`UPDATE "db_pastes" SET size = NULL, line_count = NULL WHERE encryption_method IN (1, 2, 3)`,
+			},
+			"postgres": {
+				`UPDATE "db_pastes" SET size = NULL, line_count = NULL WHERE encryption_method IN (1, 2, 3)`,
+			},
+			"mysql": {
+				"UPDATE `db_pastes` SET size = NULL, line_count = NULL WHERE encryption_method IN (1, 2, 3)",
+			},
+		},
+	},
+	{
+		// Whether a paste, or a revision, is a bundle of files is recorded when it is written
+		// instead of being read from its body. Bundles written before are shown as the text
+		// of their bodies until they are next written.
+		Version: 16,
+		Name:    "paste bundles",
+		Up: map[string][]string{
+			"sqlite3": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "bundle" boolean NOT NULL DEFAULT 0`,
+				`ALTER TABLE "db_paste_revisions" ADD COLUMN "bundle" boolean NOT NULL DEFAULT 0`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "bundle" boolean NOT NULL DEFAULT false`,
+				`ALTER TABLE "db_paste_revisions" ADD COLUMN "bundle" boolean NOT NULL DEFAULT false`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` ADD COLUMN `bundle` boolean NOT NULL DEFAULT false",
+				"ALTER TABLE `db_paste_revisions` ADD COLUMN `bundle` boolean NOT NULL DEFAULT false",
+			},
+		},
+		Down: map[string][]string{
+			// This version of SQLite cannot drop columns; rebuild the tables without them.
+			"sqlite3": {
+				`CREATE TABLE "db_pastes_v15" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer,"parent_id" varchar(256),"creator_user_id" integer NOT NULL DEFAULT 0,"creator_session" varchar(64) NOT NULL DEFAULT '',"size" bigint,"line_count" bigint,"view_count" bigint NOT NULL DEFAULT 0,"last_viewed_at" datetime,"trashed_at" datetime,"compression_method" integer NOT NULL DEFAULT 0,"body_hash" varchar(64),"body_key" varchar(32) NOT NULL DEFAULT '',"reencrypt_method" integer NOT NULL DEFAULT 0,"kdf" varchar(128) NOT NULL DEFAULT '',"metadata" blob, PRIMARY KEY ("id"))`,
+				`INSERT INTO "db_pastes_v15" SELECT id, created_at, updated_at, title, language_name, expiration, hmac, encryption_salt, encryption_method, parent_id, creator_user_id, creator_session, size, line_count, view_count, last_viewed_at, trashed_at, compression_method, body_hash, body_key, reencrypt_method, kdf, metadata FROM "db_pastes"`,
+				`DROP TABLE "db_pastes"`,
+				`ALTER TABLE "db_pastes_v15" RENAME TO "db_pastes"`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+				`CREATE INDEX idx_paste_trashed ON "db_pastes"(trashed_at)`,
+				`CREATE INDEX idx_paste_body_hash ON "db_pastes"(body_hash)`,
+				`CREATE TABLE "db_paste_revisions_v15" ("id" integer primary key autoincrement,"paste_id" varchar(256) NOT NULL,"revision" integer NOT NULL,"created_at" datetime,"editor_user_id" integer NOT NULL DEFAULT 0,"editor_session" varchar(64) NOT NULL DEFAULT '',"title" text,"language_name" varchar(128),"data" blob,"compression_method" integer NOT NULL DEFAULT 0,"body_hash" varchar(64),"metadata" blob,"body_key" varchar(32) NOT NULL DEFAULT '')`,
+				`INSERT INTO "db_paste_revisions_v15" SELECT id, paste_id, revision, created_at, editor_user_id, editor_session, title, language_name, data, compression_method, body_hash, metadata, body_key FROM "db_paste_revisions"`,
+				`DROP TABLE "db_paste_revisions"`,
+				`ALTER TABLE "db_paste_revisions_v15" RENAME TO "db_paste_revisions"`,
+				`CREATE UNIQUE INDEX uix_paste_revision ON "db_paste_revisions"(paste_id, revision)`,
+				`CREATE INDEX idx_paste_revision_body_hash ON "db_paste_revisions"(body_hash)`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" DROP COLUMN "bundle"`,
+				`ALTER TABLE "db_paste_revisions" DROP COLUMN "bundle"`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` DROP COLUMN `bundle`",
+				"ALTER TABLE `db_paste_revisions` DROP COLUMN `bundle`",
+			},
+		},
+	},
+	{
+		// The body of an encrypted paste is stored once, as that of its latest revision,
+		// instead of being stored again under a name of its own. Pastes written before keep
+		// both until they are next written. Bodies kept in the database are stored under the
+		// pastes' names again when this is undone; others are left for versions before this
+		// one to find missing.
+		Version: 17,
+		Name:    "paste body revisions",
+		Up: map[string][]string{
+			"sqlite3": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "body_revision" bigint`,
+			},
+			"postgres": {
+				`ALTER TABLE "db_pastes" ADD COLUMN "body_revision" bigint`,
+			},
+			"mysql": {
+				"ALTER TABLE `db_pastes` ADD COLUMN `body_revision` bigint",
+			},
+		},
+		Down: map[string][]string{
+			// This version of SQLite cannot drop columns; rebuild the table without it.
+			"sqlite3": {
+				`INSERT INTO "db_paste_body_chunks" (paste_id, seq, data) SELECT p.id || '.' || p.body_key, c.seq, c.data FROM "db_pastes" p JOIN "db_paste_body_chunks" c ON c.paste_id = p.id || '@' || p.body_revision || '.' || p.body_key WHERE p.body_revision IS NOT NULL AND p.body_key <> ''`,
+				`CREATE TABLE "db_pastes_v16" ("id" varchar(256) UNIQUE,"created_at" datetime,"updated_at" datetime,"title" text,"language_name" varchar(128) DEFAULT 'text',"expiration" varchar(64),"hmac" blob,"encryption_salt" blob,"encryption_method" integer,"parent_id" varchar(256),"creator_user_id" integer NOT NULL DEFAULT 0,"creator_session" varchar(64) NOT NULL DEFAULT '',"size" bigint,"line_count" bigint,"view_count" bigint NOT NULL DEFAULT 0,"last_viewed_at" datetime,"trashed_at" datetime,"compression_method" integer NOT NULL DEFAULT 0,"body_hash" varchar(64),"body_key" varchar(32) NOT NULL DEFAULT '',"reencrypt_method" integer NOT NULL DEFAULT 0,"kdf" varchar(128) NOT NULL DEFAULT '',"metadata" blob,"bundle" boolean NOT NULL DEFAULT 0, PRIMARY KEY ("id"))`,
+				`INSERT INTO "db_pastes_v16" SELECT id, created_at, updated_at, title, language_name, expiration, hmac, encryption_salt, encryption_method, parent_id, creator_user_id, creator_session, size, line_count, view_count, last_viewed_at, trashed_at, compression_method, body_hash, body_key, reencrypt_method, kdf, metadata, bundle FROM "db_pastes"`,
+				`DROP TABLE "db_pastes"`,
+				`ALTER TABLE "db_pastes_v16" RENAME TO "db_pastes"`,
+				`CREATE INDEX idx_paste_parent ON "db_pastes"(parent_id)`,
+				`CREATE INDEX idx_paste_trashed ON "db_pastes"(trashed_at)`,
+				`CREATE INDEX idx_paste_body_hash ON "db_pastes"(body_hash)`,
+			},
+			"postgres": {
+				`INSERT INTO "db_paste_body_chunks" (paste_id, seq, data) SELECT p.id || '.' || p.body_key, c.seq, c.data FROM "db_pastes" p JOIN "db_paste_body_chunks" c ON c.paste_id = p.id || '@' || p.body_revision || '.' || p.body_key WHERE p.body_revision IS NOT NULL AND p.body_key <> ''`,
+				`ALTER TABLE "db_pastes" DROP COLUMN "body_revision"`,
+			},
+			"mysql": {
+				"INSERT INTO `db_paste_body_chunks` (paste_id, seq, data) SELECT CONCAT(p.id, '.', p.body_key), c.seq, c.data FROM `db_pastes` p JOIN `db_paste_body_chunks` c ON c.paste_id = CONCAT(p.id, '@', p.body_revision, '.', p.body_key) WHERE p.body_revision IS NOT NULL AND p.body_key <> ''",
+				"ALTER TABLE `db_pastes` DROP COLUMN `body_revision`",
+			},
+		},
+	},
+}
@@ -0,0 +1,331 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func openTestDatabase(t *testing.T) *sql.DB {
+	sqlDb, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a different database.
+	sqlDb.SetMaxOpenConns(1)
+	return sqlDb
+}
+
+func TestSchemaMigrateUpDown(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	m, err := NewSchemaMigrator("sqlite3", sqlDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := m.Version(); v != 0 || err != nil {
+		t.Errorf("fresh database at version %d (%v)", v, err)
+	}
+
+	if err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := m.Version(); v != LatestSchemaVersion() || err != nil {
+		t.Errorf("migrated database at version %d (%v); expected %d", v, err, LatestSchemaVersion())
+	}
+
+	if err := m.Check(); err != nil {
+		t.Error(err)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if !s.Applied || s.Unknown {
+			t.Errorf("migration %d (%s) not applied after Up", s.Version, s.Name)
+		}
+	}
+
+	if err := m.Down(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := m.Version(); v != 0 || err != nil {
+		t.Errorf("reverted database at version %d (%v)", v, err)
+	}
+
+	var n int
+	if err := sqlDb.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'db_pastes'`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("db_pastes survived a full downgrade (%v)", err)
+	}
+}
+
+func TestSchemaAdoptsAutoMigratedDatabase(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	// The models have since grown; the initial migration is what AutoMigrate used to produce.
+	for _, stmt := range schemaMigrations[0].Up["sqlite3"] {
+		if _, err := sqlDb.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sqlDb.Exec(`INSERT INTO db_pastes (id, updated_at, title) VALUES ('old', ?, 'Old Paste')`, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqlDb.Exec(`INSERT INTO db_paste_bodies (paste_id, data) VALUES ('old', 'old body')`); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.GetPaste("old", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := p.GetRevision(1)
+	if err != nil {
+		t.Fatal("existing paste wasn't given an initial revision:", err)
+	}
+	if body := readRevisionBody(t, rev); body != "old body" || rev.GetTitle() != "Old Paste" {
+		t.Errorf("initial revision had body <%s> and title %q", body, rev.GetTitle())
+	}
+	if p.GetSize() != 8 || p.GetLineCount() != -1 || !p.GetCreator().IsAnonymous() {
+		t.Errorf("existing paste has size %d, %d lines and creator %+v", p.GetSize(), p.GetLineCount(), p.GetCreator())
+	}
+
+	found, err := b.SearchPastes(&PasteSearch{Terms: "old body", PasteIDs: []PasteID{"old"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Error("existing paste wasn't indexed for search")
+	}
+}
+
+func TestSchemaRefusesNewerDatabase(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	m, err := NewSchemaMigrator("sqlite3", sqlDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	future := LatestSchemaVersion() + 1
+	if _, err := sqlDb.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`, future, "from the future", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := statuses[len(statuses)-1]; last.Version != future || !last.Unknown {
+		t.Errorf("unknown migration %d not reported in status", future)
+	}
+
+	_, err = NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if verr, ok := err.(SchemaVersionError); !ok || verr.Current != future {
+		t.Errorf("broker accepted a newer schema (err = %v)", err)
+	}
+
+	if err := m.Up(0); err == nil {
+		t.Error("migrated up from a newer schema")
+	}
+}
+
+func TestSchemaManualMigration(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	_, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{}, DatabaseBrokerManualMigration(true))
+	if _, ok := err.(SchemaVersionError); !ok {
+		t.Errorf("broker used an unmigrated database (err = %v)", err)
+	}
+}
+
+func TestSchemaMigrationsCoverEveryDialect(t *testing.T) {
+	for _, migration := range schemaMigrations {
+		for _, dialect := range schemaDialects {
+			if len(migration.Up[dialect]) == 0 {
+				t.Errorf("migration %d (%s) has no %s upgrade", migration.Version, migration.Name, dialect)
+			}
+			if len(migration.Down[dialect]) == 0 {
+				t.Errorf("migration %d (%s) has no %s downgrade", migration.Version, migration.Name, dialect)
+			}
+		}
+	}
+}
+
+func TestSchemaChunkedBodiesRoundTrip(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	m, err := NewSchemaMigrator("sqlite3", sqlDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(4); err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte("binary\x00body\xff")
+	if _, err := sqlDb.Exec(`INSERT INTO db_paste_bodies (paste_id, data) VALUES ('old', ?)`, body); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqlDb.Exec(`INSERT INTO db_paste_body_chunks (paste_id, seq, data) VALUES ('old', 1, 'more')`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Down(4); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	if err := sqlDb.QueryRow(`SELECT data FROM db_paste_bodies WHERE paste_id = 'old'`).Scan(&data); err != nil {
+		t.Fatal(err)
+	}
+	if expected := string(body) + "more"; string(data) != expected {
+		t.Errorf("chunks were rejoined as %q; expected %q", data, expected)
+	}
+}
+
+func TestSchemaSharedBodiesDown(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{}, DatabaseBrokerCompression(PasteCompressionMethodNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "shared")
+
+	m, err := NewSchemaMigrator("sqlite3", sqlDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Down(9); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []PasteID{p.GetID(), revisionBodyID(p.GetID(), 1)} {
+		var data []byte
+		if err := sqlDb.QueryRow(`SELECT data FROM db_paste_body_chunks WHERE paste_id = ?`, id.String()).Scan(&data); err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "shared" {
+			t.Errorf("body %s was copied as %q", id, data)
+		}
+	}
+	var n int
+	if err := sqlDb.QueryRow(`SELECT COUNT(*) FROM db_paste_body_chunks WHERE paste_id LIKE '=%'`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("%d shared body chunks survived (%v)", n, err)
+	}
+}
+
+func TestSchemaReencryptedBodiesDown(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := b.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, p, "secret")
+	if err := b.ReencryptPaste(p.GetID(), []byte("old"), []byte("new"), PasteEncryptionMethodAES_CTR); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewSchemaMigrator("sqlite3", sqlDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Down(10); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	rows, err := sqlDb.Query(`SELECT paste_id FROM db_paste_body_chunks ORDER BY paste_id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if want := []string{p.GetID().String(), revisionBodyID(p.GetID(), 1).String()}; len(ids) != 2 || ids[0] != want[0] || ids[1] != want[1] {
+		t.Errorf("bodies were renamed to %v; expected %v", ids, want)
+	}
+}
+
+func TestSchemaEncryptedPasteSizes(t *testing.T) {
+	sqlDb := openTestDatabase(t)
+	defer sqlDb.Close()
+
+	b, err := NewDatabaseBroker("sqlite3", sqlDb, &noopChallengeProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := b.CreateEncryptedPaste(PasteEncryptionMethodXChaCha20_Poly1305, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, encrypted, "secret")
+	plain, err := b.CreatePaste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePasteBody(t, plain, "public")
+
+	m, err := NewSchemaMigrator("sqlite3", sqlDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Down(14); err != nil {
+		t.Fatal(err)
+	}
+	// Sizes were recorded for every paste before.
+	if _, err := sqlDb.Exec(`UPDATE db_pastes SET size = 6, line_count = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(15); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[PasteID]bool{encrypted.GetID(): false, plain.GetID(): true} {
+		var size, lines sql.NullInt64
+		if err := sqlDb.QueryRow(`SELECT size, line_count FROM db_pastes WHERE id = ?`, id.String()).Scan(&size, &lines); err != nil {
+			t.Fatal(err)
+		}
+		if size.Valid != want || lines.Valid != want {
+			t.Errorf("paste %s has size %v and line count %v", id, size, lines)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"unicode/utf8"
+
+	"github.com/DHowett/ghostbin/lib/bundle"
+	"github.com/DHowett/ghostbin/lib/sql/querybuilder"
+	"github.com/jinzhu/gorm"
+)
+
+// dbPasteSearchDocument is the searchable text of a paste. The full-text index over it is
+// maintained by the database (see schema migration 4).
+type dbPasteSearchDocument struct {
+	PasteID string `gorm:"primary_key;type:varchar(191)"`
+	Title   string
+	Body    string
+}
+
+const dbPasteSearchIndex = "fts"
+
+// maxSearchableBodyLength is how much of the body of a paste is indexed for search.
+const maxSearchableBodyLength = 1024 * 1024
+
+// searchableBody returns the part of a body that is indexed for search. A character cut in
+// half at the end is dropped, so that a text body stays valid UTF-8. The body of a bundle, as
+// its paste says it is, is indexed by the names and contents of its files.
+func searchableBody(r io.Reader, isBundle bool) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, maxSearchableBodyLength))
+	if err != nil {
+		return nil, err
+	}
+	truncated := len(body) == maxSearchableBodyLength
+	if isBundle {
+		body = searchableBundle(body)
+	}
+	if truncated {
+		for i := 0; i < utf8.UTFMax-1 && len(body) > 0 && !utf8.Valid(body); i++ {
+			body = body[:len(body)-1]
+		}
+	}
+	return body, nil
+}
+
+// searchableBundle lists the files in a bundle one after another, each under its name. The
+// bundle may have been cut short; whatever could be read of it is kept.
+func searchableBundle(body []byte) []byte {
+	r, err := bundle.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return body
+	}
+	buf := &bytes.Buffer{}
+	for {
+		f, content, err := r.Next()
+		if err != nil {
+			break
+		}
+		buf.WriteString(f.Name)
+		buf.WriteString("\n")
+		io.Copy(buf, content)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// indexPaste replaces the search document for p within tx.
+func indexPaste(tx *gorm.DB, p *dbPaste, body []byte) error {
+	if err := tx.Delete(dbPasteSearchDocument{}, "paste_id = ?", p.ID).Error; err != nil {
+		return err
+	}
+
+	if p.IsEncrypted() {
+		return nil
+	}
+
+	doc := &dbPasteSearchDocument{
+		PasteID: p.ID,
+		Title:   p.GetTitle(),
+	}
+	// Binary bodies can't be searched (or stored as text); their titles still can.
+	if utf8.Valid(body) {
+		doc.Body = string(body)
+	}
+	return tx.Create(doc).Error
+}
+
+func (broker *dbBroker) SearchPastes(s *PasteSearch) ([]Paste, error) {
+	terms := searchTerms(s.Terms)
+	if terms == "" || (s.UserID == 0 && len(s.PasteIDs) == 0) {
+		return nil, nil
+	}
+
+	match, err := broker.QB.Build(&querybuilder.FullTextMatchQuery{
+		Table:  "db_paste_search_documents",
+		Index:  dbPasteSearchIndex,
+		Fields: []string{"title", "body"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	q := broker.Model(&dbPaste{}).
+		Joins("JOIN db_paste_search_documents ON db_paste_search_documents.paste_id = db_pastes.id").
+		Where(match, terms).
+		Where("db_pastes.trashed_at IS NULL")
+
+	switch {
+	case s.UserID != 0 && len(s.PasteIDs) > 0:
+		q = q.Where("db_pastes.id IN (SELECT paste_id FROM db_user_paste_permissions WHERE user_id = ? AND permissions > 0) OR db_pastes.id IN (?)", s.UserID, pasteIDStrings(s.PasteIDs))
+	case s.UserID != 0:
+		q = q.Where("db_pastes.id IN (SELECT paste_id FROM db_user_paste_permissions WHERE user_id = ? AND permissions > 0)", s.UserID)
+	default:
+		q = q.Where("db_pastes.id IN (?)", pasteIDStrings(s.PasteIDs))
+	}
+
+	if s.LanguageName != "" {
+		q = q.Where("db_pastes.language_name = ?", s.LanguageName)
+	}
+	if !s.ModifiedAfter.IsZero() {
+		q = q.Where("db_pastes.updated_at >= ?", s.ModifiedAfter)
+	}
+	if !s.ModifiedBefore.IsZero() {
+		q = q.Where("db_pastes.updated_at < ?", s.ModifiedBefore)
+	}
+
+	var ps []*dbPaste
+	if err := q.Select("db_pastes.*").Order("db_pastes.updated_at DESC").Limit(s.limit()).Offset(s.Offset).Find(&ps).Error; err != nil {
+		return nil, err
+	}
+	return broker.wrapPastes(ps), nil
+}
+
+func pasteIDStrings(ids []PasteID) []string {
+	stringIDs := make([]string, len(ids))
+	for i, v := range ids {
+		stringIDs[i] = string(v)
+	}
+	return stringIDs
+}
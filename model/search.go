@@ -0,0 +1,54 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPasteSearchLimit = 50
+	MaxPasteSearchLimit     = 200
+)
+
+// PasteSearch describes a full-text search over the titles and bodies of pastes.
+// Encrypted pastes are never indexed, and so are never found.
+type PasteSearch struct {
+	// Terms is the text to search for; every word in it must match.
+	Terms string
+
+	// LanguageName, if set, limits results to pastes in that language.
+	LanguageName string
+	// ModifiedAfter and ModifiedBefore, if not zero, bound the modification time of results.
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+
+	// Results are limited to pastes on which the user identified by UserID holds permissions
+	// and the pastes named in PasteIDs. A search with neither finds nothing.
+	UserID   uint
+	PasteIDs []PasteID
+
+	// Limit defaults to DefaultPasteSearchLimit and may not exceed MaxPasteSearchLimit.
+	Limit  int
+	Offset int
+}
+
+// searchTerms turns free text into a query every backend accepts: a sequence of quoted
+// words, all of which must match. Quotes in the input are discarded. The leading + marks
+// a required word for MySQL; the other backends require every word anyway, and ignore it.
+func searchTerms(text string) string {
+	words := strings.Fields(strings.Replace(text, `"`, " ", -1))
+	for i, w := range words {
+		words[i] = `+"` + w + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+func (s *PasteSearch) limit() int {
+	if s.Limit <= 0 {
+		return DefaultPasteSearchLimit
+	}
+	if s.Limit > MaxPasteSearchLimit {
+		return MaxPasteSearchLimit
+	}
+	return s.Limit
+}
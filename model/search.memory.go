@@ -0,0 +1,100 @@
+package model
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// searchTokens splits text into lower-case words, much as the databases' full-text
+// tokenizers do.
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsPhrase reports whether phrase appears in tokens as a run of consecutive words.
+func containsPhrase(tokens, phrase []string) bool {
+outer:
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		for j, word := range phrase {
+			if tokens[i+j] != word {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// matches reports whether every one of phrases is found in the title or body of the paste.
+// The caller must hold the broker's lock.
+func (record *memoryPasteRecord) matches(phrases [][]string) bool {
+	titleTokens := searchTokens(record.Title)
+	for _, phrase := range phrases {
+		if !containsPhrase(titleTokens, phrase) && !containsPhrase(record.bodyTokens, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+func (broker *memoryBroker) SearchPastes(s *PasteSearch) ([]Paste, error) {
+	// Every quoted word of the terms the database brokers are given is a phrase.
+	var phrases [][]string
+	for _, word := range strings.Fields(strings.Replace(s.Terms, `"`, " ", -1)) {
+		if phrase := searchTokens(word); len(phrase) > 0 {
+			phrases = append(phrases, phrase)
+		}
+	}
+	if len(phrases) == 0 || (s.UserID == 0 && len(s.PasteIDs) == 0) {
+		return nil, nil
+	}
+
+	inScope := make(map[PasteID]bool, len(s.PasteIDs))
+	for _, id := range s.PasteIDs {
+		inScope[id] = true
+	}
+
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+
+	var records []*memoryPasteRecord
+	for id, record := range broker.pastes {
+		if record.IsEncrypted() || record.inTrash() {
+			continue
+		}
+		if !inScope[id] && (s.UserID == 0 || broker.pastePermissions[memoryPastePermissionKey{s.UserID, id}] == 0) {
+			continue
+		}
+		if s.LanguageName != "" && record.LanguageName != s.LanguageName {
+			continue
+		}
+		if !s.ModifiedAfter.IsZero() && record.UpdatedAt.Before(s.ModifiedAfter) {
+			continue
+		}
+		if !s.ModifiedBefore.IsZero() && !record.UpdatedAt.Before(s.ModifiedBefore) {
+			continue
+		}
+		if record.matches(phrases) {
+			records = append(records, record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].UpdatedAt.Equal(records[j].UpdatedAt) {
+			return records[i].UpdatedAt.After(records[j].UpdatedAt)
+		}
+		return records[i].seq > records[j].seq
+	})
+
+	if s.Offset >= len(records) {
+		return nil, nil
+	}
+	records = records[s.Offset:]
+	if limit := s.limit(); len(records) > limit {
+		records = records[:limit]
+	}
+	return broker.wrapPastes(records), nil
+}
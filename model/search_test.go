@@ -0,0 +1,144 @@
+package model
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/DHowett/ghostbin/lib/bundle"
+)
+
+func searchIDs(t *testing.T, s *PasteSearch) map[PasteID]bool {
+	ps, err := broker.SearchPastes(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[PasteID]bool, len(ps))
+	for _, p := range ps {
+		ids[p.GetID()] = true
+	}
+	return ids
+}
+
+func TestPasteSearch(t *testing.T) {
+	// Other tests depend on the order in which users are created, so this one uses
+	// permissions directly.
+	const userID = 4242
+	grant := func(p Paste) {
+		perm := &dbUserPastePermission{UserID: userID, PasteID: p.GetID().String(), Permissions: PastePermissionAll}
+		if err := broker.(*dbBroker).Create(perm).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newPaste := func(title, language, body string) Paste {
+		p, err := broker.CreatePaste()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.SetTitle(title)
+		p.SetLanguageName(language)
+		writePasteBody(t, p, body)
+		return p
+	}
+
+	owned := newPaste("Widget Notes", "go", "the quick brown fox")
+	defer owned.Erase()
+	grant(owned)
+
+	inSession := newPaste("", "text", "quick silver")
+	defer inSession.Erase()
+
+	unrelated := newPaste("", "text", "quick but nobody's")
+	defer unrelated.Erase()
+
+	encrypted, err := broker.CreateEncryptedPaste(PasteEncryptionMethodAES_CTR, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encrypted.Erase()
+	grant(encrypted)
+	writePasteBody(t, encrypted, "quick secret")
+
+	for _, tc := range []struct {
+		name     string
+		search   PasteSearch
+		expected []Paste
+	}{
+		{"User", PasteSearch{Terms: "quick", UserID: userID}, []Paste{owned}},
+		{"Session", PasteSearch{Terms: "quick", PasteIDs: []PasteID{inSession.GetID()}}, []Paste{inSession}},
+		{"UserAndSession", PasteSearch{Terms: "quick", UserID: userID, PasteIDs: []PasteID{inSession.GetID()}}, []Paste{owned, inSession}},
+		{"NoScope", PasteSearch{Terms: "quick"}, nil},
+		{"Title", PasteSearch{Terms: "widget", UserID: userID}, []Paste{owned}},
+		{"AllTermsMustMatch", PasteSearch{Terms: "quick silver", UserID: userID, PasteIDs: []PasteID{inSession.GetID()}}, []Paste{inSession}},
+		{"Quotes", PasteSearch{Terms: `"quick`, UserID: userID}, []Paste{owned}},
+		{"Language", PasteSearch{Terms: "quick", LanguageName: "text", UserID: userID, PasteIDs: []PasteID{inSession.GetID()}}, []Paste{inSession}},
+		{"ModifiedAfter", PasteSearch{Terms: "quick", ModifiedAfter: time.Now().Add(time.Hour), UserID: userID}, nil},
+		{"ModifiedBefore", PasteSearch{Terms: "quick", ModifiedBefore: time.Now().Add(time.Hour), UserID: userID}, []Paste{owned}},
+		{"Encrypted", PasteSearch{Terms: "secret", UserID: userID}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ids := searchIDs(t, &tc.search)
+			if len(ids) != len(tc.expected) {
+				t.Errorf("expected %d results, got %v", len(tc.expected), ids)
+			}
+			for _, p := range tc.expected {
+				if !ids[p.GetID()] {
+					t.Errorf("%v missing from results %v", p.GetID(), ids)
+				}
+			}
+		})
+	}
+
+	t.Run("Update", func(t *testing.T) {
+		writePasteBody(t, owned, "the slow brown fox")
+		if ids := searchIDs(t, &PasteSearch{Terms: "quick", UserID: userID}); len(ids) != 0 {
+			t.Errorf("stale body found: %v", ids)
+		}
+
+		owned.SetTitle("Gadget Notes")
+		if err := owned.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if ids := searchIDs(t, &PasteSearch{Terms: "gadget slow", UserID: userID}); !ids[owned.GetID()] {
+			t.Errorf("retitled paste not found: %v", ids)
+		}
+	})
+
+	t.Run("Bundle", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		bw := bundle.NewWriter(buf)
+		fw, err := bw.CreateFile(bundle.File{Name: "deploy.yml", Language: "yaml"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, "replicas: three")
+		bw.Close()
+		owned.SetBundle(true)
+		writePasteBody(t, owned, buf.String())
+
+		if ids := searchIDs(t, &PasteSearch{Terms: "deploy replicas", UserID: userID}); !ids[owned.GetID()] {
+			t.Errorf("bundle not found by its file's name and content: %v", ids)
+		}
+		if ids := searchIDs(t, &PasteSearch{Terms: "ghostbin", UserID: userID}); len(ids) != 0 {
+			t.Errorf("bundle found by its encoding: %v", ids)
+		}
+
+		// Text that merely looks like a bundle is indexed as it was written.
+		owned.SetBundle(false)
+		writePasteBody(t, owned, buf.String())
+		if ids := searchIDs(t, &PasteSearch{Terms: "ghostbin", UserID: userID}); !ids[owned.GetID()] {
+			t.Errorf("text paste not found by its text: %v", ids)
+		}
+	})
+
+	t.Run("Erase", func(t *testing.T) {
+		if err := inSession.Erase(); err != nil {
+			t.Fatal(err)
+		}
+		if ids := searchIDs(t, &PasteSearch{Terms: "silver", PasteIDs: []PasteID{inSession.GetID()}}); len(ids) != 0 {
+			t.Errorf("erased paste found: %v", ids)
+		}
+	})
+}
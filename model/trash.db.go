@@ -0,0 +1,129 @@
+package model
+
+import "github.com/jinzhu/gorm"
+
+// wrapTrashedPastes binds ps, all of which must be in the trash, to the broker.
+func (broker *dbBroker) wrapTrashedPastes(ps []*dbPaste) []*TrashedPaste {
+	iPastes := broker.wrapPastes(ps)
+	trashed := make([]*TrashedPaste, len(ps))
+	for i, p := range ps {
+		trashed[i] = &TrashedPaste{Paste: iPastes[i], TrashedAt: *p.TrashedAt}
+	}
+	return trashed
+}
+
+func (broker *dbBroker) GetTrash() ([]*TrashedPaste, error) {
+	var ps []*dbPaste
+	if err := broker.Where("trashed_at IS NOT NULL").Order("trashed_at DESC").Find(&ps).Error; err != nil {
+		return nil, err
+	}
+	return broker.wrapTrashedPastes(ps), nil
+}
+
+func (broker *dbBroker) GetTrashedPastes(ids []PasteID) ([]*TrashedPaste, error) {
+	var ps []*dbPaste
+	if err := broker.Where("id IN (?) AND trashed_at IS NOT NULL", pasteIDStrings(ids)).Order("trashed_at DESC").Find(&ps).Error; err != nil {
+		return nil, err
+	}
+	return broker.wrapTrashedPastes(ps), nil
+}
+
+func (broker *dbBroker) RestorePaste(id PasteID) error {
+	db := broker.Model(&dbPaste{}).Where("id = ? AND trashed_at IS NOT NULL", id.String()).UpdateColumns(map[string]interface{}{
+		"trashed_at": nil,
+		"expiration": nil,
+	})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return PasteNotFoundError
+	}
+
+	var paste dbPaste
+	if err := broker.Find(&paste, "id = ?", id.String()).Error; err != nil {
+		return err
+	}
+	broker.publish(PasteRestoredEvent, broker.wrapPastes([]*dbPaste{&paste})[0])
+	return nil
+}
+
+func (broker *dbBroker) PurgePaste(id PasteID) error {
+	var paste dbPaste
+	if err := broker.Find(&paste, "id = ? AND trashed_at IS NOT NULL", id.String()).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return PasteNotFoundError
+		}
+		return err
+	}
+
+	var revisions []*dbPasteRevision
+	if err := broker.Select("paste_id, revision, body_hash, body_key").Where("paste_id = ?", paste.ID).Find(&revisions).Error; err != nil {
+		return err
+	}
+	// Shared bodies lose a reference for the paste and for each of its revisions.
+	var hashes []string
+	if paste.BodyHash.Valid {
+		hashes = append(hashes, paste.BodyHash.String)
+	}
+	for _, rev := range revisions {
+		if rev.BodyHash.Valid {
+			hashes = append(hashes, rev.BodyHash.String)
+		}
+	}
+	var attachments []string
+	if err := broker.Model(&dbPasteAttachment{}).Where("paste_id = ?", paste.ID).Pluck("body_key", &attachments).Error; err != nil {
+		return err
+	}
+
+	tx := broker.Begin()
+	// The paste may have been restored since it was looked up.
+	db := tx.Delete(dbPaste{}, "id = ? AND trashed_at IS NOT NULL", paste.ID)
+	if db.Error != nil || db.RowsAffected == 0 {
+		tx.Rollback()
+		if db.Error != nil {
+			return db.Error
+		}
+		return PasteNotFoundError
+	}
+	for _, model := range []interface{}{dbPasteRevision{}, dbPasteAttachment{}, dbPasteSearchDocument{}, dbUserPastePermission{}, dbGrant{}} {
+		if err := tx.Delete(model, "paste_id = ?", paste.ID).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := unrefPasteBlobs(tx, hashes); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	broker.views.forget(id)
+
+	// Subscribers learn of the purge even if a body is left behind below.
+	broker.publish(PastePurgedEvent, broker.wrapPastes([]*dbPaste{&paste})[0])
+
+	// Bodies being recompressed in the background are only put back while their rows exist,
+	// and shared bodies are only reused while they are referenced.
+	defer broker.bodyLocks.lock(id)()
+	broker.deleteUnreferencedPasteBlobs(hashes)
+	for _, rev := range revisions {
+		if rev.BodyHash.Valid {
+			continue
+		}
+		if err := broker.Bodies.DeleteBody(rev.bodyID()); err != nil {
+			return err
+		}
+	}
+	for _, key := range attachments {
+		if err := broker.Bodies.DeleteBody(attachmentBodyID(id, key)); err != nil {
+			return err
+		}
+	}
+	if paste.BodyHash.Valid {
+		return nil
+	}
+	return broker.Bodies.DeleteBody(paste.bodyID())
+}
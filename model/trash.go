@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+// A TrashedPaste is a paste that has been erased but not yet purged. Encrypted pastes in the
+// trash are placeholders.
+type TrashedPaste struct {
+	Paste
+	TrashedAt time.Time
+}
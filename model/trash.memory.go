@@ -0,0 +1,87 @@
+package model
+
+import (
+	"sort"
+	"time"
+)
+
+// wrapTrashedPastes binds copies of records, all of which must be in the trash, to the
+// broker, most recently erased first. The caller must hold the broker's lock.
+func (broker *memoryBroker) wrapTrashedPastes(records []*memoryPasteRecord) []*TrashedPaste {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].trashedAt.After(records[j].trashedAt)
+	})
+	iPastes := broker.wrapPastes(records)
+	trashed := make([]*TrashedPaste, len(records))
+	for i, record := range records {
+		trashed[i] = &TrashedPaste{Paste: iPastes[i], TrashedAt: record.trashedAt}
+	}
+	return trashed
+}
+
+func (broker *memoryBroker) GetTrash() ([]*TrashedPaste, error) {
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+	var records []*memoryPasteRecord
+	for _, record := range broker.pastes {
+		if record.inTrash() {
+			records = append(records, record)
+		}
+	}
+	return broker.wrapTrashedPastes(records), nil
+}
+
+func (broker *memoryBroker) GetTrashedPastes(ids []PasteID) ([]*TrashedPaste, error) {
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+	seen := make(map[PasteID]bool, len(ids))
+	var records []*memoryPasteRecord
+	for _, id := range ids {
+		if record, ok := broker.pastes[id]; ok && record.inTrash() && !seen[id] {
+			seen[id] = true
+			records = append(records, record)
+		}
+	}
+	return broker.wrapTrashedPastes(records), nil
+}
+
+func (broker *memoryBroker) RestorePaste(id PasteID) error {
+	broker.mu.Lock()
+	record, ok := broker.pastes[id]
+	if !ok || !record.inTrash() {
+		broker.mu.Unlock()
+		return PasteNotFoundError
+	}
+	record.trashedAt = time.Time{}
+	record.Expiration = ""
+	restored := broker.wrapPastes([]*memoryPasteRecord{record})[0]
+	broker.mu.Unlock()
+
+	broker.publish(PasteRestoredEvent, restored)
+	return nil
+}
+
+func (broker *memoryBroker) PurgePaste(id PasteID) error {
+	broker.mu.Lock()
+	record, ok := broker.pastes[id]
+	if !ok || !record.inTrash() {
+		broker.mu.Unlock()
+		return PasteNotFoundError
+	}
+	delete(broker.pastes, id)
+	for key := range broker.pastePermissions {
+		if key.pasteID == id {
+			delete(broker.pastePermissions, key)
+		}
+	}
+	for grantID, pasteID := range broker.grants {
+		if pasteID == id {
+			delete(broker.grants, grantID)
+		}
+	}
+	purged := broker.wrapPastes([]*memoryPasteRecord{record})[0]
+	broker.mu.Unlock()
+
+	broker.publish(PastePurgedEvent, purged)
+	return nil
+}
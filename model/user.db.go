@@ -3,11 +3,14 @@ package model
 import (
 	"crypto/subtle"
 	"time"
+
+	"github.com/DHowett/ghostbin/lib/crypto"
+	"github.com/golang/glog"
 )
 
 type dbUserPastePermission struct {
 	UserID      uint   `gorm:"unique_index:uix_user_paste_perm"`
-	PasteID     string `gorm:"unique_index:uix_user_paste_perm;type:varchar(256)"`
+	PasteID     string `gorm:"unique_index:uix_user_paste_perm;type:varchar(191)"`
 	Permissions Permission
 }
 
@@ -15,9 +18,11 @@ type dbUser struct {
 	ID        uint `gorm:"primary_key"`
 	UpdatedAt time.Time
 
-	Name      string `gorm:"type:varchar(512);unique_index"`
+	Name      string `gorm:"type:varchar(191);unique_index"`
 	Salt      []byte
 	Challenge []byte
+	// KDF names what the key of the challenge was derived with; see crypto.ParseKDF.
+	KDF string `gorm:"type:varchar(128)"`
 
 	Source UserSource
 
@@ -48,12 +53,13 @@ func (u *dbUser) UpdateChallenge(password string) {
 	challengeProvider := u.broker.ChallengeProvider
 
 	salt := challengeProvider.RandomSalt()
-	key := challengeProvider.DeriveKey(password, salt)
+	kdf := challengeProvider.KDF()
+	key := challengeProvider.DeriveKey(password, salt, kdf)
 
 	challengeMessage := append(salt, []byte(u.Name)...)
 	challenge := challengeProvider.Challenge(challengeMessage, key)
 
-	if err := tx.Updates(map[string]interface{}{"Salt": salt, "Challenge": challenge}).Error; err != nil {
+	if err := tx.Updates(map[string]interface{}{"Salt": salt, "Challenge": challenge, "KDF": kdf.String()}).Error; err != nil {
 		tx.Rollback()
 		u.Salt = nil
 		u.Challenge = nil
@@ -68,11 +74,23 @@ func (u *dbUser) Check(password string) bool {
 	if salt == nil {
 		return false
 	}
+	kdf, err := crypto.ParseKDF(u.KDF)
+	if err != nil {
+		glog.Errorf("user %d: %v", u.ID, err)
+		return false
+	}
 	challengeProvider := u.broker.ChallengeProvider
-	key := challengeProvider.DeriveKey(password, salt)
+	key := challengeProvider.DeriveKey(password, salt, kdf)
 	challengeMessage := append(salt, []byte(u.Name)...)
 	newChallenge := challengeProvider.Challenge(challengeMessage, key)
-	return subtle.ConstantTimeCompare(newChallenge, u.Challenge) == 1
+	if subtle.ConstantTimeCompare(newChallenge, u.Challenge) != 1 {
+		return false
+	}
+	if kdf.String() != challengeProvider.KDF().String() {
+		// The password is at hand, so the challenge can be brought up to date.
+		u.UpdateChallenge(password)
+	}
+	return true
 }
 
 func (u *dbUser) Permissions(class PermissionClass, args ...interface{}) PermissionScope {
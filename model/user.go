@@ -16,6 +16,8 @@ type User interface {
 	SetSource(UserSource)
 
 	UpdateChallenge(password string)
+	// Check reports whether password is the user's. If it is, and the user's challenge was
+	// made with another KDF than the challenge provider's, the challenge is made again.
 	Check(password string) bool
 
 	Permissions(class PermissionClass, args ...interface{}) PermissionScope
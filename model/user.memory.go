@@ -0,0 +1,190 @@
+package model
+
+import (
+	"crypto/subtle"
+	"sort"
+
+	"github.com/DHowett/ghostbin/lib/crypto"
+)
+
+type memoryUser struct {
+	ID        uint
+	Name      string
+	Salt      []byte
+	Challenge []byte
+	KDF       string
+
+	Source UserSource
+
+	UserPermissions Permission
+
+	broker *memoryBroker
+}
+
+func (u *memoryUser) GetID() uint {
+	return u.ID
+}
+
+func (u *memoryUser) GetName() string {
+	return u.Name
+}
+
+func (u *memoryUser) GetSource() UserSource {
+	return u.Source
+}
+
+func (u *memoryUser) SetSource(source UserSource) {
+	u.Source = source
+}
+
+func (u *memoryUser) UpdateChallenge(password string) {
+	challengeProvider := u.broker.ChallengeProvider
+
+	salt := challengeProvider.RandomSalt()
+	kdf := challengeProvider.KDF()
+	key := challengeProvider.DeriveKey(password, salt, kdf)
+
+	challengeMessage := append(salt, []byte(u.Name)...)
+	challenge := challengeProvider.Challenge(challengeMessage, key)
+
+	u.broker.mu.Lock()
+	if record, ok := u.broker.users[u.ID]; ok {
+		record.Salt, record.Challenge, record.KDF = salt, challenge, kdf.String()
+	}
+	u.broker.mu.Unlock()
+	u.Salt, u.Challenge, u.KDF = salt, challenge, kdf.String()
+}
+
+func (u *memoryUser) Check(password string) bool {
+	salt := u.Salt
+	if salt == nil {
+		return false
+	}
+	kdf, err := crypto.ParseKDF(u.KDF)
+	if err != nil {
+		return false
+	}
+	challengeProvider := u.broker.ChallengeProvider
+	key := challengeProvider.DeriveKey(password, salt, kdf)
+	challengeMessage := append(salt, []byte(u.Name)...)
+	newChallenge := challengeProvider.Challenge(challengeMessage, key)
+	if subtle.ConstantTimeCompare(newChallenge, u.Challenge) != 1 {
+		return false
+	}
+	if kdf.String() != challengeProvider.KDF().String() {
+		// The password is at hand, so the challenge can be brought up to date.
+		u.UpdateChallenge(password)
+	}
+	return true
+}
+
+func (u *memoryUser) Permissions(class PermissionClass, args ...interface{}) PermissionScope {
+	switch class {
+	case PermissionClassUser:
+		return &memoryUserPermissionScope{u}
+	case PermissionClassPaste:
+		var pid PasteID
+		switch idt := args[0].(type) {
+		case string:
+			pid = PasteIDFromString(idt)
+		case PasteID:
+			pid = idt
+		default:
+			return nil
+		}
+		return newMemoryPastePermissionScope(u.broker, u, pid)
+	}
+	return nil
+}
+
+func (u *memoryUser) GetPastes() ([]PasteID, error) {
+	u.broker.mu.RLock()
+	defer u.broker.mu.RUnlock()
+	var pids []PasteID
+	for key, perms := range u.broker.pastePermissions {
+		if key.userID == u.ID && perms > 0 {
+			pids = append(pids, key.pasteID)
+		}
+	}
+	sort.Slice(pids, func(i, j int) bool {
+		return pids[i] < pids[j]
+	})
+	return pids, nil
+}
+
+type memoryUserPermissionScope struct {
+	u *memoryUser
+}
+
+func (s *memoryUserPermissionScope) Has(p Permission) bool {
+	return s.u.UserPermissions&p != 0
+}
+
+// set stores the user's permissions as changed by update.
+func (s *memoryUserPermissionScope) set(update func(Permission) Permission) error {
+	s.u.broker.mu.Lock()
+	defer s.u.broker.mu.Unlock()
+	record, ok := s.u.broker.users[s.u.ID]
+	if !ok {
+		return UserNotFoundError
+	}
+	record.UserPermissions = update(s.u.UserPermissions)
+	s.u.UserPermissions = record.UserPermissions
+	return nil
+}
+
+func (s *memoryUserPermissionScope) Grant(p Permission) error {
+	return s.set(func(perms Permission) Permission {
+		return perms | p
+	})
+}
+
+func (s *memoryUserPermissionScope) Revoke(p Permission) error {
+	return s.set(func(perms Permission) Permission {
+		return perms & (^p)
+	})
+}
+
+type memoryPastePermissionKey struct {
+	userID  uint
+	pasteID PasteID
+}
+
+type memoryPastePermissionScope struct {
+	key   memoryPastePermissionKey
+	perms Permission
+
+	broker *memoryBroker
+}
+
+func newMemoryPastePermissionScope(broker *memoryBroker, u *memoryUser, id PasteID) *memoryPastePermissionScope {
+	key := memoryPastePermissionKey{u.ID, id}
+	broker.mu.RLock()
+	perms := broker.pastePermissions[key]
+	broker.mu.RUnlock()
+	return &memoryPastePermissionScope{key: key, perms: perms, broker: broker}
+}
+
+func (s *memoryPastePermissionScope) Has(p Permission) bool {
+	return s.perms&p != 0
+}
+
+func (s *memoryPastePermissionScope) set(perms Permission) error {
+	s.broker.mu.Lock()
+	if perms == 0 {
+		delete(s.broker.pastePermissions, s.key)
+	} else {
+		s.broker.pastePermissions[s.key] = perms
+	}
+	s.broker.mu.Unlock()
+	s.perms = perms
+	return nil
+}
+
+func (s *memoryPastePermissionScope) Grant(p Permission) error {
+	return s.set(s.perms | p)
+}
+
+func (s *memoryPastePermissionScope) Revoke(p Permission) error {
+	return s.set(s.perms & (^p))
+}
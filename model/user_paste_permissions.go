@@ -61,12 +61,15 @@ func (s *userPastePermissionScope) Revoke(p Permission) error {
 		return nil
 	}
 
+	// dbUserPastePermission has no primary key, so gorm must be told which row to change;
+	// left to itself, it would change every user's permissions on every paste.
 	pPerm := s.pPerm
 	newPerms := pPerm.Permissions & (^p)
+	row := s.broker.Model(&dbUserPastePermission{}).Where("user_id = ? AND paste_id = ?", pPerm.UserID, pPerm.PasteID)
 	if newPerms == 0 {
-		s.err = s.broker.Delete(pPerm).Error
+		s.err = row.Delete(&dbUserPastePermission{}).Error
 	} else {
-		s.err = s.broker.Model(pPerm).Update("Permissions", newPerms).Error
+		s.err = row.UpdateColumn("permissions", newPerms).Error
 	}
 
 	if s.err == nil {
@@ -2,6 +2,8 @@ package model
 
 import (
 	"testing"
+
+	"github.com/DHowett/ghostbin/lib/crypto"
 )
 
 func TestUserCreate(t *testing.T) {
@@ -208,3 +210,62 @@ func TestUserGetPastes(t *testing.T) {
 	u.Permissions(PermissionClassPaste, "defgh").Grant(PastePermissionEdit)
 	t.Log(u.GetPastes())
 }
+
+func TestUserChallengeKDF(t *testing.T) {
+	oldKDF := &crypto.Scrypt{N: 2, R: 1, P: 1}
+	newKDF := &crypto.Argon2id{Time: 1, Memory: 8, Threads: 1}
+
+	for _, tc := range []struct {
+		name      string
+		newBroker func(crypto.ChallengeProvider) (Broker, error)
+		kdf       func(User) string
+	}{
+		{"Database", func(cp crypto.ChallengeProvider) (Broker, error) {
+			return NewDatabaseBroker("sqlite3", openTestDatabase(t), cp)
+		}, func(u User) string { return u.(*dbUser).KDF }},
+		{"Memory", func(cp crypto.ChallengeProvider) (Broker, error) {
+			return NewMemoryBroker(cp)
+		}, func(u User) string { return u.(*memoryUser).KDF }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cp := &kdfChallengeProvider{kdf: oldKDF}
+			b, err := tc.newBroker(cp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := b.CreateUser("user")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u.UpdateChallenge("password")
+			u, _ = b.GetUserByID(u.GetID())
+			if kdf := tc.kdf(u); kdf != oldKDF.String() {
+				t.Errorf("challenge was made with %q", kdf)
+			}
+
+			// Changing the KDF doesn't lock anybody out, and their challenges are remade with
+			// it when they next give their passwords.
+			cp.kdf = newKDF
+			if u.Check("wrong") {
+				t.Error("user passed a check with the wrong password")
+			}
+			if u, _ = b.GetUserByID(u.GetID()); tc.kdf(u) != oldKDF.String() {
+				t.Error("challenge was remade after a failed check")
+			}
+			if !u.Check("password") {
+				t.Error("user failed a check with the right password after the KDF changed")
+			}
+			u, _ = b.GetUserByID(u.GetID())
+			if kdf := tc.kdf(u); kdf != newKDF.String() {
+				t.Errorf("challenge was remade with %q", kdf)
+			}
+			cp.derived = nil
+			if !u.Check("password") {
+				t.Error("user failed a check with the right password after it was remade")
+			}
+			if len(cp.derived) != 1 || cp.derived[0] != newKDF.String() {
+				t.Errorf("checked the remade challenge with %v", cp.derived)
+			}
+		})
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/DHowett/ghostbin/lib/spool"
+	"github.com/DHowett/ghostbin/lib/templatepack"
+	"github.com/DHowett/ghostbin/model"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// maxPasteAttachments is the most files that can be attached to one paste.
+const maxPasteAttachments = 10
+
+// maxAttachmentNameLength matches the longest attachment name the model accepts.
+const maxAttachmentNameLength = 191
+
+// previewableAttachmentTypes are the image types that are served inline and shown on the paste's
+// page. Anything else, SVG included, is only ever offered as a download.
+var previewableAttachmentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/bmp":  true,
+}
+
+type PasteAttachmentNotFoundError struct {
+	ID   model.PasteID
+	Name string
+}
+
+func (e PasteAttachmentNotFoundError) Error() string {
+	return "Paste " + e.ID.String() + " has no attachment " + strconv.Quote(e.Name) + "."
+}
+
+func (e PasteAttachmentNotFoundError) StatusCode() int {
+	return http.StatusNotFound
+}
+
+type PasteAttachmentError string
+
+func (e PasteAttachmentError) Error() string {
+	return string(e)
+}
+
+func (e PasteAttachmentError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+type PasteAttachmentTooLargeError struct {
+	Name string
+	Size ByteSize
+}
+
+func (e PasteAttachmentTooLargeError) Error() string {
+	return fmt.Sprintf("%s is too large (%v); attachments may be at most %v.", e.Name, e.Size, ByteSize(arguments.maxAttachmentLength))
+}
+
+func (e PasteAttachmentTooLargeError) StatusCode() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// pasteForm is what a paste form carries that is too large to hold in memory: its text,
+// spooled as it is read, and the files uploaded with it. Its other fields are left in the
+// request's Form.
+type pasteForm struct {
+	text  *spool.Spool
+	files []*pasteFormFile
+}
+
+// pasteFormFile is a file uploaded with a paste form.
+type pasteFormFile struct {
+	Filename string
+	body     *spool.Spool
+}
+
+// Close releases the form's spools. It may be called on a nil form.
+func (f *pasteForm) Close() {
+	if f == nil {
+		return
+	}
+	if f.text != nil {
+		f.text.Close()
+	}
+	for _, file := range f.files {
+		file.body.Close()
+	}
+}
+
+// spoolPasteText spools the text of a paste form, which may be no longer than a paste.
+func spoolPasteText(r io.Reader) (*spool.Spool, error) {
+	maxLength := arguments.maxPasteLength
+	text := spool.New(PASTE_BODY_SPOOL_MEMORY)
+	n, err := io.Copy(text, io.LimitReader(r, maxLength+1))
+	if err != nil {
+		text.Close()
+		return nil, PasteAttachmentError("Failed to read the upload: " + err.Error())
+	}
+	if n > maxLength {
+		text.Close()
+		return nil, PasteTooLargeError(n)
+	}
+	return text, nil
+}
+
+// parsePasteForm reads a paste form, which may carry attachments, and returns its text and
+// files; everything else it carries is put in the request's Form. The text is streamed as
+// it is read, so it may be as long as any paste, and a multipart form is capped at the
+// largest paste with its largest set of attachments, so that reading it can't fill the disk.
+// Requests that aren't forms are left alone, and nil is returned for them.
+func parsePasteForm(w http.ResponseWriter, r *http.Request) (*pasteForm, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return parseURLEncodedPasteForm(w, r)
+	case "multipart/form-data":
+	default:
+		return nil, nil
+	}
+
+	limit := arguments.maxPasteLength + maxPasteAttachments*arguments.maxAttachmentLength + 1048576
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, PasteAttachmentError("Failed to read the upload: " + err.Error())
+	}
+
+	form := &pasteForm{}
+	values := make(url.Values)
+	// The other fields are held in memory; the files of a bundle are among them, and can be
+	// no longer than a paste.
+	valuesLength := int64(0)
+	maxValuesLength := arguments.maxPasteLength + 1048576
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			form.Close()
+			return nil, PasteAttachmentError("Failed to read the upload: " + err.Error())
+		}
+		err = form.readPart(part, values, &valuesLength, maxValuesLength)
+		part.Close()
+		if err != nil {
+			form.Close()
+			return nil, err
+		}
+	}
+	setPasteFormValues(r, values)
+	return form, nil
+}
+
+// readPart reads one part of a multipart paste form into f or values.
+func (f *pasteForm) readPart(part *multipart.Part, values url.Values, valuesLength *int64, maxValuesLength int64) error {
+	name := part.FormName()
+	switch {
+	case name == "":
+		return nil
+
+	case name == "text":
+		if f.text != nil {
+			return nil
+		}
+		text, err := spoolPasteText(part)
+		if err != nil {
+			return err
+		}
+		f.text = text
+		return nil
+
+	case name == "attachment":
+		maxLength := arguments.maxAttachmentLength
+		body := spool.New(PASTE_BODY_SPOOL_MEMORY)
+		n, err := io.Copy(body, io.LimitReader(part, maxLength+1))
+		if err == nil && n > maxLength {
+			body.Close()
+			// The rest is read only to say how large the file is.
+			rest, _ := io.Copy(ioutil.Discard, part)
+			return PasteAttachmentTooLargeError{attachmentName(part.FileName()), ByteSize(n + rest)}
+		}
+		if err != nil {
+			body.Close()
+			return PasteAttachmentError("Failed to read the upload: " + err.Error())
+		}
+		if part.FileName() == "" && n == 0 {
+			// An empty file input.
+			body.Close()
+			return nil
+		}
+		f.files = append(f.files, &pasteFormFile{Filename: part.FileName(), body: body})
+		return nil
+	}
+
+	var value bytes.Buffer
+	n, err := io.Copy(&value, io.LimitReader(part, maxValuesLength-*valuesLength+1))
+	if err != nil {
+		return PasteAttachmentError("Failed to read the upload: " + err.Error())
+	}
+	if *valuesLength += n; *valuesLength > maxValuesLength {
+		return PasteTooLargeError(*valuesLength)
+	}
+	values.Add(name, value.String())
+	return nil
+}
+
+// parseURLEncodedPasteForm reads a paste form that isn't multipart. Such a form can't be
+// streamed, and is read into memory; it is capped at the largest paste with every byte
+// percent-encoded.
+func parseURLEncodedPasteForm(w http.ResponseWriter, r *http.Request) (*pasteForm, error) {
+	limit := 3*arguments.maxPasteLength + 1048576
+	encoded, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		return nil, PasteAttachmentError("Failed to read the upload: " + err.Error())
+	}
+	values, err := url.ParseQuery(string(encoded))
+	if err != nil {
+		return nil, PasteAttachmentError("Failed to read the upload: " + err.Error())
+	}
+
+	form := &pasteForm{}
+	if text, ok := values["text"]; ok {
+		delete(values, "text")
+		if form.text, err = spoolPasteText(strings.NewReader(text[0])); err != nil {
+			return nil, err
+		}
+	}
+	setPasteFormValues(r, values)
+	return form, nil
+}
+
+// setPasteFormValues puts the fields read from a paste form in the request's Form and
+// PostForm, as parsing the form would have, so that they can be read with FormValue.
+func setPasteFormValues(r *http.Request, values url.Values) {
+	r.PostForm = values
+	r.Form = make(url.Values)
+	for k, v := range values {
+		r.Form[k] = append(r.Form[k], v...)
+	}
+	for k, v := range r.URL.Query() {
+		r.Form[k] = append(r.Form[k], v...)
+	}
+}
+
+// safeFileName turns a name a client gave a file into one that is safe to store and to show:
+// the last element of the path, without control characters. It returns "" if nothing of the
+// name is left.
+func safeFileName(filename string) string {
+	name := path.Base(strings.Replace(filename, "\\", "/", -1))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name))
+	for len(name) > maxAttachmentNameLength {
+		_, n := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-n]
+	}
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// attachmentName is the name under which an uploaded file is attached.
+func attachmentName(filename string) string {
+	if name := safeFileName(filename); name != "" {
+		return name
+	}
+	return "attachment"
+}
+
+// detectAttachmentType sniffs the type of an uploaded file from its first bytes, falling back
+// to its extension when the content is unrecognizable. The type the browser claimed is ignored.
+func detectAttachmentType(name string, f io.Reader) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	mimeType := http.DetectContentType(head[:n])
+	if mimeType == "application/octet-stream" {
+		if byExtension := mime.TypeByExtension(filepath.Ext(name)); byExtension != "" {
+			mimeType = byExtension
+		}
+	}
+	return mimeType, nil
+}
+
+func attachmentIsPreviewable(a model.PasteAttachment) bool {
+	mediaType, _, _ := mime.ParseMediaType(a.GetMIMEType())
+	return previewableAttachmentTypes[mediaType]
+}
+
+func attachmentURL(id model.PasteID, name string) string {
+	return pasteURL("raw", id) + "/" + url.PathEscape(name)
+}
+
+// readPasteAttachments returns the files uploaded with a paste form, if there was one.
+func readPasteAttachments(form *pasteForm) []*pasteFormFile {
+	if form == nil {
+		return nil
+	}
+	return form.files
+}
+
+// checkPasteAttachmentCount makes sure p will not have too many attachments once files are
+// attached to it and the attachments named in detach are removed.
+func checkPasteAttachmentCount(p model.Paste, files []*pasteFormFile, detach []string) error {
+	names := make(map[string]bool)
+	if p != nil {
+		existing, err := p.GetAttachments()
+		if err != nil {
+			return err
+		}
+		for _, a := range existing {
+			names[a.GetName()] = true
+		}
+	}
+	for _, name := range detach {
+		delete(names, name)
+	}
+	for _, f := range files {
+		names[attachmentName(f.Filename)] = true
+	}
+	if len(names) > maxPasteAttachments {
+		return PasteAttachmentError(fmt.Sprintf("A paste can have at most %d attachments.", maxPasteAttachments))
+	}
+	return nil
+}
+
+func attachPasteFiles(p model.Paste, files []*pasteFormFile) error {
+	for _, f := range files {
+		name := attachmentName(f.Filename)
+		mimeType, err := detectAttachmentType(name, f.body.Reader())
+		if err != nil {
+			return err
+		}
+		if _, err := p.Attach(name, mimeType, f.body.Reader()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyPasteAttachments attaches everything attached to p to another paste.
+func copyPasteAttachments(p, to model.Paste) error {
+	attachments, err := p.GetAttachments()
+	if err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		reader, err := a.Reader()
+		if err != nil {
+			return err
+		}
+		_, err = to.Attach(a.GetName(), a.GetMIMEType(), reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPasteAttachmentHandler serves an attachment as a download. Images that browsers can safely
+// display are served inline, so that the paste's page can preview them.
+func (pc *PasteController) getPasteAttachmentHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	name := mux.Vars(r)["name"]
+	a, err := p.GetAttachment(name)
+	if err != nil {
+		if err == model.PasteAttachmentNotFoundError {
+			err = PasteAttachmentNotFoundError{p.GetID(), name}
+		}
+		panic(err)
+	}
+
+	setRawContentHeaders(w)
+
+	contentType, disposition := "application/octet-stream", "attachment"
+	if attachmentIsPreviewable(a) {
+		contentType, disposition = a.GetMIMEType(), "inline"
+	}
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": a.GetName()}); header != "" {
+		disposition = header
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Length", strconv.FormatInt(a.GetSize(), 10))
+
+	reader, err := a.Reader()
+	if err != nil {
+		panic(err)
+	}
+	defer reader.Close()
+	io.Copy(w, reader)
+}
+
+// visibleAttachments returns what is attached to p, or nothing if it can't be read.
+func visibleAttachments(p model.Paste) []model.PasteAttachment {
+	if p.IsEncrypted() {
+		return nil
+	}
+	attachments, err := p.GetAttachments()
+	if err != nil {
+		glog.Errorf("Failed to list attachments to %s: %v", p.GetID(), err)
+		return nil
+	}
+	return attachments
+}
+
+func init() {
+	globalInit.Add(&InitHandler{
+		Priority: 84,
+		Name:     "attachment_template_funcs",
+		Do: func() error {
+			templatePack.AddFunction("pasteAttachments", func(ri *templatepack.Context) []model.PasteAttachment {
+				return visibleAttachments(ri.Obj.(model.Paste))
+			})
+			templatePack.AddFunction("attachmentURL", func(a model.PasteAttachment) string {
+				return attachmentURL(a.GetPasteID(), a.GetName())
+			})
+			templatePack.AddFunction("attachmentIsPreviewable", attachmentIsPreviewable)
+			templatePack.AddFunction("attachmentsAllowed", func(ri *templatepack.Context) bool {
+				p, _ := ri.Obj.(model.Paste)
+				return p == nil || !p.IsEncrypted()
+			})
+			return nil
+		},
+	})
+}
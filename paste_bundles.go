@@ -0,0 +1,460 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DHowett/ghostbin/lib/bundle"
+	"github.com/DHowett/ghostbin/lib/formatting"
+	"github.com/DHowett/ghostbin/lib/spool"
+	"github.com/DHowett/ghostbin/lib/templatepack"
+	"github.com/DHowett/ghostbin/model"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// maxBundleFiles is the most files one paste can hold.
+const maxBundleFiles = 20
+
+type PasteFileNotFoundError struct {
+	ID   model.PasteID
+	Name string
+}
+
+func (e PasteFileNotFoundError) Error() string {
+	return "Paste " + e.ID.String() + " has no file " + strconv.Quote(e.Name) + "."
+}
+
+func (e PasteFileNotFoundError) StatusCode() int {
+	return http.StatusNotFound
+}
+
+type PasteBundleError string
+
+func (e PasteBundleError) Error() string {
+	return string(e)
+}
+
+func (e PasteBundleError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// pasteFile is one file of a paste, read in full. A paste that isn't a bundle holds a single
+// file.
+type pasteFile struct {
+	Name     string
+	Language *formatting.Language
+	Text     string
+}
+
+// renderedFileKey caches the rendering of one file of a bundle.
+type renderedFileKey struct {
+	ID   model.PasteID
+	Name string
+}
+
+func fileLanguage(name string) *formatting.Language {
+	if name == "" {
+		name = "text"
+	}
+	return formatting.LanguageNamed(name)
+}
+
+func languageExtension(lang *formatting.Language) string {
+	if lang != nil && len(lang.Extensions) > 0 {
+		return lang.Extensions[0]
+	}
+	return "txt"
+}
+
+// pasteDownloadBase is the name a paste is downloaded under, without an extension. The
+// titles of encrypted pastes are kept out of it, as downloads outlive their passphrases.
+func pasteDownloadBase(p model.Paste) string {
+	if p.IsEncrypted() {
+		return p.GetID().String()
+	}
+	if name := safeFileName(p.GetTitle()); name != "" {
+		return name
+	}
+	return p.GetID().String()
+}
+
+// pasteDownloadName is the name a paste that isn't a bundle is downloaded under.
+func pasteDownloadName(p model.Paste) string {
+	return pasteDownloadBase(p) + "." + languageExtension(formatting.LanguageNamed(p.GetLanguageName()))
+}
+
+// setDownloadHeaders asks the browser to save the response as filename.
+func setDownloadHeaders(w http.ResponseWriter, filename string) {
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Transfer-Encoding", "binary")
+}
+
+func fileURL(routeType string, p model.PasteID, name string) string {
+	url, _ := pasteRouter.Get(routeType).URL("id", p.String(), "name", name)
+	return url.String()
+}
+
+func archiveURL(format string, p model.PasteID) string {
+	url, _ := pasteRouter.Get("archive").URL("id", p.String(), "format", format)
+	return url.String()
+}
+
+// readBundle reads every file in a bundle.
+func readBundle(r io.Reader) ([]*pasteFile, error) {
+	br, err := bundle.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var files []*pasteFile
+	for {
+		f, content, err := br.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		text, err := ioutil.ReadAll(content)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &pasteFile{
+			Name:     f.Name,
+			Language: fileLanguage(f.Language),
+			Text:     string(text),
+		})
+	}
+}
+
+// writeBundle writes files to w as a bundle.
+func writeBundle(w io.Writer, files []*pasteFile) error {
+	bw := bundle.NewWriter(w)
+	for _, f := range files {
+		fw, err := bw.CreateFile(bundle.File{Name: f.Name, Language: f.Language.ID})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.Text); err != nil {
+			return err
+		}
+	}
+	return bw.Close()
+}
+
+// readPasteFiles returns the files in p, and whether p is a bundle.
+func readPasteFiles(p model.Paste) ([]*pasteFile, bool, error) {
+	reader, err := p.Reader()
+	if err != nil {
+		return nil, false, err
+	}
+	defer reader.Close()
+
+	if p.IsBundle() {
+		files, err := readBundle(reader)
+		return files, true, err
+	}
+
+	text, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, false, err
+	}
+	return []*pasteFile{{
+		Name:     pasteDownloadName(p),
+		Language: formatting.LanguageNamed(p.GetLanguageName()),
+		Text:     string(text),
+	}}, false, nil
+}
+
+// bundleFiles returns the files in p if it is a bundle, or nothing if it isn't one or can't
+// be read.
+func bundleFiles(p model.Paste) []*pasteFile {
+	files, bundled, err := readPasteFiles(p)
+	if err != nil {
+		glog.Errorf("Failed to read the files in %s: %v", p.GetID(), err)
+		return nil
+	}
+	if !bundled {
+		return nil
+	}
+	return files
+}
+
+// readPasteFormFiles collects a bundle from a paste form. The paste's own text is its first
+// file, named by the "name" field; every set of "file_name", "file_lang" and "file_text" fields
+// adds another. Empty files are dropped, and nil is returned if only the paste's own text is
+// left or if that is empty.
+func readPasteFormFiles(r *http.Request, form *pasteForm) ([]*pasteFile, error) {
+	if form.text == nil || len(r.Form["file_text"]) == 0 {
+		return nil, nil
+	}
+	// The text is no longer than a paste, and a bundle is built in memory anyway.
+	b, err := ioutil.ReadAll(form.text.Reader())
+	if err != nil {
+		return nil, err
+	}
+	text := string(b)
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	files := []*pasteFile{{
+		Name:     r.FormValue("name"),
+		Language: fileLanguage(r.FormValue("lang")),
+		Text:     text,
+	}}
+	names, langs := r.Form["file_name"], r.Form["file_lang"]
+	for i, text := range r.Form["file_text"] {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		f := &pasteFile{Text: text, Language: fileLanguage("")}
+		if i < len(names) {
+			f.Name = names[i]
+		}
+		if i < len(langs) {
+			f.Language = fileLanguage(langs[i])
+		}
+		files = append(files, f)
+	}
+	if len(files) == 1 {
+		return nil, nil
+	}
+	if len(files) > maxBundleFiles {
+		return nil, PasteBundleError(fmt.Sprintf("A paste can hold at most %d files.", maxBundleFiles))
+	}
+
+	seen := make(map[string]bool, len(files))
+	for i, f := range files {
+		f.Name = safeFileName(f.Name)
+		if f.Name == "" {
+			f.Name = fmt.Sprintf("file%d.%s", i+1, languageExtension(f.Language))
+		}
+		if seen[f.Name] {
+			return nil, PasteBundleError("Every file in a paste needs a different name; there are two called " + strconv.Quote(f.Name) + ".")
+		}
+		seen[f.Name] = true
+	}
+	return files, nil
+}
+
+// readPasteFormBundle writes the bundle described by a paste form to body. It returns false
+// if the form describes a single file, which is left for the caller.
+func readPasteFormBundle(r *http.Request, form *pasteForm, body *spool.Spool) (bool, error) {
+	files, err := readPasteFormFiles(r, form)
+	if err != nil || files == nil {
+		return false, err
+	}
+	if err := writeBundle(body, files); err != nil {
+		return false, err
+	}
+	if body.Len() > arguments.maxPasteLength {
+		return false, PasteTooLargeError(body.Len())
+	}
+	return true, nil
+}
+
+// formatBody renders a paste body in lang. The files in a bundle are rendered one after
+// another, each in its own language.
+func formatBody(r io.Reader, lang *formatting.Language, bundled bool) (string, error) {
+	if !bundled {
+		return formatting.FormatStream(r, lang)
+	}
+
+	files, err := readBundle(r)
+	if err != nil {
+		return "", err
+	}
+	var out []string
+	for _, f := range files {
+		rendered, err := formatting.FormatStream(strings.NewReader(f.Text), f.Language)
+		if err != nil {
+			return rendered, err
+		}
+		out = append(out, "<strong>==&gt; "+template.HTMLEscapeString(f.Name)+" &lt;==</strong>\n"+rendered)
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+// writeBundleText writes the files in a bundle one after another as plain text, each under a
+// header bearing its name.
+func writeBundleText(w io.Writer, files []*pasteFile) {
+	for i, f := range files {
+		if i > 0 {
+			io.WriteString(w, "\n")
+		}
+		fmt.Fprintf(w, "==> %s <==\n", f.Name)
+		io.WriteString(w, f.Text)
+		if !strings.HasSuffix(f.Text, "\n") {
+			io.WriteString(w, "\n")
+		}
+	}
+}
+
+// writeBodyText copies a paste body to w as plain text; a bundle is written as its files, one
+// after another.
+func writeBodyText(w io.Writer, r io.Reader, bundled bool) error {
+	if !bundled {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	files, err := readBundle(r)
+	if err != nil {
+		return err
+	}
+	writeBundleText(w, files)
+	return nil
+}
+
+func renderPasteFile(p model.Paste, f *pasteFile) template.HTML {
+	return renderCached(renderedFileKey{p.GetID(), f.Name}, p, func() (string, error) {
+		return formatting.FormatStream(strings.NewReader(f.Text), f.Language)
+	})
+}
+
+// writeArchive writes files to w as a zip file or a gzipped tarball, inside the directory dir.
+func writeArchive(w io.Writer, format, dir string, modTime time.Time, files []*pasteFile) error {
+	switch format {
+	case "zip":
+		zw := zip.NewWriter(w)
+		for _, f := range files {
+			fw, err := zw.CreateHeader(&zip.FileHeader{
+				Name:     dir + "/" + f.Name,
+				Method:   zip.Deflate,
+				Modified: modTime,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(fw, f.Text); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	case "tar.gz":
+		gz := gzip.NewWriter(w)
+		tw := tar.NewWriter(gz)
+		for _, f := range files {
+			err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     dir + "/" + f.Name,
+				Mode:     0644,
+				Size:     int64(len(f.Text)),
+				ModTime:  modTime,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(tw, f.Text); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+	return fmt.Errorf("unknown archive format %q", format)
+}
+
+func writePasteArchive(w http.ResponseWriter, p model.Paste, files []*pasteFile, format string) {
+	contentType := "application/zip"
+	if format == "tar.gz" {
+		contentType = "application/gzip"
+	}
+	base := pasteDownloadBase(p)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	setDownloadHeaders(w, base+"."+format)
+
+	if err := writeArchive(w, format, base, p.GetModificationTime(), files); err != nil {
+		glog.Errorf("Failed to archive %s: %v", p.GetID(), err)
+	}
+}
+
+// getPasteFileHandler serves one file of a bundle as text, or as a download.
+func (pc *PasteController) getPasteFileHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	name := mux.Vars(r)["name"]
+	var file *pasteFile
+	for _, f := range bundleFiles(p) {
+		if f.Name == name {
+			file = f
+			break
+		}
+	}
+	if file == nil {
+		panic(PasteFileNotFoundError{p.GetID(), name})
+	}
+
+	setRawContentHeaders(w)
+	if mux.CurrentRoute(r).GetName() == "file_download" {
+		setDownloadHeaders(w, file.Name)
+	}
+	io.WriteString(w, file.Text)
+}
+
+// getPasteArchiveHandler serves every file in a paste as a zip file or a tarball.
+func (pc *PasteController) getPasteArchiveHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	files, _, err := readPasteFiles(p)
+	if err != nil {
+		panic(err)
+	}
+	writePasteArchive(w, p, files, mux.Vars(r)["format"])
+}
+
+func (pc *PasteController) initBundleRoutes() {
+	pc.Router.Methods("GET").
+		Path("/{id}/files/{name}").
+		Handler(pc.wrapPasteHandler(pc.wrapPasteViewHandler(pc.getPasteFileHandler))).
+		Name("file")
+	pc.Router.Methods("GET").
+		Path("/{id}/files/{name}/download").
+		Handler(pc.wrapPasteHandler(pc.wrapPasteViewHandler(pc.getPasteFileHandler))).
+		Name("file_download")
+	pc.Router.Methods("GET").
+		Path("/{id}/archive.{format:zip|tar\\.gz}").
+		Handler(pc.wrapPasteHandler(pc.wrapPasteViewHandler(pc.getPasteArchiveHandler))).
+		Name("archive")
+}
+
+func init() {
+	globalInit.Add(&InitHandler{
+		Priority: 85,
+		Name:     "bundle_template_funcs",
+		Do: func() error {
+			templatePack.AddFunction("pasteFiles", func(ri *templatepack.Context) []*pasteFile {
+				if p, ok := ri.Obj.(model.Paste); ok {
+					return bundleFiles(p)
+				}
+				return nil
+			})
+			templatePack.AddFunction("renderFile", renderPasteFile)
+			templatePack.AddFunction("fileURL", func(e string, p model.Paste, f *pasteFile) string {
+				return fileURL(e, p.GetID(), f.Name)
+			})
+			templatePack.AddFunction("archiveURL", func(format string, p model.Paste) string {
+				return archiveURL(format, p.GetID())
+			})
+			return nil
+		},
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/DHowett/ghostbin/model"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/sessions"
+)
+
+// Pastes encrypted in the browser are made and read through JSON alone. The browser encrypts
+// the body with a key of its own making, and keeps the key in the fragment of the paste's URL,
+// which is never sent to the server; the server only ever holds ciphertext. How the body is
+// encrypted is up to the browser (see client_paste.js): the server neither knows nor checks.
+// The browser seals the paste's title and language in the body along with its text, so the
+// server is never told them; pastes made before it did keep theirs in the clear.
+
+// ClientEncryptedPasteError describes a request to make a paste encrypted in the browser that
+// can't be carried out.
+type ClientEncryptedPasteError string
+
+func (e ClientEncryptedPasteError) Error() string {
+	return string(e)
+}
+
+func (e ClientEncryptedPasteError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+type clientEncryptedPasteRequest struct {
+	Expiration string `json:"expiration"`
+	// Body is the base64 of the ciphertext.
+	Body string `json:"body"`
+}
+
+type clientEncryptedPasteReply struct {
+	ID    model.PasteID `json:"id,omitempty"`
+	URL   string        `json:"url,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+func (pc *PasteController) clientEncryptedPasteCreate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	reply := &clientEncryptedPasteReply{}
+	status := http.StatusCreated
+	defer func() {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(reply)
+	}()
+
+	p, err := pc.createClientEncryptedPaste(w, r)
+	if err != nil {
+		status = http.StatusInternalServerError
+		if weberr, ok := err.(HTTPError); ok {
+			status = weberr.StatusCode()
+		}
+		reply.Error = err.Error()
+		return
+	}
+
+	showURL, _ := url.Parse(pasteURL("show", p.GetID()))
+	reply.ID = p.GetID()
+	reply.URL = BaseURLForRequest(r).ResolveReference(showURL).String()
+	w.Header().Set("Location", reply.URL)
+}
+
+func (pc *PasteController) createClientEncryptedPaste(w http.ResponseWriter, r *http.Request) (model.Paste, error) {
+	maxLength := arguments.maxPasteLength
+	// Base64 takes four bytes for every three, and the rest of the request is small.
+	maxRequestLength := maxLength/3*4 + 4096
+	if r.ContentLength > maxRequestLength {
+		return nil, PasteTooLargeError(r.ContentLength)
+	}
+	var req clientEncryptedPasteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestLength)).Decode(&req); err != nil {
+		return nil, ClientEncryptedPasteError("The request isn't a paste: " + err.Error())
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(req.Body)
+	if err != nil {
+		return nil, ClientEncryptedPasteError("The paste's body isn't base64.")
+	}
+	if int64(len(ciphertext)) > maxLength {
+		return nil, PasteTooLargeError(len(ciphertext))
+	}
+	if len(ciphertext) == 0 {
+		return nil, ClientEncryptedPasteError("Hey, put some text in that paste.")
+	}
+
+	p, err := pasteStore.CreateClientEncryptedPaste()
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := p.Writer()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bytes.NewReader(ciphertext).WriteTo(pw); err != nil {
+		return nil, err
+	}
+	setPasteExpiration(p, req.Expiration)
+	p.SetEditor(editorForRequest(r))
+	if err := pw.Close(); err != nil { // Saves p
+		return nil, err
+	}
+
+	GetPastePermissionScope(p.GetID(), r).Grant(model.PastePermissionAll)
+	SavePastePermissionScope(w, r)
+	if err := sessions.Save(r, w); err != nil {
+		glog.Errorln(err)
+	}
+	return p, nil
+}
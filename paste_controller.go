@@ -1,18 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/DHowett/ghostbin/lib/formatting"
+	"github.com/DHowett/ghostbin/lib/spool"
 	"github.com/DHowett/ghostbin/model"
 
 	"github.com/golang/glog"
@@ -22,9 +25,9 @@ import (
 )
 
 // paste http bindings
-const CURRENT_ENCRYPTION_METHOD model.PasteEncryptionMethod = model.PasteEncryptionMethodAES_CTR
+const CURRENT_ENCRYPTION_METHOD model.PasteEncryptionMethod = model.PasteEncryptionMethodXChaCha20_Poly1305
 const PASTE_CACHE_MAX_ENTRIES int = 1000
-const PASTE_MAXIMUM_LENGTH ByteSize = 1048576 // 1 MB
+const PASTE_BODY_SPOOL_MEMORY int = 1048576 // 1 MB
 const MAX_EXPIRE_DURATION time.Duration = 15 * 24 * time.Hour
 
 type PasteAccessDeniedError struct {
@@ -41,10 +44,34 @@ func (e PasteAccessDeniedError) StatusCode() int {
 	return http.StatusForbidden
 }
 
+// PasteClientEncryptedError is returned for requests that need the body of a paste encrypted
+// in the browser.
+type PasteClientEncryptedError model.PasteID
+
+func (e PasteClientEncryptedError) Error() string {
+	return "Paste " + string(e) + " was encrypted in the browser that made it, and can only be read there."
+}
+
+func (e PasteClientEncryptedError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// PasteKeyChangedError is returned for an edit of an encrypted paste whose password was
+// changed while it was being made.
+type PasteKeyChangedError model.PasteID
+
+func (e PasteKeyChangedError) Error() string {
+	return "The password of paste " + string(e) + " was changed while you were editing it, so your changes weren't saved."
+}
+
+func (e PasteKeyChangedError) StatusCode() int {
+	return http.StatusConflict
+}
+
 type PasteTooLargeError ByteSize
 
 func (e PasteTooLargeError) Error() string {
-	return fmt.Sprintf("Your input (%v) exceeds the maximum paste length, which is %v.", ByteSize(e), PASTE_MAXIMUM_LENGTH)
+	return fmt.Sprintf("Your input (%v) exceeds the maximum paste length, which is %v.", ByteSize(e), ByteSize(arguments.maxPasteLength))
 }
 
 func (e PasteTooLargeError) StatusCode() int {
@@ -56,26 +83,57 @@ type PasteController struct {
 	PasteStore model.Broker
 }
 
-func (pc *PasteController) getPasteFromRequest(r *http.Request) (model.Paste, error) {
-	id := model.PasteIDFromString(mux.Vars(r)["id"])
-	var passphrase []byte
-
+// getPastePassphrase returns the passphrase the client has provided for paste id, if any.
+func getPastePassphrase(r *http.Request, id model.PasteID) []byte {
 	cliSession, err := clientOnlySessionStore.Get(r, "c_session")
 	if err != nil {
 		glog.Errorln(err)
 	}
 	if pasteKeys, ok := cliSession.Values["paste_passphrases"].(map[model.PasteID][]byte); ok {
 		if _key, ok := pasteKeys[id]; ok {
-			passphrase = _key
+			return _key
 		}
 	}
+	return nil
+}
+
+// setPastePassphrase remembers passphrase for paste id in the client session.
+// The caller is responsible for saving the session.
+func setPastePassphrase(r *http.Request, id model.PasteID, passphrase []byte) {
+	cliSession, err := clientOnlySessionStore.Get(r, "c_session")
+	if err != nil {
+		glog.Errorln(err)
+	}
+	pasteKeys, ok := cliSession.Values["paste_passphrases"].(map[model.PasteID][]byte)
+	if !ok {
+		pasteKeys = map[model.PasteID][]byte{}
+	}
+
+	pasteKeys[id] = passphrase
+	cliSession.Values["paste_passphrases"] = pasteKeys
+}
 
-	return pasteStore.GetPaste(id, passphrase)
+func (pc *PasteController) getPasteFromRequest(r *http.Request) (model.Paste, error) {
+	id := model.PasteIDFromString(mux.Vars(r)["id"])
+	return pasteStore.GetPaste(id, getPastePassphrase(r, id))
 }
 
 type pasteHandlerFunc func(p model.Paste, w http.ResponseWriter, r *http.Request)
 
+// wrapPasteHandler hands the paste named in the request to handler, sending the requester to
+// authenticate first if the paste is encrypted. Pastes encrypted in the browser are turned
+// away; see wrapClientEncryptedPasteHandler.
 func (pc *PasteController) wrapPasteHandler(handler pasteHandlerFunc) http.Handler {
+	return pc.pasteHandler(handler, false)
+}
+
+// wrapClientEncryptedPasteHandler is wrapPasteHandler for handlers that can also serve pastes
+// encrypted in the browser, whose bodies the server can't read.
+func (pc *PasteController) wrapClientEncryptedPasteHandler(handler pasteHandlerFunc) http.Handler {
+	return pc.pasteHandler(handler, true)
+}
+
+func (pc *PasteController) pasteHandler(handler pasteHandlerFunc, clientEncrypted bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := model.PasteIDFromString(mux.Vars(r)["id"])
 		p, err := pc.getPasteFromRequest(r)
@@ -105,11 +163,43 @@ func (pc *PasteController) wrapPasteHandler(handler pasteHandlerFunc) http.Handl
 				return
 			}
 
+			if p.IsClientEncrypted() && !clientEncrypted {
+				err := PasteClientEncryptedError(id)
+				w.WriteHeader(err.StatusCode())
+				templatePack.ExecutePage(w, r, "error", err)
+				return
+			}
+
 			handler(p, w, r)
 		}
 	})
 }
 
+// copyPasteBody copies r, the body of the paste id, to w as it is read. A body that fails
+// authentication (see model.PasteBodyTamperedError) can only be answered with an error while
+// none of it has been sent; after that, the response is cut off, so that what was sent can't
+// be taken for the whole body.
+func copyPasteBody(w http.ResponseWriter, r io.Reader, id model.PasteID) {
+	n, err := io.Copy(w, r)
+	if err == nil {
+		return
+	}
+	glog.Errorf("paste %s: %v", id, err)
+	if n > 0 {
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Del("Content-Disposition")
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// wrapPasteViewHandler counts every request handled by fn as a view of the paste.
+func (pc *PasteController) wrapPasteViewHandler(fn pasteHandlerFunc) pasteHandlerFunc {
+	return func(p model.Paste, w http.ResponseWriter, r *http.Request) {
+		pasteStore.RecordPasteView(p.GetID())
+		fn(p, w, r)
+	}
+}
+
 func (pc *PasteController) wrapPasteEditHandler(fn pasteHandlerFunc) pasteHandlerFunc {
 	return func(p model.Paste, w http.ResponseWriter, r *http.Request) {
 		if !isEditAllowed(p, r) {
@@ -126,21 +216,79 @@ func (pc *PasteController) getPasteJSONHandler(p model.Paste, w http.ResponseWri
 	reader, _ := p.Reader()
 	defer reader.Close()
 	buf := &bytes.Buffer{}
-	io.Copy(buf, reader)
+	if _, err := io.Copy(buf, reader); err != nil {
+		RenderError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	files := []map[string]interface{}{}
+	if p.IsClientEncrypted() {
+		// The body is ciphertext, for the browser to decrypt.
+		ciphertext := base64.StdEncoding.EncodeToString(buf.Bytes())
+		buf.Reset()
+		buf.WriteString(ciphertext)
+	} else if p.IsBundle() {
+		bundled, err := readBundle(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			RenderError(err, http.StatusInternalServerError, w)
+			return
+		}
+		buf.Reset()
+		writeBundleText(buf, bundled)
+		for _, f := range bundled {
+			files = append(files, map[string]interface{}{
+				"name":     f.Name,
+				"language": f.Language.ID,
+				"body":     f.Text,
+				"url":      fileURL("file", p.GetID(), f.Name),
+			})
+		}
+	}
 
 	pasteMap := map[string]interface{}{
 		"id":         p.GetID(),
+		"title":      p.GetTitle(),
 		"language":   p.GetLanguageName(),
 		"encrypted":  p.IsEncrypted(),
+		"client":     p.IsClientEncrypted(),
 		"expiration": p.GetExpiration(),
 		"body":       string(buf.Bytes()),
+		"files":      files,
+		"creator":    nil,
+		"size":       nil,
+		"lines":      nil,
+		"views":      p.GetViewCount(),
+		"lastViewed": nil,
+	}
+	if creator := p.GetCreator(); !creator.IsAnonymous() {
+		pasteMap["creator"] = creator.UserID
+	}
+	if size := p.GetSize(); size >= 0 {
+		pasteMap["size"] = size
 	}
+	if lines := p.GetLineCount(); lines >= 0 {
+		pasteMap["lines"] = lines
+	}
+	if lastViewed := p.GetLastViewTime(); !lastViewed.IsZero() {
+		pasteMap["lastViewed"] = lastViewed.UTC()
+	}
+	attachments := []map[string]interface{}{}
+	for _, a := range visibleAttachments(p) {
+		attachments = append(attachments, map[string]interface{}{
+			"name": a.GetName(),
+			"type": a.GetMIMEType(),
+			"size": a.GetSize(),
+			"url":  attachmentURL(p.GetID(), a.GetName()),
+		})
+	}
+	pasteMap["attachments"] = attachments
 
 	json, _ := json.Marshal(pasteMap)
 	w.Write(json)
 }
 
-func (pc *PasteController) getPasteRawHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+// setRawContentHeaders prepares w to serve untrusted paste content as inert plain text.
+func setRawContentHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "null")
 	w.Header().Set("Vary", "Origin")
 
@@ -148,27 +296,33 @@ func (pc *PasteController) getPasteRawHandler(p model.Paste, w http.ResponseWrit
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("X-XSS-Protection", "1; mode=block")
+}
 
-	ext := "txt"
-	if mux.CurrentRoute(r).GetName() == "download" {
-		lang := formatting.LanguageNamed(p.GetLanguageName())
-		if lang != nil {
-			if len(lang.Extensions) > 0 {
-				ext = lang.Extensions[0]
-			}
-		}
+func (pc *PasteController) getPasteRawHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	download := mux.CurrentRoute(r).GetName() == "download"
 
-		filename := p.GetID().String()
-		if p.GetTitle() != "" {
-			filename = p.GetTitle()
+	reader, _ := p.Reader()
+	defer reader.Close()
+	if p.IsBundle() {
+		files, err := readBundle(reader)
+		if err != nil {
+			RenderError(err, http.StatusInternalServerError, w)
+			return
+		}
+		if download {
+			writePasteArchive(w, p, files, "zip")
+			return
 		}
-		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"."+ext+"\"")
-		w.Header().Set("Content-Transfer-Encoding", "binary")
+		setRawContentHeaders(w)
+		writeBundleText(w, files)
+		return
 	}
 
-	reader, _ := p.Reader()
-	defer reader.Close()
-	io.Copy(w, reader)
+	setRawContentHeaders(w)
+	if download {
+		setDownloadHeaders(w, pasteDownloadName(p))
+	}
+	copyPasteBody(w, reader, p.GetID())
 }
 
 func (pc *PasteController) pasteGrantHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
@@ -219,23 +373,91 @@ func (pc *PasteController) grantAcceptHandler(w http.ResponseWriter, r *http.Req
 	w.WriteHeader(http.StatusSeeOther)
 }
 
+// readPasteBody collects the body of a paste being created or updated, and reports whether it
+// is a bundle. A paste form carries it as its text, or as several files to be bundled
+// together; any other request body is the paste itself. Either way, it is streamed to a spool
+// instead of being read into memory. The caller must close the spool.
+func readPasteBody(r *http.Request, form *pasteForm) (*spool.Spool, bool, error) {
+	maxLength := arguments.maxPasteLength
+
+	if form != nil {
+		body := spool.New(PASTE_BODY_SPOOL_MEMORY)
+		bundled, err := readPasteFormBundle(r, form, body)
+		if err != nil {
+			body.Close()
+			return nil, false, err
+		}
+		if bundled || form.text == nil {
+			return body, bundled, nil
+		}
+		// The text is handed over to the caller as it is.
+		body.Close()
+		body, form.text = form.text, nil
+		return body, false, nil
+	}
+
+	if r.ContentLength > maxLength {
+		return nil, false, PasteTooLargeError(r.ContentLength)
+	}
+	body := spool.New(PASTE_BODY_SPOOL_MEMORY)
+	n, err := io.Copy(body, io.LimitReader(r.Body, maxLength+1))
+	if err != nil {
+		body.Close()
+		return nil, false, err
+	}
+	if n > maxLength {
+		body.Close()
+		return nil, false, PasteTooLargeError(n)
+	}
+	return body, false, nil
+}
+
+func pasteBodyIsBlank(body *spool.Spool) bool {
+	br := bufio.NewReader(body.Reader())
+	for {
+		r, _, err := br.ReadRune()
+		if err != nil {
+			return true
+		}
+		if !unicode.IsSpace(r) {
+			return false
+		}
+	}
+}
+
 func (pc *PasteController) pasteUpdate(p model.Paste, w http.ResponseWriter, r *http.Request) {
-	pc.pasteUpdateCore(p, w, r, false)
+	defer errorRecoveryHandler(w)
+
+	form, err := parsePasteForm(w, r)
+	if err != nil {
+		panic(err)
+	}
+	defer form.Close()
+
+	body, bundled, err := readPasteBody(r, form)
+	if err != nil {
+		panic(err)
+	}
+	defer body.Close()
+
+	files := readPasteAttachments(form)
+	if len(files) > 0 && p.IsEncrypted() {
+		panic(PasteAttachmentError("Encrypted pastes can't have attachments."))
+	}
+	if err := checkPasteAttachmentCount(p, files, r.Form["detach"]); err != nil {
+		panic(err)
+	}
+
+	pc.pasteUpdateCore(p, w, r, false, body, bundled, files)
 }
 
-func (pc *PasteController) pasteUpdateCore(p model.Paste, w http.ResponseWriter, r *http.Request, newPaste bool) {
-	body := r.FormValue("text")
-	if len(strings.TrimSpace(body)) == 0 {
+func (pc *PasteController) pasteUpdateCore(p model.Paste, w http.ResponseWriter, r *http.Request, newPaste bool, body *spool.Spool, bundled bool, files []*pasteFormFile) {
+	if pasteBodyIsBlank(body) {
 		w.Header().Set("Location", pasteURL("delete", p.GetID()))
 		w.WriteHeader(http.StatusFound)
 		return
 	}
 
-	pasteLen := ByteSize(len(body))
-	if pasteLen > PASTE_MAXIMUM_LENGTH {
-		panic(PasteTooLargeError(pasteLen))
-	}
-
 	if !newPaste {
 		// If this is an update (instead of a new paste), blow away the hash.
 		tok := "P|H|" + p.GetID().String()
@@ -247,8 +469,13 @@ func (pc *PasteController) pasteUpdateCore(p model.Paste, w http.ResponseWriter,
 	}
 
 	lang := formatting.LanguageNamed(p.GetLanguageName())
-	pw, _ := p.Writer()
-	pw.Write([]byte(body))
+	pw, err := p.Writer()
+	if err != nil {
+		panic(err)
+	}
+	if _, err := io.Copy(pw, body.Reader()); err != nil {
+		panic(err)
+	}
 	if r.FormValue("lang") != "" {
 		lang = formatting.LanguageNamed(r.FormValue("lang"))
 	}
@@ -257,7 +484,34 @@ func (pc *PasteController) pasteUpdateCore(p model.Paste, w http.ResponseWriter,
 		p.SetLanguageName(lang.ID)
 	}
 
-	expireIn := r.FormValue("expire")
+	setPasteExpiration(p, r.FormValue("expire"))
+	p.SetTitle(r.FormValue("title"))
+	p.SetBundle(bundled)
+	p.SetEditor(editorForRequest(r))
+
+	if err := pw.Close(); err != nil { // Saves p
+		if err == model.PasteKeyChangedError {
+			panic(PasteKeyChangedError(p.GetID()))
+		}
+		panic(err)
+	}
+
+	for _, name := range r.Form["detach"] {
+		if err := p.Detach(name); err != nil {
+			panic(err)
+		}
+	}
+	if err := attachPasteFiles(p, files); err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Location", pasteURL("show", p.GetID()))
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// setPasteExpiration makes p expire after expireIn, a duration, or never if it is "-1". The
+// caller must commit p.
+func setPasteExpiration(p model.Paste, expireIn string) {
 	ePid := ExpiringPasteID(p.GetID())
 	if expireIn != "" && expireIn != "-1" {
 		dur, _ := ParseDuration(expireIn)
@@ -272,27 +526,37 @@ func (pc *PasteController) pasteUpdateCore(p model.Paste, w http.ResponseWriter,
 	}
 
 	p.SetExpiration(expireIn)
-
-	p.SetTitle(r.FormValue("title"))
-
-	pw.Close() // Saves p
-
-	w.Header().Set("Location", pasteURL("show", p.GetID()))
-	w.WriteHeader(http.StatusSeeOther)
 }
 
 func (pc *PasteController) pasteCreate(w http.ResponseWriter, r *http.Request) {
-	body := r.FormValue("text")
-	if len(strings.TrimSpace(body)) == 0 {
-		// 400 here, 200 above (one is displayed to the user, one could be an API response.)
-		RenderError(fmt.Errorf("Hey, put some text in that paste."), 400, w)
+	defer errorRecoveryHandler(w)
+
+	form, err := parsePasteForm(w, r)
+	defer form.Close()
+
+	var body *spool.Spool
+	var bundled bool
+	var files []*pasteFormFile
+	if err == nil {
+		body, bundled, err = readPasteBody(r, form)
+	}
+	if err == nil {
+		defer body.Close()
+		files = readPasteAttachments(form)
+		err = checkPasteAttachmentCount(nil, files, nil)
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		if weberr, ok := err.(HTTPError); ok {
+			status = weberr.StatusCode()
+		}
+		RenderError(err, status, w)
 		return
 	}
 
-	pasteLen := ByteSize(len(body))
-	if pasteLen > PASTE_MAXIMUM_LENGTH {
-		err := PasteTooLargeError(pasteLen)
-		RenderError(err, err.StatusCode(), w)
+	if pasteBodyIsBlank(body) {
+		// 400 here, 200 above (one is displayed to the user, one could be an API response.)
+		RenderError(fmt.Errorf("Hey, put some text in that paste."), 400, w)
 		return
 	}
 
@@ -304,18 +568,53 @@ func (pc *PasteController) pasteCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if encrypted && len(files) > 0 {
+		RenderError(fmt.Errorf("Encrypted pastes can't have attachments."), http.StatusBadRequest, w)
+		return
+	}
+
+	slug := r.FormValue("slug")
+	if slug != "" {
+		if GetUser(r) == nil {
+			RenderError(fmt.Errorf("You need to log in to choose your paste's URL."), http.StatusForbidden, w)
+			return
+		}
+		if encrypted {
+			RenderError(fmt.Errorf("Encrypted pastes can't have a custom URL."), http.StatusBadRequest, w)
+			return
+		}
+	}
+
 	var p model.Paste
-	var err error
 
-	if !encrypted {
+	if slug != "" {
+		// Vanity pastes are never hash-deduplicated: the user asked for this paste by name.
+		p, err = createVanityPaste(slug)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if weberr, ok := err.(HTTPError); ok {
+				status = weberr.StatusCode()
+			}
+			RenderError(err, status, w)
+			return
+		}
+	} else if !encrypted && len(files) > 0 {
+		// Pastes with attachments are never hash-deduplicated: only their text is hashed.
+		p, err = pasteStore.CreatePaste()
+		if err != nil {
+			panic(err)
+		}
+	} else if !encrypted {
+		// Resubmitting a paste from the same address shortly after returns the paste already
+		// made. This is apart from storage: the model shares identical bodies regardless.
 		// We can only hash-dedup non-encrypted pastes.
 		hasher := md5.New()
-		io.WriteString(hasher, body)
+		io.Copy(hasher, body.Reader())
 		hashToken := "H|" + SourceIPForRequest(r) + "|" + base32Encoder.EncodeToString(hasher.Sum(nil))
 
 		v, _ := ephStore.Get(hashToken)
 		if hashedPaste, ok := v.(model.Paste); ok {
-			pc.pasteUpdateCore(hashedPaste, w, r, true)
+			pc.pasteUpdateCore(hashedPaste, w, r, true, body, bundled, nil)
 			// TODO(DH) EARLY RETURN
 			return
 		}
@@ -333,17 +632,7 @@ func (pc *PasteController) pasteCreate(w http.ResponseWriter, r *http.Request) {
 			panic(err)
 		}
 
-		cliSession, err := clientOnlySessionStore.Get(r, "c_session")
-		if err != nil {
-			glog.Errorln(err)
-		}
-		pasteKeys, ok := cliSession.Values["paste_passphrases"].(map[model.PasteID][]byte)
-		if !ok {
-			pasteKeys = map[model.PasteID][]byte{}
-		}
-
-		pasteKeys[p.GetID()] = []byte(password)
-		cliSession.Values["paste_passphrases"] = pasteKeys
+		setPastePassphrase(r, p.GetID(), []byte(password))
 	}
 
 	GetPastePermissionScope(p.GetID(), r).Grant(model.PastePermissionAll)
@@ -354,17 +643,17 @@ func (pc *PasteController) pasteCreate(w http.ResponseWriter, r *http.Request) {
 		glog.Errorln(err)
 	}
 
-	pc.pasteUpdateCore(p, w, r, true)
+	pc.pasteUpdateCore(p, w, r, true, body, bundled, files)
 }
 
 func (pc *PasteController) pasteDelete(p model.Paste, w http.ResponseWriter, r *http.Request) {
 	oldId := p.GetID()
-	p.Erase()
-
-	GetPastePermissionScope(oldId, r).Revoke(model.PastePermissionAll)
-	SavePastePermissionScope(w, r)
+	if err := p.Erase(); err != nil {
+		panic(err)
+	}
 
-	SetFlash(w, "success", fmt.Sprintf("Paste %v deleted.", oldId))
+	// The session keeps its permissions on the paste so that it can be restored from the trash.
+	SetFlash(w, "success", fmt.Sprintf("Paste %v moved to the trash. You can restore it from your session page.", oldId))
 
 	redir := r.FormValue("redir")
 	if redir == "reports" {
@@ -385,14 +674,7 @@ func (pc *PasteController) authenticatePastePOSTHandler(w http.ResponseWriter, r
 	id := model.PasteIDFromString(mux.Vars(r)["id"])
 	passphrase := []byte(r.FormValue("password"))
 
-	cliSession, _ := clientOnlySessionStore.Get(r, "c_session")
-	pasteKeys, ok := cliSession.Values["paste_passphrases"].(map[model.PasteID][]byte)
-	if !ok {
-		pasteKeys = map[model.PasteID][]byte{}
-	}
-
-	pasteKeys[id] = passphrase
-	cliSession.Values["paste_passphrases"] = pasteKeys
+	setPastePassphrase(r, id, passphrase)
 	sessions.Save(r, w)
 
 	dest := pasteURL("show", id)
@@ -446,12 +728,20 @@ var renderCache struct {
 
 // TODO(DH) MOVE
 func renderPaste(p model.Paste) template.HTML {
+	return renderCached(p.GetID(), p, func() (string, error) {
+		return FormatPaste(p)
+	})
+}
+
+// renderCached returns what format renders for p, caching it under key until p is next
+// modified. Encrypted pastes are never cached.
+func renderCached(key lru.Key, p model.Paste, format func() (string, error)) template.HTML {
 	renderCache.mu.RLock()
 	var cached *renderedPaste
 	var cval interface{}
 	var ok bool
 	if renderCache.c != nil {
-		if cval, ok = renderCache.c.Get(p.GetID()); ok {
+		if cval, ok = renderCache.c.Get(key); ok {
 			cached = cval.(*renderedPaste)
 		}
 	}
@@ -460,10 +750,10 @@ func renderPaste(p model.Paste) template.HTML {
 	if !ok || cached.renderTime.Before(p.GetModificationTime()) {
 		defer renderCache.mu.Unlock()
 		renderCache.mu.Lock()
-		out, err := FormatPaste(p)
+		out, err := format()
 
 		if err != nil {
-			glog.Errorf("Render for %s failed: (%s) output: %s", p.GetID(), err.Error(), out)
+			glog.Errorf("Render for %v failed: (%s) output: %s", key, err.Error(), out)
 			return template.HTML("There was an error rendering this paste.")
 		}
 
@@ -477,8 +767,8 @@ func renderPaste(p model.Paste) template.HTML {
 					},
 				}
 			}
-			renderCache.c.Add(p.GetID(), &renderedPaste{body: rendered, renderTime: time.Now()})
-			glog.Info("RENDER CACHE: Cached ", p.GetID())
+			renderCache.c.Add(key, &renderedPaste{body: rendered, renderTime: time.Now()})
+			glog.Info("RENDER CACHE: Cached ", key)
 		}
 
 		return rendered
@@ -487,10 +777,28 @@ func renderPaste(p model.Paste) template.HTML {
 	}
 }
 
+// pasteShowHandler renders p or, if it was encrypted in the browser, a page that has the
+// browser decrypt and render it.
+func (pc *PasteController) pasteShowHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	page := "paste_show"
+	if p.IsClientEncrypted() {
+		page = "paste_client_show"
+	}
+	templatePack.ExecutePage(w, r, page, p)
+}
+
 func (pc *PasteController) generateRenderPageHandler(page string) pasteHandlerFunc {
 	// We don't defer the error handler here because it happened a step up
 	return func(p model.Paste, w http.ResponseWriter, r *http.Request) {
-		templatePack.ExecutePage(w, r, page, p)
+		// The page is rendered in full before any of it is sent, so that a body that can't be
+		// read gets an error instead of a page cut short.
+		buf := &bytes.Buffer{}
+		if err := templatePack.ExecutePage(buf, r, page, p); err != nil {
+			glog.Errorf("paste %s: failed to render %s: %v", p.GetID(), page, err)
+			RenderError(err, http.StatusInternalServerError, w)
+			return
+		}
+		buf.WriteTo(w)
 	}
 }
 
@@ -502,15 +810,19 @@ func (pc *PasteController) InitRoutes() {
 	pc.Router.Methods("POST").
 		Path("/new").
 		Handler(http.HandlerFunc(pc.pasteCreate))
+	pc.Router.Methods("POST").
+		Path("/new/client").
+		Handler(http.HandlerFunc(pc.clientEncryptedPasteCreate)).
+		Name("new_client")
 
 	pc.Router.Methods("GET").
 		Path("/{id}.json").
-		Handler(pc.wrapPasteHandler(pc.getPasteJSONHandler)).
+		Handler(pc.wrapClientEncryptedPasteHandler(pc.wrapPasteViewHandler(pc.getPasteJSONHandler))).
 		Name("show")
 
 	pc.Router.Methods("GET").
 		Path("/{id}").
-		Handler(pc.wrapPasteHandler(pc.generateRenderPageHandler("paste_show"))).
+		Handler(pc.wrapClientEncryptedPasteHandler(pc.wrapPasteViewHandler(pc.pasteShowHandler))).
 		Name("show")
 
 	pc.Router.Methods("POST").
@@ -523,17 +835,30 @@ func (pc *PasteController) InitRoutes() {
 		Name("grant_accept")
 	pc.Router.Methods("GET").
 		Path("/{id}/disavow").
-		Handler(pc.wrapPasteHandler(pc.wrapPasteEditHandler(pc.pasteUngrantHandler)))
+		Handler(pc.wrapClientEncryptedPasteHandler(pc.wrapPasteEditHandler(pc.pasteUngrantHandler)))
 
 	pc.Router.Methods("GET").
 		Path("/{id}/raw").
-		Handler(pc.wrapPasteHandler(pc.getPasteRawHandler)).
+		Handler(pc.wrapPasteHandler(pc.wrapPasteViewHandler(pc.getPasteRawHandler))).
 		Name("raw")
+	pc.Router.Methods("GET").
+		Path("/{id}/raw/{name}").
+		Handler(pc.wrapPasteHandler(pc.getPasteAttachmentHandler)).
+		Name("attachment")
 	pc.Router.Methods("GET").
 		Path("/{id}/download").
-		Handler(pc.wrapPasteHandler(pc.getPasteRawHandler)).
+		Handler(pc.wrapPasteHandler(pc.wrapPasteViewHandler(pc.getPasteRawHandler))).
 		Name("download")
 
+	pc.Router.Methods("POST").
+		Path("/{id}/fork").
+		Handler(pc.wrapPasteHandler(pc.pasteForkHandler)).
+		Name("fork")
+
+	pc.initRevisionRoutes()
+	pc.initBundleRoutes()
+	pc.initPasswordRoutes()
+
 	pc.Router.Methods("GET").
 		Path("/{id}/edit").
 		Handler(pc.wrapPasteHandler(pc.wrapPasteEditHandler(pc.generateRenderPageHandler("paste_edit")))).
@@ -544,11 +869,15 @@ func (pc *PasteController) InitRoutes() {
 
 	pc.Router.Methods("GET").
 		Path("/{id}/delete").
-		Handler(pc.wrapPasteHandler(pc.wrapPasteEditHandler(pc.generateRenderPageHandler("paste_delete_confirm")))).
+		Handler(pc.wrapClientEncryptedPasteHandler(pc.wrapPasteEditHandler(pc.generateRenderPageHandler("paste_delete_confirm")))).
 		Name("delete")
 	pc.Router.Methods("POST").
 		Path("/{id}/delete").
-		Handler(pc.wrapPasteHandler(pc.wrapPasteEditHandler(pc.pasteDelete)))
+		Handler(pc.wrapClientEncryptedPasteHandler(pc.wrapPasteEditHandler(pc.pasteDelete)))
+	pc.Router.Methods("POST").
+		Path("/{id}/restore").
+		Handler(http.HandlerFunc(pc.pasteRestore)).
+		Name("restore")
 
 	pc.Router.Methods("POST").
 		Path("/{id}/report").
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/DHowett/ghostbin/lib/templatepack"
+	"github.com/DHowett/ghostbin/model"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/sessions"
+)
+
+// pasteForkHandler copies p into a new paste owned by the requester and records p as its parent.
+// Forks of encrypted pastes are encrypted with the same passphrase.
+func (pc *PasteController) pasteForkHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	var fork model.Paste
+	var err error
+	if p.IsEncrypted() {
+		passphrase := getPastePassphrase(r, p.GetID())
+		fork, err = pasteStore.CreateEncryptedPaste(CURRENT_ENCRYPTION_METHOD, passphrase)
+		if err != nil {
+			panic(err)
+		}
+		setPastePassphrase(r, fork.GetID(), passphrase)
+	} else {
+		fork, err = pasteStore.CreatePaste()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	reader, err := p.Reader()
+	if err != nil {
+		panic(err)
+	}
+	defer reader.Close()
+
+	pw, err := fork.Writer()
+	if err != nil {
+		panic(err)
+	}
+	if _, err := io.Copy(pw, reader); err != nil {
+		panic(err)
+	}
+
+	fork.SetTitle(p.GetTitle())
+	fork.SetLanguageName(p.GetLanguageName())
+	fork.SetBundle(p.IsBundle())
+	fork.SetParentID(p.GetID())
+	fork.SetEditor(editorForRequest(r))
+
+	if err := pw.Close(); err != nil { // Saves fork
+		panic(err)
+	}
+	if !p.IsEncrypted() {
+		if err := copyPasteAttachments(p, fork); err != nil {
+			panic(err)
+		}
+	}
+
+	GetPastePermissionScope(fork.GetID(), r).Grant(model.PastePermissionAll)
+	SavePastePermissionScope(w, r)
+
+	err = sessions.Save(r, w)
+	if err != nil {
+		glog.Errorln(err)
+	}
+
+	SetFlash(w, "success", fmt.Sprintf("Paste %v forked from %v.", fork.GetID(), p.GetID()))
+	w.Header().Set("Location", pasteURL("edit", fork.GetID()))
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// visiblePaste returns the paste named by id as the requester would see it: decrypted if they
+// hold its passphrase, otherwise as a placeholder. It returns nil if the paste no longer exists.
+func visiblePaste(r *http.Request, id model.PasteID) model.Paste {
+	p, err := pasteStore.GetPaste(id, getPastePassphrase(r, id))
+	if err != nil && err != model.PasteEncryptedError {
+		return nil
+	}
+	return p
+}
+
+// visibleForks returns the forks of p that the requester may know about. The existence of an
+// encrypted fork is only revealed to those who hold its passphrase.
+func visibleForks(p model.Paste, r *http.Request) []model.Paste {
+	forks, err := p.GetForks()
+	if err != nil {
+		glog.Errorf("Failed to list forks of %s: %v", p.GetID(), err)
+		return nil
+	}
+
+	visible := make([]model.Paste, 0, len(forks))
+	for _, fork := range forks {
+		if fork.IsEncrypted() {
+			passphrase := getPastePassphrase(r, fork.GetID())
+			if passphrase == nil {
+				continue
+			}
+			fork, err = pasteStore.GetPaste(fork.GetID(), passphrase)
+			if err != nil {
+				continue
+			}
+		}
+		visible = append(visible, fork)
+	}
+	return visible
+}
+
+func init() {
+	globalInit.Add(&InitHandler{
+		Priority: 82,
+		Name:     "fork_template_funcs",
+		Do: func() error {
+			templatePack.AddFunction("pasteParent", func(ri *templatepack.Context) model.Paste {
+				id := ri.Obj.(model.Paste).GetParentID()
+				if id == "" {
+					return nil
+				}
+				return visiblePaste(ri.Request, id)
+			})
+			templatePack.AddFunction("pasteForks", func(ri *templatepack.Context) []model.Paste {
+				return visibleForks(ri.Obj.(model.Paste), ri.Request)
+			})
+			return nil
+		},
+	})
+}
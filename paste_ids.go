@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/DHowett/ghostbin/model"
+)
+
+// pasteIDConfig describes the random IDs given to new pastes.
+// It is populated from the -paste-id-* flags and the "paste_ids" section of the
+// configuration file; flags given on the command line take precedence.
+type pasteIDConfig struct {
+	Alphabet        string `yaml:"alphabet"`
+	Length          int    `yaml:"length"`
+	EncryptedLength int    `yaml:"encrypted_length"`
+}
+
+func (c *pasteIDConfig) register() {
+	defaults := model.NewPasteIDGenerator()
+	flag.StringVar(&c.Alphabet, "paste-id-alphabet", defaults.Alphabet, "characters from which paste IDs are made (letters, digits, _ and -)")
+	flag.IntVar(&c.Length, "paste-id-length", defaults.Length, "length of the IDs of new pastes; IDs grow as they run out")
+	flag.IntVar(&c.EncryptedLength, "encrypted-paste-id-length", defaults.EncryptedLength, "length of the IDs of new encrypted pastes")
+}
+
+// merge fills in every setting that was not given explicitly on the command line from o.
+func (c *pasteIDConfig) merge(o *pasteIDConfig, explicit map[string]bool) {
+	if o.Alphabet != "" && !explicit["paste-id-alphabet"] {
+		c.Alphabet = o.Alphabet
+	}
+	if o.Length != 0 && !explicit["paste-id-length"] {
+		c.Length = o.Length
+	}
+	if o.EncryptedLength != 0 && !explicit["encrypted-paste-id-length"] {
+		c.EncryptedLength = o.EncryptedLength
+	}
+}
+
+func (c *pasteIDConfig) generator() (*model.PasteIDGenerator, error) {
+	g := &model.PasteIDGenerator{
+		Alphabet:        c.Alphabet,
+		Length:          c.Length,
+		EncryptedLength: c.EncryptedLength,
+	}
+	if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid paste ID configuration: %v", err)
+	}
+	return g, nil
+}
+
+// reservedPasteIDs can't be chosen as vanity IDs, as they name other routes under /paste.
+var reservedPasteIDs = map[string]bool{
+	"new":   true,
+	"grant": true,
+}
+
+const minVanityPasteIDLength = 3
+
+type VanityPasteIDError struct {
+	ID     string
+	reason string
+	status int
+}
+
+func (e VanityPasteIDError) Error() string {
+	return "You can't use " + e.ID + " for your paste: " + e.reason + "."
+}
+
+func (e VanityPasteIDError) StatusCode() int {
+	return e.status
+}
+
+// validateVanityPasteID checks an ID requested for a new paste. Anything that can name a paste
+// and isn't too short is allowed, except the names of the routes it would shadow.
+func validateVanityPasteID(id string) error {
+	if len(id) < minVanityPasteIDLength {
+		return VanityPasteIDError{id, fmt.Sprintf("it must be at least %d characters long", minVanityPasteIDLength), http.StatusBadRequest}
+	}
+	if model.ValidatePasteID(model.PasteID(id)) != nil {
+		return VanityPasteIDError{id, fmt.Sprintf("it may only have up to %d letters, digits, _ and -", model.MaxPasteIDLength), http.StatusBadRequest}
+	}
+	if reservedPasteIDs[strings.ToLower(id)] {
+		return VanityPasteIDError{id, "it's reserved", http.StatusBadRequest}
+	}
+	return nil
+}
+
+// createVanityPaste creates a paste named id, which the user asked for.
+func createVanityPaste(id string) (model.Paste, error) {
+	if err := validateVanityPasteID(id); err != nil {
+		return nil, err
+	}
+	p, err := pasteStore.CreatePasteWithID(model.PasteID(id))
+	if err == model.PasteIDUnavailableError {
+		return nil, VanityPasteIDError{id, "it's taken", http.StatusConflict}
+	}
+	return p, err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/DHowett/ghostbin/model"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/sessions"
+)
+
+// wrapPasteOwnerHandler admits only the paste's owner, who holds every permission on it, to fn.
+func (pc *PasteController) wrapPasteOwnerHandler(fn pasteHandlerFunc) pasteHandlerFunc {
+	return func(p model.Paste, w http.ResponseWriter, r *http.Request) {
+		if !isPasteOwner(p, r) {
+			panic(PasteAccessDeniedError{"change the password of", p.GetID()})
+		}
+		fn(p, w, r)
+	}
+}
+
+// pastePasswordHandler encrypts p and its revisions again under a new password, with the
+// current encryption method. The old password is asked for again, even though the session
+// holds it, so that a session left open can't be used to lock the owner out.
+func (pc *PasteController) pastePasswordHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	if !p.IsEncrypted() {
+		panic(fmt.Errorf("Paste %v has no password to change.", p.GetID()))
+	}
+	if throttleAuthForRequest(r) {
+		RenderError(fmt.Errorf("Cool it."), 420, w)
+		return
+	}
+
+	newPassword := r.FormValue("new_password")
+	var problem string
+	switch {
+	case newPassword == "":
+		problem = "The new password can't be empty."
+	case newPassword != r.FormValue("confirm_password"):
+		problem = "The new passwords don't match."
+	default:
+		err := pasteStore.ReencryptPaste(p.GetID(), []byte(r.FormValue("password")), []byte(newPassword), CURRENT_ENCRYPTION_METHOD)
+		if err == model.PasteInvalidKeyError {
+			problem = "Incorrect password."
+		} else if err != nil {
+			panic(err)
+		}
+	}
+	if problem != "" {
+		SetFlash(w, "error", problem)
+		w.Header().Set("Location", pasteURL("password", p.GetID()))
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+
+	setPastePassphrase(r, p.GetID(), []byte(newPassword))
+	if err := sessions.Save(r, w); err != nil {
+		glog.Errorln(err)
+	}
+
+	SetFlash(w, "success", fmt.Sprintf("Password for paste %v changed.", p.GetID()))
+	w.Header().Set("Location", pasteURL("show", p.GetID()))
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+func (pc *PasteController) initPasswordRoutes() {
+	pc.Router.Methods("GET").
+		MatcherFunc(HTTPSMuxMatcher).
+		Path("/{id}/password").
+		Handler(pc.wrapPasteHandler(pc.wrapPasteOwnerHandler(pc.generateRenderPageHandler("paste_password")))).
+		Name("password")
+	pc.Router.Methods("POST").
+		MatcherFunc(HTTPSMuxMatcher).
+		Path("/{id}/password").
+		Handler(pc.wrapPasteHandler(pc.wrapPasteOwnerHandler(pc.pastePasswordHandler)))
+}
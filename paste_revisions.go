@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/DHowett/ghostbin/lib/diff"
+	"github.com/DHowett/ghostbin/lib/formatting"
+	"github.com/DHowett/ghostbin/lib/templatepack"
+	"github.com/DHowett/ghostbin/model"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+const REVISION_DIFF_CONTEXT_LINES int = 3
+
+type PasteRevisionNotFoundError struct {
+	ID       model.PasteID
+	Revision string
+}
+
+func (e PasteRevisionNotFoundError) Error() string {
+	return "Paste " + e.ID.String() + " has no revision " + e.Revision + "."
+}
+
+func (e PasteRevisionNotFoundError) StatusCode() int {
+	return http.StatusNotFound
+}
+
+type pasteRevisionView struct {
+	Paste    model.Paste
+	Revision model.PasteRevision
+}
+
+type pasteDiffView struct {
+	Paste    model.Paste
+	From, To model.PasteRevision
+	Diff     string
+}
+
+// editorForRequest identifies the user or anonymous session responsible for a request.
+// Anonymous sessions are recorded as a digest so that revision history never reveals a session key.
+func editorForRequest(r *http.Request) model.PasteEditor {
+	if user := GetUser(r); user != nil {
+		return model.PasteEditor{UserID: user.GetID()}
+	}
+
+	cookieSession, _ := sessionStore.Get(r, "session")
+	if cookieSession == nil || cookieSession.ID == "" {
+		return model.PasteEditor{}
+	}
+
+	sum := sha256.Sum256([]byte(cookieSession.ID))
+	return model.PasteEditor{Session: base32Encoder.EncodeToString(sum[:15])}
+}
+
+func revisionURL(routeType string, p model.PasteID, revision int) string {
+	url, _ := pasteRouter.Get(routeType).URL("id", p.String(), "revision", strconv.Itoa(revision))
+	return url.String()
+}
+
+func diffURL(routeType string, p model.PasteID, from, to int) string {
+	url, _ := pasteRouter.Get(routeType).URL("id", p.String())
+	url.RawQuery = fmt.Sprintf("from=%d&to=%d", from, to)
+	return url.String()
+}
+
+// lookupRevision returns the revision of p named by the request variable of the same name,
+// or the revision numbered fallback if the variable is empty.
+func lookupRevision(p model.Paste, r *http.Request, variable string, fallback int) model.PasteRevision {
+	v, _ := mux.Vars(r)[variable]
+	if v == "" {
+		v = r.FormValue(variable)
+	}
+
+	n := fallback
+	if v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil {
+			panic(PasteRevisionNotFoundError{p.GetID(), v})
+		}
+	}
+
+	rev, err := p.GetRevision(n)
+	if err != nil {
+		if err == model.PasteRevisionNotFoundError {
+			panic(PasteRevisionNotFoundError{p.GetID(), strconv.Itoa(n)})
+		}
+		panic(err)
+	}
+	return rev
+}
+
+func readRevision(rev model.PasteRevision) string {
+	reader, err := rev.Reader()
+	if err != nil {
+		panic(err)
+	}
+	defer reader.Close()
+	buf := &bytes.Buffer{}
+	if err := writeBodyText(buf, reader, rev.IsBundle()); err != nil {
+		panic(err)
+	}
+	return buf.String()
+}
+
+func (pc *PasteController) pasteRevisionsHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	revs, err := p.GetRevisions()
+	if err != nil {
+		panic(err)
+	}
+
+	// Newest first.
+	for i, j := 0, len(revs)-1; i < j; i, j = i+1, j-1 {
+		revs[i], revs[j] = revs[j], revs[i]
+	}
+
+	templatePack.ExecutePage(w, r, "paste_revisions", &struct {
+		Paste     model.Paste
+		Revisions []model.PasteRevision
+	}{p, revs})
+}
+
+func (pc *PasteController) pasteRevisionHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	rev := lookupRevision(p, r, "revision", 0)
+	templatePack.ExecutePage(w, r, "paste_revision", &pasteRevisionView{p, rev})
+}
+
+func (pc *PasteController) pasteRevisionRawHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	rev := lookupRevision(p, r, "revision", 0)
+	reader, err := rev.Reader()
+	if err != nil {
+		panic(err)
+	}
+	defer reader.Close()
+
+	setRawContentHeaders(w)
+	if !rev.IsBundle() {
+		copyPasteBody(w, reader, p.GetID())
+		return
+	}
+	if err := writeBodyText(w, reader, true); err != nil {
+		panic(err)
+	}
+}
+
+func (pc *PasteController) pasteDiff(p model.Paste, r *http.Request) *pasteDiffView {
+	revs, err := p.GetRevisions()
+	if err != nil {
+		panic(err)
+	}
+
+	newest := 0
+	if len(revs) > 0 {
+		newest = revs[len(revs)-1].GetNumber()
+	}
+
+	to := lookupRevision(p, r, "to", newest)
+	previous := to.GetNumber() - 1
+	if previous < 1 {
+		previous = 1
+	}
+	from := lookupRevision(p, r, "from", previous)
+
+	buf := &bytes.Buffer{}
+	fromName := fmt.Sprintf("%s@%d", p.GetID(), from.GetNumber())
+	toName := fmt.Sprintf("%s@%d", p.GetID(), to.GetNumber())
+	diff.Unified(buf, fromName, toName, readRevision(from), readRevision(to), REVISION_DIFF_CONTEXT_LINES)
+
+	return &pasteDiffView{
+		Paste: p,
+		From:  from,
+		To:    to,
+		Diff:  buf.String(),
+	}
+}
+
+func (pc *PasteController) pasteDiffHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	templatePack.ExecutePage(w, r, "paste_diff", pc.pasteDiff(p, r))
+}
+
+func (pc *PasteController) pasteDiffRawHandler(p model.Paste, w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	d := pc.pasteDiff(p, r)
+	setRawContentHeaders(w)
+	io.WriteString(w, d.Diff)
+}
+
+func renderRevision(rev model.PasteRevision) template.HTML {
+	reader, err := rev.Reader()
+	if err != nil {
+		glog.Errorf("Render for %s@%d failed: %v", rev.GetPasteID(), rev.GetNumber(), err)
+		return template.HTML("There was an error rendering this revision.")
+	}
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		glog.Errorf("Render for %s@%d failed: %v", rev.GetPasteID(), rev.GetNumber(), err)
+		return template.HTML("There was an error rendering this revision.")
+	}
+
+	out, err := formatBody(bytes.NewReader(body), formatting.LanguageNamed(rev.GetLanguageName()), rev.IsBundle())
+	if err != nil {
+		glog.Errorf("Render for %s@%d failed: (%s) output: %s", rev.GetPasteID(), rev.GetNumber(), err.Error(), out)
+		return template.HTML("There was an error rendering this revision.")
+	}
+	return template.HTML(out)
+}
+
+func renderDiff(d *pasteDiffView) template.HTML {
+	out, err := formatting.FormatStream(bytes.NewReader([]byte(d.Diff)), formatting.LanguageNamed("diff"))
+	if err != nil {
+		glog.Errorf("Render for diff of %s failed: (%s) output: %s", d.Paste.GetID(), err.Error(), out)
+		return template.HTML("There was an error rendering this diff.")
+	}
+	return template.HTML(out)
+}
+
+func (pc *PasteController) initRevisionRoutes() {
+	pc.Router.Methods("GET").
+		Path("/{id}/revisions").
+		Handler(pc.wrapPasteHandler(pc.pasteRevisionsHandler)).
+		Name("revisions")
+	pc.Router.Methods("GET").
+		Path("/{id}/revisions/{revision:[0-9]+}").
+		Handler(pc.wrapPasteHandler(pc.pasteRevisionHandler)).
+		Name("revision")
+	pc.Router.Methods("GET").
+		Path("/{id}/revisions/{revision:[0-9]+}/raw").
+		Handler(pc.wrapPasteHandler(pc.pasteRevisionRawHandler)).
+		Name("revision_raw")
+
+	pc.Router.Methods("GET").
+		Path("/{id}/diff").
+		Handler(pc.wrapPasteHandler(pc.pasteDiffHandler)).
+		Name("diff")
+	pc.Router.Methods("GET").
+		Path("/{id}/diff/raw").
+		Handler(pc.wrapPasteHandler(pc.pasteDiffRawHandler)).
+		Name("diff_raw")
+}
+
+func init() {
+	globalInit.Add(&InitHandler{
+		Priority: 81,
+		Name:     "revision_template_funcs",
+		Do: func() error {
+			templatePack.AddFunction("revisionURL", func(e string, rev model.PasteRevision) string {
+				return revisionURL(e, rev.GetPasteID(), rev.GetNumber())
+			})
+			templatePack.AddFunction("diffURL", func(e string, p model.Paste, from, to int) string {
+				return diffURL(e, p.GetID(), from, to)
+			})
+			templatePack.AddFunction("renderRevision", renderRevision)
+			templatePack.AddFunction("renderDiff", renderDiff)
+			templatePack.AddFunction("revisionEditor", func(ri *templatepack.Context, rev model.PasteRevision) string {
+				editor := rev.GetEditor()
+				if editor == editorForRequest(ri.Request) && editor != (model.PasteEditor{}) {
+					return "you"
+				}
+				if editor.IsAnonymous() {
+					return "anonymous"
+				}
+				return "a registered user"
+			})
+			return nil
+		},
+	})
+}
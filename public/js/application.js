@@ -199,7 +199,7 @@ $(function() {
 
 		Ghostbin.loadLanguages();
 
-		langbox.select2({
+		var languageSelect2Options = {
 			data: Ghostbin.languagesForSelect2(),
 			matcher: function(term, text, lang) {
 				// The ifs here are blown apart so that we might short-circuit.
@@ -211,12 +211,33 @@ $(function() {
 				}
 				return false;
 			},
-		});
+		};
+		langbox.select2(languageSelect2Options);
 		var lang = Ghostbin.languageNamed(langbox.data("selected")) ||
 				Ghostbin.defaultLanguage() ||
 				Ghostbin.languageNamed("text");
 		langbox.select2("data", lang);
 
+		// Every extra file in a bundle has its own language.
+		var initFileEditor = function(editor) {
+			var fileLangbox = editor.find(".file-langbox");
+			fileLangbox.select2(languageSelect2Options);
+			fileLangbox.select2("data", Ghostbin.languageNamed(fileLangbox.data("selected")) || Ghostbin.languageNamed("text"));
+			editor.find(".remove-file-button").on("click", function() {
+				editor.remove();
+			});
+		};
+		$("#bundle-files .paste-file-editor").each(function() {
+			initFileEditor($(this));
+		});
+		$("#addFileButton").on("click", function() {
+			var editor = $("#file-editor-template .paste-file-editor").clone();
+			editor.find("input, textarea").prop("disabled", false);
+			editor.appendTo("#bundle-files");
+			initFileEditor(editor);
+			editor.find("textarea").focus();
+		});
+
 		if(context === "new") {
 			pasteForm.find("input[name='expire']").val(Ghostbin.defaultExpiration());
 
@@ -304,7 +325,7 @@ $(function() {
 			$(this).find("input").eq(0).focus().select();
 		}).on("hidden", function() {
 			pastePasswordField.val(modalPasswordField.val());
-			setEncrypted($(this).find("input").val().length > 0);
+			setEncrypted(modalPasswordField.val().length > 0 || $("#clientEncryption").prop("checked"));
 		});
 
 		$("#encryptionButton").on("click", function() {
@@ -0,0 +1,156 @@
+// Pastes encrypted in the browser. Their keys are made here and kept in the fragments of their
+// URLs, which browsers never send; the server holds only what encrypt() returns: a version
+// byte, a 12-byte IV and the AES-256-GCM ciphertext of the UTF-8 JSON of the paste's title,
+// language and text. Pastes of the first version held the text alone, and their titles and
+// languages were sent to the server in the clear.
+(function(window) {
+	"use strict";
+	var VERSION = 2, TEXT_VERSION = 1, IV_LENGTH = 12;
+	var subtle = window.crypto && window.crypto.subtle;
+
+	var toBase64 = function(bytes) {
+		var s = "";
+		for(var i = 0; i < bytes.length; i++) {
+			s += String.fromCharCode(bytes[i]);
+		}
+		return window.btoa(s);
+	};
+	var fromBase64 = function(s) {
+		var bin = window.atob(s), bytes = new Uint8Array(bin.length);
+		for(var i = 0; i < bin.length; i++) {
+			bytes[i] = bin.charCodeAt(i);
+		}
+		return bytes;
+	};
+	// Keys go in URLs.
+	var toBase64URL = function(bytes) {
+		return toBase64(bytes).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+	};
+	var fromBase64URL = function(s) {
+		s = s.replace(/-/g, "+").replace(/_/g, "/");
+		while(s.length % 4 !== 0) s += "=";
+		return fromBase64(s);
+	};
+
+	// encrypt resolves to the key, for the URL, and the body, for the server, of paste: its
+	// title, language and text.
+	var encrypt = function(paste) {
+		var text = JSON.stringify({title: paste.title, language: paste.language, text: paste.text});
+		var iv = window.crypto.getRandomValues(new Uint8Array(IV_LENGTH));
+		return subtle.generateKey({name: "AES-GCM", length: 256}, true, ["encrypt"]).then(function(key) {
+			return Promise.all([
+				subtle.exportKey("raw", key),
+				subtle.encrypt({name: "AES-GCM", iv: iv}, key, new TextEncoder().encode(text)),
+			]);
+		}).then(function(results) {
+			var ciphertext = new Uint8Array(results[1]);
+			var body = new Uint8Array(1 + IV_LENGTH + ciphertext.length);
+			body[0] = VERSION;
+			body.set(iv, 1);
+			body.set(ciphertext, 1 + IV_LENGTH);
+			return {key: toBase64URL(new Uint8Array(results[0])), body: toBase64(body)};
+		});
+	};
+
+	// decrypt resolves to the paste sealed in body, and rejects a body that has been tampered
+	// with.
+	var decrypt = function(keyString, body) {
+		var bytes = fromBase64(body);
+		if(bytes.length < 1 + IV_LENGTH || (bytes[0] !== VERSION && bytes[0] !== TEXT_VERSION)) {
+			return Promise.reject(new Error("This paste wasn't encrypted in a way this page understands."));
+		}
+		return subtle.importKey("raw", fromBase64URL(keyString), {name: "AES-GCM"}, false, ["decrypt"]).then(function(key) {
+			return subtle.decrypt({name: "AES-GCM", iv: bytes.subarray(1, 1 + IV_LENGTH)}, key, bytes.subarray(1 + IV_LENGTH));
+		}).then(function(plaintext) {
+			var text = new TextDecoder().decode(plaintext);
+			if(bytes[0] === TEXT_VERSION) {
+				return {text: text};
+			}
+			return JSON.parse(text);
+		});
+	};
+
+	window.ClientPaste = {
+		available: !!(subtle && window.TextEncoder && window.Promise),
+		encrypt: encrypt,
+		decrypt: decrypt,
+	};
+})(window);
+
+$(function() {
+	"use strict";
+
+	// Making one: the paste form, with "Encrypt in my browser" checked.
+	var pasteForm = $("#pasteForm"), clientBox = $("#clientEncryption");
+	if(pasteForm.length > 0 && clientBox.length > 0) {
+		if(!ClientPaste.available) {
+			clientBox.prop("disabled", true).closest("label").addClass("muted");
+		}
+		pasteForm.on("submit", function(e) {
+			if(e.isDefaultPrevented() || !clientBox.prop("checked")) return;
+			e.preventDefault();
+
+			if($("#bundle-files .paste-file-editor").length > 0 || pasteForm.find("input[type='file']").filter(function() { return this.value; }).length > 0) {
+				Ghostbin.displayFlash({type: "error", body: "Pastes encrypted in the browser can't have more than one file, or attachments."});
+				return;
+			}
+			// The title and language are sealed along with the text, and the server sees neither.
+			ClientPaste.encrypt({
+				title: pasteForm.find("input[name='title']").val(),
+				language: pasteForm.find("#langbox").select2("val"),
+				text: $("#code-editor").val(),
+			}).then(function(sealed) {
+				return Promise.resolve($.ajax({
+					url: clientBox.data("url"),
+					type: "POST",
+					contentType: "application/json",
+					dataType: "json",
+					data: JSON.stringify({
+						expiration: pasteForm.find("input[name='expire']").val(),
+						body: sealed.body,
+					}),
+				})).then(function(reply) {
+					window.location = reply.url + "#" + sealed.key;
+				});
+			}).catch(function(err) {
+				var reply = err && err.responseJSON;
+				Ghostbin.displayFlash({type: "error", body: (reply && reply.error) || "The paste couldn't be encrypted and saved."});
+			});
+		});
+	}
+
+	// Reading one: the key is in the fragment.
+	var view = $("#client-paste");
+	if(view.length > 0) {
+		var fail = function(message) {
+			view.find(".client-paste-status").text(message).show();
+		};
+		var key = window.location.hash.slice(1);
+		if(!ClientPaste.available) {
+			fail("This browser can't decrypt pastes.");
+		} else if(!key) {
+			fail("The key to this paste was in the fragment of its link, after the #. Without it, the paste can't be read.");
+		} else {
+			Promise.resolve($.getJSON(view.data("url"))).then(function(paste) {
+				return ClientPaste.decrypt(key, paste.body).then(function(sealed) {
+					view.find(".client-paste-status").hide();
+					if(sealed.title) {
+						$(".client-paste-title").text(sealed.title);
+					}
+					if(sealed.language) {
+						Ghostbin.loadLanguages();
+						var language = Ghostbin.languageNamed(sealed.language);
+						if(language) {
+							$(".client-paste-language").text(language.name);
+						}
+					}
+					// The text is shown as it is, not highlighted: the server's highlighter never
+					// sees it, and there is none in the browser.
+					view.find("#code").empty().append($('<div class="highlight"></div>').append($("<pre></pre>").text(sealed.text)));
+				});
+			}).catch(function() {
+				fail("This paste couldn't be decrypted. Check that its link is whole.");
+			});
+		}
+	}
+});
@@ -42,6 +42,10 @@ func RenderError(e error, statusCode int, w http.ResponseWriter) {
 
 func errorRecoveryHandler(w http.ResponseWriter) {
 	if err := recover(); err != nil {
+		if err == http.ErrAbortHandler {
+			// The response was cut off on purpose; see copyPasteBody.
+			panic(err)
+		}
 		status := http.StatusInternalServerError
 		if weberr, ok := err.(HTTPError); ok {
 			status = weberr.StatusCode()
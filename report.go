@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/DHowett/ghostbin/model"
 	"github.com/golang/glog"
@@ -16,6 +17,7 @@ type ReportInfo map[string]int
 type ReportStore struct {
 	Reports  map[model.PasteID]ReportInfo
 	filename string
+	mu       sync.Mutex
 }
 
 func (r *ReportStore) Save() error {
@@ -32,6 +34,8 @@ func (r *ReportStore) Save() error {
 }
 
 func (r *ReportStore) Add(id model.PasteID, kind string) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
 	currentReportsForPaste, ok := r.Reports[id]
 
 	if !ok {
@@ -44,6 +48,11 @@ func (r *ReportStore) Add(id model.PasteID, kind string) {
 }
 
 func (r *ReportStore) Delete(p model.PasteID) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	if _, ok := r.Reports[p]; !ok {
+		return
+	}
 	delete(r.Reports, p)
 	glog.Info(p, " deleted from report history.")
 	r.Save()
@@ -93,6 +102,15 @@ func reportClear(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusFound)
 }
 
+// reportPasteEventCallback forgets the reports against purged pastes; those against pastes
+// that are merely in the trash are kept in case they are restored. Saving the reports can
+// be slow, so it is subscribed to the broker's events asynchronously.
+func reportPasteEventCallback(e *model.PasteEvent) {
+	if e.Type == model.PastePurgedEvent {
+		reportStore.Delete(e.ID)
+	}
+}
+
 func initReportStore() {
 	reportStore = LoadReportStore(filepath.Join(arguments.root, "reports.gob"))
 }
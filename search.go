@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/DHowett/ghostbin/lib/templatepack"
+	"github.com/DHowett/ghostbin/model"
+)
+
+const SEARCH_RESULTS_PER_PAGE int = 25
+const SEARCH_DATE_FORMAT string = "2006-01-02"
+
+type SearchQueryError string
+
+func (e SearchQueryError) Error() string {
+	return string(e)
+}
+
+func (e SearchQueryError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+type searchResults struct {
+	Results []model.Paste
+	// Page is 1-based; More is set when there is a page after it.
+	Page int
+	More bool
+}
+
+// PreviousPage returns the number of the page before this one, or 0.
+func (s *searchResults) PreviousPage() int {
+	return s.Page - 1
+}
+
+// NextPage returns the number of the page after this one, or 0.
+func (s *searchResults) NextPage() int {
+	if !s.More {
+		return 0
+	}
+	return s.Page + 1
+}
+
+type searchJSONResult struct {
+	ID       model.PasteID `json:"id"`
+	Title    string        `json:"title,omitempty"`
+	Language string        `json:"language"`
+	Modified time.Time     `json:"modified"`
+	URL      string        `json:"url"`
+}
+
+type searchJSONReply struct {
+	Results []searchJSONResult `json:"results"`
+	Page    int                `json:"page"`
+	More    bool               `json:"more"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// sessionPasteIDs returns the pastes on which an anonymous session holds permissions.
+func sessionPasteIDs(r *http.Request) []model.PasteID {
+	// Failed lookup is non-fatal here.
+	cookieSession, _ := sessionStore.Get(r, "session")
+	v3EntriesI, _ := cookieSession.Values["v3permissions"]
+	v3Perms, _ := v3EntriesI.(map[model.PasteID]model.Permission)
+
+	ids := make([]model.PasteID, len(v3Perms))
+	n := 0
+	for pid, _ := range v3Perms {
+		ids[n] = pid
+		n++
+	}
+	return ids
+}
+
+func parseSearchDate(r *http.Request, variable string) (time.Time, error) {
+	v := r.FormValue(variable)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(SEARCH_DATE_FORMAT, v)
+	if err != nil {
+		return time.Time{}, SearchQueryError("Dates look like " + SEARCH_DATE_FORMAT + ", not " + strconv.Quote(v) + ".")
+	}
+	return t, nil
+}
+
+// searchPastes runs the search described by the request over the pastes on which the requester
+// holds permissions. A request without search terms finds nothing.
+func searchPastes(r *http.Request) (*searchResults, error) {
+	results := &searchResults{Page: 1}
+	if p, err := strconv.Atoi(r.FormValue("page")); err == nil && p > 1 {
+		results.Page = p
+	}
+
+	search := &model.PasteSearch{
+		Terms:        r.FormValue("q"),
+		LanguageName: r.FormValue("lang"),
+		Limit:        SEARCH_RESULTS_PER_PAGE + 1,
+		Offset:       (results.Page - 1) * SEARCH_RESULTS_PER_PAGE,
+	}
+	if search.Terms == "" {
+		return results, nil
+	}
+
+	var err error
+	if search.ModifiedAfter, err = parseSearchDate(r, "after"); err != nil {
+		return nil, err
+	}
+	if search.ModifiedBefore, err = parseSearchDate(r, "before"); err != nil {
+		return nil, err
+	}
+	if !search.ModifiedBefore.IsZero() {
+		// "before" names the last day to include.
+		search.ModifiedBefore = search.ModifiedBefore.Add(24 * time.Hour)
+	}
+
+	// Assumption: as in sessionHandler, a logged-in session will never have v3 perms and user perms.
+	if user := GetUser(r); user != nil {
+		search.UserID = user.GetID()
+	} else {
+		search.PasteIDs = sessionPasteIDs(r)
+	}
+
+	results.Results, err = pasteStore.SearchPastes(search)
+	if err != nil {
+		return nil, err
+	}
+	if len(results.Results) > SEARCH_RESULTS_PER_PAGE {
+		results.Results = results.Results[:SEARCH_RESULTS_PER_PAGE]
+		results.More = true
+	}
+	return results, nil
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	results, err := searchPastes(r)
+	if err != nil {
+		panic(err)
+	}
+	templatePack.ExecutePage(w, r, "search", results)
+}
+
+func searchJSONHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	reply := &searchJSONReply{
+		Results: []searchJSONResult{},
+	}
+	defer func() {
+		enc := json.NewEncoder(w)
+		enc.Encode(reply)
+	}()
+
+	results, err := searchPastes(r)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if weberr, ok := err.(HTTPError); ok {
+			status = weberr.StatusCode()
+		}
+		w.WriteHeader(status)
+		reply.Error = err.Error()
+		return
+	}
+
+	base := BaseURLForRequest(r)
+	for _, p := range results.Results {
+		showURL, _ := url.Parse(pasteURL("show", p.GetID()))
+		reply.Results = append(reply.Results, searchJSONResult{
+			ID:       p.GetID(),
+			Title:    p.GetTitle(),
+			Language: p.GetLanguageName(),
+			Modified: p.GetModificationTime(),
+			URL:      base.ResolveReference(showURL).String(),
+		})
+	}
+	reply.Page = results.Page
+	reply.More = results.More
+}
+
+// searchPageURL returns the URL of another page of the current search's results.
+func searchPageURL(ri *templatepack.Context, page int) string {
+	query := ri.Request.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	return ri.Request.URL.Path + "?" + query.Encode()
+}
+
+func init() {
+	globalInit.Add(&InitHandler{
+		Priority: 83,
+		Name:     "search_template_funcs",
+		Do: func() error {
+			templatePack.AddFunction("searchPageURL", searchPageURL)
+			templatePack.AddFunction("searchDateFormat", func() string { return SEARCH_DATE_FORMAT })
+			return nil
+		},
+	})
+}
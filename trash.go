@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/DHowett/ghostbin/model"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// trashPurgeInterval is how often erased pastes are checked against the retention window.
+const trashPurgeInterval = time.Hour
+
+// TrashedPasteNotFoundError is returned when a paste is not in the trash.
+type TrashedPasteNotFoundError model.PasteID
+
+func (e TrashedPasteNotFoundError) Error() string {
+	return fmt.Sprintf("Paste %s is not in the trash.", model.PasteID(e))
+}
+
+func (e TrashedPasteNotFoundError) StatusCode() int {
+	return http.StatusNotFound
+}
+
+// TrashExpiredError is returned when a paste has been in the trash for too long to be
+// restored by its owner.
+type TrashExpiredError model.PasteID
+
+func (e TrashExpiredError) Error() string {
+	return fmt.Sprintf("Paste %s was deleted too long ago to be restored.", model.PasteID(e))
+}
+
+func (e TrashExpiredError) StatusCode() int {
+	return http.StatusGone
+}
+
+// trashRestorable reports whether the owner of t may still bring it back. With no
+// retention window, erased pastes stay in the trash until an administrator purges them.
+func trashRestorable(t *model.TrashedPaste) bool {
+	return arguments.trashRetention <= 0 || time.Since(t.TrashedAt) < arguments.trashRetention
+}
+
+// getTrashedPaste looks up paste id in the trash.
+func getTrashedPaste(id model.PasteID) (*model.TrashedPaste, error) {
+	trash, err := pasteStore.GetTrashedPastes([]model.PasteID{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(trash) == 0 {
+		return nil, TrashedPasteNotFoundError(id)
+	}
+	return trash[0], nil
+}
+
+// purgeExpiredTrash permanently removes the pastes that have outlived the retention window.
+func purgeExpiredTrash(broker model.Broker, retention time.Duration) {
+	trash, err := broker.GetTrash()
+	if err != nil {
+		glog.Error("Failed to list the trash: ", err)
+		return
+	}
+	for _, t := range trash {
+		if time.Since(t.TrashedAt) < retention {
+			continue
+		}
+		if err := broker.PurgePaste(t.GetID()); err != nil && err != model.PasteNotFoundError {
+			glog.Errorf("Failed to purge paste %s: %v", t.GetID(), err)
+			continue
+		}
+		glog.Info("Purged paste ", t.GetID(), " from the trash.")
+	}
+}
+
+func startTrashPurger(broker model.Broker, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	go func() {
+		for {
+			purgeExpiredTrash(broker, retention)
+			time.Sleep(trashPurgeInterval)
+		}
+	}()
+}
+
+func (pc *PasteController) pasteRestore(w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	id := model.PasteIDFromString(mux.Vars(r)["id"])
+	if !GetPastePermissionScope(id, r).Has(model.PastePermissionEdit) {
+		panic(PasteAccessDeniedError{action: "restore", ID: id})
+	}
+
+	t, err := getTrashedPaste(id)
+	if err != nil {
+		panic(err)
+	}
+	if !trashRestorable(t) {
+		panic(TrashExpiredError(id))
+	}
+	if err := pc.PasteStore.RestorePaste(id); err != nil {
+		panic(err)
+	}
+
+	SetFlash(w, "success", fmt.Sprintf("Paste %v restored.", id))
+	w.Header().Set("Location", pasteURL("show", id))
+	w.WriteHeader(http.StatusFound)
+}
+
+func adminTrashHandler(w http.ResponseWriter, r *http.Request) {
+	trash, err := pasteStore.GetTrash()
+	if err != nil {
+		panic(err)
+	}
+	templatePack.ExecutePage(w, r, "admin_trash", trash)
+}
+
+func adminTrashRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	id := model.PasteIDFromString(mux.Vars(r)["id"])
+	if err := pasteStore.RestorePaste(id); err != nil {
+		if err == model.PasteNotFoundError {
+			err = TrashedPasteNotFoundError(id)
+		}
+		panic(err)
+	}
+
+	SetFlash(w, "success", fmt.Sprintf("Paste %v restored.", id))
+	w.Header().Set("Location", "/admin/trash")
+	w.WriteHeader(http.StatusFound)
+}
+
+func adminTrashPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	defer errorRecoveryHandler(w)
+
+	id := model.PasteIDFromString(mux.Vars(r)["id"])
+	if err := pasteStore.PurgePaste(id); err != nil {
+		if err == model.PasteNotFoundError {
+			err = TrashedPasteNotFoundError(id)
+		}
+		panic(err)
+	}
+
+	SetFlash(w, "success", fmt.Sprintf("Paste %v purged.", id))
+	w.Header().Set("Location", "/admin/trash")
+	w.WriteHeader(http.StatusFound)
+}